@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/kontraktor-sh/kontraktor/internal/cli"
+	"github.com/kontraktor-sh/kontraktor/internal/output"
+	"github.com/kontraktor-sh/kontraktor/internal/task"
+	"github.com/kontraktor-sh/kontraktor/internal/vars"
+)
+
+// runExplain prints where the variables of the configured task come from and returns the process exit code.
+// Values masked by the output handler, such as secret arguments, are masked in the explanation.
+func runExplain(executor *task.Executor, outputHandler *output.Handler, config *cli.Config) int {
+	args := make(map[string]interface{})
+	for k, v := range config.TaskArgs {
+		args[k] = v
+	}
+	explanation, err := executor.Explain(config.TaskName, args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", outputHandler.MaskSensitiveData(err.Error()))
+		return 1
+	}
+	var buf bytes.Buffer
+	printExplanation(&buf, explanation)
+	fmt.Fprint(os.Stdout, outputHandler.MaskSensitiveData(buf.String()))
+	return 0
+}
+
+// printExplanation prints a task explanation followed by those of the tasks it references
+func printExplanation(w io.Writer, e *task.Explanation) {
+	fmt.Fprintf(w, "task %s", e.Task)
+	if e.Parent != "" {
+		fmt.Fprintf(w, " (run by task %s)", e.Parent)
+	}
+	if e.Desc != "" {
+		fmt.Fprintf(w, ": %s", e.Desc)
+	}
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "  steps:")
+	for i, step := range e.Steps {
+		fmt.Fprintf(w, "    %d. %s: %s\n", i+1, step.Type, step.Summary)
+	}
+
+	names := e.Vars.Names()
+	width := 0
+	for _, name := range names {
+		if len(name) > width {
+			width = len(name)
+		}
+	}
+	fmt.Fprintln(w, "  variables:")
+	if len(names) == 0 {
+		fmt.Fprintln(w, "    (none)")
+	}
+	for _, name := range names {
+		used, shadowed := e.Vars.Explain(name)
+		label := "overrides"
+		if used != nil {
+			// Recorded values show vars by their definition, without running commands
+			fmt.Fprintf(w, "    %-*s = %s\n", width, name, displayValue(used.Type, used.Value))
+		} else if variable, err := e.Vars.GetVariable(name); err == nil {
+			fmt.Fprintf(w, "    %-*s = %s\n", width, name, displayValue(variable.Type, variable.Value))
+		} else if len(shadowed) > 0 {
+			// Left out of the precedence of bare names
+			fmt.Fprintf(w, "    %-*s   (only visible as ${%s.%s})\n", width, name, namespaceOf(shadowed[0].Type), name)
+			label = "set by"
+		}
+		if used != nil {
+			fmt.Fprintf(w, "      from %s\n", describeOrigin(*used))
+		}
+		for _, origin := range shadowed {
+			fmt.Fprintf(w, "      %s %s = %s\n", label, describeOrigin(origin), displayValue(origin.Type, origin.Value))
+		}
+	}
+
+	for _, step := range e.Steps {
+		if step.Task != nil {
+			fmt.Fprintln(w)
+			printExplanation(w, step.Task)
+		}
+	}
+}
+
+// namespaceOf returns the namespace reading variables of type t
+func namespaceOf(t vars.VariableType) string {
+	switch t {
+	case vars.TypeSecret:
+		return vars.NamespaceSecrets
+	case vars.TypeArg:
+		return vars.NamespaceArgs
+	case vars.TypeVar:
+		return vars.NamespaceVars
+	default:
+		return vars.NamespaceEnv
+	}
+}
+
+func describeOrigin(origin vars.Origin) string {
+	return fmt.Sprintf("%s (%s)", origin.Source, origin.Type)
+}
+
+// displayValue masks the value of secrets
+func displayValue(t vars.VariableType, value string) string {
+	if t == vars.TypeSecret {
+		return task.MaskedValue
+	}
+	return value
+}
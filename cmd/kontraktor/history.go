@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kontraktor-sh/kontraktor/internal/cli"
+	"github.com/kontraktor-sh/kontraktor/internal/history"
+)
+
+// runHistory lists the past runs selected by the configured filter and returns the process exit code.
+// Only runs of the configured taskfile are listed unless --all is given.
+func runHistory(config *cli.Config) int {
+	store, err := config.CreateHistoryStore()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	runs, err := store.List()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	filter := config.HistoryFilter
+	if !config.HistoryAll {
+		if filter.Taskfile, err = filepath.Abs(config.Taskfile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+	}
+	runs = filter.Apply(runs)
+
+	if config.Format == cli.FormatJSON {
+		if runs == nil {
+			runs = []*history.Run{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(runs); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	if len(runs) == 0 {
+		fmt.Println("no runs found")
+		return 0
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RUN\tTASK\tSTATUS\tSTARTED\tDURATION")
+	for _, run := range runs {
+		duration := "-"
+		if run.End != nil {
+			duration = run.Duration().Round(time.Millisecond).String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", run.ID, run.Task, run.Status, run.Start.Local().Format(time.DateTime), duration)
+	}
+	w.Flush()
+	return 0
+}
+
+// runLogs prints the recorded output of a past run, or of one of its steps, and returns the process exit code
+func runLogs(config *cli.Config) int {
+	store, err := config.CreateHistoryStore()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	run, err := store.Load(config.RunID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	if config.Step != "" {
+		step, err := run.FindStep(config.Step)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		log, err := store.Log(run, step)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		fmt.Print(log)
+		return 0
+	}
+
+	fmt.Printf("run %s of task %s: %s\n", run.ID, run.Task, run.Status)
+	for _, step := range run.Steps {
+		header := fmt.Sprintf("--- %d. %s:%d %s", step.Number, step.Task, step.Step, step.Interpreter)
+		if step.Command != "" {
+			header += ": " + firstLine(step.Command)
+		}
+		fmt.Printf("%s (%s)\n", header, step.Status)
+		log, err := store.Log(run, step)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		fmt.Print(log)
+		if step.Error != "" {
+			fmt.Printf("error: %s\n", step.Error)
+		}
+	}
+	if run.Error != "" {
+		fmt.Printf("error: %s\n", run.Error)
+	}
+	return 0
+}
+
+// firstLine returns the first line of a command, marking commands of several lines
+func firstLine(command string) string {
+	line, _, more := strings.Cut(strings.TrimSpace(command), "\n")
+	if more {
+		return line + " ..."
+	}
+	return line
+}
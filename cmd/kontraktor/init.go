@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/kontraktor-sh/kontraktor/internal/cli"
+	"github.com/kontraktor-sh/kontraktor/internal/scaffold"
+)
+
+// runInit writes a starter taskfile from a template and returns the process exit code
+func runInit(config *cli.Config) int {
+	if _, err := os.Stat(config.Taskfile); err == nil && !config.Force {
+		fmt.Fprintf(os.Stderr, "Error: %s already exists (use --force to overwrite)\n", config.Taskfile)
+		return 1
+	}
+
+	tmpl, err := scaffold.Load(config.Template, ".")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if config.Template == "" || config.Template == scaffold.TemplateAuto {
+		if types := scaffold.DetectProjectTypes("."); len(types) > 0 {
+			fmt.Printf("Detected project types: %s\n", strings.Join(types, ", "))
+		}
+	}
+
+	values, err := tmpl.Resolve(scaffold.Options{
+		Vars:        config.TemplateVars,
+		Interactive: isTerminal(os.Stdin),
+		In:          os.Stdin,
+		Out:         os.Stdout,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	content, err := tmpl.Render(values)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if err := os.WriteFile(config.Taskfile, content, 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Created %s from template %s\n", config.Taskfile, tmpl.Name)
+	return 0
+}
+
+// isTerminal reports whether f is an interactive terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
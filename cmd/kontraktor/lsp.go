@@ -0,0 +1,22 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/kontraktor-sh/kontraktor/internal/lsp"
+	"github.com/kontraktor-sh/kontraktor/internal/taskfile"
+)
+
+// runLSP serves the language server on stdin/stdout and returns the process exit code
+func runLSP() int {
+	registry := defaultRegistry()
+	server := lsp.NewServer(taskfile.LintOptions{
+		KnownCommandType: registry.CanHandle,
+	})
+	if err := server.Serve(os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
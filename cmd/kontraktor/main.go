@@ -4,12 +4,20 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/kontraktor-sh/kontraktor/internal/cli"
+	"github.com/kontraktor-sh/kontraktor/internal/history"
+	"github.com/kontraktor-sh/kontraktor/internal/output"
 	"github.com/kontraktor-sh/kontraktor/internal/secret"
 	"github.com/kontraktor-sh/kontraktor/internal/task"
 	"github.com/kontraktor-sh/kontraktor/internal/taskfile"
 	"github.com/kontraktor-sh/kontraktor/internal/taskfile/interpreter"
+	"github.com/kontraktor-sh/kontraktor/internal/telemetry"
+	"github.com/kontraktor-sh/kontraktor/internal/vars"
+	"github.com/kontraktor-sh/kontraktor/internal/vault"
 )
 
 func main() {
@@ -20,6 +28,21 @@ func main() {
 		os.Exit(1)
 	}
 
+	switch config.Command {
+	case cli.CommandValidate:
+		os.Exit(runValidate(config))
+	case cli.CommandSchema:
+		os.Exit(runSchema())
+	case cli.CommandLSP:
+		os.Exit(runLSP())
+	case cli.CommandInit:
+		os.Exit(runInit(config))
+	case cli.CommandHistory:
+		os.Exit(runHistory(config))
+	case cli.CommandLogs:
+		os.Exit(runLogs(config))
+	}
+
 	// Create output handler
 	outputHandler, err := config.CreateOutputHandler()
 	if err != nil {
@@ -27,51 +50,106 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Create secret manager
-	secretManager := secret.NewManager()
-	// TODO: Register vaults based on configuration
-
-	// Create interpreter registry
-	registry := interpreter.NewRegistry()
-	registry.Register(interpreter.NewBashInterpreter())
-	// TODO: Register other interpreters
-
-	// Create interpreter
-	interpreter := interpreter.NewBashInterpreter()
+	var trace *output.Trace
+	if config.Trace != "" {
+		trace = output.NewTrace()
+		outputHandler.AddListener(trace.Event)
+	}
 
-	// Create task executor
-	executor := task.NewExecutor(outputHandler, secretManager, interpreter)
+	// Trace runs exported to OpenTelemetry, within the trace of the process running kontraktor if any
+	var exporter *telemetry.Exporter
+	var runSpan telemetry.SpanContext
+	var runParent string
+	if config.Command == cli.CommandRun {
+		exporter, err = config.CreateExporter()
+		if err != nil {
+			outputHandler.Error("%v", err)
+			os.Exit(1)
+		}
+	}
+	if exporter != nil {
+		runSpan, runParent = telemetry.NewRunSpan(os.Getenv("TRACEPARENT"))
+		if runSpan.Sampled {
+			outputHandler.AddListener(exporter.Event)
+		}
+	}
 
 	// Load the taskfile
-	taskfile, err := taskfile.ParseTaskfile("taskfile.ktr.yml")
+	taskfile, err := taskfile.ParseTaskfileTimed(config.Taskfile, func(t taskfile.ImportTiming) {
+		phase := output.Event{Type: output.EventPhase, Time: t.Start, Phase: output.PhaseImport, File: t.Path, Status: output.StatusSuccess}
+		if runSpan.IsValid() {
+			phase.TraceID, phase.SpanID, phase.ParentSpanID = runSpan.TraceID, telemetry.NewSpanID(), runSpan.SpanID
+		}
+		phase.Duration(t.Duration)
+		if t.Err != nil {
+			phase.Status = output.StatusFailure
+			phase.Error = t.Err.Error()
+		}
+		outputHandler.Emit(phase)
+	})
 	if err != nil {
 		outputHandler.Error("Failed to load taskfile: %v", err)
+		writeTrace(outputHandler, trace, config.Trace)
+		exportTrace(outputHandler, exporter)
 		os.Exit(1)
 	}
 
-	// Get the task definition
-	taskDef, exists := taskfile.Tasks[config.TaskName]
-	if !exists {
-		outputHandler.Error("Task '%s' not found in taskfile", config.TaskName)
+	// Mask the sensitive data listed by the taskfile; patterns are checked by taskfile validation
+	for _, m := range taskfile.Mask {
+		re, _ := m.Regexp()
+		outputHandler.AddMaskPattern(re)
+	}
+
+	for name, t := range taskfile.Tasks {
+		if t.Output != "" {
+			mode, _ := output.ParseMode(t.Output) // checked by taskfile validation
+			outputHandler.SetTaskMode(name, mode)
+		}
+	}
+
+	// Create task executor
+	executor, err := newExecutor(outputHandler, taskfile, config)
+	if err != nil {
+		outputHandler.Error("%v", err)
 		os.Exit(1)
 	}
+	if runSpan.IsValid() {
+		executor.SetTrace(runSpan, runParent)
+	}
+
+	if config.Command == cli.CommandExplain {
+		os.Exit(runExplain(executor, outputHandler, config))
+	}
 
-	// Convert taskfile.Task to task.Task
-	taskToExecute := &task.Task{
-		Desc:        taskDef.Desc,
-		Args:        convertTaskArgs(taskDef.Args),
-		Cmds:        convertTaskCmds(taskDef.Cmds),
-		Environment: make(map[string]string),
+	var report *output.JUnitReport
+	if config.JUnit != "" {
+		report = output.NewJUnitReport()
+		outputHandler.AddListener(report.Event)
 	}
 
-	// Copy global environment variables
-	for k, v := range taskfile.Environment {
-		taskToExecute.Environment[k] = v
+	if config.Resume {
+		checkpoint, err := loadCheckpoint(config, taskfile)
+		if err != nil {
+			outputHandler.Error("%v", err)
+			os.Exit(1)
+		}
+		// Arguments of the command line override those of the run resumed
+		config.TaskName = checkpoint.Task
+		for k, v := range checkpoint.Args {
+			if _, ok := config.TaskArgs[k]; !ok {
+				config.TaskArgs[k] = v
+			}
+		}
+		outputHandler.Info("Resuming run %s of task %s", checkpoint.RunID, checkpoint.Task)
+		executor.Resume(checkpoint)
 	}
 
-	// Copy task-specific environment variables (overriding globals)
-	for k, v := range taskDef.Environment {
-		taskToExecute.Environment[k] = v
+	store, recorder := recordRun(outputHandler, config, taskfile)
+	if recorder != nil {
+		executor.OnCheckpoint(func(checkpoint *task.Checkpoint) {
+			checkpoint.TaskfileHash = taskfile.Hash
+			recorder.Checkpoint(checkpoint)
+		})
 	}
 
 	// Execute the task
@@ -80,30 +158,213 @@ func main() {
 	for k, v := range config.TaskArgs {
 		args[k] = v
 	}
-	err = executor.Execute(ctx, taskToExecute, args)
+	err = executor.Run(ctx, config.TaskName, args)
+	if recorder != nil {
+		if err := recorder.Err(); err != nil {
+			outputHandler.Error("%v", err)
+		}
+		if err := store.Prune(config.HistoryMaxRuns, config.HistoryMaxAge, time.Now()); err != nil {
+			outputHandler.Error("%v", err)
+		}
+	}
+	if report != nil {
+		if err := report.WriteFile(config.JUnit); err != nil {
+			outputHandler.Error("%v", err)
+			os.Exit(1)
+		}
+	}
+	if !writeTrace(outputHandler, trace, config.Trace) {
+		os.Exit(1)
+	}
+	exportTrace(outputHandler, exporter)
 	if err != nil {
 		outputHandler.Error("Task execution failed: %v", err)
 		os.Exit(1)
 	}
 }
 
+// recordRun starts recording the run in the run history unless --no-history is given. Values of
+// secret arguments are recorded masked. Failures are reported but do not change the exit code of the run.
+func recordRun(outputHandler *output.Handler, config *cli.Config, tf *taskfile.Taskfile) (*history.Store, *history.Recorder) {
+	if config.NoHistory {
+		return nil, nil
+	}
+	store, err := config.CreateHistoryStore()
+	if err != nil {
+		outputHandler.Error("%v", err)
+		return nil, nil
+	}
+	path, err := filepath.Abs(config.Taskfile)
+	if err != nil {
+		outputHandler.Error("%v", err)
+		return nil, nil
+	}
+	args := make(map[string]string, len(config.TaskArgs))
+	for k, v := range config.TaskArgs {
+		args[k] = v
+	}
+	if t, ok := tf.Tasks[config.TaskName]; ok {
+		for _, arg := range t.Args {
+			if _, ok := args[arg.Name]; ok && arg.Type == "secret" {
+				args[arg.Name] = output.Masked
+			}
+		}
+	}
+	recorder := store.NewRecorder(path, args, outputHandler.MaskSensitiveData)
+	outputHandler.AddListener(recorder.Event)
+	return store, recorder
+}
+
+// loadCheckpoint returns the checkpoint of the run to resume: the configured run, or the most
+// recent run of the taskfile. Runs of a taskfile that changed since are
+// only resumed with --force.
+func loadCheckpoint(config *cli.Config, tf *taskfile.Taskfile) (*task.Checkpoint, error) {
+	store, err := config.CreateHistoryStore()
+	if err != nil {
+		return nil, err
+	}
+	var run *history.Run
+	if config.RunID != "" {
+		run, err = store.Load(config.RunID)
+	} else {
+		var path string
+		if path, err = filepath.Abs(config.Taskfile); err == nil {
+			run, err = store.Last(path)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if run.Status == output.StatusSuccess {
+		return nil, fmt.Errorf("run %s succeeded: there is nothing to resume", run.ID)
+	}
+	checkpoint, err := store.LoadCheckpoint(run)
+	if err != nil {
+		return nil, err
+	}
+	if checkpoint.TaskfileHash != tf.Hash && !config.Force {
+		return nil, fmt.Errorf("taskfile changed since run %s: use --force to resume it anyway", run.ID)
+	}
+	return checkpoint, nil
+}
+
+// writeTrace writes the trace of a run to path if it is recorded, and reports whether it succeeded
+func writeTrace(outputHandler *output.Handler, trace *output.Trace, path string) bool {
+	if trace == nil {
+		return true
+	}
+	if err := trace.WriteFile(path); err != nil {
+		outputHandler.Error("%v", err)
+		return false
+	}
+	return true
+}
+
+// exportTrace exports the trace of a run if runs are exported. Failures are reported but do not
+// change the exit code of the run.
+func exportTrace(outputHandler *output.Handler, exporter *telemetry.Exporter) {
+	if exporter == nil {
+		return
+	}
+	if err := exporter.Export(context.Background()); err != nil {
+		outputHandler.Error("%v", err)
+	}
+}
+
+// newExecutor creates a task executor for the tasks, environment and vaults of a taskfile
+func newExecutor(outputHandler *output.Handler, tf *taskfile.Taskfile, config *cli.Config) (*task.Executor, error) {
+	// Create secret manager
+	secretManager := secret.NewManager()
+	secretManager.SetWarningHandler(func(message string) {
+		outputHandler.Warning("%s", message)
+	})
+	secretManager.SetConcurrency(config.SecretFetches)
+	cache, err := config.CreateSecretCache()
+	if err != nil {
+		return nil, err
+	}
+	if cache != nil {
+		secretManager.SetDiskCache(cache)
+	}
+	if tf.Vaults != nil {
+		for _, name := range tf.Vaults.Order {
+			vaultType, vaultName, _ := strings.Cut(name, ".")
+			options := secret.VaultOptions{Priority: tf.Vaults.Options[name].Priority, AllowOverride: tf.Vaults.Options[name].AllowOverride}
+			switch vaultType {
+			case "azure_keyvault":
+				secretManager.RegisterVaultWithOptions(name, vault.NewAzureVault(tf.Vaults.AzureKeyVault[vaultName], nil), options)
+			case "hashicorp_vault":
+				secretManager.RegisterVaultWithOptions(name, vault.NewHashiCorpVault(tf.Vaults.HashiCorpVault[vaultName], os.Getenv), options)
+			case "aws_secrets_manager":
+				secretManager.RegisterVaultWithOptions(name, vault.NewAWSSecretsManagerVault(tf.Vaults.AWSSecretsManager[vaultName], os.Getenv), options)
+			case "aws_ssm":
+				secretManager.RegisterVaultWithOptions(name, vault.NewAWSSSMVault(tf.Vaults.AWSSSM[vaultName], os.Getenv), options)
+			default:
+				// Registered vault types are described and validated, but this build cannot fetch their secrets
+				return nil, fmt.Errorf("vault %s: vault type %s is not supported by this build of kontraktor", name, vaultType)
+			}
+		}
+	}
+
+	// Create interpreter registry; task references run through the executor
+	var executor *task.Executor
+	registry := interpreter.NewDefaultRegistry(func(ctx context.Context, taskName string, args map[string]interface{}, taskCtx *interpreter.TaskContext) (*interpreter.Result, error) {
+		return executor.ExecuteReference(ctx, taskName, args, taskCtx)
+	})
+
+	executor = task.NewExecutor(outputHandler, secretManager, registry)
+	executor.SetEnvironment(tf.Environment)
+	executor.SetVars(convertVars(tf.Vars), tf.Dir)
+	precedence, _ := vars.ParsePrecedence(tf.Precedence) // checked by taskfile validation
+	executor.SetPrecedence(precedence)
+	for name, taskDef := range tf.Tasks {
+		executor.AddTask(name, &task.Task{
+			Desc:        taskDef.Desc,
+			Args:        convertTaskArgs(taskDef.Args),
+			Cmds:        convertTaskCmds(taskDef.File, taskDef.Cmds),
+			Environment: taskDef.Environment,
+			Vars:        convertVars(taskDef.Vars),
+			Secrets:     taskDef.Secrets,
+			Dir:         taskDef.Dir,
+		})
+	}
+	return executor, nil
+}
+
 func convertTaskArgs(args []taskfile.TaskArg) []task.TaskArg {
 	result := make([]task.TaskArg, len(args))
 	for i, arg := range args {
 		result[i] = task.TaskArg{
 			Name:    arg.Name,
-			Default: fmt.Sprint(arg.Default),
+			Default: arg.Default,
+			Secret:  arg.Type == "secret",
+		}
+	}
+	return result
+}
+
+func convertVars(vs map[string]taskfile.Var) map[string]task.Var {
+	result := make(map[string]task.Var, len(vs))
+	for name, v := range vs {
+		result[name] = task.Var{
+			Value:    v.Value,
+			Sh:       v.Sh,
+			File:     v.File,
+			JSONPath: v.JSONPath,
 		}
 	}
 	return result
 }
 
-func convertTaskCmds(cmds []taskfile.TaskCmd) []interpreter.Command {
+func convertTaskCmds(file string, cmds []taskfile.TaskCmd) []interpreter.Command {
 	result := make([]interpreter.Command, len(cmds))
 	for i, cmd := range cmds {
 		result[i] = interpreter.Command{
 			Type:    cmd.Type,
 			Content: cmd.Content,
+			File:    file,
+			Line:    cmd.Line,
+			Column:  cmd.Column,
 		}
 	}
 	return result
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kontraktor-sh/kontraktor/internal/cli"
+	"github.com/kontraktor-sh/kontraktor/internal/output"
+	"github.com/kontraktor-sh/kontraktor/internal/taskfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewExecutor_UnsupportedVaultType(t *testing.T) {
+	taskfile.RegisterVaultType("secret_file", struct {
+		Secrets map[string]string `yaml:"secrets"`
+	}{})
+	t.Cleanup(func() { taskfile.UnregisterVaultType("secret_file") })
+
+	path := filepath.Join(t.TempDir(), "taskfile.ktr.yml")
+	require.NoError(t, os.WriteFile(path, []byte(`version: "0.3"
+vaults:
+  secret_file:
+    local:
+      secrets:
+        API_KEY: api-key
+tasks:
+  deploy:
+    desc: Deploy
+    secrets: [API_KEY]
+    cmds:
+      - echo deploy
+`), 0o600))
+	tf, err := taskfile.ParseTaskfile(path)
+	require.NoError(t, err)
+	require.NoError(t, tf.Validate())
+
+	_, err = newExecutor(output.NewHandler(), tf, &cli.Config{SecretFetches: 1})
+	assert.EqualError(t, err, "vault secret_file.local: vault type secret_file is not supported by this build of kontraktor")
+}
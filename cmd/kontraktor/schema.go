@@ -0,0 +1,27 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/kontraktor-sh/kontraktor/internal/schema"
+	"github.com/kontraktor-sh/kontraktor/internal/taskfile/interpreter"
+)
+
+// defaultRegistry returns the interpreters available in this binary, used to
+// check command types and describe their content. Task references are not executed.
+func defaultRegistry() *interpreter.Registry {
+	return interpreter.NewDefaultRegistry(nil)
+}
+
+// runSchema prints the taskfile JSON Schema and returns the process exit code
+func runSchema() int {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(schema.Taskfile(defaultRegistry().Interpreters())); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/kontraktor-sh/kontraktor/internal/cli"
+	"github.com/kontraktor-sh/kontraktor/internal/taskfile"
+)
+
+// runValidate lints the configured taskfile and returns the process exit code
+func runValidate(config *cli.Config) int {
+	registry := defaultRegistry()
+	diags, err := taskfile.Lint(config.Taskfile, taskfile.LintOptions{
+		KnownCommandType: registry.CanHandle,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	switch config.Format {
+	case cli.FormatJSON:
+		if diags == nil {
+			diags = []taskfile.Diagnostic{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(diags); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+	default:
+		errors, warnings := 0, 0
+		for _, d := range diags {
+			fmt.Println(d)
+			if d.Severity == taskfile.SeverityError {
+				errors++
+			} else {
+				warnings++
+			}
+		}
+		if len(diags) == 0 {
+			fmt.Printf("%s: no problems found\n", config.Taskfile)
+		} else {
+			fmt.Printf("%d error(s), %d warning(s)\n", errors, warnings)
+		}
+	}
+
+	if taskfile.HasErrors(diags) {
+		return 1
+	}
+	return 0
+}
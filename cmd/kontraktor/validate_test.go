@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/kontraktor-sh/kontraktor/internal/cli"
+	"github.com/kontraktor-sh/kontraktor/internal/taskfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// captureStdout returns what fn writes to os.Stdout
+func captureStdout(t *testing.T, fn func()) string {
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	done := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(r)
+		done <- data
+	}()
+	fn()
+	w.Close()
+	return string(<-done)
+}
+
+func TestRunValidate_JSONWithGitImport(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	repo := filepath.Join(dir, "repo")
+	require.NoError(t, os.MkdirAll(repo, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(repo, "lib.ktr.yml"), []byte("version: \"0.3\"\ntasks:\n  lint:\n    desc: Lint\n    cmds:\n      - echo lint\n"), 0o600))
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "lib.ktr.yml"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "lib"},
+		{"clone", "-q", "--bare", repo, filepath.Join(dir, "repo.git")},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repo
+		out, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(out))
+	}
+	path := filepath.Join(dir, "taskfile.ktr.yml")
+	require.NoError(t, os.WriteFile(path, []byte("version: \"0.3\"\nimports:\n  - file://"+dir+"/repo.git//lib.ktr.yml\ntasks:\n  build:\n    desc: Build\n    cmds:\n      - echo build\n"), 0o600))
+
+	var code int
+	out := captureStdout(t, func() {
+		code = runValidate(&cli.Config{Taskfile: path, Format: cli.FormatJSON})
+	})
+
+	// Clone progress stays off stdout, which holds the report alone
+	var diags []taskfile.Diagnostic
+	require.NoError(t, json.Unmarshal([]byte(out), &diags), out)
+	assert.Empty(t, diags)
+	assert.Equal(t, 0, code)
+}
@@ -1,11 +1,20 @@
 package cli
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/kontraktor-sh/kontraktor/internal/history"
 	"github.com/kontraktor-sh/kontraktor/internal/output"
+	"github.com/kontraktor-sh/kontraktor/internal/secret"
+	"github.com/kontraktor-sh/kontraktor/internal/telemetry"
 )
 
 // VerbosityLevel represents the output verbosity level
@@ -22,24 +31,150 @@ const (
 	VerbosityDebug VerbosityLevel = "DEBUG"
 )
 
+// Commands understood by the CLI
+const (
+	// CommandRun executes a task
+	CommandRun = "run"
+	// CommandValidate lints the taskfile and reports all problems found
+	CommandValidate = "validate"
+	// CommandSchema prints the JSON Schema of taskfiles
+	CommandSchema = "schema"
+	// CommandLSP serves the Language Server Protocol over stdio
+	CommandLSP = "lsp"
+	// CommandInit writes a starter taskfile from a template
+	CommandInit = "init"
+	// CommandExplain shows where the variables of a task come from
+	CommandExplain = "explain"
+	// CommandHistory lists past runs
+	CommandHistory = "history"
+	// CommandLogs replays the output of a past run
+	CommandLogs = "logs"
+)
+
+// Output formats for commands producing reports
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// DefaultTaskfile is the taskfile loaded when no other path is given
+const DefaultTaskfile = "taskfile.ktr.yml"
+
 // Config holds the CLI configuration
 type Config struct {
-	Verbosity    VerbosityLevel
-	TaskName     string
-	TaskArgs     map[string]string
-	MaskPatterns []string
+	Command        string
+	Taskfile       string
+	Verbosity      VerbosityLevel
+	TaskName       string
+	TaskArgs       map[string]string
+	Output         string
+	OutputMode     string
+	JUnit          string
+	Trace          string
+	Summary        bool
+	OTLPEndpoint   string
+	OTLPProtocol   string
+	OTLPFile       string
+	CI             string
+	MaskPatterns   []string
+	NoDefaultMasks bool
+	Format         string
+	Template       string
+	TemplateVars   map[string]string
+	Force          bool
+	StateDir       string
+	NoHistory      bool
+	HistoryMaxRuns int
+	HistoryMaxAge  time.Duration
+	SecretCacheTTL time.Duration // Disk cache of secrets disabled when zero
+	SecretFetches  int           // Secrets and vaults fetched at the same time
+	HistoryFilter  history.Filter
+	HistoryAll     bool
+	RunID          string // Run of the logs command, or run resumed by the run command
+	Step           string
+	Resume         bool
 }
 
+const usage = `usage: kontraktor [flags] <command> [args...]
+
+flags:
+  --taskfile <path>      path to the taskfile (default taskfile.ktr.yml)
+  --verbosity <level>    SILENT, ERROR, INFO or DEBUG
+  --output text|json     output of run: text, or newline-delimited JSON events
+  --output-mode <mode>   print task output interleaved, grouped or quiet-success
+  --junit <path>         write a JUnit XML report of run
+  --trace <path>         write a Chrome trace of run, for Perfetto
+  --summary              print a table of the steps of run and their durations
+  --otlp-endpoint <url>  export run as an OpenTelemetry trace to a collector
+  --otlp-protocol grpc|http/protobuf|http/json
+                         OTLP protocol of --otlp-endpoint (default http/protobuf)
+  --otlp-file <path>     append run as an OTLP JSON trace to a file
+  --ci github|gitlab|none
+                         format text output for a CI system (detected by default)
+  --mask <regex>         mask matches in output, or only their capture groups (repeatable)
+  --no-default-masks     do not mask values of credential assignments such as token=...
+  --state-dir <path>     directory of the run history and secret cache (default ~/.local/state/kontraktor)
+  --no-history           do not record run in the run history
+  --history-max-runs <n> number of runs kept in the run history (default 100, 0 for no limit)
+  --history-max-age <age>
+                         age of the oldest run kept in the run history (default 30d, 0 for no limit)
+  --secret-cache-ttl <duration>
+                         keep fetched secrets in an encrypted disk cache for a duration such as 5m
+  --secret-fetches <n>   number of secrets fetched at the same time (default 8)
+
+commands:
+  run <taskname> [key=value...]                      run a task
+  run --resume [run-id] [--force] [key=value...]     resume a failed run from its failed step
+  validate [--format text|json] [taskfile]           report all problems in a taskfile
+  schema                                             print the JSON Schema of taskfiles
+  lsp                                                run the language server over stdio
+  init [--var key=value...] [--force] [template]     write a starter taskfile
+  explain <taskname> [key=value...]                  show where the variables of a task come from
+  history [--task name] [--status status] [--since time] [--limit n] [--all] [--format text|json]
+                                                     list past runs
+  logs <run-id> [step]                               replay the output of a past run`
+
 // ParseFlags parses command line flags and returns the configuration
 func ParseFlags() (*Config, error) {
+	return parseArgs(flag.CommandLine, os.Args[1:])
+}
+
+// parseArgs parses global flags from fs followed by a command and its arguments
+func parseArgs(fs *flag.FlagSet, arguments []string) (*Config, error) {
 	config := &Config{
 		TaskArgs:     make(map[string]string),
 		MaskPatterns: []string{},
+		TemplateVars: make(map[string]string),
 	}
 
-	// Parse verbosity flag
-	verbosity := flag.String("verbosity", string(VerbosityInfo), "Output verbosity level (SILENT, ERROR, INFO, DEBUG)")
-	flag.Parse()
+	// Parse global flags
+	verbosity := fs.String("verbosity", string(VerbosityInfo), "Output verbosity level (SILENT, ERROR, INFO, DEBUG)")
+	fs.StringVar(&config.Taskfile, "taskfile", DefaultTaskfile, "Path to the taskfile")
+	fs.StringVar(&config.Output, "output", FormatText, "Output format of run (text, json)")
+	fs.StringVar(&config.OutputMode, "output-mode", "", "How task output is printed in the text format (interleaved, grouped, quiet-success)")
+	fs.StringVar(&config.CI, "ci", "", "Format text output for a CI system (github, gitlab, none); detected from the environment by default")
+	fs.StringVar(&config.JUnit, "junit", "", "Write a JUnit XML report of run to this file")
+	fs.StringVar(&config.Trace, "trace", "", "Write a trace of run in the Chrome trace event format to this file")
+	fs.BoolVar(&config.Summary, "summary", false, "Print a table of the steps of run with their status and duration when it ends")
+	fs.StringVar(&config.OTLPEndpoint, "otlp-endpoint", "", "Export run as an OpenTelemetry trace to the collector at this URL; defaults to OTEL_EXPORTER_OTLP_ENDPOINT")
+	fs.StringVar(&config.OTLPProtocol, "otlp-protocol", "", "OTLP protocol (grpc, http/protobuf, http/json); defaults to OTEL_EXPORTER_OTLP_PROTOCOL, then http/protobuf")
+	fs.StringVar(&config.OTLPFile, "otlp-file", "", "Append run as an OpenTelemetry trace in the OTLP JSON encoding to this file")
+	fs.Var((*listFlag)(&config.MaskPatterns), "mask", "Regular expression of sensitive data to mask in output (repeatable)")
+	fs.BoolVar(&config.NoDefaultMasks, "no-default-masks", false, "Do not mask values of common credential assignments such as token=...")
+	fs.StringVar(&config.StateDir, "state-dir", "", "Directory of the run history and secret cache; defaults to KONTRAKTOR_STATE_DIR, then kontraktor in XDG_STATE_HOME or ~/.local/state")
+	fs.BoolVar(&config.NoHistory, "no-history", false, "Do not record run in the run history")
+	maxRuns := fs.String("history-max-runs", "", "Number of runs kept in the run history, 0 for no limit; defaults to KONTRAKTOR_HISTORY_MAX_RUNS, then 100")
+	maxAge := fs.String("history-max-age", "", "Age of the oldest run kept in the run history, such as 12h or 30d, 0 for no limit; defaults to KONTRAKTOR_HISTORY_MAX_AGE, then 30d")
+	cacheTTL := fs.String("secret-cache-ttl", "", "Keep fetched secrets in an encrypted disk cache for this duration, such as 5m; defaults to KONTRAKTOR_SECRET_CACHE_TTL, then no cache")
+	fetches := fs.String("secret-fetches", "", "Number of secrets and vaults fetched at the same time; defaults to KONTRAKTOR_SECRET_FETCHES, then 8")
+	if err := fs.Parse(arguments); err != nil {
+		return nil, err
+	}
+	for _, pattern := range config.MaskPatterns {
+		if _, err := compileMaskPattern(pattern); err != nil {
+			return nil, err
+		}
+	}
 
 	// Set verbosity level
 	switch strings.ToUpper(*verbosity) {
@@ -55,32 +190,330 @@ func ParseFlags() (*Config, error) {
 		return nil, fmt.Errorf("invalid verbosity level: %s", *verbosity)
 	}
 
-	// Get task name and arguments
-	args := flag.Args()
-	if len(args) < 2 || args[0] != "run" {
-		return nil, fmt.Errorf("usage: kontraktor run <taskname> [args...]")
+	args := fs.Args()
+	if len(args) < 1 {
+		return nil, errors.New(usage)
+	}
+	config.Command = args[0]
+
+	if config.Output != FormatText && config.Output != FormatJSON {
+		return nil, fmt.Errorf("invalid output format: %s (expected %s or %s)", config.Output, FormatText, FormatJSON)
+	}
+	if config.Output == FormatJSON && config.Command != CommandRun {
+		return nil, fmt.Errorf("--output %s is only supported by the %s command", FormatJSON, CommandRun)
+	}
+	if config.OutputMode != "" {
+		if _, err := output.ParseMode(config.OutputMode); err != nil {
+			return nil, err
+		}
+	}
+	switch output.CI(config.CI) {
+	case "", output.CINone, output.CIGitHub, output.CIGitLab:
+	default:
+		return nil, fmt.Errorf("invalid CI system: %s (expected %s, %s or %s)", config.CI, output.CIGitHub, output.CIGitLab, output.CINone)
+	}
+	if config.JUnit != "" && config.Command != CommandRun {
+		return nil, fmt.Errorf("--junit is only supported by the %s command", CommandRun)
+	}
+	if config.Trace != "" && config.Command != CommandRun {
+		return nil, fmt.Errorf("--trace is only supported by the %s command", CommandRun)
+	}
+	if config.Summary && config.Command != CommandRun {
+		return nil, fmt.Errorf("--summary is only supported by the %s command", CommandRun)
+	}
+	if config.OTLPProtocol != "" && !telemetry.IsProtocol(config.OTLPProtocol) {
+		return nil, fmt.Errorf("unknown OTLP protocol '%s' (expected %s)", config.OTLPProtocol, strings.Join(telemetry.Protocols, ", "))
+	}
+	if (config.OTLPEndpoint != "" || config.OTLPProtocol != "" || config.OTLPFile != "") && config.Command != CommandRun {
+		return nil, fmt.Errorf("--otlp-endpoint, --otlp-protocol and --otlp-file are only supported by the %s command", CommandRun)
+	}
+	if (config.NoHistory || *maxRuns != "" || *maxAge != "") && config.Command != CommandRun {
+		return nil, fmt.Errorf("--no-history, --history-max-runs and --history-max-age are only supported by the %s command", CommandRun)
+	}
+	if err := config.parseRetention(*maxRuns, *maxAge); err != nil {
+		return nil, err
+	}
+	if (*cacheTTL != "" || *fetches != "") && config.Command != CommandRun {
+		return nil, fmt.Errorf("--secret-cache-ttl and --secret-fetches are only supported by the %s command", CommandRun)
+	}
+	if err := config.parseSecretOptions(*cacheTTL, *fetches); err != nil {
+		return nil, err
+	}
+
+	var err error
+	switch config.Command {
+	case CommandRun, CommandExplain:
+		err = config.parseRunArgs(args[1:])
+	case CommandValidate:
+		err = config.parseValidateArgs(args[1:])
+	case CommandInit:
+		err = config.parseInitArgs(args[1:])
+	case CommandHistory:
+		err = config.parseHistoryArgs(args[1:])
+	case CommandLogs:
+		err = config.parseLogsArgs(args[1:])
+	case CommandSchema, CommandLSP:
+		if len(args) > 1 {
+			err = fmt.Errorf("usage: kontraktor %s", config.Command)
+		}
+	default:
+		err = fmt.Errorf("unknown command '%s'\n%s", config.Command, usage)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// parseRunArgs parses the task name and key=value task arguments of the run and explain commands,
+// or the --resume and --force flags and optional run ID of run resuming a run
+func (c *Config) parseRunArgs(args []string) error {
+	var positional []string
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "-") || strings.Contains(arg, "=") {
+			positional = append(positional, arg)
+			continue
+		}
+		switch strings.TrimLeft(arg, "-") {
+		case "resume":
+			c.Resume = true
+		case "force":
+			c.Force = true
+		default:
+			return fmt.Errorf("unknown flag %s of the %s command", arg, c.Command)
+		}
+	}
+	args = positional
+	if (c.Resume || c.Force) && c.Command != CommandRun {
+		return fmt.Errorf("--resume and --force are only supported by the %s command", CommandRun)
+	}
+	if c.Force && !c.Resume {
+		return fmt.Errorf("--force is only supported with --resume")
 	}
 
-	config.TaskName = args[1]
+	if c.Resume {
+		// The task is the task of the run resumed
+		if len(args) > 0 && !strings.Contains(args[0], "=") {
+			c.RunID = args[0]
+			args = args[1:]
+		}
+	} else {
+		if len(args) < 1 {
+			return fmt.Errorf("usage: kontraktor %s <taskname> [args...]", c.Command)
+		}
+		c.TaskName = args[0]
+		args = args[1:]
+	}
 
 	// Parse task arguments (key=value pairs)
-	for _, arg := range args[2:] {
+	for _, arg := range args {
 		parts := strings.SplitN(arg, "=", 2)
 		if len(parts) != 2 {
-			return nil, fmt.Errorf("invalid argument format: %s (expected key=value)", arg)
+			return fmt.Errorf("invalid argument format: %s (expected key=value)", arg)
 		}
-		config.TaskArgs[parts[0]] = parts[1]
+		c.TaskArgs[parts[0]] = parts[1]
+	}
+	return nil
+}
+
+// parseValidateArgs parses the flags and optional taskfile path of the validate command
+func (c *Config) parseValidateArgs(args []string) error {
+	fs := flag.NewFlagSet(CommandValidate, flag.ContinueOnError)
+	fs.StringVar(&c.Format, "format", FormatText, "Report format (text, json)")
+	if err := fs.Parse(args); err != nil {
+		return err
 	}
+	if c.Format != FormatText && c.Format != FormatJSON {
+		return fmt.Errorf("invalid format: %s (expected %s or %s)", c.Format, FormatText, FormatJSON)
+	}
+	switch fs.NArg() {
+	case 0:
+	case 1:
+		c.Taskfile = fs.Arg(0)
+	default:
+		return fmt.Errorf("usage: kontraktor validate [--format text|json] [taskfile]")
+	}
+	return nil
+}
 
-	// Add default mask patterns for sensitive data
-	config.MaskPatterns = append(config.MaskPatterns,
-		"password=.*",
-		"secret=.*",
-		"key=.*",
-		"token=.*",
-	)
+// parseInitArgs parses the flags and optional template of the init command
+func (c *Config) parseInitArgs(args []string) error {
+	fs := flag.NewFlagSet(CommandInit, flag.ContinueOnError)
+	fs.Var(keyValueFlag(c.TemplateVars), "var", "Template variable as key=value (repeatable)")
+	fs.BoolVar(&c.Force, "force", false, "Overwrite an existing taskfile")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	switch fs.NArg() {
+	case 0:
+	case 1:
+		c.Template = fs.Arg(0)
+	default:
+		return fmt.Errorf("usage: kontraktor init [--var key=value...] [--force] [template]")
+	}
+	return nil
+}
 
-	return config, nil
+// parseRetention parses the retention limits of the run history, defaulting to
+// KONTRAKTOR_HISTORY_MAX_RUNS and KONTRAKTOR_HISTORY_MAX_AGE
+func (c *Config) parseRetention(maxRuns, maxAge string) error {
+	if maxRuns == "" {
+		maxRuns = os.Getenv("KONTRAKTOR_HISTORY_MAX_RUNS")
+	}
+	c.HistoryMaxRuns = history.DefaultMaxRuns
+	if maxRuns != "" {
+		n, err := strconv.Atoi(maxRuns)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid number of runs to keep '%s' (expected a number, 0 for no limit)", maxRuns)
+		}
+		c.HistoryMaxRuns = n
+	}
+	if maxAge == "" {
+		maxAge = os.Getenv("KONTRAKTOR_HISTORY_MAX_AGE")
+	}
+	c.HistoryMaxAge = history.DefaultMaxAge
+	if maxAge != "" {
+		age, err := history.ParseAge(maxAge)
+		if err != nil {
+			return err
+		}
+		c.HistoryMaxAge = age
+	}
+	return nil
+}
+
+// parseSecretOptions parses the disk cache duration and number of concurrent fetches of secrets,
+// defaulting to KONTRAKTOR_SECRET_CACHE_TTL and KONTRAKTOR_SECRET_FETCHES
+func (c *Config) parseSecretOptions(cacheTTL, fetches string) error {
+	if cacheTTL == "" {
+		cacheTTL = os.Getenv("KONTRAKTOR_SECRET_CACHE_TTL")
+	}
+	if cacheTTL != "" {
+		ttl, err := time.ParseDuration(cacheTTL)
+		if err != nil || ttl < 0 {
+			return fmt.Errorf("invalid secret cache duration '%s' (expected a duration such as 5m, 0 for no cache)", cacheTTL)
+		}
+		c.SecretCacheTTL = ttl
+	}
+	if fetches == "" {
+		fetches = os.Getenv("KONTRAKTOR_SECRET_FETCHES")
+	}
+	c.SecretFetches = secret.DefaultConcurrency
+	if fetches != "" {
+		n, err := strconv.Atoi(fetches)
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid number of secret fetches '%s' (expected a positive number)", fetches)
+		}
+		c.SecretFetches = n
+	}
+	return nil
+}
+
+// parseHistoryArgs parses the filters of the history command
+func (c *Config) parseHistoryArgs(args []string) error {
+	fs := flag.NewFlagSet(CommandHistory, flag.ContinueOnError)
+	fs.StringVar(&c.HistoryFilter.Task, "task", "", "List only runs of this task")
+	fs.StringVar(&c.HistoryFilter.Status, "status", "", "List only runs with this status (success, failure, running)")
+	since := fs.String("since", "", "List only runs started since a time (2006-01-02 or RFC 3339) or for a duration (24h, 7d)")
+	fs.IntVar(&c.HistoryFilter.Limit, "limit", 20, "Number of runs listed, 0 for no limit")
+	fs.BoolVar(&c.HistoryAll, "all", false, "List runs of all taskfiles, not only of the taskfile")
+	fs.StringVar(&c.Format, "format", FormatText, "Report format (text, json)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("usage: kontraktor history [--task name] [--status status] [--since time] [--limit n] [--all] [--format text|json]")
+	}
+	switch c.HistoryFilter.Status {
+	case "", output.StatusSuccess, output.StatusFailure, history.StatusRunning:
+	default:
+		return fmt.Errorf("invalid status: %s (expected %s, %s or %s)", c.HistoryFilter.Status, output.StatusSuccess, output.StatusFailure, history.StatusRunning)
+	}
+	if c.HistoryFilter.Limit < 0 {
+		return fmt.Errorf("invalid limit: %d (expected a number, 0 for no limit)", c.HistoryFilter.Limit)
+	}
+	if c.Format != FormatText && c.Format != FormatJSON {
+		return fmt.Errorf("invalid format: %s (expected %s or %s)", c.Format, FormatText, FormatJSON)
+	}
+	if *since != "" {
+		t, err := parseSince(*since, time.Now())
+		if err != nil {
+			return err
+		}
+		c.HistoryFilter.Since = t
+	}
+	return nil
+}
+
+// parseSince parses a point in time given as a date, an RFC 3339 time, or a duration before now
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, s, time.Local); err == nil {
+		return t, nil
+	}
+	if age, err := history.ParseAge(s); err == nil {
+		return now.Add(-age), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time '%s' (expected a date such as 2006-01-02, an RFC 3339 time, or a duration such as 24h or 7d)", s)
+}
+
+// parseLogsArgs parses the run ID and optional step of the logs command
+func (c *Config) parseLogsArgs(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("usage: kontraktor logs <run-id> [step]")
+	}
+	c.RunID = args[0]
+	if len(args) == 2 {
+		c.Step = args[1]
+	}
+	return nil
+}
+
+// keyValueFlag collects repeated key=value flags into a map
+type keyValueFlag map[string]string
+
+func (f keyValueFlag) String() string {
+	pairs := make([]string, 0, len(f))
+	for k, v := range f {
+		pairs = append(pairs, k+"="+v)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (f keyValueFlag) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("invalid value %s (expected key=value)", value)
+	}
+	f[parts[0]] = parts[1]
+	return nil
+}
+
+// listFlag collects repeated flags into a slice
+type listFlag []string
+
+func (f *listFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *listFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// compileMaskPattern compiles a mask pattern given on the command line
+func compileMaskPattern(pattern string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mask pattern '%s': %w", pattern, err)
+	}
+	if re.MatchString("") {
+		return nil, fmt.Errorf("invalid mask pattern '%s': matches empty text", pattern)
+	}
+	return re, nil
 }
 
 // CreateOutputHandler creates an output handler based on the configuration
@@ -99,10 +532,95 @@ func (c *Config) CreateOutputHandler() (*output.Handler, error) {
 		handler.SetLevel(output.LevelDebug)
 	}
 
+	if c.Output == FormatJSON {
+		handler.SetFormat(output.FormatJSON)
+	}
+	if c.OutputMode != "" {
+		mode, _ := output.ParseMode(c.OutputMode) // checked by parseArgs
+		handler.SetMode(mode)
+	}
+	handler.SetColor(colorEnabled())
+	ci := output.CI(c.CI)
+	if ci == "" {
+		ci = output.DetectCI(os.Getenv)
+	}
+	handler.SetCI(ci, os.Getenv("GITHUB_OUTPUT"))
+	if c.Summary {
+		handler.EnableSummary()
+	}
+
 	// Add mask patterns
-	for _, pattern := range c.MaskPatterns {
-		handler.AddMaskPattern(pattern)
+	patterns := c.MaskPatterns
+	if !c.NoDefaultMasks {
+		patterns = append(append([]string{}, output.DefaultMaskPatterns...), patterns...)
+	}
+	for _, pattern := range patterns {
+		re, err := compileMaskPattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		handler.AddMaskPattern(re)
 	}
 
 	return handler, nil
 }
+
+// CreateExporter creates the exporter of runs as OpenTelemetry traces configured by flags and
+// OTEL_* environment variables, or returns nil if runs are not exported
+func (c *Config) CreateExporter() (*telemetry.Exporter, error) {
+	config := telemetry.ConfigFromEnv(os.Getenv)
+	if c.OTLPEndpoint != "" {
+		config.Endpoint, config.TracesEndpoint = c.OTLPEndpoint, ""
+	}
+	if c.OTLPProtocol != "" {
+		config.Protocol = c.OTLPProtocol
+	}
+	config.File = c.OTLPFile
+	if !config.Enabled() {
+		return nil, nil
+	}
+	return telemetry.NewExporter(config)
+}
+
+// CreateHistoryStore returns the run history in the configured state directory
+func (c *Config) CreateHistoryStore() (*history.Store, error) {
+	dir, err := c.stateDir()
+	if err != nil {
+		return nil, err
+	}
+	return history.NewStore(dir), nil
+}
+
+// CreateSecretCache returns the disk cache of secrets in the configured state directory, or nil
+// when secrets are not cached
+func (c *Config) CreateSecretCache() (*secret.DiskCache, error) {
+	if c.SecretCacheTTL == 0 {
+		return nil, nil
+	}
+	dir, err := c.stateDir()
+	if err != nil {
+		return nil, err
+	}
+	return secret.NewDiskCache(filepath.Join(dir, "secrets"), c.SecretCacheTTL), nil
+}
+
+// stateDir returns the configured state directory
+func (c *Config) stateDir() (string, error) {
+	if c.StateDir != "" {
+		return c.StateDir, nil
+	}
+	return history.DefaultStateDir(os.Getenv)
+}
+
+// colorEnabled reports whether output may be colored: standard output is a terminal, or
+// a CI system rendering colors, and NO_COLOR is not set
+func colorEnabled() bool {
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+	if output.DetectCI(os.Getenv) != output.CINone {
+		return true
+	}
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
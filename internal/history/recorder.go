@@ -0,0 +1,153 @@
+// recorder.go
+// Records a run in a store from its events.
+package history
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/kontraktor-sh/kontraktor/internal/output"
+	"github.com/kontraktor-sh/kontraktor/internal/task"
+)
+
+// Recorder records a run and the output of its steps in a store as the run progresses.
+// Register Event with output.Handler.AddListener: events are masked, so the record is too.
+type Recorder struct {
+	store    *Store
+	taskfile string
+	args     map[string]string
+	mask     func(string) string
+
+	run  *Run
+	open []*openStep // Steps running, innermost last
+	err  error       // First error writing the record
+}
+
+// openStep is a running step and its log file
+type openStep struct {
+	step *Step
+	log  *os.File
+}
+
+// NewRecorder creates a recorder of a run of a task of taskfile with arguments args.
+// Arguments are recorded masked by mask, which should mask the values of secret arguments.
+func (s *Store) NewRecorder(taskfile string, args map[string]string, mask func(string) string) *Recorder {
+	return &Recorder{store: s, taskfile: taskfile, args: args, mask: mask}
+}
+
+// Err returns the first error recording the run
+func (r *Recorder) Err() error {
+	return r.err
+}
+
+// Run returns the record of the run, once it started
+func (r *Recorder) Run() *Run {
+	return r.run
+}
+
+// Event records an event of the run
+func (r *Recorder) Event(e output.Event) {
+	if r.run == nil && e.Type != output.EventRunStart {
+		return
+	}
+	switch e.Type {
+	case output.EventRunStart:
+		r.run = &Run{ID: e.RunID, Task: e.Task, Taskfile: r.taskfile, Start: e.Time, Status: StatusRunning, Steps: []*Step{}}
+		r.save()
+	case output.EventStepStart:
+		step := r.addStep(e, StatusRunning)
+		log, err := os.OpenFile(r.store.logPath(r.run.ID, step.Number), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+		r.fail(err)
+		r.open = append(r.open, &openStep{step: step, log: log})
+	case output.EventStepSkipped:
+		r.addStep(e, StatusSkipped)
+	case output.EventOutput:
+		if s := r.current(e.Task, e.Step); s != nil && s.log != nil {
+			_, err := fmt.Fprintln(s.log, e.Line)
+			r.fail(err)
+		}
+	case output.EventStepEnd:
+		s := r.current(e.Task, e.Step)
+		if s == nil {
+			return
+		}
+		for i := len(r.open) - 1; i >= 0; i-- {
+			if r.open[i] == s {
+				r.open = append(r.open[:i], r.open[i+1:]...)
+				break
+			}
+		}
+		if s.log != nil {
+			r.fail(s.log.Close())
+		}
+		s.step.Status = e.Status
+		s.step.Error = e.Error
+		if e.DurationMS != nil {
+			s.step.DurationMS = *e.DurationMS
+		}
+	case output.EventRunEnd:
+		end := e.Time
+		r.run.End = &end
+		r.run.Status = e.Status
+		r.run.Error = e.Error
+		for _, s := range r.open {
+			if s.log != nil {
+				r.fail(s.log.Close())
+			}
+		}
+		r.open = nil
+		r.save()
+	}
+}
+
+// Checkpoint records the checkpoint of the run, to resume it later. Register it with
+// task.Executor.OnCheckpoint.
+func (r *Recorder) Checkpoint(checkpoint *task.Checkpoint) {
+	r.fail(r.store.writeJSON(checkpoint.RunID, "checkpoint.json", checkpoint))
+}
+
+// addStep adds a step to the record of the run
+func (r *Recorder) addStep(e output.Event, status string) *Step {
+	step := &Step{
+		Number:      len(r.run.Steps) + 1,
+		Task:        e.Task,
+		Step:        e.Step,
+		Interpreter: e.Interpreter,
+		Command:     e.Command,
+		Status:      status,
+	}
+	if status == StatusRunning {
+		step.Start = e.Time
+	}
+	r.run.Steps = append(r.run.Steps, step)
+	return step
+}
+
+// current returns the innermost running step of a task
+func (r *Recorder) current(task string, step int) *openStep {
+	for i := len(r.open) - 1; i >= 0; i-- {
+		if r.open[i].step.Task == task && r.open[i].step.Step == step {
+			return r.open[i]
+		}
+	}
+	return nil
+}
+
+// save writes the record of the run, with arguments masked
+func (r *Recorder) save() {
+	r.run.Args = make(map[string]string, len(r.args))
+	for k, v := range r.args {
+		r.run.Args[k] = r.mask(v)
+	}
+	if len(r.run.Args) == 0 {
+		r.run.Args = nil
+	}
+	r.fail(r.store.save(r.run))
+}
+
+// fail keeps the first error recording the run
+func (r *Recorder) fail(err error) {
+	if err != nil && r.err == nil {
+		r.err = fmt.Errorf("failed to record run: %w", err)
+	}
+}
@@ -0,0 +1,90 @@
+package history
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kontraktor-sh/kontraktor/internal/output"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorder(t *testing.T) {
+	store := NewStore(t.TempDir())
+	mask := func(s string) string { return strings.ReplaceAll(s, "s3cr3t", output.Masked) }
+	recorder := store.NewRecorder("/work/taskfile.ktr.yml", map[string]string{"token": "s3cr3t", "env": "prod"}, mask)
+
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	end := func(e output.Event, ms int64) output.Event {
+		e.Duration(time.Duration(ms) * time.Millisecond)
+		return e
+	}
+	events := []output.Event{
+		{Type: output.EventRunStart, Time: start, RunID: "r1", Task: "ci"},
+		{Type: output.EventTaskStart, Task: "ci"},
+		{Type: output.EventStepStart, Time: start, Task: "ci", Step: 1, Interpreter: "bash", Command: "make build"},
+		{Type: output.EventOutput, Task: "ci", Step: 1, Line: "building"},
+		end(output.Event{Type: output.EventStepEnd, Task: "ci", Step: 1, Status: output.StatusSuccess}, 120),
+		{Type: output.EventStepStart, Time: start, Task: "ci", Step: 2, Interpreter: "task"},
+		{Type: output.EventTaskStart, Task: "test"},
+		{Type: output.EventStepStart, Time: start, Task: "test", Step: 1, Interpreter: "bash", Command: "make test"},
+		{Type: output.EventOutput, Task: "test", Step: 1, Line: "FAIL"},
+		end(output.Event{Type: output.EventStepEnd, Task: "test", Step: 1, Status: output.StatusFailure, Error: "exit status 1"}, 30),
+		{Type: output.EventTaskEnd, Task: "test", Status: output.StatusFailure},
+		end(output.Event{Type: output.EventStepEnd, Task: "ci", Step: 2, Status: output.StatusFailure}, 31),
+		{Type: output.EventStepSkipped, Task: "ci", Step: 3, Interpreter: "bash", Command: "make deploy"},
+		{Type: output.EventTaskEnd, Task: "ci", Status: output.StatusFailure},
+	}
+	for _, e := range events {
+		recorder.Event(e)
+	}
+
+	// The run is recorded as running until it ends
+	running, err := store.Load("r1")
+	require.NoError(t, err)
+	assert.Equal(t, StatusRunning, running.Status)
+	assert.Nil(t, running.End)
+
+	recorder.Event(output.Event{Type: output.EventRunEnd, Time: start.Add(time.Second), RunID: "r1", Task: "ci", Status: output.StatusFailure, Error: "command failed"})
+	require.NoError(t, recorder.Err())
+
+	run, err := store.Load("r1")
+	require.NoError(t, err)
+	assert.Equal(t, "ci", run.Task)
+	assert.Equal(t, "/work/taskfile.ktr.yml", run.Taskfile)
+	assert.Equal(t, map[string]string{"token": output.Masked, "env": "prod"}, run.Args)
+	assert.Equal(t, output.StatusFailure, run.Status)
+	assert.Equal(t, "command failed", run.Error)
+	assert.Equal(t, time.Second, run.Duration())
+
+	require.Len(t, run.Steps, 4)
+	expected := []Step{
+		{Number: 1, Task: "ci", Step: 1, Interpreter: "bash", Command: "make build", Status: output.StatusSuccess, Start: start, DurationMS: 120},
+		{Number: 2, Task: "ci", Step: 2, Interpreter: "task", Status: output.StatusFailure, Start: start, DurationMS: 31},
+		{Number: 3, Task: "test", Step: 1, Interpreter: "bash", Command: "make test", Status: output.StatusFailure, Error: "exit status 1", Start: start, DurationMS: 30},
+		{Number: 4, Task: "ci", Step: 3, Interpreter: "bash", Command: "make deploy", Status: StatusSkipped},
+	}
+	for i, step := range run.Steps {
+		step.Start = step.Start.UTC()
+		assert.Equal(t, expected[i], *step)
+	}
+
+	logs := []string{"building\n", "", "FAIL\n", ""}
+	for i, step := range run.Steps {
+		log, err := store.Log(run, step)
+		require.NoError(t, err)
+		assert.Equal(t, logs[i], log, "step %d", step.Number)
+	}
+}
+
+func TestRecorder_IgnoresEventsBeforeRun(t *testing.T) {
+	store := NewStore(t.TempDir())
+	recorder := store.NewRecorder("/work/taskfile.ktr.yml", nil, func(s string) string { return s })
+	recorder.Event(output.Event{Type: output.EventPhase, Phase: output.PhaseImport})
+	assert.Nil(t, recorder.Run())
+
+	runs, err := store.List()
+	require.NoError(t, err)
+	assert.Empty(t, runs)
+}
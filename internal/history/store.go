@@ -0,0 +1,319 @@
+// store.go
+// Stores records of past runs and the output of their steps under a state directory.
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kontraktor-sh/kontraktor/internal/task"
+)
+
+// Statuses of recorded runs and steps, besides the statuses of output events
+const (
+	StatusRunning = "running"
+	StatusSkipped = "skipped"
+)
+
+// Default retention limits of recorded runs
+const (
+	DefaultMaxRuns = 100
+	DefaultMaxAge  = 30 * 24 * time.Hour
+)
+
+// Run is the record of a run
+type Run struct {
+	ID       string            `json:"id"`
+	Task     string            `json:"task"`
+	Taskfile string            `json:"taskfile"` // Absolute path of the taskfile defining the task
+	Args     map[string]string `json:"args,omitempty"`
+	Start    time.Time         `json:"start"`
+	End      *time.Time        `json:"end,omitempty"`
+	Status   string            `json:"status"`
+	Error    string            `json:"error,omitempty"`
+	Steps    []*Step           `json:"steps"`
+}
+
+// Step is the record of a step of a run, including steps of tasks run by other tasks
+type Step struct {
+	Number      int       `json:"number"` // Position of the step in the run, from 1
+	Task        string    `json:"task"`
+	Step        int       `json:"step"` // Position of the step in its task, from 1
+	Interpreter string    `json:"interpreter"`
+	Command     string    `json:"command,omitempty"`
+	Status      string    `json:"status"`
+	Error       string    `json:"error,omitempty"`
+	Start       time.Time `json:"start"`
+	DurationMS  int64     `json:"duration_ms,omitempty"`
+}
+
+// Duration returns the duration of a run, or zero if it has not ended
+func (r *Run) Duration() time.Duration {
+	if r.End == nil {
+		return 0
+	}
+	return r.End.Sub(r.Start)
+}
+
+// Store is a directory of recorded runs, each in a directory named after its ID holding
+// run.json and a log file per step
+type Store struct {
+	dir string
+}
+
+// NewStore returns the store of a state directory. Runs are kept in its runs directory,
+// created when the first run is recorded.
+func NewStore(stateDir string) *Store {
+	return &Store{dir: filepath.Join(stateDir, "runs")}
+}
+
+// DefaultStateDir returns the state directory: KONTRAKTOR_STATE_DIR, or kontraktor in
+// XDG_STATE_HOME or ~/.local/state
+func DefaultStateDir(getenv func(string) string) (string, error) {
+	if dir := getenv("KONTRAKTOR_STATE_DIR"); dir != "" {
+		return dir, nil
+	}
+	if dir := getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "kontraktor"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find state directory: %w", err)
+	}
+	return filepath.Join(home, ".local", "state", "kontraktor"), nil
+}
+
+// ParseAge parses a retention age: a Go duration such as 12h, or a number of days such as 30d
+func ParseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err == nil && n >= 0 {
+			return time.Duration(n) * 24 * time.Hour, nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid age '%s' (expected a duration such as 12h or a number of days such as 30d)", s)
+	}
+	return d, nil
+}
+
+// runDir returns the directory of a run
+func (s *Store) runDir(id string) string {
+	return filepath.Join(s.dir, id)
+}
+
+// logPath returns the log file of a step of a run
+func (s *Store) logPath(id string, number int) string {
+	return filepath.Join(s.runDir(id), fmt.Sprintf("step-%d.log", number))
+}
+
+// save writes the record of a run
+func (s *Store) save(run *Run) error {
+	return s.writeJSON(run.ID, "run.json", run)
+}
+
+// writeJSON writes a file of a run as JSON
+func (s *Store) writeJSON(id, name string, v interface{}) error {
+	if err := os.MkdirAll(s.runDir(id), 0o700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Replace the file at once, so it is never read half written
+	tmp := filepath.Join(s.runDir(id), name+".tmp")
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(s.runDir(id), name))
+}
+
+// List returns the recorded runs, most recent first
+func (s *Store) List() ([]*Run, error) {
+	entries, err := os.ReadDir(s.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read run history: %w", err)
+	}
+	var runs []*Run
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		run, err := s.read(entry.Name())
+		if err != nil {
+			// Runs being recorded or removed are skipped
+			continue
+		}
+		runs = append(runs, run)
+	}
+	sort.SliceStable(runs, func(i, j int) bool {
+		return runs[i].Start.After(runs[j].Start)
+	})
+	return runs, nil
+}
+
+// read reads the record of a run
+func (s *Store) read(id string) (*Run, error) {
+	data, err := os.ReadFile(filepath.Join(s.runDir(id), "run.json"))
+	if err != nil {
+		return nil, err
+	}
+	var run Run
+	if err := json.Unmarshal(data, &run); err != nil {
+		return nil, fmt.Errorf("invalid record of run %s: %w", id, err)
+	}
+	return &run, nil
+}
+
+// Load returns a recorded run from its ID or a prefix of its ID matching a single run
+func (s *Store) Load(id string) (*Run, error) {
+	runs, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	var matches []*Run
+	for _, run := range runs {
+		if run.ID == id {
+			return run, nil
+		}
+		if id != "" && strings.HasPrefix(run.ID, id) {
+			matches = append(matches, run)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("run '%s' not found", id)
+	case 1:
+		return matches[0], nil
+	}
+	return nil, fmt.Errorf("run ID '%s' is ambiguous: it matches %d runs", id, len(matches))
+}
+
+// FindStep returns a step of a run from its position in the run, such as 3, or in its task, such as build:2
+func (r *Run) FindStep(spec string) (*Step, error) {
+	if task, step, ok := strings.Cut(spec, ":"); ok {
+		n, err := strconv.Atoi(step)
+		if err != nil {
+			return nil, fmt.Errorf("invalid step '%s' (expected a number or task:number)", spec)
+		}
+		// A task run several times: its first run
+		for _, s := range r.Steps {
+			if s.Task == task && s.Step == n {
+				return s, nil
+			}
+		}
+		return nil, fmt.Errorf("step '%s' not found in run %s", spec, r.ID)
+	}
+	n, err := strconv.Atoi(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid step '%s' (expected a number or task:number)", spec)
+	}
+	for _, s := range r.Steps {
+		if s.Number == n {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("step %d not found in run %s", n, r.ID)
+}
+
+// Log returns the output of a step of a run, masked as it was printed
+func (s *Store) Log(run *Run, step *Step) (string, error) {
+	data, err := os.ReadFile(s.logPath(run.ID, step.Number))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read output of step %d: %w", step.Number, err)
+	}
+	return string(data), nil
+}
+
+// LoadCheckpoint returns the checkpoint of a run
+func (s *Store) LoadCheckpoint(run *Run) (*task.Checkpoint, error) {
+	data, err := os.ReadFile(filepath.Join(s.runDir(run.ID), "checkpoint.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("run %s cannot be resumed: it has no checkpoint", run.ID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint of run %s: %w", run.ID, err)
+	}
+	var checkpoint task.Checkpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint of run %s: %w", run.ID, err)
+	}
+	return &checkpoint, nil
+}
+
+// Last returns the most recent run of a taskfile
+func (s *Store) Last(taskfile string) (*Run, error) {
+	runs, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, run := range runs {
+		if run.Taskfile == taskfile {
+			return run, nil
+		}
+	}
+	return nil, fmt.Errorf("no run of %s found", taskfile)
+}
+
+// Filter selects recorded runs. Empty fields select all runs.
+type Filter struct {
+	Taskfile string
+	Task     string
+	Status   string
+	Since    time.Time
+	Limit    int // Number of most recent runs selected
+}
+
+// Match reports whether the filter selects a run, regardless of its limit
+func (f Filter) Match(run *Run) bool {
+	return (f.Taskfile == "" || run.Taskfile == f.Taskfile) &&
+		(f.Task == "" || run.Task == f.Task) &&
+		(f.Status == "" || run.Status == f.Status) &&
+		(f.Since.IsZero() || !run.Start.Before(f.Since))
+}
+
+// Apply returns the runs selected by the filter, in order
+func (f Filter) Apply(runs []*Run) []*Run {
+	var selected []*Run
+	for _, run := range runs {
+		if f.Limit > 0 && len(selected) == f.Limit {
+			break
+		}
+		if f.Match(run) {
+			selected = append(selected, run)
+		}
+	}
+	return selected
+}
+
+// Prune removes runs beyond the most recent maxRuns and runs started more than maxAge
+// before now. Limits of zero are not applied.
+func (s *Store) Prune(maxRuns int, maxAge time.Duration, now time.Time) error {
+	runs, err := s.List()
+	if err != nil {
+		return err
+	}
+	for i, run := range runs {
+		if (maxRuns > 0 && i >= maxRuns) || (maxAge > 0 && now.Sub(run.Start) > maxAge) {
+			if err := os.RemoveAll(s.runDir(run.ID)); err != nil {
+				return fmt.Errorf("failed to remove run %s: %w", run.ID, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package history
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kontraktor-sh/kontraktor/internal/task"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAge(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected time.Duration
+		wantErr  bool
+	}{
+		{input: "30d", expected: 30 * 24 * time.Hour},
+		{input: "0", expected: 0},
+		{input: "12h", expected: 12 * time.Hour},
+		{input: "1h30m", expected: 90 * time.Minute},
+		{input: "-1d", wantErr: true},
+		{input: "-1h", wantErr: true},
+		{input: "week", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			age, err := ParseAge(tt.input)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, age)
+		})
+	}
+}
+
+func TestDefaultStateDir(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		expected string
+	}{
+		{name: "state dir", env: map[string]string{"KONTRAKTOR_STATE_DIR": "/state", "XDG_STATE_HOME": "/xdg"}, expected: "/state"},
+		{name: "xdg", env: map[string]string{"XDG_STATE_HOME": "/xdg"}, expected: "/xdg/kontraktor"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := DefaultStateDir(func(key string) string { return tt.env[key] })
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, dir)
+		})
+	}
+}
+
+// saveRuns records runs started at the given times before now, with IDs run1, run2, ...
+func saveRuns(t *testing.T, store *Store, now time.Time, ages ...time.Duration) {
+	t.Helper()
+	for i, age := range ages {
+		run := &Run{ID: "run" + string(rune('1'+i)), Task: "build", Start: now.Add(-age), Status: "success"}
+		require.NoError(t, store.save(run))
+	}
+}
+
+func ids(runs []*Run) []string {
+	var result []string
+	for _, run := range runs {
+		result = append(result, run.ID)
+	}
+	return result
+}
+
+func TestStore_List(t *testing.T) {
+	store := NewStore(t.TempDir())
+	runs, err := store.List()
+	require.NoError(t, err)
+	assert.Empty(t, runs)
+
+	now := time.Now()
+	saveRuns(t, store, now, 2*time.Hour, time.Hour, 3*time.Hour)
+	runs, err = store.List()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"run2", "run1", "run3"}, ids(runs))
+}
+
+func TestStore_Load(t *testing.T) {
+	store := NewStore(t.TempDir())
+	for _, id := range []string{"abc1", "abc2", "abd3"} {
+		require.NoError(t, store.save(&Run{ID: id, Start: time.Now()}))
+	}
+
+	tests := []struct {
+		id       string
+		expected string
+		wantErr  string
+	}{
+		{id: "abc1", expected: "abc1"},
+		{id: "abd", expected: "abd3"},
+		{id: "abc", wantErr: "run ID 'abc' is ambiguous: it matches 2 runs"},
+		{id: "x", wantErr: "run 'x' not found"},
+		{id: "", wantErr: "run '' not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			run, err := store.Load(tt.id)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, run.ID)
+		})
+	}
+}
+
+func TestRun_FindStep(t *testing.T) {
+	run := &Run{ID: "r", Steps: []*Step{
+		{Number: 1, Task: "ci", Step: 1},
+		{Number: 2, Task: "ci", Step: 2},
+		{Number: 3, Task: "test", Step: 1},
+	}}
+
+	tests := []struct {
+		spec     string
+		expected int
+		wantErr  string
+	}{
+		{spec: "2", expected: 2},
+		{spec: "test:1", expected: 3},
+		{spec: "ci:2", expected: 2},
+		{spec: "4", wantErr: "step 4 not found in run r"},
+		{spec: "test:2", wantErr: "step 'test:2' not found in run r"},
+		{spec: "ci:x", wantErr: "invalid step 'ci:x' (expected a number or task:number)"},
+		{spec: "last", wantErr: "invalid step 'last' (expected a number or task:number)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			step, err := run.FindStep(tt.spec)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, step.Number)
+		})
+	}
+}
+
+func TestFilter_Apply(t *testing.T) {
+	now := time.Now()
+	runs := []*Run{
+		{ID: "1", Taskfile: "/a.yml", Task: "build", Status: "success", Start: now.Add(-time.Hour)},
+		{ID: "2", Taskfile: "/b.yml", Task: "build", Status: "failure", Start: now.Add(-2 * time.Hour)},
+		{ID: "3", Taskfile: "/a.yml", Task: "test", Status: "failure", Start: now.Add(-3 * time.Hour)},
+		{ID: "4", Taskfile: "/a.yml", Task: "build", Status: "failure", Start: now.Add(-48 * time.Hour)},
+	}
+
+	tests := []struct {
+		name     string
+		filter   Filter
+		expected []string
+	}{
+		{name: "all", filter: Filter{}, expected: []string{"1", "2", "3", "4"}},
+		{name: "taskfile", filter: Filter{Taskfile: "/a.yml"}, expected: []string{"1", "3", "4"}},
+		{name: "task", filter: Filter{Task: "build"}, expected: []string{"1", "2", "4"}},
+		{name: "status", filter: Filter{Status: "failure"}, expected: []string{"2", "3", "4"}},
+		{name: "since", filter: Filter{Since: now.Add(-24 * time.Hour)}, expected: []string{"1", "2", "3"}},
+		{name: "limit", filter: Filter{Status: "failure", Limit: 2}, expected: []string{"2", "3"}},
+		{name: "none", filter: Filter{Task: "deploy"}, expected: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, ids(tt.filter.Apply(runs)))
+		})
+	}
+}
+
+func TestStore_Prune(t *testing.T) {
+	day := 24 * time.Hour
+	tests := []struct {
+		name     string
+		maxRuns  int
+		maxAge   time.Duration
+		expected []string
+	}{
+		{name: "no limits", expected: []string{"run1", "run2", "run3", "run4"}},
+		{name: "count", maxRuns: 2, expected: []string{"run1", "run2"}},
+		{name: "age", maxAge: 7 * day, expected: []string{"run1", "run2", "run3"}},
+		{name: "count and age", maxRuns: 1, maxAge: 7 * day, expected: []string{"run1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewStore(t.TempDir())
+			now := time.Now()
+			saveRuns(t, store, now, time.Hour, day, 2*day, 10*day)
+
+			require.NoError(t, store.Prune(tt.maxRuns, tt.maxAge, now))
+			runs, err := store.List()
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, ids(runs))
+		})
+	}
+}
+
+func TestStore_Last(t *testing.T) {
+	store := NewStore(t.TempDir())
+	now := time.Now()
+	for _, run := range []*Run{
+		{ID: "a1", Taskfile: "/a.yml", Start: now.Add(-2 * time.Hour)},
+		{ID: "b1", Taskfile: "/b.yml", Start: now.Add(-time.Hour)},
+		{ID: "a2", Taskfile: "/a.yml", Start: now.Add(-90 * time.Minute)},
+	} {
+		require.NoError(t, store.save(run))
+	}
+
+	run, err := store.Last("/a.yml")
+	require.NoError(t, err)
+	assert.Equal(t, "a2", run.ID)
+	_, err = store.Last("/c.yml")
+	assert.EqualError(t, err, "no run of /c.yml found")
+}
+
+func TestStore_LoadCheckpoint(t *testing.T) {
+	store := NewStore(t.TempDir())
+	recorder := store.NewRecorder("/a.yml", nil, func(s string) string { return s })
+	recorder.Checkpoint(&task.Checkpoint{RunID: "r1", Task: "ci", TaskfileHash: "abc", Steps: []string{"ci:1"}})
+	require.NoError(t, recorder.Err())
+
+	checkpoint, err := store.LoadCheckpoint(&Run{ID: "r1"})
+	require.NoError(t, err)
+	assert.Equal(t, &task.Checkpoint{RunID: "r1", Task: "ci", TaskfileHash: "abc", Steps: []string{"ci:1"}}, checkpoint)
+
+	_, err = store.LoadCheckpoint(&Run{ID: "r2"})
+	assert.EqualError(t, err, "run r2 cannot be resumed: it has no checkpoint")
+}
@@ -0,0 +1,276 @@
+package lsp
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/kontraktor-sh/kontraktor/internal/taskfile"
+	"gopkg.in/yaml.v3"
+)
+
+// document is a taskfile open in the editor or read from disk for navigation
+type document struct {
+	uri   string
+	path  string
+	text  string
+	lines []string
+	index *index
+}
+
+// index holds what the server knows about the structure of a document
+type index struct {
+	tasks   map[string]*taskInfo
+	order   []string // task names sorted by position
+	refs    []taskRef
+	globals map[string]string // global environment name -> value
+	vars    map[string]taskfile.Var
+	secrets map[string]string // secret environment name -> vault path
+	imports []string
+}
+
+// taskInfo describes a task defined in a document
+type taskInfo struct {
+	name string
+	key  span
+	desc string
+	args []taskfile.TaskArg
+	env  map[string]string
+	vars map[string]taskfile.Var
+}
+
+// taskRef is a reference to a task from a command
+type taskRef struct {
+	name string
+	span span
+}
+
+// span is the range of a scalar, in zero-based lines and byte columns
+type span struct {
+	line, start, end int
+}
+
+func (s span) contains(line, col int) bool {
+	return line == s.line && col >= s.start && col <= s.end
+}
+
+// newDocument creates a document, keeping the previous index when text does not parse
+func newDocument(uri, text string, previous *index) (*document, error) {
+	path, err := uriToPath(uri)
+	if err != nil {
+		return nil, err
+	}
+	doc := &document{
+		uri:   uri,
+		path:  path,
+		text:  text,
+		lines: strings.Split(text, "\n"),
+		index: previous,
+	}
+	if idx, err := buildIndex([]byte(text)); err == nil {
+		doc.index = idx
+	}
+	if doc.index == nil {
+		doc.index = &index{tasks: make(map[string]*taskInfo)}
+	}
+	return doc, nil
+}
+
+// loadDocument reads a taskfile from disk
+func loadDocument(path string) (*document, error) {
+	text, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return newDocument(pathToURI(path), string(text), nil)
+}
+
+// buildIndex parses taskfile source into an index
+func buildIndex(src []byte) (*index, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(src, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("taskfile must be a mapping")
+	}
+	root := doc.Content[0]
+	idx := &index{
+		tasks:   make(map[string]*taskInfo),
+		globals: make(map[string]string),
+		secrets: make(map[string]string),
+	}
+
+	if env := mappingValue(root, "environment"); env != nil {
+		_ = env.Decode(&idx.globals)
+	}
+	if vs := mappingValue(root, "vars"); vs != nil {
+		_ = vs.Decode(&idx.vars)
+	}
+	if imports := mappingValue(root, "imports"); imports != nil {
+		_ = imports.Decode(&idx.imports)
+	}
+	if vaults := mappingValue(root, "vaults"); vaults != nil && vaults.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(vaults.Content); i += 2 {
+			section := vaults.Content[i+1]
+			for j := 0; j+1 < len(section.Content); j += 2 {
+				secrets := mappingValue(section.Content[j+1], "secrets")
+				if secrets == nil {
+					continue
+				}
+				for k := 0; k+1 < len(secrets.Content); k += 2 {
+					idx.secrets[secrets.Content[k].Value] = fmt.Sprintf("vaults.%s.%s", vaults.Content[i].Value, section.Content[j].Value)
+				}
+			}
+		}
+	}
+
+	tasks := mappingValue(root, "tasks")
+	if tasks == nil || tasks.Kind != yaml.MappingNode {
+		return idx, nil
+	}
+	for i := 0; i+1 < len(tasks.Content); i += 2 {
+		key, value := tasks.Content[i], tasks.Content[i+1]
+		info := &taskInfo{name: key.Value, key: scalarSpan(key)}
+		var task taskfile.Task
+		if err := value.Decode(&task); err == nil {
+			info.desc = task.Desc
+			info.args = task.Args
+			info.env = task.Environment
+			info.vars = task.Vars
+		}
+		idx.tasks[key.Value] = info
+		idx.order = append(idx.order, key.Value)
+
+		if cmds := mappingValue(value, "cmds"); cmds != nil {
+			for _, cmd := range cmds.Content {
+				if ref := taskRefNode(cmd); ref != nil {
+					idx.refs = append(idx.refs, taskRef{name: ref.Value, span: scalarSpan(ref)})
+				}
+			}
+		}
+	}
+	sort.SliceStable(idx.order, func(a, b int) bool {
+		return idx.tasks[idx.order[a]].key.line < idx.tasks[idx.order[b]].key.line
+	})
+	return idx, nil
+}
+
+// taskRefNode returns the node naming the referenced task of a task command, if cmd is one
+func taskRefNode(cmd *yaml.Node) *yaml.Node {
+	if name := mappingValue(cmd, "task"); name != nil && name.Kind == yaml.ScalarNode {
+		return name
+	}
+	if cmdType := mappingValue(cmd, "type"); cmdType != nil && cmdType.Value == "task" {
+		if name := mappingValue(mappingValue(cmd, "content"), "name"); name != nil && name.Kind == yaml.ScalarNode {
+			return name
+		}
+	}
+	return nil
+}
+
+// taskAt returns the task defined or referenced at a position
+func (idx *index) taskAt(line, col int) (string, bool) {
+	for _, name := range idx.order {
+		if idx.tasks[name].key.contains(line, col) {
+			return name, true
+		}
+	}
+	for _, ref := range idx.refs {
+		if ref.span.contains(line, col) {
+			return ref.name, true
+		}
+	}
+	return "", false
+}
+
+// enclosingTask returns the task whose definition contains a line
+func (idx *index) enclosingTask(line int) *taskInfo {
+	var result *taskInfo
+	for _, name := range idx.order {
+		if idx.tasks[name].key.line > line {
+			break
+		}
+		result = idx.tasks[name]
+	}
+	return result
+}
+
+// scalarSpan returns the span of a scalar value, excluding quotes
+func scalarSpan(node *yaml.Node) span {
+	start := node.Column - 1
+	if node.Style == yaml.DoubleQuotedStyle || node.Style == yaml.SingleQuotedStyle {
+		start++
+	}
+	return span{line: node.Line - 1, start: start, end: start + len(node.Value)}
+}
+
+// rangeOf converts a span to an LSP range
+func (d *document) rangeOf(s span) Range {
+	return Range{
+		Start: Position{Line: s.line, Character: d.utf16Column(s.line, s.start)},
+		End:   Position{Line: s.line, Character: d.utf16Column(s.line, s.end)},
+	}
+}
+
+// utf16Column converts a byte column to UTF-16 code units
+func (d *document) utf16Column(line, col int) int {
+	if line < 0 || line >= len(d.lines) {
+		return col
+	}
+	text := d.lines[line]
+	if col > len(text) {
+		return col - len(text) + len(utf16.Encode([]rune(text)))
+	}
+	return len(utf16.Encode([]rune(text[:col])))
+}
+
+// byteColumn converts a UTF-16 column to a byte column
+func (d *document) byteColumn(line, col int) int {
+	if line < 0 || line >= len(d.lines) {
+		return col
+	}
+	text := d.lines[line]
+	units := 0
+	for i, r := range text {
+		if units >= col {
+			return i
+		}
+		units += utf16.RuneLen(r)
+	}
+	return len(text)
+}
+
+// mappingValue returns the value node for key in a mapping node
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// uriToPath converts a file URI to a path
+func uriToPath(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", fmt.Errorf("invalid document URI %s: %w", uri, err)
+	}
+	if u.Scheme != "file" {
+		return "", fmt.Errorf("unsupported document URI %s", uri)
+	}
+	return filepath.FromSlash(u.Path), nil
+}
+
+// pathToURI converts a path to a file URI
+func pathToURI(path string) string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
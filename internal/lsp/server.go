@@ -0,0 +1,519 @@
+package lsp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/kontraktor-sh/kontraktor/internal/taskfile"
+	"github.com/kontraktor-sh/kontraktor/internal/vars"
+)
+
+var (
+	taskKeyContext  = regexp.MustCompile(`^\s*(-\s+)?task:\s*["']?[\w.:-]*$`)
+	nameKeyContext  = regexp.MustCompile(`^\s*name:\s*["']?[\w.:-]*$`)
+	taskTypeContext = regexp.MustCompile(`^\s*(-\s+)?type:\s*["']?task["']?\s*$`)
+	validTaskName   = regexp.MustCompile(`^[^\s:#{}\[\],&*!|>'"%@` + "`" + `]+$`)
+)
+
+// fetchedImport is the outcome of fetching a remote import, cached for the session
+type fetchedImport struct {
+	path string
+	err  error
+}
+
+// Server is a Language Server Protocol server for taskfiles
+type Server struct {
+	conn     *conn
+	opts     taskfile.LintOptions
+	docs     map[string]*document
+	imports  map[string]fetchedImport
+	shutdown bool
+}
+
+// NewServer creates a new server; opts configure the diagnostics published for open documents
+func NewServer(opts taskfile.LintOptions) *Server {
+	return &Server{
+		opts:    opts,
+		docs:    make(map[string]*document),
+		imports: make(map[string]fetchedImport),
+	}
+}
+
+// Serve handles messages from in and writes responses to out until the client exits.
+// It returns an error when the client exits without shutting the server down first.
+func (s *Server) Serve(in io.Reader, out io.Writer) error {
+	s.conn = newConn(in, out)
+	for {
+		req, err := s.conn.read()
+		if err != nil {
+			var rpcErr *rpcError
+			if errors.As(err, &rpcErr) {
+				if err := s.conn.replyError(nil, rpcErr); err != nil {
+					return err
+				}
+				continue
+			}
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if req.Method == "exit" {
+			if !s.shutdown {
+				return fmt.Errorf("exit without shutdown")
+			}
+			return nil
+		}
+		if err := s.handle(req); err != nil {
+			return err
+		}
+	}
+}
+
+// handle dispatches a message and writes its response
+func (s *Server) handle(req *request) error {
+	result, err := s.dispatch(req)
+	if req.ID == nil {
+		// Notifications have no response
+		return nil
+	}
+	if err != nil {
+		var rpcErr *rpcError
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &rpcError{Code: codeRequestFailed, Message: err.Error()}
+		}
+		return s.conn.replyError(req.ID, rpcErr)
+	}
+	return s.conn.reply(req.ID, result)
+}
+
+func (s *Server) dispatch(req *request) (interface{}, error) {
+	switch req.Method {
+	case "initialize":
+		return s.initialize(), nil
+	case "initialized", "$/cancelRequest", "$/setTrace", "textDocument/didSave":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var params DidOpenTextDocumentParams
+		if err := decodeParams(req, &params); err != nil {
+			return nil, err
+		}
+		return nil, s.update(params.TextDocument.URI, params.TextDocument.Text)
+	case "textDocument/didChange":
+		var params DidChangeTextDocumentParams
+		if err := decodeParams(req, &params); err != nil {
+			return nil, err
+		}
+		if len(params.ContentChanges) == 0 {
+			return nil, nil
+		}
+		// Full synchronization: the last change holds the whole document
+		return nil, s.update(params.TextDocument.URI, params.ContentChanges[len(params.ContentChanges)-1].Text)
+	case "textDocument/didClose":
+		var params DidCloseTextDocumentParams
+		if err := decodeParams(req, &params); err != nil {
+			return nil, err
+		}
+		delete(s.docs, params.TextDocument.URI)
+		return nil, s.conn.notify("textDocument/publishDiagnostics", &PublishDiagnosticsParams{
+			URI:         params.TextDocument.URI,
+			Diagnostics: []Diagnostic{},
+		})
+	case "textDocument/completion":
+		var params TextDocumentPositionParams
+		if err := decodeParams(req, &params); err != nil {
+			return nil, err
+		}
+		return s.completion(params)
+	case "textDocument/definition":
+		var params TextDocumentPositionParams
+		if err := decodeParams(req, &params); err != nil {
+			return nil, err
+		}
+		return s.definition(params)
+	case "textDocument/hover":
+		var params TextDocumentPositionParams
+		if err := decodeParams(req, &params); err != nil {
+			return nil, err
+		}
+		return s.hover(params)
+	case "textDocument/rename":
+		var params RenameParams
+		if err := decodeParams(req, &params); err != nil {
+			return nil, err
+		}
+		return s.rename(params)
+	default:
+		return nil, &rpcError{Code: codeMethodNotFound, Message: fmt.Sprintf("method not found: %s", req.Method)}
+	}
+}
+
+func decodeParams(req *request, v interface{}) error {
+	if err := json.Unmarshal(req.Params, v); err != nil {
+		return &rpcError{Code: codeInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
+
+func (s *Server) initialize() interface{} {
+	return map[string]interface{}{
+		"capabilities": map[string]interface{}{
+			"textDocumentSync": 1, // full
+			"completionProvider": map[string]interface{}{
+				"triggerCharacters": []string{"{", " "},
+			},
+			"definitionProvider": true,
+			"hoverProvider":      true,
+			"renameProvider":     true,
+		},
+		"serverInfo": map[string]string{"name": "kontraktor"},
+	}
+}
+
+// update stores the new text of a document and publishes its diagnostics
+func (s *Server) update(uri, text string) error {
+	var previous *index
+	if doc, ok := s.docs[uri]; ok {
+		previous = doc.index
+	}
+	doc, err := newDocument(uri, text, previous)
+	if err != nil {
+		return err
+	}
+	s.docs[uri] = doc
+	return s.conn.notify("textDocument/publishDiagnostics", &PublishDiagnosticsParams{
+		URI:         uri,
+		Diagnostics: s.diagnostics(doc),
+	})
+}
+
+// diagnostics lints a document, resolving local imports relative to it and fetching remote ones once
+func (s *Server) diagnostics(doc *document) []Diagnostic {
+	opts := s.opts
+	dir := filepath.Dir(doc.path)
+	opts.FetchImport = func(importPath string) (string, error) {
+		if !taskfile.IsRemoteImport(importPath) {
+			return resolveLocal(dir, importPath), nil
+		}
+		fetched, ok := s.imports[importPath]
+		if !ok {
+			fetched.path, fetched.err = taskfile.FetchImport(importPath)
+			s.imports[importPath] = fetched
+		}
+		return fetched.path, fetched.err
+	}
+
+	result := []Diagnostic{}
+	for _, d := range taskfile.LintSource(doc.path, []byte(doc.text), opts) {
+		line := d.Line - 1
+		start := d.Column - 1
+		severity := SeverityError
+		if d.Severity == taskfile.SeverityWarning {
+			severity = SeverityWarning
+		}
+		result = append(result, Diagnostic{
+			Range:    doc.rangeOf(span{line: line, start: start, end: doc.tokenEnd(line, start)}),
+			Severity: severity,
+			Source:   "kontraktor",
+			Message:  d.Message,
+		})
+	}
+	return result
+}
+
+// tokenEnd returns the end column of the token starting at a position
+func (d *document) tokenEnd(line, col int) int {
+	if line < 0 || line >= len(d.lines) || col < 0 || col >= len(d.lines[line]) {
+		return col
+	}
+	text := d.lines[line][col:]
+	if strings.HasPrefix(text, "${") {
+		if end := strings.Index(text, "}"); end >= 0 {
+			return col + end + 1
+		}
+	}
+	end := strings.IndexAny(text, " \t:,#")
+	if end <= 0 {
+		end = len(text)
+	}
+	return col + end
+}
+
+func (s *Server) document(uri string) (*document, error) {
+	doc, ok := s.docs[uri]
+	if !ok {
+		return nil, fmt.Errorf("document not open: %s", uri)
+	}
+	return doc, nil
+}
+
+// completion proposes variables inside ${...} and task names in task references
+func (s *Server) completion(params TextDocumentPositionParams) ([]CompletionItem, error) {
+	doc, err := s.document(params.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+	line := params.Position.Line
+	if line < 0 || line >= len(doc.lines) || params.Position.Character < 0 {
+		return []CompletionItem{}, nil
+	}
+	prefix := doc.lines[line][:doc.byteColumn(line, params.Position.Character)]
+
+	if i := strings.LastIndex(prefix, "${"); i >= 0 && !strings.Contains(prefix[i:], "}") {
+		if strings.Contains(prefix[i:], "|") {
+			return filterCompletions(), nil
+		}
+		return s.variableCompletions(doc, line), nil
+	}
+	if taskKeyContext.MatchString(prefix) || (nameKeyContext.MatchString(prefix) && inTaskCommand(doc, line)) {
+		return s.taskCompletions(doc), nil
+	}
+	return []CompletionItem{}, nil
+}
+
+// inTaskCommand reports whether a content line belongs to a command of type task,
+// looking back to the start of the list item containing it
+func inTaskCommand(doc *document, line int) bool {
+	for i := line - 1; i >= 0 && i >= line-3; i-- {
+		if taskTypeContext.MatchString(doc.lines[i]) {
+			return true
+		}
+		if strings.HasPrefix(strings.TrimSpace(doc.lines[i]), "- ") {
+			return false
+		}
+	}
+	return false
+}
+
+func (s *Server) variableCompletions(doc *document, line int) []CompletionItem {
+	items := make(map[string]CompletionItem)
+	for name, value := range doc.index.globals {
+		items[name] = CompletionItem{Label: name, Kind: CompletionKindVariable, Detail: "global environment", Documentation: value}
+	}
+	for name, v := range doc.index.vars {
+		items[name] = CompletionItem{Label: name, Kind: CompletionKindVariable, Detail: "global vars", Documentation: describeVar(v)}
+	}
+	for name, vault := range doc.index.secrets {
+		items[name] = CompletionItem{Label: name, Kind: CompletionKindVariable, Detail: "secret from " + vault}
+	}
+	if task := doc.index.enclosingTask(line); task != nil {
+		for name, value := range task.env {
+			items[name] = CompletionItem{Label: name, Kind: CompletionKindVariable, Detail: fmt.Sprintf("environment of task %s", task.name), Documentation: value}
+		}
+		for name, v := range task.vars {
+			items[name] = CompletionItem{Label: name, Kind: CompletionKindVariable, Detail: fmt.Sprintf("vars of task %s", task.name), Documentation: describeVar(v)}
+		}
+		for _, arg := range task.args {
+			item := CompletionItem{Label: arg.Name, Kind: CompletionKindVariable, Detail: fmt.Sprintf("argument of task %s", task.name)}
+			if arg.Default != nil {
+				item.Documentation = fmt.Sprintf("default: %v", arg.Default)
+			}
+			items[arg.Name] = item
+		}
+	}
+	return sortedItems(items)
+}
+
+// describeVar returns how the value of a vars entry is computed
+func describeVar(v taskfile.Var) string {
+	switch {
+	case v.Sh != "":
+		return "sh: " + v.Sh
+	case v.File != "" && v.JSONPath != "":
+		return fmt.Sprintf("file: %s, json_path: %s", v.File, v.JSONPath)
+	case v.File != "":
+		return "file: " + v.File
+	}
+	return v.Value
+}
+
+func filterCompletions() []CompletionItem {
+	items := []CompletionItem{}
+	for _, name := range vars.FilterNames() {
+		items = append(items, CompletionItem{Label: name, Kind: CompletionKindFunction, Detail: "filter"})
+	}
+	return items
+}
+
+func (s *Server) taskCompletions(doc *document) []CompletionItem {
+	items := make(map[string]CompletionItem)
+	s.eachDocument(doc, func(d *document) {
+		for name, task := range d.index.tasks {
+			if _, exists := items[name]; !exists {
+				items[name] = CompletionItem{Label: name, Kind: CompletionKindFunction, Detail: task.desc}
+			}
+		}
+	})
+	return sortedItems(items)
+}
+
+func sortedItems(items map[string]CompletionItem) []CompletionItem {
+	result := make([]CompletionItem, 0, len(items))
+	for _, item := range items {
+		result = append(result, item)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Label < result[j].Label })
+	return result
+}
+
+// eachDocument calls fn for doc and every document it imports locally, depth first
+func (s *Server) eachDocument(doc *document, fn func(*document)) {
+	visited := make(map[string]bool)
+	var walk func(d *document)
+	walk = func(d *document) {
+		if visited[d.path] {
+			return
+		}
+		visited[d.path] = true
+		fn(d)
+		for _, imp := range d.index.imports {
+			if taskfile.IsRemoteImport(imp) {
+				continue
+			}
+			if imported := s.localDocument(resolveLocal(filepath.Dir(d.path), imp)); imported != nil {
+				walk(imported)
+			}
+		}
+	}
+	walk(doc)
+}
+
+// localDocument returns the open document for a path, or reads it from disk
+func (s *Server) localDocument(path string) *document {
+	for _, doc := range s.docs {
+		if doc.path == path {
+			return doc
+		}
+	}
+	doc, err := loadDocument(path)
+	if err != nil {
+		return nil
+	}
+	return doc
+}
+
+// resolveLocal resolves a local import relative to the directory of the importing taskfile
+func resolveLocal(dir, importPath string) string {
+	if filepath.IsAbs(importPath) {
+		return importPath
+	}
+	return filepath.Join(dir, importPath)
+}
+
+// findTask returns the document defining a task, searching local imports after doc itself
+func (s *Server) findTask(doc *document, name string) (*document, *taskInfo) {
+	var foundDoc *document
+	var found *taskInfo
+	s.eachDocument(doc, func(d *document) {
+		if found != nil {
+			return
+		}
+		if task, ok := d.index.tasks[name]; ok {
+			foundDoc, found = d, task
+		}
+	})
+	return foundDoc, found
+}
+
+// taskAtPosition returns the task name at an LSP position
+func (s *Server) taskAtPosition(params TextDocumentPositionParams) (*document, string, bool, error) {
+	doc, err := s.document(params.TextDocument.URI)
+	if err != nil {
+		return nil, "", false, err
+	}
+	line := params.Position.Line
+	name, ok := doc.index.taskAt(line, doc.byteColumn(line, params.Position.Character))
+	return doc, name, ok, nil
+}
+
+func (s *Server) definition(params TextDocumentPositionParams) (interface{}, error) {
+	doc, name, ok, err := s.taskAtPosition(params)
+	if err != nil || !ok {
+		return nil, err
+	}
+	defDoc, task := s.findTask(doc, name)
+	if task == nil {
+		return nil, nil
+	}
+	return []Location{{URI: defDoc.uri, Range: defDoc.rangeOf(task.key)}}, nil
+}
+
+func (s *Server) hover(params TextDocumentPositionParams) (interface{}, error) {
+	doc, name, ok, err := s.taskAtPosition(params)
+	if err != nil || !ok {
+		return nil, err
+	}
+	defDoc, task := s.findTask(doc, name)
+	if task == nil {
+		return nil, nil
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "**task** `%s`", task.name)
+	if defDoc != doc {
+		fmt.Fprintf(&b, " (from %s)", filepath.Base(defDoc.path))
+	}
+	if task.desc != "" {
+		fmt.Fprintf(&b, "\n\n%s", task.desc)
+	}
+	if len(task.args) > 0 {
+		b.WriteString("\n\n**Arguments**\n")
+		for _, arg := range task.args {
+			fmt.Fprintf(&b, "\n- `%s`", arg.Name)
+			if arg.Type != "" {
+				fmt.Fprintf(&b, " (%s)", arg.Type)
+			}
+			if arg.Default != nil {
+				fmt.Fprintf(&b, ", default `%v`", arg.Default)
+			}
+		}
+	}
+	return &Hover{Contents: MarkupContent{Kind: "markdown", Value: b.String()}}, nil
+}
+
+// rename renames a task and every reference to it. Tasks defined in the open document are
+// renamed there only; tasks defined in a local import are renamed across the import chain.
+func (s *Server) rename(params RenameParams) (*WorkspaceEdit, error) {
+	doc, name, ok, err := s.taskAtPosition(TextDocumentPositionParams{TextDocument: params.TextDocument, Position: params.Position})
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("no task at this position")
+	}
+	if !validTaskName.MatchString(params.NewName) {
+		return nil, fmt.Errorf("invalid task name '%s'", params.NewName)
+	}
+
+	docs := []*document{doc}
+	if _, defined := doc.index.tasks[name]; !defined {
+		docs = nil
+		s.eachDocument(doc, func(d *document) { docs = append(docs, d) })
+	}
+
+	edit := &WorkspaceEdit{Changes: make(map[string][]TextEdit)}
+	for _, d := range docs {
+		var edits []TextEdit
+		if task, ok := d.index.tasks[name]; ok {
+			edits = append(edits, TextEdit{Range: d.rangeOf(task.key), NewText: params.NewName})
+		}
+		for _, ref := range d.index.refs {
+			if ref.name == name {
+				edits = append(edits, TextEdit{Range: d.rangeOf(ref.span), NewText: params.NewName})
+			}
+		}
+		if len(edits) > 0 {
+			edit.Changes[d.uri] = edits
+		}
+	}
+	return edit, nil
+}
@@ -0,0 +1,243 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/kontraktor-sh/kontraktor/internal/taskfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const mainTaskfile = `version: "0.3"
+imports:
+  - common.ktr.yml
+environment:
+  GREETING: hello
+vaults:
+  azure_keyvault:
+    main:
+      keyvault_name: kv
+      secrets:
+        API_KEY: api-key
+tasks:
+  build:
+    desc: Build the project
+    args:
+      - name: target
+        default: linux
+    cmds:
+      - echo "${GREETING} ${}"
+      - task: setup
+      - type: task
+        content:
+          name: build
+  release:
+    desc: Release
+    cmds:
+      - task: build
+      - task: ${MISSING}
+vars:
+  SHA:
+    sh: git rev-parse HEAD
+`
+
+const importedTaskfile = `version: "0.3"
+tasks:
+  setup:
+    desc: Prepare the workspace
+    cmds:
+      - echo setup
+`
+
+// session builds the input stream of an LSP session
+type session struct {
+	buf bytes.Buffer
+	id  int
+}
+
+func (s *session) send(method string, params interface{}, request bool) int {
+	msg := map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params}
+	if request {
+		s.id++
+		msg["id"] = s.id
+	}
+	body, _ := json.Marshal(msg)
+	fmt.Fprintf(&s.buf, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return s.id
+}
+
+func position(uri string, line, character int) map[string]interface{} {
+	return map[string]interface{}{
+		"textDocument": map[string]string{"uri": uri},
+		"position":     map[string]int{"line": line, "character": character},
+	}
+}
+
+// readMessages parses every framed message written by the server
+func readMessages(t *testing.T, out []byte) []map[string]json.RawMessage {
+	r := textproto.NewReader(bufio.NewReader(bytes.NewReader(out)))
+	var msgs []map[string]json.RawMessage
+	for {
+		header, err := r.ReadMIMEHeader()
+		if err == io.EOF {
+			return msgs
+		}
+		require.NoError(t, err)
+		length, err := strconv.Atoi(header.Get("Content-Length"))
+		require.NoError(t, err)
+		body := make([]byte, length)
+		_, err = io.ReadFull(r.R, body)
+		require.NoError(t, err)
+		var msg map[string]json.RawMessage
+		require.NoError(t, json.Unmarshal(body, &msg))
+		msgs = append(msgs, msg)
+	}
+}
+
+func TestServer(t *testing.T) {
+	dir := t.TempDir()
+	mainPath := filepath.Join(dir, "taskfile.ktr.yml")
+	importPath := filepath.Join(dir, "common.ktr.yml")
+	require.NoError(t, os.WriteFile(importPath, []byte(importedTaskfile), 0o644))
+	uri := pathToURI(mainPath)
+
+	var s session
+	s.send("initialize", map[string]interface{}{}, true)
+	s.send("initialized", map[string]interface{}{}, false)
+	s.send("textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri, "languageId": "yaml", "version": 1, "text": mainTaskfile},
+	}, false)
+	variables := s.send("textDocument/completion", position(uri, 18, 28), true)
+	tasks := s.send("textDocument/completion", position(uri, 19, 14), true)
+	negativeLine := s.send("textDocument/completion", position(uri, -1, 0), true)
+	negativeColumn := s.send("textDocument/completion", position(uri, 18, -1), true)
+	definition := s.send("textDocument/definition", position(uri, 19, 16), true)
+	hover := s.send("textDocument/hover", position(uri, 12, 3), true)
+	rename := s.send("textDocument/rename", map[string]interface{}{
+		"textDocument": map[string]string{"uri": uri},
+		"position":     map[string]int{"line": 12, "character": 2},
+		"newName":      "compile",
+	}, true)
+	unknown := s.send("textDocument/formatting", map[string]interface{}{}, true)
+	s.send("shutdown", nil, true)
+	s.send("exit", nil, false)
+
+	var out bytes.Buffer
+	server := NewServer(taskfile.LintOptions{})
+	require.NoError(t, server.Serve(&s.buf, &out))
+
+	results := make(map[int]json.RawMessage)
+	errs := make(map[int]json.RawMessage)
+	var published []PublishDiagnosticsParams
+	for _, msg := range readMessages(t, out.Bytes()) {
+		if method, ok := msg["method"]; ok {
+			assert.Equal(t, `"textDocument/publishDiagnostics"`, string(method))
+			var params PublishDiagnosticsParams
+			require.NoError(t, json.Unmarshal(msg["params"], &params))
+			published = append(published, params)
+			continue
+		}
+		var id int
+		require.NoError(t, json.Unmarshal(msg["id"], &id))
+		if e, ok := msg["error"]; ok {
+			errs[id] = e
+		} else {
+			results[id] = msg["result"]
+		}
+	}
+
+	t.Run("diagnostics", func(t *testing.T) {
+		require.Len(t, published, 1)
+		assert.Equal(t, uri, published[0].URI)
+		var messages []string
+		for _, d := range published[0].Diagnostics {
+			messages = append(messages, d.Message)
+		}
+		assert.Contains(t, messages, "undefined variable 'MISSING' in task 'release'")
+		assert.Contains(t, messages, "circular task reference: build -> build")
+		for _, d := range published[0].Diagnostics {
+			if d.Message == "undefined variable 'MISSING' in task 'release'" {
+				assert.Equal(t, Range{Start: Position{Line: 27, Character: 14}, End: Position{Line: 27, Character: 24}}, d.Range)
+			}
+		}
+	})
+
+	t.Run("variable completion", func(t *testing.T) {
+		var items []CompletionItem
+		require.NoError(t, json.Unmarshal(results[variables], &items))
+		var labels []string
+		for _, item := range items {
+			labels = append(labels, item.Label)
+		}
+		assert.Equal(t, []string{"API_KEY", "GREETING", "SHA", "target"}, labels)
+		assert.Equal(t, "secret from vaults.azure_keyvault.main", items[0].Detail)
+		assert.Equal(t, CompletionItem{Label: "SHA", Kind: CompletionKindVariable, Detail: "global vars", Documentation: "sh: git rev-parse HEAD"}, items[2])
+		assert.Equal(t, "argument of task build", items[3].Detail)
+	})
+
+	t.Run("task completion", func(t *testing.T) {
+		var items []CompletionItem
+		require.NoError(t, json.Unmarshal(results[tasks], &items))
+		assert.Equal(t, []CompletionItem{
+			{Label: "build", Kind: CompletionKindFunction, Detail: "Build the project"},
+			{Label: "release", Kind: CompletionKindFunction, Detail: "Release"},
+			{Label: "setup", Kind: CompletionKindFunction, Detail: "Prepare the workspace"},
+		}, items)
+	})
+
+	t.Run("completion outside the document", func(t *testing.T) {
+		for _, id := range []int{negativeLine, negativeColumn} {
+			var items []CompletionItem
+			require.NoError(t, json.Unmarshal(results[id], &items))
+			assert.Empty(t, items)
+		}
+	})
+
+	t.Run("definition across imports", func(t *testing.T) {
+		var locations []Location
+		require.NoError(t, json.Unmarshal(results[definition], &locations))
+		assert.Equal(t, []Location{{
+			URI:   pathToURI(importPath),
+			Range: Range{Start: Position{Line: 2, Character: 2}, End: Position{Line: 2, Character: 7}},
+		}}, locations)
+	})
+
+	t.Run("hover", func(t *testing.T) {
+		var h Hover
+		require.NoError(t, json.Unmarshal(results[hover], &h))
+		assert.Equal(t, "markdown", h.Contents.Kind)
+		assert.Equal(t, "**task** `build`\n\nBuild the project\n\n**Arguments**\n\n- `target`, default `linux`", h.Contents.Value)
+	})
+
+	t.Run("rename", func(t *testing.T) {
+		var edit WorkspaceEdit
+		require.NoError(t, json.Unmarshal(results[rename], &edit))
+		assert.Equal(t, map[string][]TextEdit{uri: {
+			{Range: Range{Start: Position{Line: 12, Character: 2}, End: Position{Line: 12, Character: 7}}, NewText: "compile"},
+			{Range: Range{Start: Position{Line: 22, Character: 16}, End: Position{Line: 22, Character: 21}}, NewText: "compile"},
+			{Range: Range{Start: Position{Line: 26, Character: 14}, End: Position{Line: 26, Character: 19}}, NewText: "compile"},
+		}}, edit.Changes)
+	})
+
+	t.Run("unknown method", func(t *testing.T) {
+		var e rpcError
+		require.NoError(t, json.Unmarshal(errs[unknown], &e))
+		assert.Equal(t, codeMethodNotFound, e.Code)
+	})
+}
+
+func TestServer_ExitWithoutShutdown(t *testing.T) {
+	var s session
+	s.send("exit", nil, false)
+	err := NewServer(taskfile.LintOptions{}).Serve(&s.buf, io.Discard)
+	assert.Error(t, err)
+}
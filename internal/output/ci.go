@@ -0,0 +1,211 @@
+// ci.go
+// Formats text output for the logs of continuous integration systems.
+package output
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// CI identifies a continuous integration system whose log format is used for text output
+type CI string
+
+const (
+	// CINone prints plain text
+	CINone CI = "none"
+	// CIGitHub formats output for GitHub Actions
+	CIGitHub CI = "github"
+	// CIGitLab formats output for GitLab CI
+	CIGitLab CI = "gitlab"
+)
+
+// DetectCI returns the CI system a process runs in, from its environment
+func DetectCI(getenv func(string) string) CI {
+	switch {
+	case getenv("GITHUB_ACTIONS") == "true":
+		return CIGitHub
+	case getenv("GITLAB_CI") == "true":
+		return CIGitLab
+	}
+	return CINone
+}
+
+// outputNameRe matches characters that cannot be used in names of GitHub step outputs
+var outputNameRe = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// ciFormatter writes the markers of collapsible groups and error annotations around the
+// text output of tasks and steps
+type ciFormatter struct {
+	handler    *Handler
+	ci         CI
+	outputFile string              // $GITHUB_OUTPUT, receiving the standard output of steps
+	sections   []string            // Open GitLab sections, innermost last
+	titles     map[string]string   // Titles of running steps
+	stdout     map[string][]string // Standard output of running steps
+	count      int
+}
+
+// SetCI formats text output for a CI system: tasks and steps are collapsible groups and
+// failed steps are annotated. On GitHub Actions, values masked by AddMaskValue are also
+// masked by the runner, and the standard output of each step is written as a step output
+// named after the task and step, such as build_step_1, to outputFile if it is not empty.
+func (h *Handler) SetCI(ci CI, outputFile string) {
+	if ci == CINone || h.JSON() {
+		return
+	}
+	f := &ciFormatter{handler: h, ci: ci, outputFile: outputFile, titles: make(map[string]string), stdout: make(map[string][]string)}
+	h.mu.Lock()
+	h.ci = ci
+	values := make([]string, 0, len(h.maskValues))
+	for v := range h.maskValues {
+		values = append(values, v)
+	}
+	h.mu.Unlock()
+	sort.Strings(values)
+	if ci == CIGitHub {
+		h.addMasks(values)
+	}
+	h.AddListener(f.event)
+}
+
+// addMasks asks the GitHub Actions runner to mask values in its logs
+func (h *Handler) addMasks(values []string) {
+	for _, v := range values {
+		fmt.Fprintf(h.out, "::add-mask::%s\n", escapeCommand(v))
+	}
+}
+
+func (f *ciFormatter) event(e Event) {
+	out := f.handler.out
+	step := fmt.Sprintf("%s:%d", e.Task, e.Step)
+	switch e.Type {
+	case EventTaskStart:
+		if f.ci == CIGitLab {
+			f.startSection(e, "Task "+e.Task)
+		}
+	case EventStepStart:
+		title := fmt.Sprintf("%s: %d. %s", e.Task, e.Step, stepName(e))
+		f.titles[step] = title
+		switch {
+		case f.ci == CIGitLab:
+			f.startSection(e, title)
+		case e.Interpreter != "task":
+			// GitHub groups cannot be nested: the steps of referenced tasks are groups of their own
+			fmt.Fprintf(out, "::group::%s\n", title)
+		}
+	case EventOutput:
+		if e.Stream == StreamStdout {
+			f.stdout[step] = append(f.stdout[step], e.Line)
+		}
+	case EventStepEnd:
+		title, lines := f.titles[step], f.stdout[step]
+		delete(f.titles, step)
+		delete(f.stdout, step)
+		if f.ci == CIGitLab {
+			f.endSection(e)
+		} else if e.Interpreter != "task" {
+			fmt.Fprintln(out, "::endgroup::")
+		}
+		if e.Interpreter == "task" {
+			// Failures of referenced tasks are reported by their own steps
+			return
+		}
+		if e.Status == StatusFailure {
+			f.annotate(e, title)
+		}
+		value := strings.TrimSpace(strings.Join(lines, "\n"))
+		if f.ci == CIGitHub && f.outputFile != "" && value != "" {
+			name := outputNameRe.ReplaceAllString(fmt.Sprintf("%s_step_%d", e.Task, e.Step), "_")
+			if err := appendOutput(f.outputFile, name, value); err != nil {
+				fmt.Fprintf(f.handler.err, "[ERROR] %v\n", err)
+			}
+		}
+	case EventTaskEnd:
+		if f.ci == CIGitLab {
+			f.endSection(e)
+		}
+	}
+}
+
+// startSection opens a GitLab section
+func (f *ciFormatter) startSection(e Event, title string) {
+	f.count++
+	name := fmt.Sprintf("kontraktor_%d", f.count)
+	f.sections = append(f.sections, name)
+	fmt.Fprintf(f.handler.out, "\x1b[0Ksection_start:%d:%s\r\x1b[0K%s\n", e.Time.Unix(), name, title)
+}
+
+// endSection closes the innermost GitLab section
+func (f *ciFormatter) endSection(e Event) {
+	if len(f.sections) == 0 {
+		return
+	}
+	name := f.sections[len(f.sections)-1]
+	f.sections = f.sections[:len(f.sections)-1]
+	fmt.Fprintf(f.handler.out, "\x1b[0Ksection_end:%d:%s\r\x1b[0K\n", e.Time.Unix(), name)
+}
+
+// annotate reports a failed step: as an error annotation on GitHub, or a highlighted line on GitLab
+func (f *ciFormatter) annotate(e Event, title string) {
+	message := fmt.Sprintf("step %d of task '%s' failed", e.Step, e.Task)
+	if e.Error != "" {
+		message += ": " + e.Error
+	}
+	if f.ci == CIGitLab {
+		fmt.Fprintf(f.handler.out, "\x1b[31;1m%s\x1b[0m\n", message)
+		return
+	}
+	var props []string
+	if e.File != "" {
+		props = append(props, "file="+escapeProperty(e.File))
+		if e.FileLine > 0 {
+			props = append(props, fmt.Sprintf("line=%d", e.FileLine))
+		}
+	}
+	props = append(props, "title="+escapeProperty(title))
+	fmt.Fprintf(f.handler.out, "::error %s::%s\n", strings.Join(props, ","), escapeCommand(message))
+}
+
+// stepName returns the first line of the command of a step, or its command type
+func stepName(e Event) string {
+	if e.Command != "" {
+		return firstLine(e.Command)
+	}
+	return e.Interpreter
+}
+
+// appendOutput appends a step output to a $GITHUB_OUTPUT file
+func appendOutput(path, name, value string) error {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Errorf("failed to write step output '%s': %w", name, err)
+	}
+	delimiter := "ghadelimiter_" + hex.EncodeToString(b)
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to write step output '%s': %w", name, err)
+	}
+	_, err = fmt.Fprintf(file, "%s<<%s\n%s\n%s\n", name, delimiter, value, delimiter)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write step output '%s': %w", name, err)
+	}
+	return nil
+}
+
+// escapeCommand escapes the message of a GitHub workflow command
+func escapeCommand(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// escapeProperty escapes a property of a GitHub workflow command
+func escapeProperty(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(s)
+}
@@ -0,0 +1,121 @@
+package output
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/kontraktor-sh/kontraktor/internal/taskfile/interpreter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectCI(t *testing.T) {
+	env := func(vars map[string]string) func(string) string {
+		return func(name string) string { return vars[name] }
+	}
+	assert.Equal(t, CIGitHub, DetectCI(env(map[string]string{"GITHUB_ACTIONS": "true"})))
+	assert.Equal(t, CIGitLab, DetectCI(env(map[string]string{"GITLAB_CI": "true"})))
+	assert.Equal(t, CINone, DetectCI(env(map[string]string{"CI": "true"})))
+}
+
+// emitRun emits the events and text output of a run where task release runs task publish,
+// whose command fails
+func emitRun(h *Handler) {
+	at := time.Unix(1700000000, 0)
+	end := func(e Event) Event {
+		e.Time = at
+		e.Duration(time.Second)
+		return e
+	}
+	h.Emit(Event{Type: EventTaskStart, Time: at, Task: "release"})
+	h.Emit(Event{Type: EventStepStart, Time: at, Task: "release", Step: 1, Interpreter: "bash", Command: "make build"})
+	h.Emit(Event{Type: EventOutput, Task: "release", Step: 1, Stream: StreamStdout, Line: "v1.2.0"})
+	h.Emit(Event{Type: EventOutput, Task: "release", Step: 1, Stream: StreamStderr, Line: "warning"})
+	h.PrintResult(&interpreter.Result{Success: true, Output: "v1.2.0\nwarning"})
+	h.Emit(end(Event{Type: EventStepEnd, Task: "release", Step: 1, Interpreter: "bash", Status: StatusSuccess}))
+	h.Emit(Event{Type: EventStepStart, Time: at, Task: "release", Step: 2, Interpreter: "task"})
+	h.Emit(Event{Type: EventTaskStart, Time: at, Task: "publish"})
+	h.Emit(Event{Type: EventStepStart, Time: at, Task: "publish", Step: 1, Interpreter: "bash", Command: "curl -u ci:hunter2 https://repo"})
+	h.PrintResult(&interpreter.Result{Success: false, Output: "denied", Error: errors.New("exit status 22")})
+	h.Emit(end(Event{Type: EventStepEnd, Task: "publish", Step: 1, Interpreter: "bash", Status: StatusFailure, Error: "exit status 22", File: "ci/taskfile.ktr.yml", FileLine: 12}))
+	h.Emit(end(Event{Type: EventTaskEnd, Task: "publish", Status: StatusFailure}))
+	h.Emit(end(Event{Type: EventStepEnd, Task: "release", Step: 2, Interpreter: "task", Status: StatusFailure, Error: "command failed"}))
+	h.Emit(end(Event{Type: EventTaskEnd, Task: "release", Status: StatusFailure}))
+}
+
+func TestHandler_GitHubActions(t *testing.T) {
+	var out bytes.Buffer
+	outputFile := filepath.Join(t.TempDir(), "github_output")
+	h := NewHandler()
+	h.SetOutput(&out)
+	h.SetError(&out)
+	h.AddMaskValue("hunter2")
+	h.SetCI(CIGitHub, outputFile)
+	h.AddMaskValue("hunter2")
+	h.AddMaskValue("s3cr3t\nline")
+	emitRun(h)
+
+	assert.Equal(t, `::add-mask::aHVudGVyMg
+::add-mask::aHVudGVyMg==
+::add-mask::hunter2
+::add-mask::s3cr3t%0Aline
+::add-mask::s3cr3t
+::add-mask::line
+::add-mask::czNjcjN0CmxpbmU=
+::add-mask::czNjcjN0CmxpbmU
+::add-mask::s3cr3t%250Aline
+::add-mask::s3cr3t\nline
+::group::release: 1. make build
+v1.2.0
+warning
+::endgroup::
+::group::publish: 1. curl -u ci:[MASKED] https://repo
+[ERROR] Error: exit status 22
+Output: denied
+::endgroup::
+::error file=ci/taskfile.ktr.yml,line=12,title=publish%3A 1. curl -u ci%3A[MASKED] https%3A//repo::step 1 of task 'publish' failed: exit status 22
+`, out.String())
+
+	data, err := os.ReadFile(outputFile)
+	require.NoError(t, err)
+	assert.Regexp(t, regexp.MustCompile(`^release_step_1<<(ghadelimiter_[0-9a-f]{16})\nv1\.2\.0\n(ghadelimiter_[0-9a-f]{16})\n$`), string(data))
+}
+
+func TestHandler_GitLabCI(t *testing.T) {
+	var out bytes.Buffer
+	h := NewHandler()
+	h.SetOutput(&out)
+	h.SetError(&out)
+	h.SetCI(CIGitLab, "")
+	h.AddMaskValue("hunter2")
+	emitRun(h)
+
+	assert.Equal(t, "\x1b[0Ksection_start:1700000000:kontraktor_1\r\x1b[0KTask release\n"+
+		"\x1b[0Ksection_start:1700000000:kontraktor_2\r\x1b[0Krelease: 1. make build\n"+
+		"v1.2.0\nwarning\n"+
+		"\x1b[0Ksection_end:1700000000:kontraktor_2\r\x1b[0K\n"+
+		"\x1b[0Ksection_start:1700000000:kontraktor_3\r\x1b[0Krelease: 2. task\n"+
+		"\x1b[0Ksection_start:1700000000:kontraktor_4\r\x1b[0KTask publish\n"+
+		"\x1b[0Ksection_start:1700000000:kontraktor_5\r\x1b[0Kpublish: 1. curl -u ci:[MASKED] https://repo\n"+
+		"[ERROR] Error: exit status 22\nOutput: denied\n"+
+		"\x1b[0Ksection_end:1700000000:kontraktor_5\r\x1b[0K\n"+
+		"\x1b[31;1mstep 1 of task 'publish' failed: exit status 22\x1b[0m\n"+
+		"\x1b[0Ksection_end:1700000000:kontraktor_4\r\x1b[0K\n"+
+		"\x1b[0Ksection_end:1700000000:kontraktor_3\r\x1b[0K\n"+
+		"\x1b[0Ksection_end:1700000000:kontraktor_1\r\x1b[0K\n", out.String())
+}
+
+func TestHandler_CIIgnoredInJSONFormat(t *testing.T) {
+	var out bytes.Buffer
+	h := NewHandler()
+	h.SetOutput(&out)
+	h.SetFormat(FormatJSON)
+	h.SetCI(CIGitHub, "")
+	h.AddMaskValue("hunter2")
+	assert.Empty(t, out.String())
+}
@@ -0,0 +1,204 @@
+// event.go
+// Defines the events of the JSON output format, written as one JSON object per line.
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Format is the format of output written by a Handler
+type Format string
+
+const (
+	// FormatText prints human readable messages and command output
+	FormatText Format = "text"
+	// FormatJSON prints newline-delimited JSON events
+	FormatJSON Format = "json"
+)
+
+// EventType identifies the kind of an event
+type EventType string
+
+const (
+	// EventRunStart is emitted once before the task given on the command line starts
+	EventRunStart EventType = "run_start"
+	// EventRunEnd is emitted once with the final status of the run
+	EventRunEnd EventType = "run_end"
+	// EventTaskStart is emitted when a task starts, including tasks referenced by other tasks
+	EventTaskStart EventType = "task_start"
+	// EventTaskEnd is emitted when a task ends
+	EventTaskEnd EventType = "task_end"
+	// EventStepStart is emitted before a command of a task runs
+	EventStepStart EventType = "step_start"
+	// EventStepEnd is emitted after a command of a task ran
+	EventStepEnd EventType = "step_end"
+	// EventStepSkipped is emitted for a command that does not run
+	EventStepSkipped EventType = "step_skipped"
+	// EventOutput is emitted for each line a command writes
+	EventOutput EventType = "output"
+	// EventPhase is emitted when a phase of the run other than a task ends, such as fetching secrets
+	EventPhase EventType = "phase"
+	// EventLog is emitted for messages of kontraktor itself, such as debug information and errors
+	EventLog EventType = "log"
+)
+
+// Statuses of runs, tasks and steps
+const (
+	StatusSuccess = "success"
+	StatusFailure = "failure"
+)
+
+// Phases of runs
+const (
+	PhaseImport  = "import"  // Fetching and parsing an imported taskfile
+	PhaseSecrets = "secrets" // Fetching secrets from vaults
+)
+
+// Streams of output events
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+)
+
+// Event is an entry of the JSON output. Fields that do not apply to a type of event are omitted.
+type Event struct {
+	Type        EventType `json:"type"`
+	Time        time.Time `json:"time"`
+	RunID       string    `json:"run_id,omitempty"`
+	Task        string    `json:"task,omitempty"`
+	Phase       string    `json:"phase,omitempty"`
+	Vault       string    `json:"vault,omitempty"`       // Vault fetched by a secrets phase
+	Step        int       `json:"step,omitempty"`        // Position of the command in its task, from 1
+	Interpreter string    `json:"interpreter,omitempty"` // Command type of the step
+	Command     string    `json:"command,omitempty"`     // Command of the step before substitution, if it has one
+	File        string    `json:"file,omitempty"`        // Taskfile defining the step
+	FileLine    int       `json:"file_line,omitempty"`   // Line of the step in its taskfile
+	Stream      string    `json:"stream,omitempty"`
+	Line        string    `json:"line,omitempty"` // Omitted for empty lines
+	Level       string    `json:"level,omitempty"`
+	Message     string    `json:"message,omitempty"`
+	Status      string    `json:"status,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	Reason      string    `json:"reason,omitempty"`
+	ExitCode    *int      `json:"exit_code,omitempty"` // Exit code of the process run by a step, if it ran one
+	DurationMS  *int64    `json:"duration_ms,omitempty"`
+	// Span of the run, task, step or phase when runs are traced
+	TraceID      string `json:"trace_id,omitempty"`
+	SpanID       string `json:"span_id,omitempty"`
+	ParentSpanID string `json:"parent_span_id,omitempty"`
+}
+
+// Duration sets the duration of an ending run, task or step
+func (e *Event) Duration(d time.Duration) {
+	ms := d.Milliseconds()
+	e.DurationMS = &ms
+}
+
+// SetFormat sets the output format
+func (h *Handler) SetFormat(format Format) {
+	h.format = format
+}
+
+// JSON reports whether events are written as JSON
+func (h *Handler) JSON() bool {
+	return h.format == FormatJSON
+}
+
+// AddListener registers a function receiving every event, with sensitive data masked.
+// Listeners are called one event at a time, in the order events are emitted.
+func (h *Handler) AddListener(listener func(Event)) {
+	h.emitMu.Lock()
+	defer h.emitMu.Unlock()
+	h.listeners = append(h.listeners, listener)
+}
+
+// Events reports whether emitted events are used: written in the JSON format or received by listeners
+func (h *Handler) Events() bool {
+	h.emitMu.Lock()
+	defer h.emitMu.Unlock()
+	return h.JSON() || len(h.listeners) > 0
+}
+
+// Emit passes an event to listeners and writes it in the JSON output format, with sensitive
+// data masked; events are not written in the text format. The time of the event defaults
+// to the current time.
+func (h *Handler) Emit(e Event) {
+	if !h.Events() {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	e.Time = e.Time.UTC()
+	e.Command = h.MaskSensitiveData(e.Command)
+	e.Line = h.MaskSensitiveData(e.Line)
+	e.Message = h.MaskSensitiveData(e.Message)
+	e.Error = h.MaskSensitiveData(e.Error)
+
+	h.emitMu.Lock()
+	defer h.emitMu.Unlock()
+	for _, listener := range h.listeners {
+		listener(e)
+	}
+	if !h.JSON() {
+		return
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(e); err == nil {
+		h.out.Write(buf.Bytes())
+	}
+}
+
+// LineWriter emits an output event for each line written to it.
+// It is safe for concurrent use; Close emits the last line if it is not terminated.
+type LineWriter struct {
+	mu      sync.Mutex
+	handler *Handler
+	event   Event
+	partial []byte
+}
+
+// OutputWriter returns a writer emitting output events for the lines of a stream.
+// The run, task, step and interpreter of base identify the step writing the output.
+func (h *Handler) OutputWriter(base Event, stream string) *LineWriter {
+	e := Event{Type: EventOutput, RunID: base.RunID, Task: base.Task, Step: base.Step, Interpreter: base.Interpreter, Stream: stream}
+	return &LineWriter{handler: h, event: e}
+}
+
+func (w *LineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.emit(string(w.partial[:i]))
+		w.partial = w.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// Close emits the remaining unterminated line, if any
+func (w *LineWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.partial) > 0 {
+		w.emit(string(w.partial))
+		w.partial = nil
+	}
+	return nil
+}
+
+func (w *LineWriter) emit(line string) {
+	e := w.event
+	e.Line = strings.TrimSuffix(line, "\r")
+	w.handler.Emit(e)
+}
@@ -1,10 +1,17 @@
 package output
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"io"
+	"net/url"
 	"os"
 	"regexp"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/kontraktor-sh/kontraktor/internal/taskfile/interpreter"
 )
@@ -23,22 +30,38 @@ const (
 	LevelDebug
 )
 
+// Masked replaces sensitive data in output
+const Masked = "[MASKED]"
+
 // Handler manages command output and sensitive data masking
 type Handler struct {
+	mu           sync.RWMutex // Guards mask patterns and values
+	emitMu       sync.Mutex   // Serializes events emitted concurrently and guards listeners
+	listeners    []func(Event)
+	printer      *textPrinter
 	maskPatterns []*regexp.Regexp
+	maskValues   map[string]bool
+	masker       *strings.Replacer
 	verbosity    VerbosityLevel
+	format       Format
+	ci           CI
 	out          io.Writer
 	err          io.Writer
 }
 
 // NewHandler creates a new output handler
 func NewHandler() *Handler {
-	return &Handler{
+	h := &Handler{
 		maskPatterns: make([]*regexp.Regexp, 0),
+		maskValues:   make(map[string]bool),
 		verbosity:    LevelInfo, // Default to Info level
+		format:       FormatText,
 		out:          os.Stdout,
 		err:          os.Stderr,
 	}
+	h.printer = newTextPrinter(h)
+	h.listeners = []func(Event){h.printer.event}
+	return h
 }
 
 // SetLevel sets the verbosity level
@@ -56,45 +79,153 @@ func (h *Handler) SetError(err io.Writer) {
 	h.err = err
 }
 
-// AddMaskPattern adds a regex pattern for masking sensitive data
-func (h *Handler) AddMaskPattern(pattern string) {
-	re, err := regexp.Compile(pattern)
-	if err != nil {
-		// Log error but don't fail
-		fmt.Fprintf(h.err, "Warning: invalid mask pattern '%s': %v\n", pattern, err)
+// DefaultMaskPatterns mask the values of common credential assignments, such as token=abc
+var DefaultMaskPatterns = []string{
+	`(?i)[\w-]*(?:password|passwd|secret|token|key)=["']?([^\s&;,'"]+)`,
+}
+
+// AddMaskPattern masks matches of a regular expression in output. When the expression has
+// capture groups, only the text of the groups is masked.
+func (h *Handler) AddMaskPattern(pattern *regexp.Regexp) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.maskPatterns = append(h.maskPatterns, pattern)
+}
+
+// AddMaskValue masks every occurrence of a sensitive value, such as a secret, in output.
+// The value is also masked when base64, URL or JSON encoded, and each line of a multiline
+// value is masked on its own.
+func (h *Handler) AddMaskValue(value string) {
+	if strings.TrimSpace(value) == "" {
 		return
 	}
-	h.maskPatterns = append(h.maskPatterns, re)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var added []string
+	for _, v := range maskVariants(value) {
+		if !h.maskValues[v] {
+			h.maskValues[v] = true
+			added = append(added, v)
+		}
+	}
+	if h.ci == CIGitHub {
+		h.addMasks(added)
+	}
+	values := make([]string, 0, len(h.maskValues))
+	for v := range h.maskValues {
+		values = append(values, v)
+	}
+	// The replacer prefers earlier arguments: try longer values first
+	sort.Slice(values, func(i, j int) bool {
+		if len(values[i]) != len(values[j]) {
+			return len(values[i]) > len(values[j])
+		}
+		return values[i] < values[j]
+	})
+	pairs := make([]string, 0, 2*len(values))
+	for _, v := range values {
+		pairs = append(pairs, v, Masked)
+	}
+	h.masker = strings.NewReplacer(pairs...)
+}
+
+// maskVariants returns a value with its common encodings and, for multiline values, its lines
+func maskVariants(value string) []string {
+	variants := []string{value}
+	for _, line := range strings.Split(value, "\n") {
+		if line = strings.TrimSpace(line); line != "" && line != value {
+			variants = append(variants, line)
+		}
+	}
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.URLEncoding, base64.RawStdEncoding, base64.RawURLEncoding} {
+		variants = append(variants, enc.EncodeToString([]byte(value)))
+	}
+	variants = append(variants, url.QueryEscape(value), url.PathEscape(value))
+	for _, escapeHTML := range []bool{false, true} {
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(escapeHTML)
+		if err := enc.Encode(value); err == nil {
+			quoted := strings.TrimSpace(buf.String())
+			variants = append(variants, quoted[1:len(quoted)-1])
+		}
+	}
+	return variants
 }
 
-// MaskSensitiveData masks sensitive information in the output
+// MaskSensitiveData masks sensitive information in the output: values added with AddMaskValue,
+// then matches of mask patterns
 func (h *Handler) MaskSensitiveData(output string) string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
 	masked := output
+	if h.masker != nil {
+		masked = h.masker.Replace(masked)
+	}
 	for _, pattern := range h.maskPatterns {
-		masked = pattern.ReplaceAllString(masked, "[MASKED]")
+		masked = maskPattern(pattern, masked)
 	}
 	return masked
 }
 
+// maskPattern masks the matches of pattern in s, or only their capture groups if it has any
+func maskPattern(pattern *regexp.Regexp, s string) string {
+	if pattern.NumSubexp() == 0 {
+		return pattern.ReplaceAllLiteralString(s, Masked)
+	}
+	var b strings.Builder
+	last := 0
+	for _, match := range pattern.FindAllStringSubmatchIndex(s, -1) {
+		for i := 2; i < len(match); i += 2 {
+			start, end := match[i], match[i+1]
+			// Skip groups that did not participate or are nested in a masked group
+			if start < 0 || start < last || start == end {
+				continue
+			}
+			b.WriteString(s[last:start])
+			b.WriteString(Masked)
+			last = end
+		}
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
 // Debug prints debug information if verbosity level is DebugLevel
 func (h *Handler) Debug(format string, args ...interface{}) {
 	if h.verbosity >= LevelDebug {
-		fmt.Fprintf(h.out, "[DEBUG] "+format+"\n", args...)
+		h.print(h.out, "debug", "[DEBUG] ", fmt.Sprintf(format, args...))
 	}
 }
 
 // Info prints information if verbosity level is InfoLevel or higher
 func (h *Handler) Info(format string, args ...interface{}) {
 	if h.verbosity >= LevelInfo {
-		fmt.Fprintf(h.out, format+"\n", args...)
+		h.print(h.out, "info", "", fmt.Sprintf(format, args...))
+	}
+}
+
+// Warning prints a warning if verbosity level is ErrorLevel or higher
+func (h *Handler) Warning(format string, args ...interface{}) {
+	if h.verbosity >= LevelError {
+		h.print(h.err, "warning", "[WARNING] ", fmt.Sprintf(format, args...))
 	}
 }
 
 // Error prints error information if verbosity level is ErrorLevel or higher
 func (h *Handler) Error(format string, args ...interface{}) {
 	if h.verbosity >= LevelError {
-		fmt.Fprintf(h.err, "[ERROR] "+format+"\n", args...)
+		h.print(h.err, "error", "[ERROR] ", fmt.Sprintf(format, args...))
+	}
+}
+
+// print writes a message as a log event in the JSON format, or as a line of text to w
+func (h *Handler) print(w io.Writer, level, prefix, message string) {
+	if h.JSON() {
+		h.Emit(Event{Type: EventLog, Level: level, Message: message})
+		return
 	}
+	fmt.Fprintln(w, h.MaskSensitiveData(prefix+message))
 }
 
 // FormatError formats an error with masked sensitive data
@@ -103,8 +234,7 @@ func (h *Handler) FormatError(err error, output string) string {
 		return ""
 	}
 
-	maskedOutput := h.MaskSensitiveData(output)
-	return fmt.Sprintf("Error: %v\nOutput: %s", err, maskedOutput)
+	return h.MaskSensitiveData(fmt.Sprintf("Error: %v\nOutput: %s", err, output))
 }
 
 // FormatSuccess formats successful output with masked sensitive data
@@ -128,15 +258,27 @@ func (h *Handler) PrintCommand(cmdType string, content map[string]interface{}) {
 	}
 }
 
-// PrintResult prints command result based on verbosity level
+// PrintResult prints command result based on verbosity level. In the JSON format and in
+// output modes other than the default one, output is printed from output events instead.
 func (h *Handler) PrintResult(result *interpreter.Result) {
+	if h.JSON() {
+		return
+	}
+	h.emitMu.Lock()
+	mode := h.printer.currentMode()
+	h.emitMu.Unlock()
 	if result.Success {
-		if h.verbosity >= LevelInfo {
-			h.Info(h.FormatSuccess(result.Output))
+		if h.verbosity >= LevelInfo && mode == ModeDefault {
+			h.Info("%s", h.FormatSuccess(result.Output))
 		}
 	} else {
 		if h.verbosity >= LevelError {
-			h.Error(h.FormatError(result.Error, result.Output))
+			if mode == ModeDefault {
+				h.Error("%s", h.FormatError(result.Error, result.Output))
+			} else if !h.printer.buffer(h.MaskSensitiveData(fmt.Sprintf("[ERROR] Error: %v", result.Error))) {
+				// The output is printed according to the output mode of the task
+				h.Error("Error: %v", result.Error)
+			}
 		}
 	}
 }
@@ -0,0 +1,209 @@
+// junit.go
+// Builds JUnit XML reports of runs from their events.
+package output
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JUnitReport collects the events of a run into a JUnit XML report: each task run is
+// a test suite and each of its steps a test case. Register Event with Handler.AddListener.
+type JUnitReport struct {
+	mu     sync.Mutex
+	suites []*junitSuite
+	open   []*junitSuite // Suites of the tasks running, innermost last
+}
+
+type junitSuites struct {
+	XMLName  xml.Name      `xml:"testsuites"`
+	Name     string        `xml:"name,attr"`
+	Tests    int           `xml:"tests,attr"`
+	Failures int           `xml:"failures,attr"`
+	Skipped  int           `xml:"skipped,attr"`
+	Time     string        `xml:"time,attr"`
+	Suites   []*junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name      string       `xml:"name,attr"`
+	Tests     int          `xml:"tests,attr"`
+	Failures  int          `xml:"failures,attr"`
+	Errors    int          `xml:"errors,attr"`
+	Skipped   int          `xml:"skipped,attr"`
+	Time      string       `xml:"time,attr"`
+	Timestamp string       `xml:"timestamp,attr"`
+	Cases     []*junitCase `xml:"testcase"`
+
+	duration time.Duration
+	root     bool // Run directly rather than by another task
+	steps    map[int]*junitCase
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+
+	output strings.Builder
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+// NewJUnitReport creates an empty report
+func NewJUnitReport() *JUnitReport {
+	return &JUnitReport{}
+}
+
+// Event adds an event of the run to the report
+func (r *JUnitReport) Event(e Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if e.Type == EventTaskStart {
+		suite := &junitSuite{Name: e.Task, Timestamp: e.Time.UTC().Format("2006-01-02T15:04:05"), root: len(r.open) == 0, steps: make(map[int]*junitCase)}
+		r.suites = append(r.suites, suite)
+		r.open = append(r.open, suite)
+		return
+	}
+	suite := r.current(e.Task)
+	if suite == nil {
+		return
+	}
+	switch e.Type {
+	case EventTaskEnd:
+		suite.duration = eventDuration(e)
+		for i := len(r.open) - 1; i >= 0; i-- {
+			if r.open[i] == suite {
+				r.open = append(r.open[:i], r.open[i+1:]...)
+				break
+			}
+		}
+	case EventStepStart, EventStepSkipped:
+		name := e.Interpreter
+		if e.Command != "" {
+			name = e.Command
+		}
+		c := &junitCase{Name: fmt.Sprintf("%d. %s", e.Step, firstLine(name)), Classname: e.Task}
+		if e.Type == EventStepSkipped {
+			c.Skipped = &junitMessage{Message: e.Reason}
+		}
+		suite.steps[e.Step] = c
+		suite.Cases = append(suite.Cases, c)
+	case EventOutput:
+		if c := suite.steps[e.Step]; c != nil {
+			c.output.WriteString(e.Line + "\n")
+		}
+	case EventStepEnd:
+		if c := suite.steps[e.Step]; c != nil {
+			c.Time = seconds(eventDuration(e))
+			if e.Status == StatusFailure {
+				message := e.Error
+				if message == "" {
+					message = "step failed"
+				}
+				c.Failure = &junitMessage{Message: firstLine(message), Text: message}
+			}
+		}
+	}
+}
+
+// current returns the innermost running suite of a task
+func (r *JUnitReport) current(task string) *junitSuite {
+	for i := len(r.open) - 1; i >= 0; i-- {
+		if r.open[i].Name == task {
+			return r.open[i]
+		}
+	}
+	return nil
+}
+
+// Write writes the report as JUnit XML
+func (r *JUnitReport) Write(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	root := junitSuites{Name: "kontraktor", Suites: r.suites}
+	var total time.Duration
+	for _, suite := range r.suites {
+		suite.Tests, suite.Failures, suite.Skipped = len(suite.Cases), 0, 0
+		for _, c := range suite.Cases {
+			c.SystemOut = c.output.String()
+			if c.Time == "" {
+				c.Time = seconds(0)
+			}
+			if c.Failure != nil {
+				suite.Failures++
+			}
+			if c.Skipped != nil {
+				suite.Skipped++
+			}
+		}
+		suite.Time = seconds(suite.duration)
+		root.Tests += suite.Tests
+		root.Failures += suite.Failures
+		root.Skipped += suite.Skipped
+		// Referenced tasks run within the steps of their callers
+		if suite.root {
+			total += suite.duration
+		}
+	}
+	root.Time = seconds(total)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(root); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// WriteFile writes the report as JUnit XML to a file
+func (r *JUnitReport) WriteFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to write JUnit report: %w", err)
+	}
+	if err := r.Write(f); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write JUnit report: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write JUnit report: %w", err)
+	}
+	return nil
+}
+
+// eventDuration returns the duration of an end event
+func eventDuration(e Event) time.Duration {
+	if e.DurationMS == nil {
+		return 0
+	}
+	return time.Duration(*e.DurationMS) * time.Millisecond
+}
+
+// seconds formats a duration as JUnit does
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// firstLine returns the first line of a possibly multiline text
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(s), "\n")
+	return line
+}
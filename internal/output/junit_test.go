@@ -0,0 +1,69 @@
+package output
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJUnitReport(t *testing.T) {
+	var out bytes.Buffer
+	h := NewHandler()
+	h.SetOutput(&out)
+	h.AddMaskValue("hunter2")
+	report := NewJUnitReport()
+	h.AddListener(report.Event)
+	assert.True(t, h.Events())
+
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	end := func(e Event, ms int64) Event {
+		e.Duration(time.Duration(ms) * time.Millisecond)
+		return e
+	}
+	for _, e := range []Event{
+		{Type: EventRunStart, Time: start, Task: "release"},
+		{Type: EventTaskStart, Time: start, Task: "release"},
+		{Type: EventStepStart, Task: "release", Step: 1, Interpreter: "bash", Command: "make build\nmake test"},
+		{Type: EventOutput, Task: "release", Step: 1, Stream: StreamStdout, Line: "built with hunter2"},
+		{Type: EventOutput, Task: "release", Step: 1, Stream: StreamStderr, Line: "warning <deprecated>"},
+		end(Event{Type: EventStepEnd, Task: "release", Step: 1, Status: StatusSuccess}, 1500),
+		{Type: EventStepStart, Task: "release", Step: 2, Interpreter: "task"},
+		{Type: EventTaskStart, Time: start, Task: "publish"},
+		{Type: EventStepStart, Task: "publish", Step: 1, Interpreter: "bash", Command: "exit 1"},
+		end(Event{Type: EventStepEnd, Task: "publish", Step: 1, Status: StatusFailure, Error: "exit status 1"}, 20),
+		end(Event{Type: EventTaskEnd, Task: "publish", Status: StatusFailure}, 25),
+		end(Event{Type: EventStepEnd, Task: "release", Step: 2, Status: StatusFailure, Error: "command failed"}, 30),
+		{Type: EventStepSkipped, Task: "release", Step: 3, Interpreter: "bash", Command: "echo done", Reason: "a previous step failed"},
+		end(Event{Type: EventTaskEnd, Task: "release", Status: StatusFailure}, 1540),
+		end(Event{Type: EventRunEnd, Task: "release", Status: StatusFailure}, 1541),
+	} {
+		h.Emit(e)
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, report.Write(&buf))
+	assert.Empty(t, out.String(), "events are not written in the text format")
+	assert.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites name="kontraktor" tests="4" failures="2" skipped="1" time="1.540">
+  <testsuite name="release" tests="3" failures="1" errors="0" skipped="1" time="1.540" timestamp="2026-03-01T12:00:00">
+    <testcase name="1. make build" classname="release" time="1.500">
+      <system-out>built with [MASKED]&#xA;warning &lt;deprecated&gt;&#xA;</system-out>
+    </testcase>
+    <testcase name="2. task" classname="release" time="0.030">
+      <failure message="command failed">command failed</failure>
+    </testcase>
+    <testcase name="3. echo done" classname="release" time="0.000">
+      <skipped message="a previous step failed"></skipped>
+    </testcase>
+  </testsuite>
+  <testsuite name="publish" tests="1" failures="1" errors="0" skipped="0" time="0.025" timestamp="2026-03-01T12:00:00">
+    <testcase name="1. exit 1" classname="publish" time="0.020">
+      <failure message="exit status 1">exit status 1</failure>
+    </testcase>
+  </testsuite>
+</testsuites>
+`, buf.String())
+}
@@ -0,0 +1,170 @@
+// mode.go
+// Prints the output of tasks in the text format according to their output mode.
+package output
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+)
+
+// Mode is how the output of a task is printed in the text format
+type Mode string
+
+const (
+	// ModeDefault prints the output of each command when the command finishes
+	ModeDefault Mode = ""
+	// ModeInterleaved prints lines as they are written, prefixed with [task:step]
+	ModeInterleaved Mode = "interleaved"
+	// ModeGrouped prints the output of a task at once when the task finishes
+	ModeGrouped Mode = "grouped"
+	// ModeQuietSuccess prints the output of a task at once when the task fails, and nothing when it succeeds
+	ModeQuietSuccess Mode = "quiet-success"
+)
+
+// Modes lists the output modes that can be selected
+var Modes = []Mode{ModeInterleaved, ModeGrouped, ModeQuietSuccess}
+
+// ParseMode parses the name of an output mode
+func ParseMode(name string) (Mode, error) {
+	for _, m := range Modes {
+		if string(m) == name {
+			return m, nil
+		}
+	}
+	return ModeDefault, fmt.Errorf("unknown output mode '%s' (expected interleaved, grouped or quiet-success)", name)
+}
+
+// prefixColors are the ANSI colors of task prefixes in the interleaved mode
+var prefixColors = []string{"36", "33", "35", "32", "34", "31"}
+
+// SetMode sets the output mode of tasks that do not set their own
+func (h *Handler) SetMode(mode Mode) {
+	h.emitMu.Lock()
+	defer h.emitMu.Unlock()
+	h.printer.mode = mode
+}
+
+// SetTaskMode sets the output mode of a task. Tasks referenced by a task without their own
+// mode inherit it.
+func (h *Handler) SetTaskMode(task string, mode Mode) {
+	h.emitMu.Lock()
+	defer h.emitMu.Unlock()
+	h.printer.taskModes[task] = mode
+}
+
+// SetColor enables colored task prefixes in the interleaved mode
+func (h *Handler) SetColor(color bool) {
+	h.emitMu.Lock()
+	defer h.emitMu.Unlock()
+	h.printer.color = color
+}
+
+// textPrinter prints the output of running tasks from events, in the text format
+type textPrinter struct {
+	handler   *Handler
+	mode      Mode
+	taskModes map[string]Mode
+	color     bool
+	tasks     []*taskOutput // Running tasks, innermost last
+}
+
+// taskOutput is the output of a running task, buffered unless printed as it is written
+type taskOutput struct {
+	name  string
+	mode  Mode
+	lines []string
+}
+
+func newTextPrinter(h *Handler) *textPrinter {
+	return &textPrinter{handler: h, taskModes: make(map[string]Mode)}
+}
+
+// current returns the innermost running task, if any
+func (p *textPrinter) current() *taskOutput {
+	if len(p.tasks) == 0 {
+		return nil
+	}
+	return p.tasks[len(p.tasks)-1]
+}
+
+// currentMode returns the output mode of the innermost running task
+func (p *textPrinter) currentMode() Mode {
+	if t := p.current(); t != nil {
+		return t.mode
+	}
+	return p.mode
+}
+
+// buffer adds a line to the output of the innermost running task when that output is
+// printed at once, so that the line is printed with it. It reports whether the line was added.
+func (p *textPrinter) buffer(line string) bool {
+	p.handler.emitMu.Lock()
+	defer p.handler.emitMu.Unlock()
+	t := p.current()
+	if t == nil || (t.mode != ModeGrouped && t.mode != ModeQuietSuccess) {
+		return false
+	}
+	t.lines = append(t.lines, line)
+	return true
+}
+
+func (p *textPrinter) event(e Event) {
+	if p.handler.JSON() || p.handler.verbosity < LevelInfo {
+		return
+	}
+	switch e.Type {
+	case EventTaskStart:
+		mode, ok := p.taskModes[e.Task]
+		if !ok {
+			mode = p.currentMode()
+		}
+		p.tasks = append(p.tasks, &taskOutput{name: e.Task, mode: mode})
+	case EventOutput:
+		t := p.current()
+		if t == nil || t.mode == ModeDefault {
+			return
+		}
+		line := p.prefix(e.Task, e.Step) + e.Line
+		if t.mode == ModeInterleaved {
+			fmt.Fprintln(p.handler.out, line)
+			return
+		}
+		t.lines = append(t.lines, line)
+	case EventTaskEnd:
+		t := p.current()
+		if t == nil {
+			return
+		}
+		p.tasks = p.tasks[:len(p.tasks)-1]
+		if t.mode != ModeGrouped && t.mode != ModeQuietSuccess {
+			return
+		}
+		if t.mode == ModeQuietSuccess && e.Status != StatusFailure {
+			return
+		}
+		block := append([]string{p.header(e)}, t.lines...)
+		// Tasks run by a buffered task are part of its output
+		if parent := p.current(); parent != nil && (parent.mode == ModeGrouped || parent.mode == ModeQuietSuccess) {
+			parent.lines = append(parent.lines, block...)
+			return
+		}
+		fmt.Fprint(p.handler.out, strings.Join(block, "\n")+"\n")
+	}
+}
+
+// prefix returns the [task:step] prefix of an output line
+func (p *textPrinter) prefix(task string, step int) string {
+	prefix := fmt.Sprintf("[%s:%d] ", task, step)
+	if !p.color {
+		return prefix
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(task))
+	return fmt.Sprintf("\x1b[%sm%s\x1b[0m", prefixColors[hash.Sum32()%uint32(len(prefixColors))], prefix)
+}
+
+// header returns the line printed before the buffered output of a task
+func (p *textPrinter) header(e Event) string {
+	return fmt.Sprintf("--- %s: %s (%s)", e.Task, e.Status, eventDuration(e))
+}
@@ -0,0 +1,122 @@
+package output
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kontraktor-sh/kontraktor/internal/taskfile/interpreter"
+	"github.com/stretchr/testify/assert"
+)
+
+// emitNested emits the events and results of a run where task build runs task lint between
+// two commands of its own, and lint fails
+func emitNested(h *Handler) {
+	end := func(t EventType, task string, step int, status string) {
+		e := Event{Type: t, Task: task, Step: step, Status: status}
+		e.Duration(1500 * time.Millisecond)
+		h.Emit(e)
+	}
+	h.Emit(Event{Type: EventTaskStart, Task: "build"})
+	h.Emit(Event{Type: EventStepStart, Task: "build", Step: 1})
+	h.Emit(Event{Type: EventOutput, Task: "build", Step: 1, Line: "compiling"})
+	h.PrintResult(&interpreter.Result{Success: true, Output: "compiling"})
+	end(EventStepEnd, "build", 1, StatusSuccess)
+	h.Emit(Event{Type: EventStepStart, Task: "build", Step: 2})
+	h.Emit(Event{Type: EventTaskStart, Task: "lint"})
+	h.Emit(Event{Type: EventStepStart, Task: "lint", Step: 1})
+	h.Emit(Event{Type: EventOutput, Task: "lint", Step: 1, Line: "main.go:3: unused"})
+	h.PrintResult(&interpreter.Result{Success: false, Output: "main.go:3: unused", Error: errors.New("exit status 1")})
+	end(EventStepEnd, "lint", 1, StatusFailure)
+	end(EventTaskEnd, "lint", 0, StatusFailure)
+	end(EventStepEnd, "build", 2, StatusFailure)
+	end(EventTaskEnd, "build", 0, StatusFailure)
+}
+
+func TestHandler_OutputModes(t *testing.T) {
+	tests := []struct {
+		name      string
+		mode      Mode
+		taskModes map[string]Mode
+		want      string
+	}{
+		{
+			name: "default",
+			want: "compiling\n[ERROR] Error: exit status 1\nOutput: main.go:3: unused\n",
+		},
+		{
+			name: "interleaved",
+			mode: ModeInterleaved,
+			want: "[build:1] compiling\n[lint:1] main.go:3: unused\n[ERROR] Error: exit status 1\n",
+		},
+		{
+			name: "grouped includes referenced tasks",
+			mode: ModeGrouped,
+			want: "--- build: failure (1.5s)\n[build:1] compiling\n" +
+				"--- lint: failure (1.5s)\n[lint:1] main.go:3: unused\n[ERROR] Error: exit status 1\n",
+		},
+		{
+			name: "quiet-success prints errors with the failing task",
+			mode: ModeQuietSuccess,
+			want: "--- build: failure (1.5s)\n[build:1] compiling\n" +
+				"--- lint: failure (1.5s)\n[lint:1] main.go:3: unused\n[ERROR] Error: exit status 1\n",
+		},
+		{
+			name:      "quiet-success task",
+			taskModes: map[string]Mode{"build": ModeQuietSuccess, "lint": ModeInterleaved},
+			want:      "[lint:1] main.go:3: unused\n[ERROR] Error: exit status 1\n--- build: failure (1.5s)\n[build:1] compiling\n",
+		},
+		{
+			name:      "task modes override the run mode",
+			mode:      ModeInterleaved,
+			taskModes: map[string]Mode{"lint": ModeGrouped},
+			want:      "[build:1] compiling\n--- lint: failure (1.5s)\n[lint:1] main.go:3: unused\n[ERROR] Error: exit status 1\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			h := NewHandler()
+			h.SetOutput(&out)
+			h.SetError(&out)
+			h.SetMode(tt.mode)
+			for task, mode := range tt.taskModes {
+				h.SetTaskMode(task, mode)
+			}
+			emitNested(h)
+			assert.Equal(t, tt.want, out.String())
+		})
+	}
+}
+
+func TestHandler_QuietSuccessHidesSucceedingTasks(t *testing.T) {
+	var out bytes.Buffer
+	h := NewHandler()
+	h.SetOutput(&out)
+	h.SetMode(ModeQuietSuccess)
+	h.Emit(Event{Type: EventTaskStart, Task: "build"})
+	h.Emit(Event{Type: EventOutput, Task: "build", Step: 1, Line: "ok"})
+	h.PrintResult(&interpreter.Result{Success: true, Output: "ok"})
+	h.Emit(Event{Type: EventTaskEnd, Task: "build", Status: StatusSuccess})
+	assert.Empty(t, out.String())
+}
+
+func TestHandler_ColoredPrefixes(t *testing.T) {
+	var out bytes.Buffer
+	h := NewHandler()
+	h.SetOutput(&out)
+	h.SetMode(ModeInterleaved)
+	h.SetColor(true)
+	h.Emit(Event{Type: EventTaskStart, Task: "build"})
+	h.Emit(Event{Type: EventOutput, Task: "build", Step: 2, Line: "ok"})
+	assert.Regexp(t, `^\x1b\[3\dm\[build:2\] \x1b\[0mok\n$`, out.String())
+}
+
+func TestParseMode(t *testing.T) {
+	mode, err := ParseMode("quiet-success")
+	assert.NoError(t, err)
+	assert.Equal(t, ModeQuietSuccess, mode)
+	_, err = ParseMode("quiet")
+	assert.EqualError(t, err, "unknown output mode 'quiet' (expected interleaved, grouped or quiet-success)")
+}
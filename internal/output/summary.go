@@ -0,0 +1,119 @@
+// summary.go
+// Prints a table of the steps of a run with their status and duration when the run ends.
+package output
+
+import (
+	"fmt"
+	"text/tabwriter"
+	"time"
+)
+
+// summaryWidth is the number of characters of a command shown in the summary table
+const summaryWidth = 40
+
+// summary collects the steps of a run for the table printed when it ends
+type summary struct {
+	handler *Handler
+	rows    []*summaryRow
+	open    []*summaryTask // Tasks running, innermost last
+}
+
+type summaryTask struct {
+	name  string
+	steps map[int]*summaryRow
+}
+
+type summaryRow struct {
+	task        string
+	step        string
+	interpreter string
+	status      string
+	duration    time.Duration
+	retries     int // Times the step started again; 0 until the executor retries steps
+}
+
+// EnableSummary prints a table of the steps of the run, in the order they ran, with their
+// status and duration when the run ends. The table is printed in the text format only.
+func (h *Handler) EnableSummary() {
+	s := &summary{handler: h}
+	h.AddListener(s.event)
+}
+
+func (s *summary) event(e Event) {
+	switch e.Type {
+	case EventRunStart:
+		s.rows, s.open = nil, nil
+	case EventTaskStart:
+		s.open = append(s.open, &summaryTask{name: e.Task, steps: make(map[int]*summaryRow)})
+	case EventTaskEnd:
+		for i := len(s.open) - 1; i >= 0; i-- {
+			if s.open[i].name == e.Task {
+				s.open = append(s.open[:i], s.open[i+1:]...)
+				break
+			}
+		}
+	case EventStepStart, EventStepSkipped:
+		t := s.current(e.Task)
+		if t == nil {
+			return
+		}
+		// A step starting again within the same task run is retried. The executor does not
+		// retry steps yet, so the RETRIES column is reserved and 0 in runs for now.
+		if row := t.steps[e.Step]; row != nil {
+			row.retries++
+			return
+		}
+		row := &summaryRow{task: e.Task, step: fmt.Sprintf("%d. %s", e.Step, truncate(stepName(e), summaryWidth)), interpreter: e.Interpreter}
+		if e.Type == EventStepSkipped {
+			row.status = "skipped"
+		}
+		t.steps[e.Step] = row
+		s.rows = append(s.rows, row)
+	case EventStepEnd:
+		if t := s.current(e.Task); t != nil && t.steps[e.Step] != nil {
+			t.steps[e.Step].status = e.Status
+			t.steps[e.Step].duration = eventDuration(e)
+		}
+	case EventRunEnd:
+		s.print(e)
+	}
+}
+
+// current returns the innermost running task with a name
+func (s *summary) current(task string) *summaryTask {
+	for i := len(s.open) - 1; i >= 0; i-- {
+		if s.open[i].name == task {
+			return s.open[i]
+		}
+	}
+	return nil
+}
+
+// print writes the table of the steps of a run that ended
+func (s *summary) print(e Event) {
+	h := s.handler
+	if h.JSON() || h.verbosity < LevelInfo {
+		return
+	}
+	w := tabwriter.NewWriter(h.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "TASK\tSTEP\tINTERPRETER\tSTATUS\tDURATION\tRETRIES")
+	for _, row := range s.rows {
+		duration := "-"
+		if row.status != "skipped" {
+			duration = row.duration.String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\n", row.task, row.step, row.interpreter, row.status, duration, row.retries)
+	}
+	fmt.Fprintf(w, "total\t\t\t%s\t%s\n", e.Status, eventDuration(e))
+	w.Flush()
+}
+
+// truncate shortens a text to at most n characters, marking the cut with ...
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-3]) + "..."
+}
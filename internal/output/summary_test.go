@@ -0,0 +1,75 @@
+package output
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandler_Summary(t *testing.T) {
+	end := func(e Event, ms int64) Event {
+		e.Duration(time.Duration(ms) * time.Millisecond)
+		return e
+	}
+	events := []Event{
+		{Type: EventRunStart, Task: "ci"},
+		{Type: EventTaskStart, Task: "ci"},
+		{Type: EventStepStart, Task: "ci", Step: 1, Interpreter: "bash", Command: "go build -ldflags \"-X main.version=1.2.3 -X main.commit=abcdef\" ./..."},
+		end(Event{Type: EventStepEnd, Task: "ci", Step: 1, Status: StatusSuccess}, 1204),
+		{Type: EventStepStart, Task: "ci", Step: 2, Interpreter: "task"},
+		{Type: EventTaskStart, Task: "test"},
+		{Type: EventStepStart, Task: "test", Step: 1, Interpreter: "bash", Command: "go test ./..."},
+		// Starting again, as a retried step will
+		{Type: EventStepStart, Task: "test", Step: 1, Interpreter: "bash", Command: "go test ./..."},
+		end(Event{Type: EventStepEnd, Task: "test", Step: 1, Status: StatusFailure}, 65000),
+		end(Event{Type: EventTaskEnd, Task: "test", Status: StatusFailure}, 65001),
+		end(Event{Type: EventStepEnd, Task: "ci", Step: 2, Status: StatusFailure}, 65002),
+		{Type: EventStepSkipped, Task: "ci", Step: 3, Interpreter: "bash", Command: "echo done"},
+		end(Event{Type: EventTaskEnd, Task: "ci", Status: StatusFailure}, 66210),
+		end(Event{Type: EventRunEnd, Task: "ci", Status: StatusFailure}, 66211),
+	}
+
+	tests := []struct {
+		name     string
+		json     bool
+		level    VerbosityLevel
+		expected string
+	}{
+		{
+			name:  "text",
+			level: LevelInfo,
+			expected: `
+TASK   STEP                                         INTERPRETER  STATUS   DURATION  RETRIES
+ci     1. go build -ldflags "-X main.version=1....  bash         success  1.204s    0
+ci     2. task                                      task         failure  1m5.002s  0
+test   1. go test ./...                             bash         failure  1m5s      1
+ci     3. echo done                                 bash         skipped  -         0
+total                                                            failure  1m6.211s
+`,
+		},
+		{name: "json", json: true, level: LevelInfo},
+		{name: "silent", level: LevelError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			h := NewHandler()
+			h.SetOutput(&out)
+			h.SetLevel(tt.level)
+			h.EnableSummary()
+			if tt.json {
+				h.SetFormat(FormatJSON)
+			}
+			for _, e := range events {
+				h.Emit(e)
+			}
+			if tt.json {
+				assert.NotContains(t, out.String(), "TASK")
+				return
+			}
+			assert.Equal(t, tt.expected, out.String())
+		})
+	}
+}
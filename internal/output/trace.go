@@ -0,0 +1,223 @@
+// trace.go
+// Builds trace files of runs from their events, in the Chrome trace event format read by
+// Perfetto and chrome://tracing.
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Trace collects the events of a run into a trace: the run, each task, each step and phases
+// such as fetching secrets and resolving imports are spans. Spans running at the same time
+// without being nested are placed in parallel lanes. Register Event with Handler.AddListener.
+type Trace struct {
+	mu    sync.Mutex
+	spans []*traceSpan
+	open  map[string][]*traceSpan // Spans started but not ended, innermost last, by task or step
+}
+
+type traceSpan struct {
+	name  string
+	cat   string
+	start time.Time
+	end   time.Time
+	args  map[string]interface{}
+}
+
+// traceEvent is an entry of the trace event format
+type traceEvent struct {
+	Name string                 `json:"name"`
+	Cat  string                 `json:"cat,omitempty"`
+	Ph   string                 `json:"ph"`
+	Ts   int64                  `json:"ts"`            // Microseconds since the start of the trace
+	Dur  *int64                 `json:"dur,omitempty"` // Microseconds
+	Pid  int                    `json:"pid"`
+	Tid  int                    `json:"tid"`
+	Args map[string]interface{} `json:"args,omitempty"`
+}
+
+type traceFile struct {
+	TraceEvents     []traceEvent `json:"traceEvents"`
+	DisplayTimeUnit string       `json:"displayTimeUnit"`
+}
+
+// NewTrace creates an empty trace
+func NewTrace() *Trace {
+	return &Trace{open: make(map[string][]*traceSpan)}
+}
+
+// Event adds an event of the run to the trace
+func (t *Trace) Event(e Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch e.Type {
+	case EventRunStart:
+		t.start("run", &traceSpan{name: "run " + e.Task, cat: "run", start: e.Time, args: map[string]interface{}{"run_id": e.RunID}})
+	case EventTaskStart:
+		t.start("task:"+e.Task, &traceSpan{name: e.Task, cat: "task", start: e.Time, args: map[string]interface{}{}})
+	case EventStepStart:
+		args := map[string]interface{}{"task": e.Task, "step": e.Step, "interpreter": e.Interpreter}
+		if e.Command != "" {
+			args["command"] = e.Command
+		}
+		if e.File != "" {
+			args["file"] = e.File
+			args["file_line"] = e.FileLine
+		}
+		t.start(fmt.Sprintf("step:%s:%d", e.Task, e.Step), &traceSpan{name: fmt.Sprintf("%s: %d. %s", e.Task, e.Step, stepName(e)), cat: "step", start: e.Time, args: args})
+	case EventRunEnd:
+		t.end("run", e)
+	case EventTaskEnd:
+		t.end("task:"+e.Task, e)
+	case EventStepEnd:
+		t.end(fmt.Sprintf("step:%s:%d", e.Task, e.Step), e)
+	case EventPhase:
+		name := e.Phase
+		if e.Vault != "" {
+			name += " " + e.Vault
+		} else if e.File != "" {
+			name += " " + e.File
+		}
+		span := &traceSpan{name: name, cat: "phase", start: e.Time, end: e.Time.Add(eventDuration(e)), args: map[string]interface{}{}}
+		setStatus(span, e)
+		t.spans = append(t.spans, span)
+	}
+}
+
+// start opens a span ended by a later event
+func (t *Trace) start(key string, span *traceSpan) {
+	t.open[key] = append(t.open[key], span)
+}
+
+// end closes the innermost open span of a key
+func (t *Trace) end(key string, e Event) {
+	open := t.open[key]
+	if len(open) == 0 {
+		return
+	}
+	span := open[len(open)-1]
+	t.open[key] = open[:len(open)-1]
+	span.end = e.Time
+	setStatus(span, e)
+	t.spans = append(t.spans, span)
+}
+
+// setStatus records the status and error of an end event in the arguments of a span
+func setStatus(span *traceSpan, e Event) {
+	if e.Status != "" {
+		span.args["status"] = e.Status
+	}
+	if e.Error != "" {
+		span.args["error"] = e.Error
+	}
+}
+
+// lanes assigns the spans, sorted by start, to lanes numbered from 1. Spans in a lane are
+// nested or follow each other, as the trace event format requires of complete events in a thread.
+func lanes(spans []*traceSpan) []int {
+	var stacks [][]*traceSpan // Spans of each lane that may contain later spans, innermost last
+	assigned := make([]int, len(spans))
+	for i, span := range spans {
+		lane := -1
+		for l, stack := range stacks {
+			for len(stack) > 0 && !stack[len(stack)-1].end.After(span.start) {
+				stack = stack[:len(stack)-1]
+			}
+			stacks[l] = stack
+			if len(stack) == 0 {
+				lane = l
+				break
+			}
+			top := stack[len(stack)-1]
+			// Durations of phases are known to the millisecond: a nested phase may appear to end
+			// a little after the phase containing it
+			if span.end.After(top.end) && span.end.Sub(top.end) < time.Millisecond {
+				span.end = top.end
+			}
+			if !span.end.After(top.end) {
+				lane = l
+				break
+			}
+		}
+		if lane < 0 {
+			lane = len(stacks)
+			stacks = append(stacks, nil)
+		}
+		stacks[lane] = append(stacks[lane], span)
+		assigned[i] = lane + 1
+	}
+	return assigned
+}
+
+// Write writes the trace as JSON in the trace event format
+func (t *Trace) Write(w io.Writer) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	spans := append([]*traceSpan(nil), t.spans...)
+	// Spans starting together are nested: the longest contains the others
+	sort.SliceStable(spans, func(i, j int) bool {
+		if !spans[i].start.Equal(spans[j].start) {
+			return spans[i].start.Before(spans[j].start)
+		}
+		return spans[i].end.After(spans[j].end)
+	})
+
+	file := traceFile{DisplayTimeUnit: "ms", TraceEvents: []traceEvent{
+		{Name: "process_name", Ph: "M", Pid: 1, Args: map[string]interface{}{"name": "kontraktor"}},
+	}}
+	assigned := lanes(spans)
+	count := 0
+	for _, lane := range assigned {
+		if lane > count {
+			count = lane
+		}
+	}
+	for lane := 1; lane <= count; lane++ {
+		file.TraceEvents = append(file.TraceEvents, traceEvent{Name: "thread_name", Ph: "M", Pid: 1, Tid: lane, Args: map[string]interface{}{"name": fmt.Sprintf("lane %d", lane)}})
+	}
+	var origin time.Time
+	if len(spans) > 0 {
+		origin = spans[0].start
+	}
+	for i, span := range spans {
+		dur := span.end.Sub(span.start).Microseconds()
+		file.TraceEvents = append(file.TraceEvents, traceEvent{
+			Name: span.name,
+			Cat:  span.cat,
+			Ph:   "X",
+			Ts:   span.start.Sub(origin).Microseconds(),
+			Dur:  &dur,
+			Pid:  1,
+			Tid:  assigned[i],
+			Args: span.args,
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return enc.Encode(file)
+}
+
+// WriteFile writes the trace as JSON in the trace event format to a file
+func (t *Trace) WriteFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to write trace: %w", err)
+	}
+	if err := t.Write(f); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write trace: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write trace: %w", err)
+	}
+	return nil
+}
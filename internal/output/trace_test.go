@@ -0,0 +1,92 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrace(t *testing.T) {
+	h := NewHandler()
+	h.SetOutput(&bytes.Buffer{})
+	h.AddMaskValue("hunter2")
+	trace := NewTrace()
+	h.AddListener(trace.Event)
+
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	at := func(ms int) time.Time {
+		return start.Add(time.Duration(ms) * time.Millisecond)
+	}
+	phase := func(e Event, ms int64) Event {
+		e.Type = EventPhase
+		e.Status = StatusSuccess
+		e.Duration(time.Duration(ms) * time.Millisecond)
+		return e
+	}
+	for _, e := range []Event{
+		// A nested import appears to end after the import containing it
+		phase(Event{Time: at(0).Add(500 * time.Microsecond), Phase: PhaseImport, File: "shared/go.ktr.yml"}, 9),
+		phase(Event{Time: at(0), Phase: PhaseImport, File: "shared.ktr.yml"}, 9),
+		{Type: EventRunStart, Time: at(10), RunID: "r1", Task: "ci"},
+		phase(Event{Time: at(11), RunID: "r1", Task: "ci", Phase: PhaseSecrets}, 4),
+		{Type: EventTaskStart, Time: at(20), Task: "ci"},
+		{Type: EventStepStart, Time: at(21), Task: "ci", Step: 1, Interpreter: "bash", Command: "deploy --token hunter2", File: "taskfile.ktr.yml", FileLine: 7},
+		// Steps of another task running at the same time
+		{Type: EventTaskStart, Time: at(25), Task: "lint"},
+		{Type: EventStepStart, Time: at(26), Task: "lint", Step: 1, Interpreter: "bash", Command: "golangci-lint run"},
+		{Type: EventStepEnd, Time: at(40), Task: "ci", Step: 1, Status: StatusFailure, Error: "exit status 1"},
+		{Type: EventTaskEnd, Time: at(41), Task: "ci", Status: StatusFailure},
+		{Type: EventStepEnd, Time: at(50), Task: "lint", Step: 1, Status: StatusSuccess},
+		{Type: EventTaskEnd, Time: at(51), Task: "lint", Status: StatusSuccess},
+		{Type: EventRunEnd, Time: at(60), RunID: "r1", Task: "ci", Status: StatusFailure},
+	} {
+		h.Emit(e)
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, trace.Write(&buf))
+	var file struct {
+		DisplayTimeUnit string       `json:"displayTimeUnit"`
+		TraceEvents     []traceEvent `json:"traceEvents"`
+	}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &file))
+	assert.Equal(t, "ms", file.DisplayTimeUnit)
+
+	type span struct {
+		name, cat string
+		ts, dur   int64
+		tid       int
+	}
+	var spans []span
+	var threads []string
+	for _, e := range file.TraceEvents {
+		switch e.Ph {
+		case "X":
+			spans = append(spans, span{e.Name, e.Cat, e.Ts, *e.Dur, e.Tid})
+		case "M":
+			threads = append(threads, e.Args["name"].(string))
+		}
+	}
+	assert.Equal(t, []string{"kontraktor", "lane 1", "lane 2"}, threads)
+	assert.Equal(t, []span{
+		{"import shared.ktr.yml", "phase", 0, 9000, 1},
+		{"import shared/go.ktr.yml", "phase", 500, 8500, 1},
+		{"run ci", "run", 10000, 50000, 1},
+		{"secrets", "phase", 11000, 4000, 1},
+		{"ci", "task", 20000, 21000, 1},
+		{"ci: 1. deploy --token [MASKED]", "step", 21000, 19000, 1},
+		{"lint", "task", 25000, 26000, 2},
+		{"lint: 1. golangci-lint run", "step", 26000, 24000, 2},
+	}, spans)
+
+	last := file.TraceEvents[len(file.TraceEvents)-1]
+	assert.Equal(t, map[string]interface{}{"task": "lint", "step": float64(1), "interpreter": "bash", "command": "golangci-lint run", "status": "success"}, last.Args)
+	step := file.TraceEvents[len(file.TraceEvents)-3]
+	assert.Equal(t, "failure", step.Args["status"])
+	assert.Equal(t, "exit status 1", step.Args["error"])
+	assert.Equal(t, "taskfile.ktr.yml", step.Args["file"])
+}
@@ -0,0 +1,330 @@
+// Package scaffold creates starter taskfiles from built-in and remote templates.
+package scaffold
+
+import (
+	"bufio"
+	"bytes"
+	"embed"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/kontraktor-sh/kontraktor/internal/taskfile"
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed templates/*.tmpl
+var builtins embed.FS
+
+// Built-in template names
+const (
+	// TemplateAuto proposes tasks for the project types detected in the directory
+	TemplateAuto = "auto"
+	// TemplateMinimal contains the taskfile skeleton only
+	TemplateMinimal = "minimal"
+)
+
+// TemplateFile is the taskfile template inside a template directory
+const TemplateFile = "taskfile.ktr.yml"
+
+// VariablesFile optionally declares the variables of a template directory
+const VariablesFile = "template.yml"
+
+// projectType is a kind of project with tasks to propose for it
+type projectType struct {
+	name   string
+	marker string // file identifying the project type
+}
+
+// projectTypes are the project types known to the built-in templates, in detection order
+var projectTypes = []projectType{
+	{name: "go", marker: "go.mod"},
+	{name: "node", marker: "package.json"},
+	{name: "python", marker: "pyproject.toml"},
+	{name: "docker", marker: "Dockerfile"},
+}
+
+// Variable is a value asked for when instantiating a template
+type Variable struct {
+	Name    string `yaml:"name"`
+	Prompt  string `yaml:"prompt,omitempty"`
+	Default string `yaml:"default,omitempty"`
+}
+
+// Template is a taskfile template
+type Template struct {
+	Name      string
+	Source    string
+	Variables []Variable
+}
+
+// Options configure how template variables are resolved
+type Options struct {
+	// Vars holds variable values given on the command line
+	Vars map[string]string
+	// Interactive enables prompting for variables missing from Vars
+	Interactive bool
+	// In and Out are used for prompting
+	In  io.Reader
+	Out io.Writer
+}
+
+// BuiltinNames returns the names of the built-in templates
+func BuiltinNames() []string {
+	names := []string{TemplateAuto, TemplateMinimal}
+	for _, pt := range projectTypes {
+		names = append(names, pt.name)
+	}
+	return names
+}
+
+// DetectProjectTypes returns the project types found in dir
+func DetectProjectTypes(dir string) []string {
+	var found []string
+	for _, pt := range projectTypes {
+		if _, err := os.Stat(filepath.Join(dir, pt.marker)); err == nil {
+			found = append(found, pt.name)
+		}
+	}
+	return found
+}
+
+// Load returns the template named by ref: a built-in template name, a local file or directory,
+// or a git or HTTP(S) location fetched like taskfile imports. dir is the project directory,
+// used to detect project types and default the project name.
+func Load(ref, dir string) (*Template, error) {
+	if ref == "" {
+		ref = TemplateAuto
+	}
+	if isBuiltin(ref) {
+		if _, err := os.Stat(ref); os.IsNotExist(err) {
+			return loadBuiltin(ref, dir)
+		}
+	}
+
+	path, err := taskfile.FetchImport(ref)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("template %s: %w", ref, err)
+	}
+
+	tmpl := &Template{Name: ref}
+	if info.IsDir() {
+		source, err := os.ReadFile(filepath.Join(path, TemplateFile))
+		if err != nil {
+			return nil, fmt.Errorf("template %s: %w", ref, err)
+		}
+		tmpl.Source = string(source)
+		if vars, err := os.ReadFile(filepath.Join(path, VariablesFile)); err == nil {
+			var decl struct {
+				Variables []Variable `yaml:"variables"`
+			}
+			if err := yaml.Unmarshal(vars, &decl); err != nil {
+				return nil, fmt.Errorf("template %s: decode %s: %w", ref, VariablesFile, err)
+			}
+			tmpl.Variables = decl.Variables
+		}
+	} else {
+		source, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("template %s: %w", ref, err)
+		}
+		tmpl.Source = string(source)
+	}
+
+	if err := tmpl.discoverVariables(); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+func isBuiltin(name string) bool {
+	for _, builtin := range BuiltinNames() {
+		if name == builtin {
+			return true
+		}
+	}
+	return false
+}
+
+// loadBuiltin composes a built-in template from the skeleton and the tasks of its project types
+func loadBuiltin(name, dir string) (*Template, error) {
+	var types []string
+	switch name {
+	case TemplateAuto:
+		types = DetectProjectTypes(dir)
+	case TemplateMinimal:
+	default:
+		types = []string{name}
+	}
+
+	skeleton, err := builtins.ReadFile("templates/taskfile.tmpl")
+	if err != nil {
+		return nil, err
+	}
+	var source strings.Builder
+	source.WriteString(`{{ $prefix := "" }}`)
+	source.Write(skeleton)
+	for _, t := range types {
+		snippet, err := builtins.ReadFile("templates/" + t + ".tmpl")
+		if err != nil {
+			return nil, err
+		}
+		// Tasks are prefixed with their project type when several types are combined
+		if len(types) > 1 {
+			fmt.Fprintf(&source, `{{ $prefix = "%s-" }}`, t)
+		}
+		source.Write(snippet)
+	}
+
+	project := "my-project"
+	if abs, err := filepath.Abs(dir); err == nil {
+		project = filepath.Base(abs)
+	}
+	return &Template{
+		Name:   name,
+		Source: source.String(),
+		Variables: []Variable{
+			{Name: "project", Prompt: "Project name", Default: project},
+		},
+	}, nil
+}
+
+// discoverVariables adds the fields referenced by the template source that are not declared
+func (t *Template) discoverVariables() error {
+	parsed, err := template.New(t.Name).Parse(t.Source)
+	if err != nil {
+		return fmt.Errorf("parse template %s: %w", t.Name, err)
+	}
+	declared := make(map[string]bool)
+	for _, v := range t.Variables {
+		declared[v.Name] = true
+	}
+	var found []string
+	for _, tree := range parsed.Templates() {
+		if tree.Tree == nil {
+			continue
+		}
+		walkFields(tree.Tree.Root, func(name string) {
+			if !declared[name] {
+				declared[name] = true
+				found = append(found, name)
+			}
+		})
+	}
+	sort.Strings(found)
+	for _, name := range found {
+		t.Variables = append(t.Variables, Variable{Name: name})
+	}
+	return nil
+}
+
+// walkFields calls fn with the first identifier of every field access on dot or $ below node
+func walkFields(node parse.Node, fn func(string)) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			walkFields(child, fn)
+		}
+	case *parse.ActionNode:
+		walkFields(n.Pipe, fn)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			walkFields(cmd, fn)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			walkFields(arg, fn)
+		}
+	case *parse.FieldNode:
+		fn(n.Ident[0])
+	case *parse.VariableNode:
+		// $ is dot at the start of the template, also inside with and range
+		if n.Ident[0] == "$" && len(n.Ident) > 1 {
+			fn(n.Ident[1])
+		}
+	case *parse.IfNode:
+		walkFields(n.Pipe, fn)
+		walkFields(n.List, fn)
+		walkFields(n.ElseList, fn)
+	case *parse.RangeNode:
+		walkFields(n.Pipe, fn)
+		walkFields(n.List, fn)
+		walkFields(n.ElseList, fn)
+	case *parse.WithNode:
+		walkFields(n.Pipe, fn)
+		walkFields(n.List, fn)
+		walkFields(n.ElseList, fn)
+	}
+}
+
+// Resolve returns the value of every template variable, from opts.Vars, prompting or defaults
+func (t *Template) Resolve(opts Options) (map[string]string, error) {
+	values := make(map[string]string)
+	var reader *bufio.Reader
+	if opts.Interactive && opts.In != nil {
+		reader = bufio.NewReader(opts.In)
+	}
+	for _, v := range t.Variables {
+		if value, ok := opts.Vars[v.Name]; ok {
+			values[v.Name] = value
+			continue
+		}
+		if reader != nil {
+			prompt := v.Prompt
+			if prompt == "" {
+				prompt = v.Name
+			}
+			if v.Default != "" {
+				fmt.Fprintf(opts.Out, "%s [%s]: ", prompt, v.Default)
+			} else {
+				fmt.Fprintf(opts.Out, "%s: ", prompt)
+			}
+			line, err := reader.ReadString('\n')
+			if err != nil && err != io.EOF {
+				return nil, fmt.Errorf("read %s: %w", v.Name, err)
+			}
+			if line = strings.TrimSpace(line); line != "" {
+				values[v.Name] = line
+				continue
+			}
+		}
+		if v.Default == "" {
+			return nil, fmt.Errorf("missing value for template variable '%s' (pass --var %s=value)", v.Name, v.Name)
+		}
+		values[v.Name] = v.Default
+	}
+	return values, nil
+}
+
+// Render instantiates the template with the given variable values
+func (t *Template) Render(values map[string]string) ([]byte, error) {
+	parsed, err := template.New(t.Name).Option("missingkey=error").Parse(t.Source)
+	if err != nil {
+		return nil, fmt.Errorf("parse template %s: %w", t.Name, err)
+	}
+	var out bytes.Buffer
+	if err := parsed.Execute(&out, values); err != nil {
+		return nil, fmt.Errorf("render template %s: %w", t.Name, err)
+	}
+
+	var check yaml.Node
+	if err := yaml.Unmarshal(out.Bytes(), &check); err != nil {
+		return nil, fmt.Errorf("template %s does not produce valid YAML: %w", t.Name, err)
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,149 @@
+package scaffold
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kontraktor-sh/kontraktor/internal/taskfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectProjectTypes(t *testing.T) {
+	dir := t.TempDir()
+	assert.Empty(t, DetectProjectTypes(dir))
+
+	for _, marker := range []string{"Dockerfile", "go.mod", "package.json"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, marker), nil, 0o644))
+	}
+	assert.Equal(t, []string{"go", "node", "docker"}, DetectProjectTypes(dir))
+}
+
+func TestLoad_Builtin(t *testing.T) {
+	tests := []struct {
+		name      string
+		template  string
+		markers   []string
+		wantTasks []string
+	}{
+		{"auto without markers", "", nil, []string{"hello"}},
+		{"auto with one project type", TemplateAuto, []string{"go.mod"}, []string{"build", "hello", "lint", "test"}},
+		{"auto with several project types", TemplateAuto, []string{"pyproject.toml", "Dockerfile"},
+			[]string{"docker-build", "hello", "python-install", "python-test"}},
+		{"explicit project type", "node", []string{"go.mod"}, []string{"build", "hello", "install", "test"}},
+		{"minimal", TemplateMinimal, []string{"go.mod"}, []string{"hello"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "shop")
+			require.NoError(t, os.Mkdir(dir, 0o755))
+			for _, marker := range tt.markers {
+				require.NoError(t, os.WriteFile(filepath.Join(dir, marker), nil, 0o644))
+			}
+
+			tmpl, err := Load(tt.template, dir)
+			require.NoError(t, err)
+			assert.Equal(t, []Variable{{Name: "project", Prompt: "Project name", Default: "shop"}}, tmpl.Variables)
+
+			values, err := tmpl.Resolve(Options{})
+			require.NoError(t, err)
+			content, err := tmpl.Render(values)
+			require.NoError(t, err)
+
+			path := filepath.Join(dir, "taskfile.ktr.yml")
+			require.NoError(t, os.WriteFile(path, content, 0o644))
+			tf, err := taskfile.ParseTaskfile(path)
+			require.NoError(t, err)
+			var names []string
+			for name := range tf.Tasks {
+				names = append(names, name)
+			}
+			assert.ElementsMatch(t, tt.wantTasks, names)
+			assert.Equal(t, "shop", tf.Environment["PROJECT_NAME"])
+			assert.Empty(t, taskfile.LintSource(path, content, taskfile.LintOptions{}))
+		})
+	}
+}
+
+func TestLoad_Directory(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, TemplateFile), []byte(`version: "0.3"
+environment:
+  SERVICE: {{ .service }}
+  REGISTRY: {{ .registry }}
+tasks:
+  deploy:
+    desc: Deploy {{ .service }} to {{ .cluster }}
+    cmds:
+      - echo "deploying ${SERVICE}"
+`), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, VariablesFile), []byte(`variables:
+  - name: service
+    prompt: Service name
+  - name: registry
+    default: ghcr.io/acme
+`), 0o644))
+
+	tmpl, err := Load(dir, t.TempDir())
+	require.NoError(t, err)
+	assert.Equal(t, []Variable{
+		{Name: "service", Prompt: "Service name"},
+		{Name: "registry", Default: "ghcr.io/acme"},
+		{Name: "cluster"},
+	}, tmpl.Variables)
+
+	t.Run("missing value", func(t *testing.T) {
+		_, err := tmpl.Resolve(Options{Vars: map[string]string{"service": "api"}})
+		assert.EqualError(t, err, "missing value for template variable 'cluster' (pass --var cluster=value)")
+	})
+
+	t.Run("flags and prompts", func(t *testing.T) {
+		var out bytes.Buffer
+		values, err := tmpl.Resolve(Options{
+			Vars:        map[string]string{"cluster": "prod"},
+			Interactive: true,
+			In:          strings.NewReader("api\n\n"),
+			Out:         &out,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"service": "api", "registry": "ghcr.io/acme", "cluster": "prod"}, values)
+		assert.Equal(t, "Service name: registry [ghcr.io/acme]: ", out.String())
+
+		content, err := tmpl.Render(values)
+		require.NoError(t, err)
+		assert.Contains(t, string(content), "desc: Deploy api to prod")
+		assert.Contains(t, string(content), `echo "deploying ${SERVICE}"`)
+	})
+}
+
+func TestLoad_With(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "with.ktr.yml")
+	require.NoError(t, os.WriteFile(path, []byte(`version: "0.3"
+environment:
+{{- with .registry }}
+  IMAGE: {{ . }}/{{ $.service }}
+{{- else }}
+  IMAGE: {{ .default_registry }}/app
+{{- end }}
+tasks: {}
+`), 0o644))
+
+	tmpl, err := Load(path, t.TempDir())
+	require.NoError(t, err)
+	assert.Equal(t, []Variable{{Name: "default_registry"}, {Name: "registry"}, {Name: "service"}}, tmpl.Variables)
+
+	content, err := tmpl.Render(map[string]string{"registry": "ghcr.io/acme", "service": "api", "default_registry": "docker.io"})
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "IMAGE: ghcr.io/acme/api")
+}
+
+func TestLoad_InvalidTemplate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "broken.ktr.yml")
+	require.NoError(t, os.WriteFile(path, []byte("version: {{ .version"), 0o644))
+	_, err := Load(path, t.TempDir())
+	assert.Error(t, err)
+}
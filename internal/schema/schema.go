@@ -0,0 +1,222 @@
+// Package schema generates JSON Schemas describing Kontraktor taskfiles.
+package schema
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Draft is the JSON Schema dialect of generated schemas
+const Draft = "http://json-schema.org/draft-07/schema#"
+
+// Schema is a JSON Schema document or subschema
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	Ref                  string             `json:"$ref,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Const                interface{}        `json:"const,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties interface{}        `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AllOf                []*Schema          `json:"allOf,omitempty"`
+	AnyOf                []*Schema          `json:"anyOf,omitempty"`
+	Definitions          map[string]*Schema `json:"definitions,omitempty"`
+}
+
+// Reflector builds schemas from Go types, using their yaml field names.
+// Named struct types are emitted once under definitions and referenced with $ref.
+//
+// Fields are described by struct tags:
+//
+//	jsonschema:"required,enum=a|b"   marks the field as required and restricts its values
+//	jsonschema_description:"..."     describes the field
+type Reflector struct {
+	definitions map[string]*Schema
+	names       map[reflect.Type]string
+	overrides   map[reflect.Type]*Schema
+}
+
+// NewReflector creates a new reflector
+func NewReflector() *Reflector {
+	return &Reflector{
+		definitions: make(map[string]*Schema),
+		names:       make(map[reflect.Type]string),
+		overrides:   make(map[reflect.Type]*Schema),
+	}
+}
+
+// Override uses s for every occurrence of the type of v instead of reflecting it
+func (r *Reflector) Override(v interface{}, s *Schema) {
+	r.overrides[reflect.TypeOf(v)] = s
+}
+
+// Define adds a named schema to the definitions and returns a reference to it
+func (r *Reflector) Define(name string, s *Schema) *Schema {
+	r.definitions[name] = s
+	return &Schema{Ref: "#/definitions/" + name}
+}
+
+// Definitions returns the definitions collected so far
+func (r *Reflector) Definitions() map[string]*Schema {
+	return r.definitions
+}
+
+// Reflect returns the schema of the type of v
+func (r *Reflector) Reflect(v interface{}) *Schema {
+	return r.reflectType(reflect.TypeOf(v))
+}
+
+func (r *Reflector) reflectType(t reflect.Type) *Schema {
+	if t == nil {
+		return &Schema{}
+	}
+	if s, ok := r.overrides[t]; ok {
+		return s
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return r.reflectType(t.Elem())
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: r.reflectType(t.Elem())}
+	case reflect.Map:
+		s := &Schema{Type: "object"}
+		if t.Elem().Kind() != reflect.Interface {
+			s.AdditionalProperties = r.reflectType(t.Elem())
+		}
+		return s
+	case reflect.Struct:
+		return r.reflectStruct(t)
+	default:
+		return &Schema{}
+	}
+}
+
+func (r *Reflector) reflectStruct(t reflect.Type) *Schema {
+	if t.Name() == "" {
+		return r.structSchema(t)
+	}
+	name, ok := r.names[t]
+	if !ok {
+		name = r.definitionName(t)
+		r.names[t] = name
+		// Reserve the name before reflecting fields so recursive types terminate
+		r.definitions[name] = &Schema{}
+		*r.definitions[name] = *r.structSchema(t)
+	}
+	return &Schema{Ref: "#/definitions/" + name}
+}
+
+// definitionName returns a definitions key for t, qualified by package on collision
+func (r *Reflector) definitionName(t reflect.Type) string {
+	name := t.Name()
+	if _, taken := r.definitions[name]; !taken {
+		return name
+	}
+	pkg := t.PkgPath()
+	if i := strings.LastIndex(pkg, "/"); i >= 0 {
+		pkg = pkg[i+1:]
+	}
+	return pkg + "." + name
+}
+
+func (r *Reflector) structSchema(t reflect.Type) *Schema {
+	s := &Schema{
+		Type:                 "object",
+		Properties:           make(map[string]*Schema),
+		AdditionalProperties: false,
+	}
+	r.addFields(s, t)
+	sort.Strings(s.Required)
+	return s
+}
+
+func (r *Reflector) addFields(s *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, opts := parseYAMLTag(field)
+		if name == "-" {
+			continue
+		}
+		if opts["inline"] {
+			// Inline structs contribute their fields, inline maps hold keys described elsewhere
+			if field.Type.Kind() == reflect.Struct {
+				r.addFields(s, field.Type)
+			}
+			continue
+		}
+
+		prop := r.reflectType(field.Type)
+		if desc := field.Tag.Get("jsonschema_description"); desc != "" {
+			prop = withDescription(prop, desc)
+		}
+		for _, opt := range strings.Split(field.Tag.Get("jsonschema"), ",") {
+			switch {
+			case opt == "required":
+				s.Required = append(s.Required, name)
+			case strings.HasPrefix(opt, "enum="):
+				prop = withEnum(prop, strings.Split(strings.TrimPrefix(opt, "enum="), "|"))
+			}
+		}
+		s.Properties[name] = prop
+	}
+}
+
+// parseYAMLTag returns the yaml key of a field and its tag options
+func parseYAMLTag(field reflect.StructField) (string, map[string]bool) {
+	parts := strings.Split(field.Tag.Get("yaml"), ",")
+	opts := make(map[string]bool)
+	for _, opt := range parts[1:] {
+		opts[opt] = true
+	}
+	name := parts[0]
+	if name == "" {
+		name = strings.ToLower(field.Name)
+	}
+	return name, opts
+}
+
+// withDescription returns s with a description, without modifying shared schemas.
+// References are wrapped since draft-07 ignores keywords next to $ref.
+func withDescription(s *Schema, desc string) *Schema {
+	if s.Ref != "" {
+		return &Schema{Description: desc, AllOf: []*Schema{s}}
+	}
+	c := copySchema(s)
+	c.Description = desc
+	return c
+}
+
+// withEnum returns s restricted to values, applied to the items of arrays
+func withEnum(s *Schema, values []string) *Schema {
+	c := copySchema(s)
+	if c.Type == "array" && c.Items != nil {
+		c.Items = withEnum(c.Items, values)
+		return c
+	}
+	for _, value := range values {
+		c.Enum = append(c.Enum, value)
+	}
+	return c
+}
+
+func copySchema(s *Schema) *Schema {
+	c := *s
+	return &c
+}
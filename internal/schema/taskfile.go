@@ -0,0 +1,167 @@
+package schema
+
+import (
+	"reflect"
+	"sort"
+
+	"github.com/kontraktor-sh/kontraktor/internal/taskfile"
+	"github.com/kontraktor-sh/kontraktor/internal/taskfile/interpreter"
+)
+
+// Taskfile returns the schema of taskfile.ktr.yml files.
+// Command content is described for every interpreter implementing interpreter.ContentDescriber,
+// and vault sections for every type registered with taskfile.RegisterVaultType.
+func Taskfile(interpreters []interpreter.Interpreter) *Schema {
+	r := NewReflector()
+	r.Override(taskfile.TaskCmd{}, commandSchema(r, interpreters))
+	r.Override(taskfile.Var{}, varSchema(r))
+	r.Override(taskfile.Mask{}, maskSchema(r))
+
+	r.Reflect(taskfile.Taskfile{})
+	defs := r.Definitions()
+
+	vaults := defs["Vaults"]
+	vaultTypes := taskfile.VaultTypes()
+	names := make([]string, 0, len(vaultTypes))
+	for name := range vaultTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		vaults.Properties[name] = &Schema{
+			Type:                 "object",
+			AdditionalProperties: r.Reflect(vaultTypes[name]),
+		}
+	}
+
+	root := defs["Taskfile"]
+	delete(defs, "Taskfile")
+	root.Schema = Draft
+	root.Title = "Kontraktor taskfile"
+	// Unquoted versions are read as numbers by YAML
+	root.Properties["version"] = &Schema{
+		Description: root.Properties["version"].Description,
+		Enum:        []interface{}{taskfile.SupportedVersion, 0.3},
+	}
+	root.Definitions = defs
+	return root
+}
+
+// commandSchema describes a task command: a bash command string or mapping, a task
+// reference shorthand, or an explicit type with the content of the interpreter handling it,
+// under content or inline next to the type
+func commandSchema(r *Reflector, interpreters []interpreter.Interpreter) *Schema {
+	variants := []*Schema{
+		{Type: "string", Description: "Bash command"},
+		{
+			Type:        "object",
+			Description: "Reference to another task",
+			Properties: map[string]*Schema{
+				"task": {Type: "string", Description: "Name of the task to run"},
+			},
+			Required:             []string{"task"},
+			AdditionalProperties: false,
+		},
+	}
+	for _, i := range interpreters {
+		describer, ok := i.(interpreter.ContentDescriber)
+		if !ok {
+			continue
+		}
+		prototype := describer.ContentPrototype()
+		content := r.Reflect(prototype)
+		for _, cmdType := range describer.CommandTypes() {
+			variants = append(variants, &Schema{
+				Type: "object",
+				Properties: map[string]*Schema{
+					"type":    {Const: cmdType},
+					"content": content,
+				},
+				Required:             []string{"type", "content"},
+				AdditionalProperties: false,
+			})
+		}
+
+		// Without content, the fields of the content sit next to the type
+		t := reflect.TypeOf(prototype)
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct {
+			continue
+		}
+		fields := r.structSchema(t)
+		for _, cmdType := range describer.CommandTypes() {
+			inline := copySchema(fields)
+			inline.Properties = map[string]*Schema{"type": {Const: cmdType}}
+			for name, prop := range fields.Properties {
+				inline.Properties[name] = prop
+			}
+			inline.Required = append([]string{"type"}, fields.Required...)
+			variants = append(variants, inline)
+		}
+		// Mappings without a type are bash commands
+		if i.CanHandle("bash") {
+			bash := copySchema(fields)
+			bash.Description = "Bash command with options"
+			variants = append(variants, bash)
+		}
+	}
+	return r.Define("TaskCmd", &Schema{
+		Description: "Command run by a task",
+		AnyOf:       variants,
+	})
+}
+
+// varSchema describes an entry of a vars section: a literal value, or a mapping with
+// the command or file computing the value
+func varSchema(r *Reflector) *Schema {
+	fields := r.structSchema(reflect.TypeOf(taskfile.Var{}))
+	return r.Define("Var", &Schema{
+		Description: "Variable: a literal value, or the output of a command or the content of a file",
+		AnyOf: []*Schema{
+			{Type: "string", Description: "Literal value"},
+			{Type: "number"},
+			{Type: "boolean"},
+			{
+				Type:                 "object",
+				Description:          "Output of a command",
+				Properties:           map[string]*Schema{"sh": fields.Properties["sh"]},
+				Required:             []string{"sh"},
+				AdditionalProperties: false,
+			},
+			{
+				Type:        "object",
+				Description: "Content of a file",
+				Properties: map[string]*Schema{
+					"file":      fields.Properties["file"],
+					"json_path": fields.Properties["json_path"],
+				},
+				Required:             []string{"file"},
+				AdditionalProperties: false,
+			},
+		},
+	})
+}
+
+// maskSchema describes an entry of the mask section: a regular expression, or a mapping
+// with a regular expression or literal text
+func maskSchema(r *Reflector) *Schema {
+	fields := r.structSchema(reflect.TypeOf(taskfile.Mask{}))
+	variant := func(name string) *Schema {
+		return &Schema{
+			Type:                 "object",
+			Properties:           map[string]*Schema{name: fields.Properties[name]},
+			Required:             []string{name},
+			AdditionalProperties: false,
+		}
+	}
+	return r.Define("Mask", &Schema{
+		Description: "Sensitive data masked in output",
+		AnyOf: []*Schema{
+			{Type: "string", Description: "Regular expression; when it has capture groups, only the groups are masked"},
+			variant("pattern"),
+			variant("literal"),
+		},
+	})
+}
@@ -0,0 +1,237 @@
+package schema
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/kontraktor-sh/kontraktor/internal/taskfile"
+	"github.com/kontraktor-sh/kontraktor/internal/taskfile/interpreter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+type helmCommand struct {
+	Chart  string            `yaml:"chart" jsonschema:"required"`
+	Values map[string]string `yaml:"values,omitempty"`
+}
+
+type helmInterpreter struct{}
+
+func (helmInterpreter) Execute(ctx context.Context, cmd interpreter.Command, taskCtx *interpreter.TaskContext) (*interpreter.Result, error) {
+	return &interpreter.Result{Success: true}, nil
+}
+
+func (helmInterpreter) CanHandle(cmdType string) bool { return cmdType == "helm" }
+
+func (helmInterpreter) CommandTypes() []string { return []string{"helm"} }
+
+func (helmInterpreter) ContentPrototype() interface{} { return helmCommand{} }
+
+type fileVaultConfig struct {
+	Path    string            `yaml:"path" jsonschema:"required"`
+	Secrets map[string]string `yaml:"secrets"`
+}
+
+func TestTaskfile(t *testing.T) {
+	taskfile.RegisterVaultType("file", fileVaultConfig{})
+	t.Cleanup(func() { taskfile.UnregisterVaultType("file") })
+
+	registry := interpreter.NewDefaultRegistry(nil)
+	registry.Register(helmInterpreter{})
+	s := Taskfile(registry.Interpreters())
+
+	assert.Equal(t, Draft, s.Schema)
+	assert.Equal(t, []string{"tasks", "version"}, s.Required)
+	assert.Equal(t, false, s.AdditionalProperties)
+	assert.Contains(t, s.Definitions, "Task")
+	assert.Contains(t, s.Definitions, "TaskArg")
+	assert.Contains(t, s.Definitions, "BashCommand")
+
+	// Every registered command type gets a variant referencing its content schema
+	cmdTypes := make(map[string]*Schema)
+	for _, variant := range s.Definitions["TaskCmd"].AnyOf {
+		if typ, ok := variant.Properties["type"]; ok && variant.Properties["content"] != nil {
+			cmdTypes[typ.Const.(string)] = variant.Properties["content"]
+		}
+	}
+	for _, cmdType := range []string{"bash", "ktr@bash", "python", "docker", "task", "helm"} {
+		assert.Contains(t, cmdTypes, cmdType)
+	}
+	assert.Equal(t, "#/definitions/helmCommand", cmdTypes["helm"].Ref)
+	assert.Equal(t, []string{"chart"}, s.Definitions["helmCommand"].Required)
+
+	// Vars are literal values or mappings with sh or file
+	require.Contains(t, s.Definitions, "Var")
+	var required [][]string
+	for _, variant := range s.Definitions["Var"].AnyOf {
+		required = append(required, variant.Required)
+	}
+	assert.Equal(t, [][]string{nil, nil, nil, {"sh"}, {"file"}}, required)
+	assert.Equal(t, &Schema{Ref: "#/definitions/Var"}, s.Definitions["Task"].Properties["vars"].AdditionalProperties)
+
+	// Masks are patterns or mappings with pattern or literal
+	require.Contains(t, s.Definitions, "Mask")
+	required = nil
+	for _, variant := range s.Definitions["Mask"].AnyOf {
+		required = append(required, variant.Required)
+	}
+	assert.Equal(t, [][]string{nil, {"pattern"}, {"literal"}}, required)
+	assert.Equal(t, &Schema{Ref: "#/definitions/Mask"}, s.Properties["mask"].Items)
+
+	// Registered vault types are described next to the built-in ones
+	vaults := s.Definitions["Vaults"]
+	assert.Contains(t, vaults.Properties, "azure_keyvault")
+	assert.Contains(t, vaults.Properties, "hashicorp_vault")
+	assert.Contains(t, vaults.Properties, "aws_secrets_manager")
+	assert.Contains(t, vaults.Properties, "aws_ssm")
+	require.Contains(t, vaults.Properties, "file")
+	assert.Equal(t, &Schema{Ref: "#/definitions/fileVaultConfig"}, vaults.Properties["file"].AdditionalProperties)
+
+	_, err := json.Marshal(s)
+	assert.NoError(t, err)
+}
+
+func TestTaskfile_VaultTypesUnregistered(t *testing.T) {
+	taskfile.RegisterVaultType("secret_file", fileVaultConfig{})
+	assert.Contains(t, Taskfile(nil).Definitions["Vaults"].Properties, "secret_file")
+
+	taskfile.UnregisterVaultType("secret_file")
+	assert.NotContains(t, Taskfile(nil).Definitions["Vaults"].Properties, "secret_file")
+	assert.False(t, taskfile.IsVaultType("secret_file"))
+}
+
+func TestReflector_TaskArg(t *testing.T) {
+	r := NewReflector()
+	ref := r.Reflect(taskfile.TaskArg{})
+	assert.Equal(t, "#/definitions/TaskArg", ref.Ref)
+
+	arg := r.Definitions()["TaskArg"]
+	assert.Equal(t, []string{"name"}, arg.Required)
+	assert.Equal(t, []interface{}{"string", "[]", "bool", "number", "secret"}, arg.Properties["type"].Enum)
+	assert.Equal(t, &Schema{Description: "Value used when the argument is not given"}, arg.Properties["default"])
+}
+
+func TestTaskfile_Commands(t *testing.T) {
+	s := Taskfile(interpreter.NewDefaultRegistry(nil).Interpreters())
+
+	tests := []struct {
+		name  string
+		cmd   string
+		valid bool
+	}{
+		{name: "string", cmd: `echo hi`, valid: true},
+		{name: "task reference", cmd: `{task: build}`, valid: true},
+		{name: "type and content", cmd: `{type: bash, content: {command: echo hi}}`, valid: true},
+		{name: "implicit bash mapping", cmd: `{command: echo hi, working_dir: /tmp}`, valid: true},
+		{name: "type with inline content", cmd: `{type: bash, command: echo hi, timeout: 10}`, valid: true},
+		{name: "python with inline content", cmd: `{type: python, script: print(1)}`, valid: true},
+		{name: "bash mapping without command", cmd: `{working_dir: /tmp}`},
+		{name: "inline content of another type", cmd: `{type: bash, script: print(1)}`},
+		{name: "unknown field", cmd: `{command: echo hi, shell: zsh}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var doc interface{}
+			require.NoError(t, yaml.Unmarshal([]byte("version: \"0.3\"\ntasks:\n  hello:\n    cmds:\n      - "+tt.cmd+"\n"), &doc))
+			err := validate(s, s, doc)
+			if tt.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+// validate checks value against the keywords of s used by generated schemas, resolving
+// references in the definitions of root
+func validate(root, s *Schema, value interface{}) error {
+	if s.Ref != "" {
+		return validate(root, root.Definitions[strings.TrimPrefix(s.Ref, "#/definitions/")], value)
+	}
+	for _, sub := range s.AllOf {
+		if err := validate(root, sub, value); err != nil {
+			return err
+		}
+	}
+	if len(s.AnyOf) > 0 {
+		var errs []string
+		for _, sub := range s.AnyOf {
+			err := validate(root, sub, value)
+			if err == nil {
+				errs = nil
+				break
+			}
+			errs = append(errs, err.Error())
+		}
+		if errs != nil {
+			return fmt.Errorf("no variant matches %v: %s", value, strings.Join(errs, "; "))
+		}
+	}
+	if s.Const != nil && s.Const != value {
+		return fmt.Errorf("%v is not %v", value, s.Const)
+	}
+	if len(s.Enum) > 0 {
+		found := false
+		for _, v := range s.Enum {
+			found = found || v == value
+		}
+		if !found {
+			return fmt.Errorf("%v is not one of %v", value, s.Enum)
+		}
+	}
+
+	switch s.Type {
+	case "string":
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("%v is not a string", value)
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%v is not an array", value)
+		}
+		if s.Items != nil {
+			for _, item := range items {
+				if err := validate(root, s.Items, item); err != nil {
+					return err
+				}
+			}
+		}
+	case "object":
+		fields, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%v is not an object", value)
+		}
+		for _, name := range s.Required {
+			if _, ok := fields[name]; !ok {
+				return fmt.Errorf("missing %s", name)
+			}
+		}
+		for name, field := range fields {
+			prop, ok := s.Properties[name]
+			if !ok {
+				switch additional := s.AdditionalProperties.(type) {
+				case bool:
+					if !additional {
+						return fmt.Errorf("unknown field %s", name)
+					}
+					continue
+				case *Schema:
+					prop = additional
+				default:
+					continue
+				}
+			}
+			if err := validate(root, prop, field); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+// cache.go
+// Keeps fetched secrets on disk for a short time, encrypted with a key derived on this machine.
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const (
+	cacheFile   = "cache"
+	cacheKeyLen = 32
+)
+
+// SecretIdentifier is implemented by vaults whose secrets may be cached on disk. The identifier
+// of a secret must change whenever its value may, for example when the vault it is read from
+// changes, since cached values are reused across taskfiles.
+type SecretIdentifier interface {
+	SecretID(name string) string
+}
+
+// DiskCache keeps fetched secrets in a file for a short time, so that runs following each other
+// do not fetch them again. The file is encrypted with AES-GCM, with a key derived from a random
+// key file next to it, the machine identifier and the user ID: copying the files to another
+// machine or reading them as another user does not reveal the secrets.
+type DiskCache struct {
+	dir     string
+	ttl     time.Duration
+	now     func() time.Time
+	entries map[string]cacheEntry // Entries read from the file, once loaded
+}
+
+type cacheEntry struct {
+	Value   string    `json:"value"`
+	Expires time.Time `json:"expires"`
+}
+
+// NewDiskCache creates a cache in dir keeping secrets for ttl
+func NewDiskCache(dir string, ttl time.Duration) *DiskCache {
+	return &DiskCache{dir: dir, ttl: ttl, now: time.Now}
+}
+
+// Get returns the cached value of a secret, unless it expired
+func (c *DiskCache) Get(id string) (string, bool, error) {
+	if err := c.load(); err != nil {
+		return "", false, err
+	}
+	entry, ok := c.entries[cacheID(id)]
+	if !ok || !c.now().Before(entry.Expires) {
+		return "", false, nil
+	}
+	return entry.Value, true, nil
+}
+
+// Put caches the values of secrets by identifier, dropping expired entries
+func (c *DiskCache) Put(values map[string]string) error {
+	if err := c.load(); err != nil {
+		c.entries = make(map[string]cacheEntry)
+	}
+	now := c.now()
+	for id, entry := range c.entries {
+		if !now.Before(entry.Expires) {
+			delete(c.entries, id)
+		}
+	}
+	for id, value := range values {
+		c.entries[cacheID(id)] = cacheEntry{Value: value, Expires: now.Add(c.ttl)}
+	}
+
+	data, err := json.Marshal(c.entries)
+	if err != nil {
+		return err
+	}
+	aead, err := c.cipher()
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	if err := writeFile(filepath.Join(c.dir, cacheFile), aead.Seal(nonce, nonce, data, nil)); err != nil {
+		return fmt.Errorf("failed to write secret cache: %w", err)
+	}
+	return nil
+}
+
+// load reads the entries of the cache file, once
+func (c *DiskCache) load() error {
+	if c.entries != nil {
+		return nil
+	}
+	c.entries = make(map[string]cacheEntry)
+	data, err := os.ReadFile(filepath.Join(c.dir, cacheFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read secret cache: %w", err)
+	}
+	aead, err := c.cipher()
+	if err != nil {
+		return err
+	}
+	if len(data) < aead.NonceSize() {
+		return fmt.Errorf("failed to read secret cache: file is truncated")
+	}
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		// Written with another key, for example before the key file was replaced
+		return fmt.Errorf("failed to decrypt secret cache: %w", err)
+	}
+	if err := json.Unmarshal(plain, &c.entries); err != nil {
+		return fmt.Errorf("failed to read secret cache: %w", err)
+	}
+	return nil
+}
+
+// cipher returns the AEAD encrypting the cache file, creating the key file if needed
+func (c *DiskCache) cipher() (cipher.AEAD, error) {
+	path := filepath.Join(c.dir, "key")
+	secret, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && len(secret) != cacheKeyLen) {
+		secret = make([]byte, cacheKeyLen)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		err = writeFile(path, secret)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret cache key: %w", err)
+	}
+	key, err := hkdf.Key(sha256.New, secret, machineID(), "kontraktor secret cache "+strconv.Itoa(os.Getuid()), cacheKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// cacheID hashes secret identifiers, which may name vaults and secrets
+func cacheID(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
+
+// machineID returns the identifier of the machine, or nothing where there is none
+func machineID() []byte {
+	for _, path := range []string{"/etc/machine-id", "/var/lib/dbus/machine-id"} {
+		if id, err := os.ReadFile(path); err == nil {
+			return id
+		}
+	}
+	return nil
+}
+
+// writeFile writes a file readable by the user only, replacing it atomically
+func writeFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,64 @@
+package secret
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiskCache(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	cache := NewDiskCache(dir, time.Minute)
+	cache.now = func() time.Time { return now }
+	require.NoError(t, cache.Put(map[string]string{"vault/api-key": "s3cr3t-value"}))
+
+	data, err := os.ReadFile(filepath.Join(dir, "cache"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "s3cr3t-value")
+	assert.NotContains(t, string(data), "api-key")
+	for _, name := range []string{"cache", "key"} {
+		info, err := os.Stat(filepath.Join(dir, name))
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o600), info.Mode().Perm(), name)
+	}
+
+	// A later run reads the file
+	later := NewDiskCache(dir, time.Minute)
+	later.now = func() time.Time { return now.Add(30 * time.Second) }
+	value, ok, err := later.Get("vault/api-key")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "s3cr3t-value", value)
+	_, ok, err = later.Get("vault/other")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	expired := NewDiskCache(dir, time.Minute)
+	expired.now = func() time.Time { return now.Add(time.Minute) }
+	_, ok, err = expired.Get("vault/api-key")
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestDiskCache_OtherKey(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, NewDiskCache(dir, time.Minute).Put(map[string]string{"vault/api-key": "s3cr3t-value"}))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "key"), make([]byte, cacheKeyLen), 0o600))
+
+	cache := NewDiskCache(dir, time.Minute)
+	_, ok, err := cache.Get("vault/api-key")
+	assert.ErrorContains(t, err, "failed to decrypt secret cache")
+	assert.False(t, ok)
+
+	// The cache is rewritten with the new key
+	require.NoError(t, cache.Put(map[string]string{"vault/token": "t"}))
+	value, ok, err := NewDiskCache(dir, time.Minute).Get("vault/token")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "t", value)
+}
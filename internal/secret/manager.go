@@ -3,45 +3,371 @@ package secret
 import (
 	"context"
 	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kontraktor-sh/kontraktor/internal/env"
 )
 
-// Manager handles secret management
+// DefaultConcurrency is the default number of secrets and vaults fetched at the same time
+const DefaultConcurrency = 8
+
+// Manager handles secret management. Secrets are fetched when first requested, and once.
 type Manager struct {
-	vaults map[string]Vault
+	vaults      []*registeredVault // In registration order
+	validator   *env.Validator
+	warn        func(string)
+	concurrency int
+	cache       *DiskCache
+
+	mu      sync.Mutex
+	sources map[string]*registeredVault // Vault providing each secret, once resolved
+	values  map[string]string           // Secrets fetched so far
 }
 
-// Vault represents a secret vault
+// Vault represents a secret vault. Its methods, and those of the optional interfaces below,
+// may be called concurrently.
 type Vault interface {
 	GetSecrets(ctx context.Context) (map[string]string, error)
 }
 
+// SecretLister is implemented by vaults that know the names of their secrets without fetching them
+type SecretLister interface {
+	SecretNames() []string
+}
+
+// SecretGetter is implemented by vaults that can fetch a single secret
+type SecretGetter interface {
+	GetSecret(ctx context.Context, name string) (string, error)
+}
+
+// VaultOptions decide which vault provides a secret defined by several vaults
+type VaultOptions struct {
+	// Priority orders vaults: secrets of vaults with a higher priority override those of vaults
+	// with a lower one, and vaults of equal priority are applied in registration order
+	Priority int
+	// AllowOverride lets the vault override secrets of other vaults with a warning; otherwise
+	// a secret defined by several vaults is an error
+	AllowOverride bool
+}
+
+type registeredVault struct {
+	name    string
+	vault   Vault
+	options VaultOptions
+	secrets map[string]string // All secrets of the vault, once fetched
+}
+
 // NewManager creates a new secret manager
 func NewManager() *Manager {
 	return &Manager{
-		vaults: make(map[string]Vault),
+		validator:   env.NewValidator(),
+		concurrency: DefaultConcurrency,
+		values:      make(map[string]string),
 	}
 }
 
 // RegisterVault registers a new vault
 func (m *Manager) RegisterVault(name string, vault Vault) {
-	m.vaults[name] = vault
+	m.RegisterVaultWithOptions(name, vault, VaultOptions{})
+}
+
+// RegisterVaultWithOptions registers a new vault with options
+func (m *Manager) RegisterVaultWithOptions(name string, vault Vault, options VaultOptions) {
+	m.vaults = append(m.vaults, &registeredVault{name: name, vault: vault, options: options})
+}
+
+// SetWarningHandler sets the function printing warnings, such as secrets overridden by other vaults
+func (m *Manager) SetWarningHandler(warn func(string)) {
+	m.warn = warn
+}
+
+// SetConcurrency sets the number of secrets and vaults fetched at the same time
+func (m *Manager) SetConcurrency(n int) {
+	if n < 1 {
+		n = 1
+	}
+	m.concurrency = n
+}
+
+// SetDiskCache keeps the secrets of vaults implementing SecretIdentifier in a disk cache
+// between runs
+func (m *Manager) SetDiskCache(cache *DiskCache) {
+	m.cache = cache
+}
+
+// VaultTiming is the time spent fetching the secrets of a vault
+type VaultTiming struct {
+	Vault    string
+	Start    time.Time
+	Duration time.Duration
+	Err      error
 }
 
 // GetSecrets retrieves secrets from all registered vaults
 func (m *Manager) GetSecrets(ctx context.Context) (map[string]string, error) {
-	secrets := make(map[string]string)
+	return m.GetSecretsTimed(ctx, nil)
+}
+
+// GetSecretsTimed is GetSecrets reporting the time spent on each vault to onVault when it is not nil
+func (m *Manager) GetSecretsTimed(ctx context.Context, onVault func(VaultTiming)) (map[string]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.resolve(ctx, onVault); err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(m.sources))
+	for name := range m.sources {
+		names = append(names, name)
+	}
+	return m.fetch(ctx, names, onVault)
+}
+
+// FetchTimed retrieves the named secrets, fetching only the vaults providing them and, from
+// vaults implementing SecretGetter, only those secrets. The time spent on each vault is
+// reported to onVault when it is not nil.
+func (m *Manager) FetchTimed(ctx context.Context, names []string, onVault func(VaultTiming)) (map[string]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.resolve(ctx, onVault); err != nil {
+		return nil, err
+	}
+	return m.fetch(ctx, names, onVault)
+}
 
-	for name, vault := range m.vaults {
-		vaultSecrets, err := vault.GetSecrets(ctx)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get secrets from vault %s: %w", name, err)
+// resolve finds the vault providing each secret, once. Vaults implementing SecretLister are not
+// fetched; other vaults are fetched entirely to know their secrets.
+func (m *Manager) resolve(ctx context.Context, onVault func(VaultTiming)) error {
+	if m.sources != nil {
+		return nil
+	}
+	var jobs []fetchJob
+	for _, v := range m.vaults {
+		if _, ok := v.vault.(SecretLister); !ok && v.secrets == nil {
+			jobs = append(jobs, fetchJob{vault: v})
 		}
+	}
+	if err := m.run(ctx, jobs, onVault); err != nil {
+		return err
+	}
 
-		// Merge secrets, with later vaults taking precedence
-		for k, v := range vaultSecrets {
-			secrets[k] = v
+	sources := make(map[string]*registeredVault)
+	for _, v := range m.ordered() {
+		var names []string
+		if lister, ok := v.vault.(SecretLister); ok {
+			names = lister.SecretNames()
+		} else {
+			for name := range v.secrets {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if err := m.validator.ValidateName(name); err != nil {
+				return fmt.Errorf("invalid secret in vault %s: %w", v.name, err)
+			}
+			if err := m.override(v, name, sources); err != nil {
+				return err
+			}
+			sources[name] = v
+		}
+	}
+	m.sources = sources
+	return nil
+}
+
+// fetch returns the named secrets, fetching those not fetched yet from their vaults: the named
+// secrets of vaults implementing SecretGetter, and all secrets of other vaults
+func (m *Manager) fetch(ctx context.Context, names []string, onVault func(VaultTiming)) (map[string]string, error) {
+	var jobs []fetchJob
+	queued := make(map[*registeredVault]bool)
+	for _, name := range names {
+		if _, ok := m.values[name]; ok {
+			continue
+		}
+		v, ok := m.sources[name]
+		if !ok {
+			return nil, fmt.Errorf("secret '%s' is not defined by any vault", name)
+		}
+		if value, ok := m.cached(v, name); ok {
+			m.values[name] = value
+			continue
+		}
+		if v.secrets != nil {
+			m.values[name] = v.secrets[name]
+			continue
+		}
+		if _, ok := v.vault.(SecretGetter); ok {
+			jobs = append(jobs, fetchJob{vault: v, name: name})
+		} else if !queued[v] {
+			jobs = append(jobs, fetchJob{vault: v})
+			queued[v] = true
 		}
 	}
+	if err := m.run(ctx, jobs, onVault); err != nil {
+		return nil, err
+	}
 
+	fetched := make(map[string]string)
+	secrets := make(map[string]string, len(names))
+	for _, name := range names {
+		if _, ok := m.values[name]; !ok {
+			v := m.sources[name]
+			m.values[name] = v.secrets[name]
+			if id, ok := v.vault.(SecretIdentifier); ok {
+				fetched[id.SecretID(name)] = v.secrets[name]
+			}
+		}
+		secrets[name] = m.values[name]
+	}
+	for _, job := range jobs {
+		if id, ok := job.vault.vault.(SecretIdentifier); ok && job.name != "" {
+			fetched[id.SecretID(job.name)] = m.values[job.name]
+		}
+	}
+	if m.cache != nil && len(fetched) > 0 {
+		if err := m.cache.Put(fetched); err != nil && m.warn != nil {
+			m.warn(err.Error())
+		}
+	}
 	return secrets, nil
 }
+
+// cached returns the value of a secret in the disk cache, if any
+func (m *Manager) cached(v *registeredVault, name string) (string, bool) {
+	id, ok := v.vault.(SecretIdentifier)
+	if m.cache == nil || !ok {
+		return "", false
+	}
+	value, ok, err := m.cache.Get(id.SecretID(name))
+	if err != nil && m.warn != nil {
+		m.warn(err.Error())
+	}
+	return value, ok
+}
+
+// fetchJob fetches a secret of a vault implementing SecretGetter, or all secrets of a vault
+type fetchJob struct {
+	vault *registeredVault
+	name  string // Empty to fetch all secrets
+}
+
+// run runs jobs on a pool of at most concurrency workers. The time spent on each vault, from the
+// start of its first job to the end of its last one, is reported to onVault in the order vaults
+// are applied. The error of the first vault failing in that order is returned.
+func (m *Manager) run(ctx context.Context, jobs []fetchJob, onVault func(VaultTiming)) error {
+	if len(jobs) == 0 {
+		return nil
+	}
+	type vaultRun struct {
+		start, end time.Time
+		err        error
+	}
+	runs := make(map[*registeredVault]*vaultRun)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	workers := make(chan struct{}, m.concurrency)
+	for _, job := range jobs {
+		workers <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-workers }()
+			start := time.Now()
+			var value string
+			var secrets map[string]string
+			var err error
+			if job.name != "" {
+				value, err = job.vault.vault.(SecretGetter).GetSecret(ctx, job.name)
+			} else {
+				secrets, err = job.vault.vault.GetSecrets(ctx)
+			}
+			end := time.Now()
+
+			mu.Lock()
+			defer mu.Unlock()
+			r, ok := runs[job.vault]
+			if !ok {
+				r = &vaultRun{start: start}
+				runs[job.vault] = r
+			}
+			if start.Before(r.start) {
+				r.start = start
+			}
+			if end.After(r.end) {
+				r.end = end
+			}
+			if err != nil {
+				if r.err == nil {
+					r.err = err
+				}
+				return
+			}
+			if job.name != "" {
+				m.values[job.name] = value
+			} else {
+				if secrets == nil {
+					secrets = make(map[string]string)
+				}
+				job.vault.secrets = secrets
+			}
+		}()
+	}
+	wg.Wait()
+
+	var err error
+	for _, v := range m.ordered() {
+		r, ok := runs[v]
+		if !ok {
+			continue
+		}
+		if onVault != nil {
+			onVault(VaultTiming{Vault: v.name, Start: r.start, Duration: r.end.Sub(r.start), Err: r.err})
+		}
+		if r.err != nil && err == nil {
+			err = fmt.Errorf("failed to get secrets from vault %s: %w", v.name, r.err)
+		}
+	}
+	return err
+}
+
+// Sources returns the name of the vault providing each secret, for vaults implementing SecretLister
+func (m *Manager) Sources() map[string]string {
+	sources := make(map[string]string)
+	for _, v := range m.ordered() {
+		lister, ok := v.vault.(SecretLister)
+		if !ok {
+			continue
+		}
+		for _, secret := range lister.SecretNames() {
+			sources[secret] = v.name
+		}
+	}
+	return sources
+}
+
+// ordered returns the vaults in the order their secrets are applied: by priority, then in
+// registration order
+func (m *Manager) ordered() []*registeredVault {
+	ordered := append([]*registeredVault(nil), m.vaults...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].options.Priority < ordered[j].options.Priority
+	})
+	return ordered
+}
+
+// override checks that a vault may override a secret already provided by another vault
+func (m *Manager) override(v *registeredVault, name string, sources map[string]*registeredVault) error {
+	source, ok := sources[name]
+	if !ok {
+		return nil
+	}
+	if !v.options.AllowOverride {
+		return fmt.Errorf("secret '%s' is defined by both vault %s and vault %s (set a priority and allow_override: true on the vault that should provide it)", name, source.name, v.name)
+	}
+	if m.warn != nil {
+		m.warn(fmt.Sprintf("secret '%s' of vault %s overrides the secret of vault %s", name, v.name, source.name))
+	}
+	return nil
+}
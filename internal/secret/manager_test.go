@@ -0,0 +1,266 @@
+package secret
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// staticVault returns fixed secrets
+type staticVault map[string]string
+
+func (v staticVault) GetSecrets(ctx context.Context) (map[string]string, error) {
+	return v, nil
+}
+
+func TestManager_GetSecrets(t *testing.T) {
+	type vault struct {
+		name    string
+		secrets map[string]string
+		options VaultOptions
+	}
+	tests := []struct {
+		name     string
+		vaults   []vault
+		expected map[string]string
+		warnings []string
+		wantErr  string
+	}{
+		{
+			name: "distinct secrets",
+			vaults: []vault{
+				{name: "a", secrets: map[string]string{"API_KEY": "a"}},
+				{name: "b", secrets: map[string]string{"DB_PASSWORD": "b"}},
+			},
+			expected: map[string]string{"API_KEY": "a", "DB_PASSWORD": "b"},
+		},
+		{
+			name: "conflict",
+			vaults: []vault{
+				{name: "a", secrets: map[string]string{"API_KEY": "a"}},
+				{name: "b", secrets: map[string]string{"API_KEY": "b"}},
+			},
+			wantErr: "secret 'API_KEY' is defined by both vault a and vault b (set a priority and allow_override: true on the vault that should provide it)",
+		},
+		{
+			name: "later vault allowed to override",
+			vaults: []vault{
+				{name: "a", secrets: map[string]string{"API_KEY": "a"}},
+				{name: "b", secrets: map[string]string{"API_KEY": "b"}, options: VaultOptions{AllowOverride: true}},
+			},
+			expected: map[string]string{"API_KEY": "b"},
+			warnings: []string{"secret 'API_KEY' of vault b overrides the secret of vault a"},
+		},
+		{
+			name: "higher priority declared first",
+			vaults: []vault{
+				{name: "a", secrets: map[string]string{"API_KEY": "a"}, options: VaultOptions{Priority: 10, AllowOverride: true}},
+				{name: "b", secrets: map[string]string{"API_KEY": "b"}},
+				{name: "c", secrets: map[string]string{"API_KEY": "c"}, options: VaultOptions{Priority: -1}},
+			},
+			wantErr: "secret 'API_KEY' is defined by both vault c and vault b (set a priority and allow_override: true on the vault that should provide it)",
+		},
+		{
+			name: "priority",
+			vaults: []vault{
+				{name: "a", secrets: map[string]string{"API_KEY": "a"}, options: VaultOptions{Priority: 10, AllowOverride: true}},
+				{name: "b", secrets: map[string]string{"API_KEY": "b", "TOKEN": "b"}},
+			},
+			expected: map[string]string{"API_KEY": "a", "TOKEN": "b"},
+			warnings: []string{"secret 'API_KEY' of vault a overrides the secret of vault b"},
+		},
+		{
+			name: "invalid name",
+			vaults: []vault{
+				{name: "a", secrets: map[string]string{"api-key": "a"}},
+			},
+			wantErr: "invalid secret in vault a: invalid environment variable 'api-key': name must start with a letter or underscore and contain only letters, numbers, and underscores",
+		},
+		{
+			name: "reserved name",
+			vaults: []vault{
+				{name: "a", secrets: map[string]string{"PATH": "/tmp"}},
+			},
+			wantErr: "invalid secret in vault a: invalid environment variable 'PATH': name is reserved and cannot be overridden",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewManager()
+			var warnings []string
+			m.SetWarningHandler(func(message string) { warnings = append(warnings, message) })
+			for _, v := range tt.vaults {
+				m.RegisterVaultWithOptions(v.name, staticVault(v.secrets), v.options)
+			}
+			var order []string
+			secrets, err := m.GetSecretsTimed(context.Background(), func(timing VaultTiming) {
+				order = append(order, timing.Vault)
+			})
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, secrets)
+			assert.Equal(t, tt.warnings, warnings)
+			assert.Len(t, order, len(tt.vaults))
+		})
+	}
+}
+
+// listedVault declares secret names without fetching them
+type listedVault []string
+
+func (v listedVault) GetSecrets(ctx context.Context) (map[string]string, error) {
+	return nil, nil
+}
+
+func (v listedVault) SecretNames() []string {
+	return v
+}
+
+func TestManager_Sources(t *testing.T) {
+	m := NewManager()
+	m.RegisterVaultWithOptions("team", listedVault{"API_KEY"}, VaultOptions{Priority: 1})
+	m.RegisterVault("shared", listedVault{"API_KEY", "TOKEN"})
+	m.RegisterVault("static", staticVault{"OTHER": "x"})
+	assert.Equal(t, map[string]string{"API_KEY": "team", "TOKEN": "shared"}, m.Sources())
+}
+
+// countingVault lists its secrets and records the secrets fetched
+type countingVault struct {
+	secrets map[string]string
+	mu      sync.Mutex
+	fetched []string
+}
+
+func (v *countingVault) GetSecrets(ctx context.Context) (map[string]string, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.fetched = append(v.fetched, "*")
+	return v.secrets, nil
+}
+
+func (v *countingVault) SecretNames() []string {
+	names := make([]string, 0, len(v.secrets))
+	for name := range v.secrets {
+		names = append(names, name)
+	}
+	return names
+}
+
+func (v *countingVault) GetSecret(ctx context.Context, name string) (string, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.fetched = append(v.fetched, name)
+	return v.secrets[name], nil
+}
+
+func (v *countingVault) SecretID(name string) string {
+	return "counting/" + name
+}
+
+func TestManager_FetchTimed(t *testing.T) {
+	team := &countingVault{secrets: map[string]string{"API_KEY": "a", "TOKEN": "t"}}
+	other := &countingVault{secrets: map[string]string{"DB_PASSWORD": "d"}}
+	m := NewManager()
+	m.RegisterVault("team", team)
+	m.RegisterVault("other", other)
+
+	var vaults []string
+	onVault := func(timing VaultTiming) { vaults = append(vaults, timing.Vault) }
+	secrets, err := m.FetchTimed(context.Background(), []string{"API_KEY"}, onVault)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"API_KEY": "a"}, secrets)
+	assert.Equal(t, []string{"team"}, vaults)
+
+	// Fetched secrets are cached
+	secrets, err = m.FetchTimed(context.Background(), []string{"API_KEY", "TOKEN"}, onVault)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"API_KEY": "a", "TOKEN": "t"}, secrets)
+	assert.ElementsMatch(t, []string{"API_KEY", "TOKEN"}, team.fetched)
+	assert.Empty(t, other.fetched)
+
+	_, err = m.FetchTimed(context.Background(), []string{"MISSING"}, nil)
+	assert.EqualError(t, err, "secret 'MISSING' is not defined by any vault")
+}
+
+// inFlight counts the secrets being fetched, recording the most fetched at the same time
+type inFlight struct {
+	n, max atomic.Int32
+}
+
+// slowVault fetches each secret in a while
+type slowVault struct {
+	names    []string
+	inFlight *inFlight
+}
+
+func (v *slowVault) GetSecrets(ctx context.Context) (map[string]string, error) {
+	panic("secrets must be fetched one by one")
+}
+
+func (v *slowVault) SecretNames() []string {
+	return v.names
+}
+
+func (v *slowVault) GetSecret(ctx context.Context, name string) (string, error) {
+	n := v.inFlight.n.Add(1)
+	defer v.inFlight.n.Add(-1)
+	for {
+		max := v.inFlight.max.Load()
+		if n <= max || v.inFlight.max.CompareAndSwap(max, n) {
+			break
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	return "value of " + name, nil
+}
+
+func TestManager_FetchConcurrently(t *testing.T) {
+	counter := &inFlight{}
+	a := &slowVault{names: []string{"A1", "A2", "A3"}, inFlight: counter}
+	b := &slowVault{names: []string{"B1", "B2", "B3"}, inFlight: counter}
+	m := NewManager()
+	m.SetConcurrency(4)
+	m.RegisterVault("a", a)
+	m.RegisterVault("b", b)
+
+	var timings []VaultTiming
+	secrets, err := m.FetchTimed(context.Background(), []string{"A1", "A2", "A3", "B1", "B2", "B3"}, func(timing VaultTiming) {
+		timings = append(timings, timing)
+	})
+	require.NoError(t, err)
+	assert.Len(t, secrets, 6)
+	assert.Equal(t, "value of B2", secrets["B2"])
+	assert.Equal(t, int32(4), counter.max.Load(), "4 secrets are fetched at the same time")
+	require.Len(t, timings, 2)
+	assert.Equal(t, "a", timings[0].Vault)
+	assert.Equal(t, "b", timings[1].Vault)
+}
+
+func TestManager_DiskCache(t *testing.T) {
+	dir := t.TempDir()
+	first := &countingVault{secrets: map[string]string{"API_KEY": "a"}}
+	m := NewManager()
+	m.SetDiskCache(NewDiskCache(dir, time.Minute))
+	m.RegisterVault("team", first)
+	secrets, err := m.FetchTimed(context.Background(), []string{"API_KEY"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"API_KEY": "a"}, secrets)
+
+	// The next run reads the secret from the disk cache
+	next := &countingVault{secrets: map[string]string{"API_KEY": "changed"}}
+	m = NewManager()
+	m.SetDiskCache(NewDiskCache(dir, time.Minute))
+	m.RegisterVault("team", next)
+	secrets, err = m.FetchTimed(context.Background(), []string{"API_KEY"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"API_KEY": "a"}, secrets)
+	assert.Empty(t, next.fetched)
+}
@@ -0,0 +1,84 @@
+package task
+
+import (
+	"context"
+	"fmt"
+)
+
+// Checkpoint is the progress of a run: its arguments, the steps that succeeded and the values
+// of variables computed by commands and files. A later run resuming it skips those steps and
+// reuses those values. Values masked in output, such as secrets, are left out.
+type Checkpoint struct {
+	RunID        string            `json:"run_id"`
+	Task         string            `json:"task"`
+	TaskfileHash string            `json:"taskfile_hash,omitempty"` // Set by the caller, to detect changes of the taskfile
+	Args         map[string]string `json:"args,omitempty"`
+	Steps        []string          `json:"steps"` // Paths of the steps that succeeded, such as ci:2/test:1
+	Vars         map[string]string `json:"vars,omitempty"`
+}
+
+// OnCheckpoint calls save with the checkpoint of the current run when it starts and each time a
+// step succeeds, including steps of tasks run by other tasks
+func (e *Executor) OnCheckpoint(save func(*Checkpoint)) {
+	e.onCheckpoint = save
+}
+
+// Resume makes the next run resume a checkpoint: steps that succeeded are skipped and
+// computed variables keep their values
+func (e *Executor) Resume(checkpoint *Checkpoint) {
+	e.resume = checkpoint
+}
+
+type stepPathKey struct{}
+
+// stepPath returns the path of a step of a task within the step running it, if any
+func stepPath(ctx context.Context, task *Task, step int) string {
+	path := fmt.Sprintf("%s:%d", task.Name, step)
+	if parent, ok := ctx.Value(stepPathKey{}).(string); ok {
+		return parent + "/" + path
+	}
+	return path
+}
+
+// startCheckpoint starts the checkpoint of a run of a task with the given arguments,
+// carrying over the progress of the run it resumes
+func (e *Executor) startCheckpoint(task *Task, args map[string]interface{}) {
+	e.done = make(map[string]bool)
+	e.checkpoint = &Checkpoint{RunID: e.run.ID, Task: task.Name, Args: make(map[string]string), Steps: []string{}}
+	secret := make(map[string]bool)
+	for _, arg := range task.Args {
+		secret[arg.Name] = arg.Secret
+	}
+	for k, v := range args {
+		value := fmt.Sprintf("%v", v)
+		if !secret[k] && e.outputHandler.MaskSensitiveData(value) == value {
+			e.checkpoint.Args[k] = value
+		}
+	}
+	if e.resume != nil {
+		for _, path := range e.resume.Steps {
+			e.done[path] = true
+		}
+		for k, v := range e.resume.Vars {
+			e.cache[k] = v
+		}
+	}
+}
+
+// saveCheckpoint marks a step as succeeded, if any, and saves the checkpoint
+func (e *Executor) saveCheckpoint(path string) {
+	if e.onCheckpoint == nil {
+		return
+	}
+	if path != "" {
+		e.checkpoint.Steps = append(e.checkpoint.Steps, path)
+	}
+	e.checkpoint.Vars = make(map[string]string)
+	for k, v := range e.cache {
+		// Keys hold substituted commands: both may contain secrets
+		if e.outputHandler.MaskSensitiveData(k) == k && e.outputHandler.MaskSensitiveData(v) == v {
+			e.checkpoint.Vars[k] = v
+		}
+	}
+	e.onCheckpoint(e.checkpoint)
+}
@@ -0,0 +1,94 @@
+package task
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kontraktor-sh/kontraktor/internal/output"
+	"github.com/kontraktor-sh/kontraktor/internal/taskfile/interpreter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecutor_Resume(t *testing.T) {
+	dir := t.TempDir()
+	log := filepath.Join(dir, "log")
+	marker := filepath.Join(dir, "marker")
+
+	newExecutor := func() (*Executor, *bytes.Buffer) {
+		var out bytes.Buffer
+		handler := output.NewHandler()
+		handler.SetOutput(&out)
+		handler.SetError(&out)
+		registry := interpreter.NewRegistry()
+		registry.Register(interpreter.NewBashInterpreter())
+		executor := NewExecutor(handler, nil, registry)
+		registry.Register(interpreter.NewTaskInterpreter(executor.ExecuteReference))
+		executor.SetVars(map[string]Var{"STAMP": {Sh: "date +%s%N"}}, dir)
+		executor.AddTask("ci", &Task{
+			Args: []TaskArg{{Name: "token", Secret: true}, {Name: "env"}},
+			Cmds: []interpreter.Command{
+				bash("echo build ${STAMP} >> " + log),
+				{Type: "task", Content: map[string]interface{}{"name": "test"}},
+				bash("echo deploy ${STAMP} ${env} >> " + log),
+			},
+		})
+		executor.AddTask("test", &Task{Cmds: []interpreter.Command{
+			bash("echo unit >> " + log),
+			bash("test -f " + marker + " && echo integration >> " + log),
+		}})
+		return executor, &out
+	}
+
+	// The first run fails at the second step of test
+	executor, _ := newExecutor()
+	var checkpoint Checkpoint
+	executor.OnCheckpoint(func(c *Checkpoint) { checkpoint = *c })
+	require.Error(t, executor.Run(context.Background(), "ci", map[string]interface{}{"token": "s3cr3t", "env": "prod"}))
+	assert.Equal(t, executor.run.ID, checkpoint.RunID)
+	assert.Equal(t, "ci", checkpoint.Task)
+	assert.Equal(t, map[string]string{"env": "prod"}, checkpoint.Args)
+	assert.Equal(t, []string{"ci:1", "ci:2/test:1"}, checkpoint.Steps)
+	require.Len(t, checkpoint.Vars, 1)
+	first, err := os.ReadFile(log)
+	require.NoError(t, err)
+
+	// The resumed run continues from the failed step, with the values of the first run
+	require.NoError(t, os.WriteFile(marker, nil, 0o600))
+	require.NoError(t, os.Remove(log))
+	executor, out := newExecutor()
+	var resumed Checkpoint
+	executor.OnCheckpoint(func(c *Checkpoint) { resumed = *c })
+	executor.Resume(&checkpoint)
+	require.NoError(t, executor.Run(context.Background(), "ci", map[string]interface{}{"env": "prod"}))
+	second, err := os.ReadFile(log)
+	require.NoError(t, err)
+	stamp := bytes.Fields(first)[1]
+	assert.Equal(t, "integration\ndeploy "+string(stamp)+" prod\n", string(second))
+	assert.Contains(t, out.String(), "Skipping step 1 of task test: succeeded in run "+checkpoint.RunID)
+	assert.Equal(t, []string{"ci:1", "ci:2/test:1", "ci:2/test:2", "ci:2", "ci:3"}, resumed.Steps)
+}
+
+func TestExecutor_CheckpointExcludesSecrets(t *testing.T) {
+	handler := output.NewHandler()
+	handler.SetOutput(&bytes.Buffer{})
+	handler.AddMaskValue("k3y")
+	registry := interpreter.NewRegistry()
+	registry.Register(interpreter.NewBashInterpreter())
+	executor := NewExecutor(handler, nil, registry)
+	executor.SetVars(map[string]Var{
+		"KEY":    {Sh: "echo k3y"},
+		"ENCODE": {Sh: "echo ${KEY} | rev"},
+		"PLAIN":  {Sh: "echo plain"},
+	}, t.TempDir())
+	executor.AddTask("build", &Task{Cmds: []interpreter.Command{bash("echo ${KEY} ${ENCODE} ${PLAIN}")}})
+
+	var checkpoint Checkpoint
+	executor.OnCheckpoint(func(c *Checkpoint) { checkpoint = *c })
+	require.NoError(t, executor.Run(context.Background(), "build", map[string]interface{}{"user": "k3y"}))
+	assert.Equal(t, map[string]string{"sh\x00echo plain": "plain"}, checkpoint.Vars)
+	assert.Empty(t, checkpoint.Args)
+}
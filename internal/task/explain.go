@@ -0,0 +1,109 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/kontraktor-sh/kontraktor/internal/output"
+	"github.com/kontraktor-sh/kontraktor/internal/taskfile/interpreter"
+	"github.com/kontraktor-sh/kontraktor/internal/vars"
+)
+
+// MaskedValue replaces the value of secrets in explanations
+const MaskedValue = output.Masked
+
+// Explanation describes the variables visible to the steps of a task, without running it
+type Explanation struct {
+	Task   string
+	Desc   string
+	Parent string        // Task referencing this one, empty for the explained task
+	Vars   *vars.Context // Variables visible to every step of the task, with their origins
+	Steps  []Step
+}
+
+// Step is a command of an explained task
+type Step struct {
+	Type    string
+	Summary string       // Command after substitution, or referenced task name
+	Task    *Explanation // Explanation of the referenced task, for task steps
+}
+
+// Explain explains the named task and the tasks it references when run with the given arguments.
+// Secrets are not fetched: the task declares their names, their vaults come from the secret
+// manager and their values are MaskedValue. Commands of vars are not run: their value shows the command as $(command).
+func (e *Executor) Explain(name string, args map[string]interface{}) (*Explanation, error) {
+	task, ok := e.tasks[name]
+	if !ok {
+		return nil, fmt.Errorf("task '%s' not found", name)
+	}
+
+	e.run = newRun()
+	e.cache = make(map[string]string)
+	e.explaining = true
+	defer func() { e.explaining = false }()
+	taskCtx, err := e.rootContext(context.Background(), task, args)
+	if err != nil {
+		return nil, err
+	}
+	return e.explain(task, taskCtx, "", []string{name})
+}
+
+func (e *Executor) explain(task *Task, taskCtx *interpreter.TaskContext, parent string, stack []string) (*Explanation, error) {
+	explanation := &Explanation{
+		Task:   task.Name,
+		Desc:   task.Desc,
+		Parent: parent,
+		Vars:   taskCtx.Vars,
+	}
+	for _, cmd := range task.Cmds {
+		step := Step{Type: cmd.Type}
+		if cmd.Type != "task" {
+			step.Summary = summarize(cmd, taskCtx)
+			explanation.Steps = append(explanation.Steps, step)
+			continue
+		}
+
+		var taskCmd interpreter.TaskCommand
+		if err := interpreter.DecodeContent(cmd.Content, &taskCmd); err != nil {
+			return nil, err
+		}
+		step.Summary = taskCmd.Name
+		ref, ok := e.tasks[taskCmd.Name]
+		if !ok {
+			return nil, fmt.Errorf("task '%s' referenced by task '%s' not found", taskCmd.Name, task.Name)
+		}
+		for _, name := range stack {
+			if name == taskCmd.Name {
+				return nil, fmt.Errorf("circular task reference: %s -> %s", strings.Join(stack, " -> "), taskCmd.Name)
+			}
+		}
+		childCtx := interpreter.NewChildContext(taskCtx, taskCmd)
+		if err := e.prepare(context.Background(), ref, childCtx); err != nil {
+			return nil, err
+		}
+		child, err := e.explain(ref, childCtx, task.Name, append(stack, taskCmd.Name))
+		if err != nil {
+			return nil, err
+		}
+		step.Task = child
+		explanation.Steps = append(explanation.Steps, step)
+	}
+	return explanation, nil
+}
+
+// summarize returns a one-line description of a command as it would run
+func summarize(cmd interpreter.Command, taskCtx *interpreter.TaskContext) string {
+	content, _ := cmd.Content.(map[string]interface{})
+	for _, key := range []string{"command", "script", "image"} {
+		value, ok := content[key].(string)
+		if !ok {
+			continue
+		}
+		if substituted, err := taskCtx.Substitute(value); err == nil {
+			return substituted
+		}
+		return value
+	}
+	return fmt.Sprintf("%v", cmd.Content)
+}
@@ -0,0 +1,110 @@
+package task
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kontraktor-sh/kontraktor/internal/output"
+	"github.com/kontraktor-sh/kontraktor/internal/secret"
+	"github.com/kontraktor-sh/kontraktor/internal/taskfile/interpreter"
+	"github.com/kontraktor-sh/kontraktor/internal/vars"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// listedVault declares secrets without being able to fetch them
+type listedVault struct {
+	names []string
+}
+
+func (v *listedVault) GetSecrets(ctx context.Context) (map[string]string, error) {
+	panic("secrets must not be fetched")
+}
+
+func (v *listedVault) SecretNames() []string {
+	return v.names
+}
+
+func bash(command string) interpreter.Command {
+	return interpreter.Command{Type: "bash", Content: map[string]interface{}{"command": command}}
+}
+
+func newTestExecutor() *Executor {
+	secrets := secret.NewManager()
+	secrets.RegisterVault("azure_keyvault.main", &listedVault{names: []string{"API_KEY"}})
+	executor := NewExecutor(output.NewHandler(), secrets, interpreter.NewRegistry())
+	executor.SetEnvironment(map[string]string{"GREETING": "hello", "OUT": "dist"})
+	executor.AddTask("release", &Task{
+		Desc:    "Release",
+		Args:    []TaskArg{{Name: "version", Default: "0.1.0"}, {Name: "channel"}},
+		Secrets: []string{"API_KEY"},
+		Environment: map[string]string{
+			"GREETING": "release ${version}",
+			"AUTH":     "Bearer ${API_KEY}",
+		},
+		Cmds: []interpreter.Command{
+			bash("echo ${GREETING}"),
+			{Type: "task", Content: map[string]interface{}{"name": "build", "args": map[string]interface{}{"arch": "arm64"}}},
+		},
+	})
+	executor.AddTask("build", &Task{
+		Desc:        "Build",
+		Args:        []TaskArg{{Name: "arch", Default: "amd64"}},
+		Environment: map[string]string{"OUT": "build/${arch}"},
+		Cmds:        []interpreter.Command{bash("make ${OUT} ${AUTH}")},
+	})
+	return executor
+}
+
+func TestExecutor_Explain(t *testing.T) {
+	explanation, err := newTestExecutor().Explain("release", map[string]interface{}{"version": "1.2.0"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "release", explanation.Task)
+	assert.Equal(t, []string{"API_KEY", "AUTH", "GREETING", "OUT", "version"}, explanation.Vars.Names())
+	require.Len(t, explanation.Steps, 2)
+	assert.Equal(t, "echo release 1.2.0", explanation.Steps[0].Summary)
+
+	used, shadowed := explanation.Vars.Explain("GREETING")
+	assert.Equal(t, &vars.Origin{Type: vars.TypeEnv, Source: "environment of task 'release'", Value: "release 1.2.0"}, used)
+	assert.Equal(t, []vars.Origin{{Type: vars.TypeEnv, Source: "global environment", Value: "hello"}}, shadowed)
+
+	used, _ = explanation.Vars.Explain("API_KEY")
+	assert.Equal(t, &vars.Origin{Type: vars.TypeSecret, Source: "vault azure_keyvault.main", Value: MaskedValue}, used)
+	assert.Equal(t, "Bearer "+MaskedValue, explanation.Vars.Environment["AUTH"])
+
+	build := explanation.Steps[1].Task
+	require.NotNil(t, build)
+	assert.Equal(t, "release", build.Parent)
+	assert.Equal(t, "make build/arm64 Bearer "+MaskedValue, build.Steps[0].Summary)
+
+	used, shadowed = build.Vars.Explain("arch")
+	assert.Equal(t, &vars.Origin{Type: vars.TypeArg, Source: "argument passed by task 'release'", Value: "arm64"}, used)
+	assert.Empty(t, shadowed)
+
+	used, _ = build.Vars.Explain("version")
+	assert.Equal(t, &vars.Origin{Type: vars.TypeArg, Source: "command line", Value: "1.2.0"}, used)
+}
+
+func TestExecutor_ExplainErrors(t *testing.T) {
+	executor := newTestExecutor()
+	executor.AddTask("loop", &Task{Cmds: []interpreter.Command{{Type: "task", Content: map[string]interface{}{"name": "loop"}}}})
+	executor.AddTask("strict", &Task{Args: []TaskArg{{Name: "token", Required: true}}})
+	executor.AddTask("undeclared", &Task{Environment: map[string]string{"AUTH": "${API_KEY}"}})
+
+	tests := []struct {
+		task    string
+		wantErr string
+	}{
+		{"missing", "task 'missing' not found"},
+		{"loop", "circular task reference: loop -> loop"},
+		{"strict", "required argument 'token' not provided"},
+		{"undeclared", "invalid environment in task 'undeclared': failed to substitute in value for 'AUTH': undeclared secret 'API_KEY' at line 1, column 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.task, func(t *testing.T) {
+			_, err := executor.Explain(tt.task, nil)
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
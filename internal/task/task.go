@@ -2,27 +2,40 @@ package task
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"sort"
+	"strconv"
+	"time"
 
 	"github.com/kontraktor-sh/kontraktor/internal/output"
 	"github.com/kontraktor-sh/kontraktor/internal/secret"
+	"github.com/kontraktor-sh/kontraktor/internal/taskfile"
 	"github.com/kontraktor-sh/kontraktor/internal/taskfile/interpreter"
+	"github.com/kontraktor-sh/kontraktor/internal/telemetry"
 	"github.com/kontraktor-sh/kontraktor/internal/vars"
 )
 
 // Task represents a single task in the taskfile
 type Task struct {
+	Name        string                `yaml:"-"`
 	Desc        string                `yaml:"desc"`
 	Args        []TaskArg             `yaml:"args,omitempty"`
 	Cmds        []interpreter.Command `yaml:"cmds"`
 	Environment map[string]string     `yaml:"environment,omitempty"`
+	Vars        map[string]Var        `yaml:"-"`
+	Secrets     []string              `yaml:"secrets,omitempty"` // Vault secrets available to the commands of the task
+	Dir         string                `yaml:"-"`
 }
 
 // TaskArg represents a task argument
 type TaskArg struct {
-	Name     string `yaml:"name"`
-	Required bool   `yaml:"required,omitempty"`
-	Default  string `yaml:"default,omitempty"`
+	Name     string      `yaml:"name"`
+	Required bool        `yaml:"required,omitempty"`
+	Default  interface{} `yaml:"default,omitempty"`
+	Secret   bool        `yaml:"-"` // The value is masked in all output
 }
 
 // Executor handles task execution
@@ -30,6 +43,22 @@ type Executor struct {
 	outputHandler *output.Handler
 	secretManager *secret.Manager
 	interpreter   interpreter.Interpreter
+	environment   map[string]string
+	vars          map[string]Var
+	varsDir       string
+	precedence    []vars.VariableType
+	tasks         map[string]*Task
+
+	run         vars.RunInfo          // Identifier and start of the current run
+	trace       telemetry.SpanContext // Span of runs, when they are traced
+	traceParent string                // Span containing runs, from the process running kontraktor
+	cache       map[string]string     // Outputs of vars commands and files read during the current run
+	explaining  bool                  // Explaining tasks: vars commands are not run
+
+	onCheckpoint func(*Checkpoint) // Saves the checkpoint of the current run
+	checkpoint   *Checkpoint       // Progress of the current run
+	resume       *Checkpoint       // Progress of the run resumed by the next run
+	done         map[string]bool   // Paths of the steps that succeeded in the run resumed
 }
 
 // NewExecutor creates a new task executor
@@ -38,58 +67,424 @@ func NewExecutor(outputHandler *output.Handler, secretManager *secret.Manager, i
 		outputHandler: outputHandler,
 		secretManager: secretManager,
 		interpreter:   interpreter,
+		environment:   make(map[string]string),
+		tasks:         make(map[string]*Task),
+		cache:         make(map[string]string),
 	}
 }
 
+// SetEnvironment sets the global environment variables, seen by every task
+func (e *Executor) SetEnvironment(env map[string]string) {
+	for k, v := range env {
+		e.environment[k] = v
+	}
+}
+
+// SetVars sets the global vars, seen by every task. Relative files of vars are read from dir.
+func (e *Executor) SetVars(vs map[string]Var, dir string) {
+	e.vars = vs
+	e.varsDir = dir
+}
+
+// SetPrecedence sets the order in which bare variable names are looked up
+func (e *Executor) SetPrecedence(precedence []vars.VariableType) {
+	e.precedence = precedence
+}
+
+// SetTrace traces runs: events carry the IDs of their spans, with root as the span of the run
+// and parentSpanID as its parent, and processes receive the span of their step as TRACEPARENT
+func (e *Executor) SetTrace(root telemetry.SpanContext, parentSpanID string) {
+	e.trace = root
+	e.traceParent = parentSpanID
+}
+
+// AddTask makes a task available to Run and to task references
+func (e *Executor) AddTask(name string, task *Task) {
+	task.Name = name
+	e.tasks[name] = task
+}
+
+// Run runs the named task with the given arguments
+func (e *Executor) Run(ctx context.Context, name string, args map[string]interface{}) error {
+	task, ok := e.tasks[name]
+	if !ok {
+		return fmt.Errorf("task '%s' not found", name)
+	}
+	return e.Execute(ctx, task, args)
+}
+
 // Execute runs a task with the given arguments
 func (e *Executor) Execute(ctx context.Context, task *Task, args map[string]interface{}) error {
+	e.run = newRun()
+	start := output.Event{Type: output.EventRunStart, Time: e.run.Timestamp, RunID: e.run.ID, Task: task.Name}
+	if e.trace.IsValid() {
+		start.TraceID, start.SpanID, start.ParentSpanID = e.trace.TraceID, e.trace.SpanID, e.traceParent
+		ctx = telemetry.ContextWithSpan(ctx, e.trace)
+	}
+	e.outputHandler.Emit(start)
+	err := e.execute(ctx, task, args)
+	end := output.Event{Type: output.EventRunEnd, RunID: e.run.ID, Task: task.Name, Status: output.StatusSuccess}
+	copySpan(&end, start)
+	end.Duration(time.Since(e.run.Timestamp))
+	if err != nil {
+		end.Status = output.StatusFailure
+		end.Error = err.Error()
+	}
+	e.outputHandler.Emit(end)
+	return err
+}
+
+// execute runs a task with the given arguments in a new context
+func (e *Executor) execute(ctx context.Context, task *Task, args map[string]interface{}) error {
 	e.outputHandler.Debug("Executing task: %s", task.Desc)
 
-	// Validate required arguments
-	for _, arg := range task.Args {
-		if arg.Required {
-			if _, ok := args[arg.Name]; !ok {
-				return fmt.Errorf("required argument '%s' not provided", arg.Name)
+	e.cache = make(map[string]string)
+	taskCtx, err := e.rootContext(ctx, task, args)
+	if err != nil {
+		return err
+	}
+	e.startCheckpoint(task, args)
+	e.saveCheckpoint("")
+	if err := e.runTask(ctx, task, taskCtx); err != nil {
+		return err
+	}
+
+	e.outputHandler.Info("Task completed successfully")
+	return nil
+}
+
+// ExecuteReference runs a task referenced by another task, in the context prepared by the
+// task interpreter. It is meant to be passed to interpreter.NewTaskInterpreter.
+func (e *Executor) ExecuteReference(ctx context.Context, taskName string, args map[string]interface{}, taskCtx *interpreter.TaskContext) (*interpreter.Result, error) {
+	task, ok := e.tasks[taskName]
+	if !ok {
+		return nil, fmt.Errorf("task '%s' not found", taskName)
+	}
+	e.outputHandler.Debug("Executing task: %s", task.Desc)
+	if err := e.prepare(ctx, task, taskCtx); err != nil {
+		return nil, err
+	}
+	if err := e.runTask(ctx, task, taskCtx); err != nil {
+		return &interpreter.Result{Success: false, Error: err}, nil
+	}
+	return &interpreter.Result{Success: true}, nil
+}
+
+// runTask runs the commands of a task between its start and end events
+func (e *Executor) runTask(ctx context.Context, task *Task, taskCtx *interpreter.TaskContext) error {
+	start := output.Event{Type: output.EventTaskStart, Time: time.Now(), RunID: e.run.ID, Task: task.Name}
+	ctx = e.startSpan(ctx, &start)
+	e.outputHandler.Emit(start)
+	err := e.runCmds(ctx, task, taskCtx)
+	end := output.Event{Type: output.EventTaskEnd, RunID: e.run.ID, Task: task.Name, Status: output.StatusSuccess}
+	copySpan(&end, start)
+	end.Duration(time.Since(start.Time))
+	if err != nil {
+		end.Status = output.StatusFailure
+		end.Error = err.Error()
+	}
+	e.outputHandler.Emit(end)
+	return err
+}
+
+// runCmds runs the commands of a task in order, stopping at the first failure
+func (e *Executor) runCmds(ctx context.Context, task *Task, taskCtx *interpreter.TaskContext) error {
+	for i, cmd := range task.Cmds {
+		step := output.Event{RunID: e.run.ID, Task: task.Name, Step: i + 1, Interpreter: cmd.Type, File: cmd.File, FileLine: cmd.Line}
+		path := stepPath(ctx, task, i+1)
+		if e.done[path] {
+			// Succeeded in the run resumed
+			e.outputHandler.Info("Skipping step %d of task %s: succeeded in run %s", i+1, task.Name, e.resume.RunID)
+			step.Type = output.EventStepSkipped
+			step.Command = commandOf(cmd)
+			step.Reason = fmt.Sprintf("succeeded in run %s", e.resume.RunID)
+			e.outputHandler.Emit(step)
+			e.saveCheckpoint(path)
+			continue
+		}
+		if err := e.runStep(context.WithValue(ctx, stepPathKey{}, path), task, cmd, step, taskCtx); err != nil {
+			for j, skipped := range task.Cmds[i+1:] {
+				e.outputHandler.Emit(output.Event{
+					Type:        output.EventStepSkipped,
+					RunID:       e.run.ID,
+					Task:        task.Name,
+					Step:        i + j + 2,
+					Interpreter: skipped.Type,
+					Command:     commandOf(skipped),
+					File:        skipped.File,
+					FileLine:    skipped.Line,
+					Reason:      "a previous step failed",
+				})
 			}
+			return err
 		}
+		e.saveCheckpoint(path)
 	}
+	return nil
+}
 
-	// Create task context
-	taskCtx := &interpreter.TaskContext{
-		Vars: vars.NewContext(),
+// runStep runs a command of a task. When events are used, its output is emitted line by line
+// between the start and end events of the step.
+func (e *Executor) runStep(ctx context.Context, task *Task, cmd interpreter.Command, step output.Event, taskCtx *interpreter.TaskContext) error {
+	content, _ := cmd.Content.(map[string]interface{})
+	e.outputHandler.PrintCommand(cmd.Type, content)
+
+	start := time.Now()
+	event := step
+	event.Type = output.EventStepStart
+	event.Time = start
+	event.Command = commandOf(cmd)
+	ctx = e.startSpan(ctx, &event)
+	e.outputHandler.Emit(event)
+
+	if sc, ok := telemetry.SpanFromContext(ctx); ok {
+		taskCtx.Traceparent = sc.Traceparent()
+		defer func() { taskCtx.Traceparent = "" }()
 	}
-	taskCtx.Vars.Environment = task.Environment
-	taskCtx.Vars.Args = args
-	taskCtx.Vars.Secrets = make(map[string]string)
+	result, err := e.executeCmd(ctx, cmd, step, taskCtx)
 
-	// Load secrets
-	if e.secretManager != nil {
-		e.outputHandler.Debug("Loading secrets from vaults")
-		secrets, err := e.secretManager.GetSecrets(ctx)
-		if err != nil {
-			return fmt.Errorf("failed to load secrets: %w", err)
+	end := step
+	end.Type = output.EventStepEnd
+	copySpan(&end, event)
+	end.Status = output.StatusFailure
+	end.Duration(time.Since(start))
+	if err != nil {
+		err = withPosition(task, cmd, err)
+		e.outputHandler.Error("Command execution failed: %v", err)
+		end.Error = err.Error()
+		e.outputHandler.Emit(end)
+		return fmt.Errorf("command execution failed: %w", err)
+	}
+
+	e.outputHandler.PrintResult(result)
+	end.ExitCode = result.ExitCode
+	if !result.Success {
+		if result.Error != nil {
+			end.Error = result.Error.Error()
 		}
-		taskCtx.Vars.Secrets = secrets
+		e.outputHandler.Emit(end)
+		return fmt.Errorf("command failed")
+	}
+	end.Status = output.StatusSuccess
+	e.outputHandler.Emit(end)
+	return nil
+}
+
+// executeCmd runs a command with its interpreter. When events are used, the output of the
+// command is emitted as it is written.
+func (e *Executor) executeCmd(ctx context.Context, cmd interpreter.Command, step output.Event, taskCtx *interpreter.TaskContext) (*interpreter.Result, error) {
+	if !e.outputHandler.Events() {
+		return e.interpreter.Execute(ctx, cmd, taskCtx)
+	}
+	stdout := e.outputHandler.OutputWriter(step, output.StreamStdout)
+	stderr := e.outputHandler.OutputWriter(step, output.StreamStderr)
+	taskCtx.Stdout, taskCtx.Stderr = stdout, stderr
+	defer func() {
+		stdout.Close()
+		stderr.Close()
+		taskCtx.Stdout, taskCtx.Stderr = nil, nil
+	}()
+	return e.interpreter.Execute(ctx, cmd, taskCtx)
+}
+
+// startSpan starts a span, child of the span of ctx, setting its IDs on the event starting it.
+// It returns the context of the new span, or ctx when runs are not traced.
+func (e *Executor) startSpan(ctx context.Context, event *output.Event) context.Context {
+	parent, ok := telemetry.SpanFromContext(ctx)
+	if !ok {
+		return ctx
 	}
+	sc := parent.Child()
+	event.TraceID, event.SpanID, event.ParentSpanID = sc.TraceID, sc.SpanID, parent.SpanID
+	return telemetry.ContextWithSpan(ctx, sc)
+}
+
+// copySpan sets the span IDs of the event starting a span on the event ending it
+func copySpan(end *output.Event, start output.Event) {
+	end.TraceID, end.SpanID, end.ParentSpanID = start.TraceID, start.SpanID, start.ParentSpanID
+}
 
-	// Execute commands
-	for _, cmd := range task.Cmds {
-		content, _ := cmd.Content.(map[string]interface{})
-		e.outputHandler.PrintCommand(cmd.Type, content)
+// commandOf returns the command of a step before substitution, for command types that have one
+func commandOf(cmd interpreter.Command) string {
+	content, _ := cmd.Content.(map[string]interface{})
+	command, _ := content["command"].(string)
+	return command
+}
+
+// withPosition reports substitution errors at their position in the taskfile defining the command
+func withPosition(task *Task, cmd interpreter.Command, err error) error {
+	var verr *vars.Error
+	if cmd.File == "" || !errors.As(err, &verr) {
+		return err
+	}
+	line, column := taskfile.ReferencePosition(cmd.File, cmd.Line, cmd.Column, verr.Input, verr.Offset, verr.Length)
+	return fmt.Errorf("%s:%d:%d: %s in task '%s'", cmd.File, line, column, verr.Message, task.Name)
+}
 
-		result, err := e.interpreter.Execute(ctx, cmd, taskCtx)
+// rootContext creates the context of a task run directly, with the given arguments from the
+// command line
+func (e *Executor) rootContext(ctx context.Context, task *Task, args map[string]interface{}) (*interpreter.TaskContext, error) {
+	taskCtx := &interpreter.TaskContext{
+		Vars:     vars.NewContext(),
+		TaskName: task.Name,
+	}
+	taskCtx.Vars.Precedence = e.precedence
+	taskCtx.Vars.Task = vars.TaskInfo{Name: task.Name, Dir: task.Dir}
+	taskCtx.Vars.Run = e.run
+	if err := e.setSecrets(ctx, task, taskCtx); err != nil {
+		return nil, err
+	}
+	for _, k := range sortedKeys(args) {
+		taskCtx.Vars.SetArg(k, args[k], "command line")
+	}
+	if err := e.setDefaults(task, taskCtx); err != nil {
+		return nil, err
+	}
+	e.setVars(ctx, e.vars, e.varsDir, "global vars", taskCtx)
+	environment, err := taskCtx.Vars.Substitutor.SubstituteMap(e.environment, taskCtx.Vars)
+	if err != nil {
+		return nil, fmt.Errorf("invalid global environment: %w", err)
+	}
+	for _, k := range sortedKeys(environment) {
+		taskCtx.Vars.SetEnvironment(k, environment[k], "global environment")
+	}
+	e.setVars(ctx, task.Vars, task.Dir, fmt.Sprintf("vars of task '%s'", task.Name), taskCtx)
+	if err := e.setEnvironment(task, taskCtx); err != nil {
+		return nil, err
+	}
+	return taskCtx, nil
+}
+
+// prepare adds the secrets, arguments defaults, vars and environment of a task to a context
+// inherited from the task referencing it
+func (e *Executor) prepare(ctx context.Context, task *Task, taskCtx *interpreter.TaskContext) error {
+	taskCtx.Vars.Task = vars.TaskInfo{Name: task.Name, Dir: task.Dir}
+	if err := e.setSecrets(ctx, task, taskCtx); err != nil {
+		return err
+	}
+	if err := e.setDefaults(task, taskCtx); err != nil {
+		return err
+	}
+	e.setVars(ctx, task.Vars, task.Dir, fmt.Sprintf("vars of task '%s'", task.Name), taskCtx)
+	return e.setEnvironment(task, taskCtx)
+}
+
+// setSecrets replaces the secrets of a context with the secrets declared by a task, fetching
+// them from their vaults on first use. Secrets of vaults the task did not declare are marked as
+// undeclared. When explaining, secrets are not fetched and their values are MaskedValue.
+func (e *Executor) setSecrets(ctx context.Context, task *Task, taskCtx *interpreter.TaskContext) error {
+	taskCtx.Vars.ClearSecrets()
+	taskCtx.Vars.Undeclared = make(map[string]bool)
+	if e.secretManager == nil {
+		if len(task.Secrets) > 0 {
+			return fmt.Errorf("secrets of task '%s' are not defined by any vault", task.Name)
+		}
+		return nil
+	}
+	sources := e.secretManager.Sources()
+	declared := make(map[string]bool)
+	for _, name := range task.Secrets {
+		declared[name] = true
+	}
+	for name := range sources {
+		if !declared[name] {
+			taskCtx.Vars.Undeclared[name] = true
+		}
+	}
+	if len(task.Secrets) == 0 {
+		return nil
+	}
 
+	var secrets map[string]string
+	if e.explaining {
+		secrets = make(map[string]string)
+		for _, name := range task.Secrets {
+			secrets[name] = MaskedValue
+		}
+	} else {
+		e.outputHandler.Debug("Loading secrets of task %s", task.Name)
+		var err error
+		secrets, err = e.secretManager.FetchTimed(ctx, task.Secrets, func(t secret.VaultTiming) {
+			phase := output.Event{Type: output.EventPhase, Time: t.Start, RunID: e.run.ID, Task: task.Name, Phase: output.PhaseSecrets, Vault: t.Vault, Status: output.StatusSuccess}
+			e.startSpan(ctx, &phase)
+			phase.Duration(t.Duration)
+			if t.Err != nil {
+				phase.Status = output.StatusFailure
+				phase.Error = t.Err.Error()
+			}
+			e.outputHandler.Emit(phase)
+		})
 		if err != nil {
-			e.outputHandler.Error("Command execution failed: %v", err)
-			return fmt.Errorf("command execution failed: %w", err)
+			return fmt.Errorf("failed to load secrets of task '%s': %w", task.Name, err)
+		}
+	}
+	for _, k := range sortedKeys(secrets) {
+		source := "vault"
+		if vault, ok := sources[k]; ok {
+			source = fmt.Sprintf("vault %s", vault)
 		}
+		taskCtx.Vars.SetSecret(k, secrets[k], source)
+		if !e.explaining {
+			e.outputHandler.AddMaskValue(secrets[k])
+		}
+	}
+	return nil
+}
 
-		e.outputHandler.PrintResult(result)
-		if !result.Success {
-			return fmt.Errorf("command failed")
+// setDefaults validates required arguments and applies the defaults of missing ones.
+// Values of secret arguments are masked in output.
+func (e *Executor) setDefaults(task *Task, taskCtx *interpreter.TaskContext) error {
+	for _, arg := range task.Args {
+		if value, ok := taskCtx.Vars.Args[arg.Name]; ok {
+			if arg.Secret {
+				e.outputHandler.AddMaskValue(fmt.Sprintf("%v", value))
+			}
+			continue
+		}
+		if arg.Required {
+			return fmt.Errorf("required argument '%s' not provided", arg.Name)
+		}
+		if arg.Default != nil {
+			taskCtx.Vars.SetArg(arg.Name, arg.Default, fmt.Sprintf("default of task '%s'", task.Name))
+			if arg.Secret {
+				e.outputHandler.AddMaskValue(fmt.Sprintf("%v", arg.Default))
+			}
 		}
 	}
+	return nil
+}
 
-	e.outputHandler.Info("Task completed successfully")
+// setEnvironment adds the environment of a task, overriding inherited values
+func (e *Executor) setEnvironment(task *Task, taskCtx *interpreter.TaskContext) error {
+	environment, err := taskCtx.Vars.Substitutor.SubstituteMap(task.Environment, taskCtx.Vars)
+	if err != nil {
+		return fmt.Errorf("invalid environment in task '%s': %w", task.Name, err)
+	}
+	for _, k := range sortedKeys(environment) {
+		taskCtx.Vars.SetEnvironment(k, environment[k], fmt.Sprintf("environment of task '%s'", task.Name))
+	}
 	return nil
 }
+
+// newRun returns the identifier and start of a new run
+func newRun() vars.RunInfo {
+	return vars.RunInfo{ID: newRunID(), Timestamp: time.Now()}
+}
+
+// newRunID returns a random identifier for a run
+func newRunID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,192 @@
+package task
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/kontraktor-sh/kontraktor/internal/output"
+	"github.com/kontraktor-sh/kontraktor/internal/secret"
+	"github.com/kontraktor-sh/kontraktor/internal/taskfile/interpreter"
+	"github.com/kontraktor-sh/kontraktor/internal/telemetry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// staticVault returns fixed secrets
+type staticVault map[string]string
+
+func (v staticVault) GetSecrets(ctx context.Context) (map[string]string, error) {
+	return v, nil
+}
+
+func TestExecutor_MasksSecrets(t *testing.T) {
+	var out, errOut bytes.Buffer
+	handler := output.NewHandler()
+	handler.SetOutput(&out)
+	handler.SetError(&errOut)
+	handler.SetLevel(output.LevelDebug)
+
+	secrets := secret.NewManager()
+	secrets.RegisterVault("azure_keyvault.main", staticVault{"API_KEY": "k3y-from-vault"})
+	registry := interpreter.NewRegistry()
+	registry.Register(interpreter.NewBashInterpreter())
+	executor := NewExecutor(handler, secrets, registry)
+	executor.AddTask("deploy", &Task{
+		Args:    []TaskArg{{Name: "password", Secret: true}, {Name: "user", Default: "admin"}},
+		Vars:    map[string]Var{"PAYLOAD": {Sh: "printf %s ${API_KEY} | base64"}},
+		Secrets: []string{"API_KEY"},
+		Cmds: []interpreter.Command{
+			bash("echo ${API_KEY} ${password} ${user}; echo ${PAYLOAD}"),
+			bash("echo ${password} >&2; exit 1"),
+		},
+	})
+
+	err := executor.Run(context.Background(), "deploy", map[string]interface{}{"password": "pa55word"})
+	require.Error(t, err)
+	handler.Error("Task execution failed: %v", err)
+
+	for _, leaked := range []string{"k3y-from-vault", "pa55word", "azN5LWZyb20tdmF1bHQ"} {
+		assert.NotContains(t, out.String(), leaked)
+		assert.NotContains(t, errOut.String(), leaked)
+	}
+	assert.Contains(t, out.String(), "[MASKED] [MASKED] admin")
+	assert.Contains(t, errOut.String(), "Output: [MASKED]")
+}
+
+func TestExecutor_JSONEvents(t *testing.T) {
+	var out bytes.Buffer
+	handler := output.NewHandler()
+	handler.SetOutput(&out)
+	handler.SetFormat(output.FormatJSON)
+	handler.AddMaskValue("s3cr3t")
+
+	registry := interpreter.NewRegistry()
+	registry.Register(interpreter.NewBashInterpreter())
+	executor := NewExecutor(handler, nil, registry)
+	registry.Register(interpreter.NewTaskInterpreter(executor.ExecuteReference))
+	executor.AddTask("release", &Task{
+		Cmds: []interpreter.Command{
+			bash("echo s3cr3t; printf partial"),
+			bash("echo warn >&2"),
+			{Type: "task", Content: map[string]interface{}{"name": "fail"}},
+			bash("echo never"),
+		},
+	})
+	executor.AddTask("fail", &Task{Cmds: []interpreter.Command{bash("exit 2")}})
+
+	require.Error(t, executor.Run(context.Background(), "release", nil))
+
+	var got []string
+	dec := json.NewDecoder(&out)
+	for dec.More() {
+		var e output.Event
+		require.NoError(t, dec.Decode(&e))
+		assert.Equal(t, executor.run.ID, e.RunID)
+		assert.False(t, e.Time.IsZero())
+		fields := []string{fmt.Sprintf("%s %s/%d", e.Type, e.Task, e.Step)}
+		for _, field := range []string{e.Interpreter, e.Stream, e.Line, e.Status, e.Error, e.Reason} {
+			if field != "" {
+				fields = append(fields, field)
+			}
+		}
+		got = append(got, strings.Join(fields, " "))
+	}
+	assert.Equal(t, []string{
+		"run_start release/0",
+		"task_start release/0",
+		"step_start release/1 bash",
+		"output release/1 bash stdout [MASKED]",
+		"output release/1 bash stdout partial",
+		"step_end release/1 bash success",
+		"step_start release/2 bash",
+		"output release/2 bash stderr warn",
+		"step_end release/2 bash success",
+		"step_start release/3 task",
+		"task_start fail/0",
+		"step_start fail/1 bash",
+		"step_end fail/1 bash failure exit status 2",
+		"task_end fail/0 failure command failed",
+		"step_end release/3 task failure command failed",
+		"step_skipped release/4 bash a previous step failed",
+		"task_end release/0 failure command failed",
+		"run_end release/0 failure command failed",
+	}, got)
+}
+
+func TestExecutor_Trace(t *testing.T) {
+	var out bytes.Buffer
+	handler := output.NewHandler()
+	handler.SetOutput(&out)
+	handler.SetFormat(output.FormatJSON)
+
+	registry := interpreter.NewRegistry()
+	registry.Register(interpreter.NewBashInterpreter())
+	executor := NewExecutor(handler, nil, registry)
+	registry.Register(interpreter.NewTaskInterpreter(executor.ExecuteReference))
+	executor.AddTask("release", &Task{
+		Cmds: []interpreter.Command{
+			bash(`echo "$TRACEPARENT"`),
+			{Type: "task", Content: map[string]interface{}{"name": "fail"}},
+		},
+	})
+	executor.AddTask("fail", &Task{Cmds: []interpreter.Command{bash("exit 3")}})
+	root := telemetry.SpanContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7", Sampled: true}
+	executor.SetTrace(root, "b7ad6b7169203331")
+
+	require.Error(t, executor.Run(context.Background(), "release", nil))
+
+	spans := make(map[string]output.Event) // Start events by span ID
+	var traceparent string
+	dec := json.NewDecoder(&out)
+	for dec.More() {
+		var e output.Event
+		require.NoError(t, dec.Decode(&e))
+		if e.Type == output.EventOutput {
+			traceparent = e.Line
+			continue
+		}
+		require.Equal(t, root.TraceID, e.TraceID, "%s %s/%d", e.Type, e.Task, e.Step)
+		switch e.Type {
+		case output.EventRunStart, output.EventTaskStart, output.EventStepStart:
+			spans[e.SpanID] = e
+		case output.EventStepEnd:
+			assert.Equal(t, spans[e.SpanID].Task, e.Task)
+			if e.Task == "fail" {
+				require.NotNil(t, e.ExitCode)
+				assert.Equal(t, 3, *e.ExitCode)
+			}
+		}
+	}
+
+	// parentOf returns the start event of the parent span of a start event
+	parentOf := func(e output.Event) output.Event {
+		parent, ok := spans[e.ParentSpanID]
+		require.True(t, ok, "parent of %s %s/%d", e.Type, e.Task, e.Step)
+		return parent
+	}
+	var failStep output.Event
+	for _, e := range spans {
+		switch {
+		case e.Type == output.EventRunStart:
+			assert.Equal(t, root.SpanID, e.SpanID)
+			assert.Equal(t, "b7ad6b7169203331", e.ParentSpanID)
+		case e.Type == output.EventStepStart && e.Task == "release" && e.Step == 1:
+			assert.Equal(t, "00-"+root.TraceID+"-"+e.SpanID+"-01", traceparent)
+		case e.Type == output.EventStepStart && e.Task == "fail":
+			failStep = e
+		}
+	}
+	require.NotEmpty(t, failStep.SpanID)
+	task := parentOf(failStep)
+	assert.Equal(t, output.EventTaskStart, task.Type)
+	assert.Equal(t, "fail", task.Task)
+	reference := parentOf(task)
+	assert.Equal(t, output.EventStepStart, reference.Type)
+	assert.Equal(t, "release", reference.Task)
+	assert.Equal(t, 2, reference.Step)
+	assert.Equal(t, output.EventRunStart, parentOf(parentOf(reference)).Type)
+}
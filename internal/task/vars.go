@@ -0,0 +1,205 @@
+package task
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/kontraktor-sh/kontraktor/internal/taskfile/interpreter"
+	"github.com/kontraktor-sh/kontraktor/internal/vars"
+)
+
+// Var is a variable of a vars section: a literal Value, the output of the Sh command,
+// or the content of File, optionally read as JSON at JSONPath
+type Var struct {
+	Value    string
+	Sh       string
+	File     string
+	JSONPath string
+}
+
+// definition describes how the value of the variable is computed
+func (v Var) definition() string {
+	switch {
+	case v.Sh != "":
+		return "sh: " + v.Sh
+	case v.File != "" && v.JSONPath != "":
+		return fmt.Sprintf("file: %s, json_path: %s", v.File, v.JSONPath)
+	case v.File != "":
+		return "file: " + v.File
+	}
+	return v.Value
+}
+
+// setVars adds the variables of a vars section to a context. Values are computed when
+// first read, with the variables of the context they are declared in; relative files
+// are read from dir.
+func (e *Executor) setVars(ctx context.Context, vs map[string]Var, dir, source string, taskCtx *interpreter.TaskContext) {
+	for _, name := range sortedKeys(vs) {
+		v := vs[name]
+		taskCtx.Vars.SetVar(name, vars.NewDynamic(v.definition(), func() (string, error) {
+			return e.computeVar(ctx, v, dir, taskCtx)
+		}), source)
+	}
+}
+
+// computeVar computes the value of a variable. Commands and files are run and read once per
+// run: variables declared by tasks run several times share the value when they substitute
+// to the same command or file.
+func (e *Executor) computeVar(ctx context.Context, v Var, dir string, taskCtx *interpreter.TaskContext) (string, error) {
+	switch {
+	case v.Sh != "":
+		command, err := taskCtx.Substitute(v.Sh)
+		if err != nil {
+			return "", err
+		}
+		return e.cached("sh\x00"+command, func() (string, error) {
+			return e.runSh(ctx, command, taskCtx)
+		})
+	case v.File != "":
+		path, err := taskCtx.Substitute(v.File)
+		if err != nil {
+			return "", err
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		return e.cached("file\x00"+path+"\x00"+v.JSONPath, func() (string, error) {
+			return readVarFile(path, v.JSONPath)
+		})
+	}
+	return taskCtx.Substitute(v.Value)
+}
+
+// cached returns the value cached under key for the current run, computing it when missing
+func (e *Executor) cached(key string, compute func() (string, error)) (string, error) {
+	if value, ok := e.cache[key]; ok {
+		return value, nil
+	}
+	value, err := compute()
+	if err != nil {
+		return "", err
+	}
+	e.cache[key] = value
+	return value, nil
+}
+
+// runSh runs a command with the bash interpreter and returns its trimmed standard output.
+// Explanations show the command instead of running it.
+func (e *Executor) runSh(ctx context.Context, command string, taskCtx *interpreter.TaskContext) (string, error) {
+	if e.explaining {
+		return "$(" + command + ")", nil
+	}
+	e.outputHandler.Debug("Computing variable: %s", command)
+	cmd := interpreter.Command{
+		Type: "bash",
+		// Already substituted: escape what the interpreter would substitute again
+		Content: map[string]interface{}{"command": strings.ReplaceAll(command, "$", "$$")},
+	}
+	// The output of the command is the value of a variable, not output of the step
+	quiet := interpreter.TaskContext{}
+	if taskCtx != nil {
+		quiet = *taskCtx
+	}
+	quiet.Stdout, quiet.Stderr, quiet.Traceparent = nil, nil, ""
+	result, err := e.interpreter.Execute(ctx, cmd, &quiet)
+	if err != nil {
+		return "", err
+	}
+	if !result.Success {
+		message := fmt.Sprintf("command '%s' failed: %v", command, result.Error)
+		if output := strings.TrimSpace(result.Output); output != "" {
+			message += ": " + output
+		}
+		return "", errors.New(message)
+	}
+	return strings.TrimSpace(result.Stdout), nil
+}
+
+// readVarFile returns the trimmed content of a file, or the value at jsonPath when given
+func readVarFile(path, jsonPath string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	if jsonPath == "" {
+		return strings.TrimSpace(string(data)), nil
+	}
+	value, err := selectJSON(data, jsonPath)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", path, err)
+	}
+	return value, nil
+}
+
+// selectJSON returns the value at path in a JSON document. Paths are made of .key, [index]
+// and ["key"] steps and may start with $. Strings are returned as is, other values as JSON.
+func selectJSON(data []byte, path string) (string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return "", fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	rest := strings.TrimPrefix(path, "$")
+	if rest != "" && rest[0] != '.' && rest[0] != '[' {
+		rest = "." + rest
+	}
+	for rest != "" {
+		key, index := "", -1
+		if rest[0] == '.' {
+			end := strings.IndexAny(rest[1:], ".[") + 1
+			if end == 0 {
+				end = len(rest)
+			}
+			key, rest = rest[1:end], rest[end:]
+			if key == "" {
+				return "", fmt.Errorf("invalid json_path '%s'", path)
+			}
+		} else {
+			end := strings.IndexByte(rest, ']')
+			if rest[0] != '[' || end < 0 {
+				return "", fmt.Errorf("invalid json_path '%s'", path)
+			}
+			step := rest[1:end]
+			rest = rest[end+1:]
+			if unquoted, err := strconv.Unquote(step); err == nil {
+				key = unquoted
+			} else if index, err = strconv.Atoi(step); err != nil || index < 0 {
+				return "", fmt.Errorf("invalid json_path '%s'", path)
+			}
+		}
+
+		if index >= 0 {
+			list, ok := value.([]interface{})
+			if !ok || index >= len(list) {
+				return "", fmt.Errorf("json_path '%s': no index %d", path, index)
+			}
+			value = list[index]
+			continue
+		}
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return "", fmt.Errorf("json_path '%s': no key '%s'", path, key)
+		}
+		if value, ok = object[key]; !ok {
+			return "", fmt.Errorf("json_path '%s': no key '%s'", path, key)
+		}
+	}
+
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	out, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
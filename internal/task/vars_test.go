@@ -0,0 +1,161 @@
+package task
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kontraktor-sh/kontraktor/internal/output"
+	"github.com/kontraktor-sh/kontraktor/internal/taskfile/interpreter"
+	"github.com/kontraktor-sh/kontraktor/internal/telemetry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecutor_Vars(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "package.json"), []byte(`{"version": "1.4.0", "targets": [{"os": "linux"}]}`), 0o644))
+	counter := filepath.Join(dir, "calls")
+	out := filepath.Join(dir, "out")
+
+	registry := interpreter.NewRegistry()
+	registry.Register(interpreter.NewBashInterpreter())
+	executor := NewExecutor(output.NewHandler(), nil, registry)
+	registry.Register(interpreter.NewTaskInterpreter(executor.ExecuteReference))
+	executor.SetVars(map[string]Var{
+		"SHA": {Sh: "echo run >> " + counter + "; echo '  abc123  '; echo warning >&2"},
+	}, dir)
+	executor.AddTask("release", &Task{
+		Dir: dir,
+		Vars: map[string]Var{
+			"VERSION": {File: "package.json", JSONPath: ".version"},
+			"OS":      {File: "package.json", JSONPath: "$.targets[0].os"},
+			"TAG":     {Value: "${VERSION}-${SHA}"},
+		},
+		Environment: map[string]string{"IMAGE": "app:${TAG}"},
+		Cmds: []interpreter.Command{
+			bash("echo ${IMAGE} ${OS} >> " + out),
+			{Type: "task", Content: map[string]interface{}{"name": "notify"}},
+			{Type: "task", Content: map[string]interface{}{"name": "notify"}},
+		},
+	})
+	executor.AddTask("notify", &Task{
+		Vars: map[string]Var{"SHORT": {Sh: "echo ${SHA} | cut -c1-3"}},
+		Cmds: []interpreter.Command{bash("echo ${SHORT} >> " + out)},
+	})
+
+	require.NoError(t, executor.Run(context.Background(), "release", nil))
+
+	got, err := os.ReadFile(out)
+	require.NoError(t, err)
+	assert.Equal(t, "app:1.4.0-abc123 linux\nabc\nabc\n", string(got))
+	calls, err := os.ReadFile(counter)
+	require.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(calls), "run"), "commands of vars run once per run")
+}
+
+func TestExecutor_VarsOutput(t *testing.T) {
+	var out bytes.Buffer
+	handler := output.NewHandler()
+	handler.SetOutput(&out)
+	handler.SetFormat(output.FormatJSON)
+
+	registry := interpreter.NewRegistry()
+	registry.Register(interpreter.NewBashInterpreter())
+	executor := NewExecutor(handler, nil, registry)
+	executor.SetTrace(telemetry.SpanContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7", Sampled: true}, "")
+	executor.AddTask("release", &Task{
+		Vars: map[string]Var{
+			"SHA":    {Sh: "echo computed-sha; echo warning >&2"},
+			"PARENT": {Sh: `echo "traceparent=$TRACEPARENT"`},
+		},
+		Cmds: []interpreter.Command{bash("echo sha is ${SHA} ${PARENT}")},
+	})
+
+	require.NoError(t, executor.Run(context.Background(), "release", nil))
+
+	// Only the step writes output; the commands of vars run without the step's writers and span
+	var lines []string
+	dec := json.NewDecoder(&out)
+	for dec.More() {
+		var e output.Event
+		require.NoError(t, dec.Decode(&e))
+		if e.Type == output.EventOutput {
+			lines = append(lines, e.Line)
+		}
+	}
+	assert.Equal(t, []string{"sha is computed-sha traceparent="}, lines)
+}
+
+func TestExecutor_VarsErrors(t *testing.T) {
+	registry := interpreter.NewRegistry()
+	registry.Register(interpreter.NewBashInterpreter())
+	executor := NewExecutor(output.NewHandler(), nil, registry)
+	executor.AddTask("build", &Task{
+		Dir: t.TempDir(),
+		Vars: map[string]Var{
+			"FAILING": {Sh: "echo oops >&2; exit 3"},
+			"MISSING": {File: "VERSION"},
+		},
+		Cmds: []interpreter.Command{bash("echo ${FAILING}")},
+	})
+
+	err := executor.Run(context.Background(), "build", nil)
+	assert.ErrorContains(t, err, "variable 'FAILING': command 'echo oops >&2; exit 3' failed: exit status 3: oops")
+
+	executor.tasks["build"].Cmds = []interpreter.Command{bash("echo ${MISSING}")}
+	err = executor.Run(context.Background(), "build", nil)
+	assert.ErrorContains(t, err, "variable 'MISSING': open ")
+}
+
+func TestExecutor_ExplainVars(t *testing.T) {
+	executor := NewExecutor(output.NewHandler(), nil, interpreter.NewRegistry())
+	executor.SetVars(map[string]Var{"SHA": {Sh: "git rev-parse HEAD"}}, "")
+	executor.AddTask("build", &Task{
+		Environment: map[string]string{"REF": "${SHA}"},
+		Cmds:        []interpreter.Command{bash("docker build -t app:${SHA} .")},
+	})
+
+	explanation, err := executor.Explain("build", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "docker build -t app:$(git rev-parse HEAD) .", explanation.Steps[0].Summary)
+	used, _ := explanation.Vars.Explain("SHA")
+	require.NotNil(t, used)
+	assert.Equal(t, "sh: git rev-parse HEAD", used.Value)
+	assert.Equal(t, "global vars", used.Source)
+}
+
+func TestSelectJSON(t *testing.T) {
+	data := []byte(`{"name": "app", "version": 2, "images": [{"tag": "v1"}], "a.b": {"ok": true}}`)
+	tests := []struct {
+		path    string
+		want    string
+		wantErr string
+	}{
+		{".name", "app", ""},
+		{"name", "app", ""},
+		{"$.version", "2", ""},
+		{".images[0].tag", "v1", ""},
+		{".images[0]", `{"tag":"v1"}`, ""},
+		{`["a.b"].ok`, "true", ""},
+		{".images[1]", "", "json_path '.images[1]': no index 1"},
+		{".missing", "", "json_path '.missing': no key 'missing'"},
+		{".images[x]", "", "invalid json_path '.images[x]'"},
+		{"..name", "", "invalid json_path '..name'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := selectJSON(data, tt.path)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
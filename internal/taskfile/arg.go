@@ -4,10 +4,10 @@ package taskfile
 
 // TaskArg represents an argument for a task.
 // name: argument name
-// type: argument type (string, [], bool, number)
+// type: argument type (string, [], bool, number, secret)
 // default: default value (interface{})
 type TaskArg struct {
-	Name    string      `yaml:"name"`
-	Type    string      `yaml:"type"`
-	Default interface{} `yaml:"default,omitempty"`
+	Name    string      `yaml:"name" jsonschema:"required" jsonschema_description:"Name of the argument"`
+	Type    string      `yaml:"type" jsonschema:"enum=string|[]|bool|number|secret" jsonschema_description:"Type of the argument; values of secret arguments are masked in all output"`
+	Default interface{} `yaml:"default,omitempty" jsonschema_description:"Value used when the argument is not given"`
 }
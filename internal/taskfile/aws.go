@@ -0,0 +1,144 @@
+// aws.go
+// Configuration of AWS Secrets Manager and SSM Parameter Store vaults.
+package taskfile
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// AWSOptions select the region, endpoint and identity of an AWS vault. Values left empty are
+// read from the environment variables and shared files of the AWS CLI, such as AWS_REGION.
+type AWSOptions struct {
+	Region          string `yaml:"region,omitempty" jsonschema_description:"AWS region; defaults to AWS_REGION, AWS_DEFAULT_REGION or the region of the profile"`
+	Profile         string `yaml:"profile,omitempty" jsonschema_description:"Profile of the shared AWS config and credentials files; defaults to AWS_PROFILE"`
+	RoleARN         string `yaml:"role_arn,omitempty" jsonschema_description:"Role assumed with the credentials of the profile or environment before reading secrets"`
+	ExternalID      string `yaml:"external_id,omitempty" jsonschema_description:"External ID required by the trust policy of role_arn"`
+	RoleSessionName string `yaml:"role_session_name,omitempty" jsonschema_description:"Session name of role_arn; kontraktor by default"`
+	Endpoint        string `yaml:"endpoint,omitempty" jsonschema_description:"URL of the service, for VPC endpoints or local stand-ins; defaults to the endpoint of the region"`
+}
+
+// AWSSecretsManagerConfig holds the config for the secrets of AWS Secrets Manager in an account
+// and region
+// secrets: map of environment variable name to name[:json_key[:version_stage[:version_id]]]
+type AWSSecretsManagerConfig struct {
+	AWSOptions   `yaml:",inline"`
+	Secrets      map[string]string `yaml:"secrets" jsonschema_description:"Environment variable name to secret name or ARN, optionally followed by :json_key, :version_stage and :version_id (empty parts are skipped, as in name::AWSPREVIOUS)"`
+	VaultOptions `yaml:",inline"`
+}
+
+// AWSSSMConfig holds the config for the parameters of SSM Parameter Store in an account and region
+// secrets: map of environment variable name to parameter name or ARN, optionally name:version or name:label
+type AWSSSMConfig struct {
+	AWSOptions   `yaml:",inline"`
+	Secrets      map[string]string `yaml:"secrets" jsonschema_description:"Environment variable name to parameter name or ARN, optionally followed by :version or :label; SecureString parameters are decrypted"`
+	VaultOptions `yaml:",inline"`
+}
+
+// AWSSecret references a secret of AWS Secrets Manager, or a JSON key of its value
+type AWSSecret struct {
+	SecretID     string // Name or ARN of the secret
+	JSONKey      string // Key of the JSON object held by the secret, or empty for the whole value
+	VersionStage string // Staging label of the version, empty for AWSCURRENT unless VersionID is set
+	VersionID    string
+}
+
+// ParseAWSSecret splits a secret of AWS Secrets Manager in the syntax of ECS task definitions:
+// the name or ARN of the secret followed by its JSON key, version stage and version ID
+func ParseAWSSecret(value string) (AWSSecret, error) {
+	id, tail, hasTail := value, "", false
+	if strings.HasPrefix(value, "arn:") {
+		// arn:partition:secretsmanager:region:account:secret:name holds six colons
+		parts := strings.SplitN(value, ":", 8)
+		if len(parts) < 7 {
+			return AWSSecret{}, fmt.Errorf("invalid secret ARN '%s'", value)
+		}
+		id = strings.Join(parts[:7], ":")
+		if len(parts) == 8 {
+			tail, hasTail = parts[7], true
+		}
+	} else {
+		id, tail, hasTail = strings.Cut(value, ":")
+	}
+	if id == "" {
+		return AWSSecret{}, fmt.Errorf("secret '%s' has no name", value)
+	}
+	secret := AWSSecret{SecretID: id}
+	if !hasTail {
+		return secret, nil
+	}
+	parts := strings.Split(tail, ":")
+	if len(parts) > 3 {
+		return AWSSecret{}, fmt.Errorf("secret '%s' has too many parts (expected name:json_key:version_stage:version_id)", value)
+	}
+	parts = append(parts, "", "")
+	secret.JSONKey, secret.VersionStage, secret.VersionID = parts[0], parts[1], parts[2]
+	return secret, nil
+}
+
+// ParseAWSParameter splits the name or ARN of an SSM parameter from its version or label
+// selector
+func ParseAWSParameter(value string) (name, selector string) {
+	if strings.HasPrefix(value, "arn:") {
+		// arn:partition:ssm:region:account:parameter/name holds five colons
+		parts := strings.SplitN(value, ":", 7)
+		if len(parts) < 7 {
+			return value, ""
+		}
+		return strings.Join(parts[:6], ":"), parts[6]
+	}
+	name, selector, _ = strings.Cut(value, ":")
+	return name, selector
+}
+
+// Validate checks the endpoint and role of an AWS vault
+func (o AWSOptions) Validate() error {
+	if o.Endpoint != "" {
+		u, err := url.Parse(o.Endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("endpoint must be an http:// or https:// URL")
+		}
+	}
+	if o.RoleARN != "" && !strings.HasPrefix(o.RoleARN, "arn:") {
+		return fmt.Errorf("role_arn must be an ARN such as arn:aws:iam::123456789012:role/deploy")
+	}
+	if o.RoleARN == "" && (o.ExternalID != "" || o.RoleSessionName != "") {
+		return fmt.Errorf("external_id and role_session_name require role_arn")
+	}
+	return nil
+}
+
+// Validate checks the options and secrets of an AWS Secrets Manager vault
+func (c AWSSecretsManagerConfig) Validate() error {
+	if err := c.AWSOptions.Validate(); err != nil {
+		return err
+	}
+	for env, value := range c.Secrets {
+		if _, err := ParseAWSSecret(value); err != nil {
+			return fmt.Errorf("invalid secret %s: %w", env, err)
+		}
+	}
+	return nil
+}
+
+// Validate checks the options and parameters of an SSM Parameter Store vault
+func (c AWSSSMConfig) Validate() error {
+	if err := c.AWSOptions.Validate(); err != nil {
+		return err
+	}
+	for env, value := range c.Secrets {
+		name, selector := ParseAWSParameter(value)
+		if name == "" {
+			return fmt.Errorf("invalid secret %s: parameter '%s' has no name", env, value)
+		}
+		if strings.HasPrefix(name, "arn:") && strings.Count(name, ":") != 5 {
+			return fmt.Errorf("invalid secret %s: invalid parameter ARN '%s'", env, value)
+		}
+		// A selector follows the name after a colon
+		if len(name) < len(value) && (selector == "" || strings.Contains(selector, ":")) {
+			return fmt.Errorf("invalid secret %s: expected name, name:version or name:label", env)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package taskfile
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestParseAWSSecret(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    AWSSecret
+		wantErr string
+	}{
+		{value: "prod/db", want: AWSSecret{SecretID: "prod/db"}},
+		{value: "prod/db:password", want: AWSSecret{SecretID: "prod/db", JSONKey: "password"}},
+		{value: "prod/db::AWSPREVIOUS", want: AWSSecret{SecretID: "prod/db", VersionStage: "AWSPREVIOUS"}},
+		{value: "prod/db:password::v1", want: AWSSecret{SecretID: "prod/db", JSONKey: "password", VersionID: "v1"}},
+		{
+			value: "arn:aws:secretsmanager:eu-west-1:123456789012:secret:prod/db-AbCdEf",
+			want:  AWSSecret{SecretID: "arn:aws:secretsmanager:eu-west-1:123456789012:secret:prod/db-AbCdEf"},
+		},
+		{
+			value: "arn:aws:secretsmanager:eu-west-1:123456789012:secret:prod/db-AbCdEf:password:AWSCURRENT",
+			want:  AWSSecret{SecretID: "arn:aws:secretsmanager:eu-west-1:123456789012:secret:prod/db-AbCdEf", JSONKey: "password", VersionStage: "AWSCURRENT"},
+		},
+		{value: "arn:aws:secretsmanager:eu-west-1", wantErr: "invalid secret ARN 'arn:aws:secretsmanager:eu-west-1'"},
+		{value: ":password", wantErr: "secret ':password' has no name"},
+		{value: "prod/db:a:b:c:d", wantErr: "secret 'prod/db:a:b:c:d' has too many parts (expected name:json_key:version_stage:version_id)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseAWSSecret(tt.value)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParseAWSParameter(t *testing.T) {
+	name, selector := ParseAWSParameter("/prod/db/password:3")
+	assert.Equal(t, "/prod/db/password", name)
+	assert.Equal(t, "3", selector)
+
+	name, selector = ParseAWSParameter("/prod/db/password")
+	assert.Equal(t, "/prod/db/password", name)
+	assert.Equal(t, "", selector)
+
+	name, selector = ParseAWSParameter("arn:aws:ssm:us-east-1:123456789012:parameter/app/db:released")
+	assert.Equal(t, "arn:aws:ssm:us-east-1:123456789012:parameter/app/db", name)
+	assert.Equal(t, "released", selector)
+}
+
+func TestAWSConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  interface{ Validate() error }
+		wantErr string
+	}{
+		{
+			name: "secrets manager",
+			config: AWSSecretsManagerConfig{
+				AWSOptions: AWSOptions{Region: "eu-west-1", Profile: "prod", RoleARN: "arn:aws:iam::123456789012:role/deploy", ExternalID: "ext-123"},
+				Secrets:    map[string]string{"DB_PASSWORD": "prod/db:password", "OLD_PASSWORD": "prod/db:password:AWSPREVIOUS"},
+			},
+		},
+		{
+			name:   "ssm with a stand-in",
+			config: AWSSSMConfig{AWSOptions: AWSOptions{Endpoint: "http://localhost:4566"}, Secrets: map[string]string{"DB_PASSWORD": "/prod/db/password:released"}},
+		},
+		{
+			name: "ssm parameter arns",
+			config: AWSSSMConfig{Secrets: map[string]string{
+				"DB_PASSWORD": "arn:aws:ssm:us-east-1:123456789012:parameter/app/db",
+				"DB_USER":     "arn:aws:ssm:us-east-1:123456789012:parameter/app/user:released",
+			}},
+		},
+		{
+			name:    "invalid ssm parameter arn",
+			config:  AWSSSMConfig{Secrets: map[string]string{"DB_PASSWORD": "arn:aws:ssm:us-east-1"}},
+			wantErr: "invalid secret DB_PASSWORD: invalid parameter ARN 'arn:aws:ssm:us-east-1'",
+		},
+		{
+			name:    "ssm parameter arn with two selectors",
+			config:  AWSSSMConfig{Secrets: map[string]string{"DB_PASSWORD": "arn:aws:ssm:us-east-1:123456789012:parameter/app/db:3:released"}},
+			wantErr: "invalid secret DB_PASSWORD: expected name, name:version or name:label",
+		},
+		{
+			name:    "invalid endpoint",
+			config:  AWSSSMConfig{AWSOptions: AWSOptions{Endpoint: "localhost:4566"}},
+			wantErr: "endpoint must be an http:// or https:// URL",
+		},
+		{
+			name:    "role name instead of arn",
+			config:  AWSSecretsManagerConfig{AWSOptions: AWSOptions{RoleARN: "deploy"}},
+			wantErr: "role_arn must be an ARN such as arn:aws:iam::123456789012:role/deploy",
+		},
+		{
+			name:    "external id without role",
+			config:  AWSSecretsManagerConfig{AWSOptions: AWSOptions{ExternalID: "ext-123"}},
+			wantErr: "external_id and role_session_name require role_arn",
+		},
+		{
+			name:    "secret without name",
+			config:  AWSSecretsManagerConfig{Secrets: map[string]string{"DB_PASSWORD": ":password"}},
+			wantErr: "invalid secret DB_PASSWORD: secret ':password' has no name",
+		},
+		{
+			name:    "parameter without name",
+			config:  AWSSSMConfig{Secrets: map[string]string{"DB_PASSWORD": ":3"}},
+			wantErr: "invalid secret DB_PASSWORD: parameter ':3' has no name",
+		},
+		{
+			name:    "parameter with two selectors",
+			config:  AWSSSMConfig{Secrets: map[string]string{"DB_PASSWORD": "/prod/db/password:3:released"}},
+			wantErr: "invalid secret DB_PASSWORD: expected name, name:version or name:label",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVaults_AWS(t *testing.T) {
+	var tf Taskfile
+	require.NoError(t, yaml.Unmarshal([]byte(`version: "0.3"
+vaults:
+  aws_ssm:
+    config:
+      region: eu-west-1
+      secrets:
+        DB_HOST: /prod/db/host
+  aws_secrets_manager:
+    prod:
+      region: eu-west-1
+      role_arn: arn:aws:iam::123456789012:role/deploy
+      priority: 1
+      secrets:
+        DB_PASSWORD: prod/db:password
+tasks: {}
+`), &tf))
+
+	assert.Equal(t, []string{"aws_ssm.config", "aws_secrets_manager.prod"}, tf.Vaults.Order)
+	assert.Equal(t, AWSSecretsManagerConfig{
+		AWSOptions:   AWSOptions{Region: "eu-west-1", RoleARN: "arn:aws:iam::123456789012:role/deploy"},
+		Secrets:      map[string]string{"DB_PASSWORD": "prod/db:password"},
+		VaultOptions: VaultOptions{Priority: 1},
+	}, tf.Vaults.AWSSecretsManager["prod"])
+	assert.Equal(t, AWSSSMConfig{
+		AWSOptions: AWSOptions{Region: "eu-west-1"},
+		Secrets:    map[string]string{"DB_HOST": "/prod/db/host"},
+	}, tf.Vaults.AWSSSM["config"])
+	assert.NotContains(t, tf.Vaults.Custom, "aws_ssm")
+	assert.NoError(t, tf.Validate())
+}
@@ -0,0 +1,150 @@
+// azure.go
+// Configuration of Azure Key Vaults beyond their secrets: credentials, endpoints and retries.
+package taskfile
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Credential types of Azure Key Vaults
+const (
+	AzureCredentialDefault          = "default"
+	AzureCredentialClientSecret     = "client_secret"
+	AzureCredentialCertificate      = "certificate"
+	AzureCredentialWorkloadIdentity = "workload_identity"
+	AzureCredentialManagedIdentity  = "managed_identity"
+	AzureCredentialAzureCLI         = "azure_cli"
+)
+
+// AzureCredential selects how to authenticate to an Azure Key Vault. Values left empty are
+// read from the environment variables used by the Azure SDK, such as AZURE_TENANT_ID.
+type AzureCredential struct {
+	Type                   string `yaml:"type" jsonschema:"required,enum=default|client_secret|certificate|workload_identity|managed_identity|azure_cli" jsonschema_description:"Kind of credential"`
+	TenantID               string `yaml:"tenant_id,omitempty" jsonschema_description:"Tenant of the application; defaults to AZURE_TENANT_ID"`
+	ClientID               string `yaml:"client_id,omitempty" jsonschema_description:"Client ID of the application or user-assigned managed identity; defaults to AZURE_CLIENT_ID"`
+	ClientSecretEnv        string `yaml:"client_secret_env,omitempty" jsonschema_description:"Environment variable holding the client secret; AZURE_CLIENT_SECRET by default"`
+	CertificatePath        string `yaml:"certificate_path,omitempty" jsonschema_description:"PEM or PKCS#12 file holding the certificate and its private key; defaults to AZURE_CLIENT_CERTIFICATE_PATH"`
+	CertificatePasswordEnv string `yaml:"certificate_password_env,omitempty" jsonschema_description:"Environment variable holding the password of the certificate; AZURE_CLIENT_CERTIFICATE_PASSWORD by default"`
+	TokenFile              string `yaml:"token_file,omitempty" jsonschema_description:"File holding the federated token of a workload identity; defaults to AZURE_FEDERATED_TOKEN_FILE"`
+	ResourceID             string `yaml:"resource_id,omitempty" jsonschema_description:"Resource ID of a user-assigned managed identity, instead of client_id"`
+}
+
+// AzureRetry configures the retries of failed requests to an Azure Key Vault. Durations are Go
+// durations such as 500ms.
+type AzureRetry struct {
+	MaxRetries    *int   `yaml:"max_retries,omitempty" jsonschema_description:"Number of retries of a failed request; 3 by default, 0 for none"`
+	RetryDelay    string `yaml:"retry_delay,omitempty" jsonschema_description:"Delay before the first retry, doubled for each retry; 4s by default"`
+	MaxRetryDelay string `yaml:"max_retry_delay,omitempty" jsonschema_description:"Longest delay between retries; 60s by default"`
+	TryTimeout    string `yaml:"try_timeout,omitempty" jsonschema_description:"Time allowed for each try of a request; unlimited by default"`
+}
+
+// ParseAzureSecret splits the name of a secret of an Azure Key Vault from its pinned version,
+// empty for the latest version
+func ParseAzureSecret(value string) (name, version string) {
+	name, version, _ = strings.Cut(value, "@")
+	return name, version
+}
+
+// AzureClouds are the domains of Key Vaults in each Azure cloud
+var AzureClouds = map[string]string{
+	"public":        "vault.azure.net",
+	"china":         "vault.azure.cn",
+	"us_government": "vault.usgovcloudapi.net",
+}
+
+// URL returns the URL of the Key Vault: vault_url, or the URL of keyvault_name in its cloud
+func (c AzureKeyVaultConfig) URL() string {
+	if c.VaultURL != "" {
+		return c.VaultURL
+	}
+	domain, ok := AzureClouds[c.Cloud]
+	if !ok {
+		domain = AzureClouds["public"]
+	}
+	return fmt.Sprintf("https://%s.%s/", c.KeyVaultName, domain)
+}
+
+// Validate checks the endpoint, secrets, credential and retries of an Azure Key Vault
+func (c AzureKeyVaultConfig) Validate() error {
+	if c.KeyVaultName == "" && c.VaultURL == "" {
+		return fmt.Errorf("keyvault_name or vault_url is required")
+	}
+	if c.VaultURL != "" && !strings.HasPrefix(c.VaultURL, "https://") {
+		return fmt.Errorf("vault_url must be an https:// URL")
+	}
+	if _, ok := AzureClouds[c.Cloud]; !ok && c.Cloud != "" {
+		return fmt.Errorf("unknown cloud '%s' (expected public, china or us_government)", c.Cloud)
+	}
+	for env, value := range c.Secrets {
+		if name, _ := ParseAzureSecret(value); name == "" {
+			return fmt.Errorf("secret %s has no name in the Key Vault", env)
+		}
+	}
+	if c.Credential != nil {
+		if err := c.Credential.Validate(); err != nil {
+			return fmt.Errorf("invalid credential: %w", err)
+		}
+	}
+	if c.Retry != nil {
+		if err := c.Retry.Validate(); err != nil {
+			return fmt.Errorf("invalid retry: %w", err)
+		}
+	}
+	return nil
+}
+
+// Validate checks the type of a credential, and that it only sets the fields of its type
+func (c AzureCredential) Validate() error {
+	allowed := map[string][]string{
+		AzureCredentialDefault:          {"tenant_id"},
+		AzureCredentialClientSecret:     {"tenant_id", "client_id", "client_secret_env"},
+		AzureCredentialCertificate:      {"tenant_id", "client_id", "certificate_path", "certificate_password_env"},
+		AzureCredentialWorkloadIdentity: {"tenant_id", "client_id", "token_file"},
+		AzureCredentialManagedIdentity:  {"client_id", "resource_id"},
+		AzureCredentialAzureCLI:         {"tenant_id"},
+	}
+	fields, ok := allowed[c.Type]
+	if !ok {
+		return fmt.Errorf("unknown type '%s' (expected default, client_secret, certificate, workload_identity, managed_identity or azure_cli)", c.Type)
+	}
+	for _, field := range []struct{ name, value string }{
+		{"tenant_id", c.TenantID},
+		{"client_id", c.ClientID},
+		{"client_secret_env", c.ClientSecretEnv},
+		{"certificate_path", c.CertificatePath},
+		{"certificate_password_env", c.CertificatePasswordEnv},
+		{"token_file", c.TokenFile},
+		{"resource_id", c.ResourceID},
+	} {
+		if field.value != "" && !slices.Contains(fields, field.name) {
+			return fmt.Errorf("%s is not supported by credentials of type %s", field.name, c.Type)
+		}
+	}
+	if c.ClientID != "" && c.ResourceID != "" {
+		return fmt.Errorf("client_id and resource_id cannot both be set")
+	}
+	return nil
+}
+
+// Validate checks the durations of retries
+func (r AzureRetry) Validate() error {
+	if r.MaxRetries != nil && *r.MaxRetries < 0 {
+		return fmt.Errorf("max_retries must not be negative")
+	}
+	for _, field := range []struct{ name, value string }{
+		{"retry_delay", r.RetryDelay},
+		{"max_retry_delay", r.MaxRetryDelay},
+		{"try_timeout", r.TryTimeout},
+	} {
+		if field.value == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(field.value); err != nil || d < 0 {
+			return fmt.Errorf("invalid %s '%s' (expected a duration such as 500ms)", field.name, field.value)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package taskfile
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAzureSecret(t *testing.T) {
+	name, version := ParseAzureSecret("api-key@0123abcd")
+	assert.Equal(t, "api-key", name)
+	assert.Equal(t, "0123abcd", version)
+
+	name, version = ParseAzureSecret("api-key")
+	assert.Equal(t, "api-key", name)
+	assert.Equal(t, "", version)
+}
+
+func TestAzureKeyVaultConfig_URL(t *testing.T) {
+	assert.Equal(t, "https://main.vault.azure.net/", AzureKeyVaultConfig{KeyVaultName: "main"}.URL())
+	assert.Equal(t, "https://main.vault.usgovcloudapi.net/", AzureKeyVaultConfig{KeyVaultName: "main", Cloud: "us_government"}.URL())
+	assert.Equal(t, "https://localhost:8443", AzureKeyVaultConfig{KeyVaultName: "main", VaultURL: "https://localhost:8443"}.URL())
+}
+
+func TestAzureKeyVaultConfig_Validate(t *testing.T) {
+	negative := -1
+	tests := []struct {
+		name    string
+		config  AzureKeyVaultConfig
+		wantErr string
+	}{
+		{
+			name:   "name",
+			config: AzureKeyVaultConfig{KeyVaultName: "main", Secrets: map[string]string{"API_KEY": "api-key@0123abcd"}},
+		},
+		{
+			name: "url, credential and retries",
+			config: AzureKeyVaultConfig{
+				VaultURL:   "https://localhost:8443",
+				Credential: &AzureCredential{Type: AzureCredentialClientSecret, TenantID: "tenant", ClientSecretEnv: "DEPLOY_SECRET"},
+				Retry:      &AzureRetry{RetryDelay: "500ms", TryTimeout: "10s"},
+			},
+		},
+		{
+			name:    "no vault",
+			config:  AzureKeyVaultConfig{},
+			wantErr: "keyvault_name or vault_url is required",
+		},
+		{
+			name:    "plain http",
+			config:  AzureKeyVaultConfig{VaultURL: "http://localhost:8080"},
+			wantErr: "vault_url must be an https:// URL",
+		},
+		{
+			name:    "unknown cloud",
+			config:  AzureKeyVaultConfig{KeyVaultName: "main", Cloud: "germany"},
+			wantErr: "unknown cloud 'germany' (expected public, china or us_government)",
+		},
+		{
+			name:    "version without name",
+			config:  AzureKeyVaultConfig{KeyVaultName: "main", Secrets: map[string]string{"API_KEY": "@0123abcd"}},
+			wantErr: "secret API_KEY has no name in the Key Vault",
+		},
+		{
+			name:    "unknown credential",
+			config:  AzureKeyVaultConfig{KeyVaultName: "main", Credential: &AzureCredential{Type: "password"}},
+			wantErr: "invalid credential: unknown type 'password' (expected default, client_secret, certificate, workload_identity, managed_identity or azure_cli)",
+		},
+		{
+			name:    "field of another credential",
+			config:  AzureKeyVaultConfig{KeyVaultName: "main", Credential: &AzureCredential{Type: AzureCredentialAzureCLI, TokenFile: "token"}},
+			wantErr: "invalid credential: token_file is not supported by credentials of type azure_cli",
+		},
+		{
+			name:    "two managed identities",
+			config:  AzureKeyVaultConfig{KeyVaultName: "main", Credential: &AzureCredential{Type: AzureCredentialManagedIdentity, ClientID: "client", ResourceID: "resource"}},
+			wantErr: "invalid credential: client_id and resource_id cannot both be set",
+		},
+		{
+			name:    "negative retries",
+			config:  AzureKeyVaultConfig{KeyVaultName: "main", Retry: &AzureRetry{MaxRetries: &negative}},
+			wantErr: "invalid retry: max_retries must not be negative",
+		},
+		{
+			name:    "invalid delay",
+			config:  AzureKeyVaultConfig{KeyVaultName: "main", Retry: &AzureRetry{RetryDelay: "soon"}},
+			wantErr: "invalid retry: invalid retry_delay 'soon' (expected a duration such as 500ms)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
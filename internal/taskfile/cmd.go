@@ -12,10 +12,19 @@ import (
 type TaskCmd struct {
 	Type    string                 `yaml:"type"`
 	Content map[string]interface{} `yaml:"content"`
+	Line    int                    `yaml:"-"` // Position of the command text in the taskfile,
+	Column  int                    `yaml:"-"` // or of the whole entry when it has none
 }
 
 // UnmarshalYAML implements custom YAML unmarshalling for TaskCmd
 func (t *TaskCmd) UnmarshalYAML(value *yaml.Node) error {
+	t.Line, t.Column = value.Line, value.Column
+	if command := mappingValue(mappingValue(value, "content"), "command"); command != nil {
+		t.Line, t.Column = command.Line, command.Column
+	} else if command := mappingValue(value, "command"); command != nil {
+		t.Line, t.Column = command.Line, command.Column
+	}
+
 	// Handle simple string commands (backward compatibility)
 	if value.Kind == yaml.ScalarNode {
 		t.Type = "bash"
@@ -0,0 +1,122 @@
+// hashicorp.go
+// Configuration of HashiCorp Vaults: KV secrets engines, authentication and TLS.
+package taskfile
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Authentication methods of HashiCorp Vaults
+const (
+	HashiCorpAuthToken      = "token"
+	HashiCorpAuthAppRole    = "approle"
+	HashiCorpAuthKubernetes = "kubernetes"
+)
+
+// HashiCorpVaultConfig holds the config for a single HashiCorp Vault and KV secrets engine.
+// Values left empty are read from the environment variables of the vault CLI, such as VAULT_ADDR.
+// secrets: map of environment variable name to path#field in the secrets engine
+type HashiCorpVaultConfig struct {
+	Address      string            `yaml:"address,omitempty" jsonschema_description:"URL of the Vault server; defaults to VAULT_ADDR"`
+	Namespace    string            `yaml:"namespace,omitempty" jsonschema_description:"Namespace of the secrets engine and of authentication (Vault Enterprise); defaults to VAULT_NAMESPACE"`
+	Mount        string            `yaml:"mount,omitempty" jsonschema_description:"Path of the KV secrets engine; secret by default"`
+	KVVersion    int               `yaml:"kv_version,omitempty" jsonschema_description:"Version of the KV secrets engine, 1 or 2; 2 by default"`
+	Secrets      map[string]string `yaml:"secrets" jsonschema_description:"Environment variable name to path#field in the secrets engine; the field may be left out for secrets with a single field"`
+	Auth         *HashiCorpAuth    `yaml:"auth,omitempty" jsonschema_description:"Authentication to the Vault; a token from VAULT_TOKEN or ~/.vault-token by default"`
+	TLS          *HashiCorpTLS     `yaml:"tls,omitempty" jsonschema_description:"TLS options of connections to the Vault"`
+	VaultOptions `yaml:",inline"`
+}
+
+// HashiCorpAuth selects how to authenticate to a HashiCorp Vault
+type HashiCorpAuth struct {
+	Method      string `yaml:"method" jsonschema:"required,enum=token|approle|kubernetes" jsonschema_description:"Authentication method"`
+	Mount       string `yaml:"mount,omitempty" jsonschema_description:"Path of the auth method; the name of the method by default"`
+	TokenEnv    string `yaml:"token_env,omitempty" jsonschema_description:"Environment variable holding the token; VAULT_TOKEN by default"`
+	RoleID      string `yaml:"role_id,omitempty" jsonschema_description:"Role ID of the AppRole"`
+	SecretIDEnv string `yaml:"secret_id_env,omitempty" jsonschema_description:"Environment variable holding the secret ID of the AppRole; VAULT_SECRET_ID by default"`
+	Role        string `yaml:"role,omitempty" jsonschema_description:"Role of the Kubernetes auth method"`
+	TokenFile   string `yaml:"token_file,omitempty" jsonschema_description:"Service account token of the Kubernetes auth method; the token mounted in pods by default"`
+}
+
+// HashiCorpTLS configures TLS connections to a HashiCorp Vault
+type HashiCorpTLS struct {
+	CACert             string `yaml:"ca_cert,omitempty" jsonschema_description:"PEM file of the certificate authorities trusted for the server; defaults to VAULT_CACERT"`
+	ClientCert         string `yaml:"client_cert,omitempty" jsonschema_description:"PEM file of the client certificate; defaults to VAULT_CLIENT_CERT"`
+	ClientKey          string `yaml:"client_key,omitempty" jsonschema_description:"PEM file of the key of the client certificate; defaults to VAULT_CLIENT_KEY"`
+	ServerName         string `yaml:"server_name,omitempty" jsonschema_description:"Name verified in the certificate of the server; defaults to VAULT_TLS_SERVER_NAME"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify,omitempty" jsonschema_description:"Accept any certificate of the server, for local development only"`
+}
+
+// ParseHashiCorpSecret splits the path of a secret in a KV secrets engine from its field, empty
+// when the secret has a single field
+func ParseHashiCorpSecret(value string) (path, field string) {
+	path, field, _ = strings.Cut(value, "#")
+	return strings.Trim(path, "/"), field
+}
+
+// Validate checks the address, secrets engine, secrets, authentication and TLS options of a
+// HashiCorp Vault
+func (c HashiCorpVaultConfig) Validate() error {
+	if c.Address != "" {
+		u, err := url.Parse(c.Address)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("address must be an http:// or https:// URL")
+		}
+	}
+	if c.KVVersion != 0 && c.KVVersion != 1 && c.KVVersion != 2 {
+		return fmt.Errorf("unknown kv_version %d (expected 1 or 2)", c.KVVersion)
+	}
+	for env, value := range c.Secrets {
+		path, field := ParseHashiCorpSecret(value)
+		if path == "" {
+			return fmt.Errorf("secret %s has no path in the secrets engine", env)
+		}
+		if strings.Contains(value, "#") && field == "" {
+			return fmt.Errorf("secret %s has an empty field", env)
+		}
+	}
+	if c.Auth != nil {
+		if err := c.Auth.Validate(); err != nil {
+			return fmt.Errorf("invalid auth: %w", err)
+		}
+	}
+	if c.TLS != nil && (c.TLS.ClientCert == "") != (c.TLS.ClientKey == "") {
+		return fmt.Errorf("invalid tls: client_cert and client_key must be set together")
+	}
+	return nil
+}
+
+// Validate checks the method of an authentication, and that it only sets the fields of its method
+func (a HashiCorpAuth) Validate() error {
+	allowed := map[string][]string{
+		HashiCorpAuthToken:      {"token_env"},
+		HashiCorpAuthAppRole:    {"mount", "role_id", "secret_id_env"},
+		HashiCorpAuthKubernetes: {"mount", "role", "token_file"},
+	}
+	fields, ok := allowed[a.Method]
+	if !ok {
+		return fmt.Errorf("unknown method '%s' (expected token, approle or kubernetes)", a.Method)
+	}
+	for _, field := range []struct{ name, value string }{
+		{"mount", a.Mount},
+		{"token_env", a.TokenEnv},
+		{"role_id", a.RoleID},
+		{"secret_id_env", a.SecretIDEnv},
+		{"role", a.Role},
+		{"token_file", a.TokenFile},
+	} {
+		if field.value != "" && !slices.Contains(fields, field.name) {
+			return fmt.Errorf("%s is not supported by the %s method", field.name, a.Method)
+		}
+	}
+	if a.Method == HashiCorpAuthAppRole && a.RoleID == "" {
+		return fmt.Errorf("approle method requires role_id")
+	}
+	if a.Method == HashiCorpAuthKubernetes && a.Role == "" {
+		return fmt.Errorf("kubernetes method requires role")
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package taskfile
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestParseHashiCorpSecret(t *testing.T) {
+	path, field := ParseHashiCorpSecret("/app/db/#password")
+	assert.Equal(t, "app/db", path)
+	assert.Equal(t, "password", field)
+
+	path, field = ParseHashiCorpSecret("app/api")
+	assert.Equal(t, "app/api", path)
+	assert.Equal(t, "", field)
+}
+
+func TestHashiCorpVaultConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  HashiCorpVaultConfig
+		wantErr string
+	}{
+		{
+			name:   "defaults",
+			config: HashiCorpVaultConfig{Secrets: map[string]string{"API_KEY": "app/api#key", "TOKEN": "app/token"}},
+		},
+		{
+			name: "approle, namespace and tls",
+			config: HashiCorpVaultConfig{
+				Address:   "https://vault.example.com:8200",
+				Namespace: "team-a",
+				Mount:     "kv",
+				KVVersion: 1,
+				Auth:      &HashiCorpAuth{Method: HashiCorpAuthAppRole, RoleID: "ci", SecretIDEnv: "CI_SECRET_ID"},
+				TLS:       &HashiCorpTLS{CACert: "ca.pem", ClientCert: "client.pem", ClientKey: "client-key.pem"},
+			},
+		},
+		{
+			name:    "invalid address",
+			config:  HashiCorpVaultConfig{Address: "vault.example.com:8200"},
+			wantErr: "address must be an http:// or https:// URL",
+		},
+		{
+			name:    "unknown kv version",
+			config:  HashiCorpVaultConfig{KVVersion: 3},
+			wantErr: "unknown kv_version 3 (expected 1 or 2)",
+		},
+		{
+			name:    "field without path",
+			config:  HashiCorpVaultConfig{Secrets: map[string]string{"API_KEY": "#key"}},
+			wantErr: "secret API_KEY has no path in the secrets engine",
+		},
+		{
+			name:    "empty field",
+			config:  HashiCorpVaultConfig{Secrets: map[string]string{"API_KEY": "app/api#"}},
+			wantErr: "secret API_KEY has an empty field",
+		},
+		{
+			name:    "unknown method",
+			config:  HashiCorpVaultConfig{Auth: &HashiCorpAuth{Method: "userpass"}},
+			wantErr: "invalid auth: unknown method 'userpass' (expected token, approle or kubernetes)",
+		},
+		{
+			name:    "field of another method",
+			config:  HashiCorpVaultConfig{Auth: &HashiCorpAuth{Method: HashiCorpAuthToken, RoleID: "ci"}},
+			wantErr: "invalid auth: role_id is not supported by the token method",
+		},
+		{
+			name:    "approle without role id",
+			config:  HashiCorpVaultConfig{Auth: &HashiCorpAuth{Method: HashiCorpAuthAppRole}},
+			wantErr: "invalid auth: approle method requires role_id",
+		},
+		{
+			name:    "kubernetes without role",
+			config:  HashiCorpVaultConfig{Auth: &HashiCorpAuth{Method: HashiCorpAuthKubernetes}},
+			wantErr: "invalid auth: kubernetes method requires role",
+		},
+		{
+			name:    "client certificate without key",
+			config:  HashiCorpVaultConfig{TLS: &HashiCorpTLS{ClientCert: "client.pem"}},
+			wantErr: "invalid tls: client_cert and client_key must be set together",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVaults_HashiCorpVault(t *testing.T) {
+	var tf Taskfile
+	require.NoError(t, yaml.Unmarshal([]byte(`version: "0.3"
+vaults:
+  hashicorp_vault:
+    platform:
+      address: https://vault.example.com:8200
+      auth:
+        method: kubernetes
+        role: deployer
+      priority: 2
+      secrets:
+        DB_PASSWORD: app/db#password
+tasks: {}
+`), &tf))
+
+	assert.Equal(t, []string{"hashicorp_vault.platform"}, tf.Vaults.Order)
+	assert.Equal(t, HashiCorpVaultConfig{
+		Address:      "https://vault.example.com:8200",
+		Auth:         &HashiCorpAuth{Method: HashiCorpAuthKubernetes, Role: "deployer"},
+		Secrets:      map[string]string{"DB_PASSWORD": "app/db#password"},
+		VaultOptions: VaultOptions{Priority: 2},
+	}, tf.Vaults.HashiCorpVault["platform"])
+	assert.NotContains(t, tf.Vaults.Custom, "hashicorp_vault")
+	assert.NoError(t, tf.Validate())
+}
@@ -3,6 +3,9 @@
 package taskfile
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"net/http"
@@ -10,52 +13,67 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
+// ImportTiming is the time spent resolving an import: fetching it and parsing it with its own imports
+type ImportTiming struct {
+	Path     string
+	Start    time.Time
+	Duration time.Duration
+	Err      error
+}
+
 // ParseTaskfile reads and parses a taskfile.ktr.yml from the given path, recursively loading imports.
 func ParseTaskfile(path string) (*Taskfile, error) {
+	return ParseTaskfileTimed(path, nil)
+}
+
+// ParseTaskfileTimed is ParseTaskfile reporting the time spent on each import, including nested
+// imports, to onImport when it is not nil.
+func ParseTaskfileTimed(path string, onImport func(ImportTiming)) (*Taskfile, error) {
 	if _, err := os.Stat(path); os.IsNotExist(err) {
 		return nil, fmt.Errorf("taskfile not found: %s", path)
 	}
-	f, err := os.Open(path)
+	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("open taskfile: %w", err)
 	}
-	defer f.Close()
 
 	var tf Taskfile
-	dec := yaml.NewDecoder(f)
+	dec := yaml.NewDecoder(bytes.NewReader(data))
 	if err := dec.Decode(&tf); err != nil {
 		return nil, fmt.Errorf("decode yaml in %s: %w", path, err)
 	}
 
+	dir, _ := filepath.Abs(filepath.Dir(path))
+	tf.Dir = dir
+	for name, task := range tf.Tasks {
+		task.File = path
+		task.Dir = dir
+		tf.Tasks[name] = task
+	}
+
 	// Validate the taskfile
 	if err := tf.Validate(); err != nil {
 		return nil, fmt.Errorf("invalid taskfile: %w", err)
 	}
 
 	// Recursively load imports
+	hash := sha256.New()
+	hash.Write(data)
 	for _, importPath := range tf.Imports {
-		var importFile string
-		if isGitImport(importPath) {
-			importFile, err = cloneAndGetFile(importPath)
-			if err != nil {
-				return nil, fmt.Errorf("git import %s: %w", importPath, err)
-			}
-		} else if isHTTPImport(importPath) {
-			importFile, err = downloadToTemp(importPath)
-			if err != nil {
-				return nil, fmt.Errorf("download import %s: %w", importPath, err)
-			}
-		} else {
-			importFile = importPath
+		start := time.Now()
+		imported, err := resolveImport(importPath, onImport)
+		if onImport != nil {
+			onImport(ImportTiming{Path: importPath, Start: start, Duration: time.Since(start), Err: err})
 		}
-		imported, err := ParseTaskfile(importFile)
 		if err != nil {
-			return nil, fmt.Errorf("import %s: %w", importPath, err)
+			return nil, err
 		}
+		hash.Write([]byte(imported.Hash))
 		// Merge imported tasks, but do not override main file tasks
 		for k, v := range imported.Tasks {
 			if _, exists := tf.Tasks[k]; !exists {
@@ -63,10 +81,49 @@ func ParseTaskfile(path string) (*Taskfile, error) {
 			}
 		}
 	}
+	tf.Hash = hex.EncodeToString(hash.Sum(nil))
 
 	return &tf, nil
 }
 
+// resolveImport fetches and parses an imported taskfile
+func resolveImport(importPath string, onImport func(ImportTiming)) (*Taskfile, error) {
+	importFile, err := FetchImport(importPath)
+	if err != nil {
+		return nil, err
+	}
+	imported, err := ParseTaskfileTimed(importFile, onImport)
+	if err != nil {
+		return nil, fmt.Errorf("import %s: %w", importPath, err)
+	}
+	return imported, nil
+}
+
+// FetchImport makes an import available locally and returns the path of the file to parse.
+// Git and HTTP(S) imports are fetched into temporary locations, local paths are returned as is.
+func FetchImport(importPath string) (string, error) {
+	if isGitImport(importPath) {
+		importFile, err := cloneAndGetFile(importPath)
+		if err != nil {
+			return "", fmt.Errorf("git import %s: %w", importPath, err)
+		}
+		return importFile, nil
+	}
+	if isHTTPImport(importPath) {
+		importFile, err := downloadToTemp(importPath)
+		if err != nil {
+			return "", fmt.Errorf("download import %s: %w", importPath, err)
+		}
+		return importFile, nil
+	}
+	return importPath, nil
+}
+
+// IsRemoteImport returns true if the import is fetched over the network rather than read from disk.
+func IsRemoteImport(path string) bool {
+	return isGitImport(path) || isHTTPImport(path)
+}
+
 // isHTTPImport returns true if the path is an HTTP(S) URL.
 func isHTTPImport(path string) bool {
 	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
@@ -112,14 +169,15 @@ func cloneAndGetFile(gitImport string) (string, error) {
 		return "", err
 	}
 
-	fmt.Printf("Cloning repo %s...\n", repoURL)
+	// Progress goes to stderr: stdout carries reports and events, such as --output json
+	fmt.Fprintf(os.Stderr, "Cloning repo %s...\n", repoURL)
 	cmd := exec.Command("git", "clone", "--depth=1", repoURL, tmpDir)
 	cmd.Stdout = nil // suppress output
 	cmd.Stderr = nil // suppress output
 	if err := cmd.Run(); err != nil {
 		return "", fmt.Errorf("git clone failed: %w", err)
 	}
-	fmt.Printf("Clone complete: %s\n", repoURL)
+	fmt.Fprintf(os.Stderr, "Clone complete: %s\n", repoURL)
 
 	fullPath := filepath.Join(tmpDir, fileInRepo)
 	if _, err := os.Stat(fullPath); os.IsNotExist(err) {
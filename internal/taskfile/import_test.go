@@ -0,0 +1,37 @@
+package taskfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTaskfile_Hash(t *testing.T) {
+	dir := t.TempDir()
+	main := filepath.Join(dir, "taskfile.ktr.yml")
+	shared := filepath.Join(dir, "shared.ktr.yml")
+	write := func(path, content string) {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	}
+	hash := func() string {
+		tf, err := ParseTaskfile(main)
+		require.NoError(t, err)
+		assert.Len(t, tf.Hash, 64)
+		return tf.Hash
+	}
+
+	write(main, "version: \"1\"\nimports:\n  - "+shared+"\ntasks:\n  build:\n    cmds:\n      - echo build\n")
+	write(shared, "version: \"1\"\ntasks:\n  lint:\n    cmds:\n      - echo lint\n")
+	original := hash()
+	assert.Equal(t, original, hash())
+
+	write(shared, "version: \"1\"\ntasks:\n  lint:\n    cmds:\n      - echo lint ./...\n")
+	changedImport := hash()
+	assert.NotEqual(t, original, changedImport)
+
+	write(main, "version: \"1\"\nimports:\n  - "+shared+"\ntasks:\n  build:\n    cmds:\n      - echo build # comment\n")
+	assert.NotEqual(t, changedImport, hash())
+}
@@ -9,7 +9,7 @@ import (
 
 // BashCommand represents a bash command to be executed
 type BashCommand struct {
-	Command     string            `yaml:"command"`
+	Command     string            `yaml:"command" jsonschema:"required"`
 	WorkingDir  string            `yaml:"working_dir,omitempty"`
 	Environment map[string]string `yaml:"environment,omitempty"`
 	Timeout     int               `yaml:"timeout,omitempty"` // timeout in seconds
@@ -23,9 +23,19 @@ func NewBashInterpreter() *BashInterpreter {
 	return &BashInterpreter{}
 }
 
-// CanHandle returns true if the command type is "ktr@bash"
+// CanHandle returns true if the command type is "bash" or "ktr@bash"
 func (i *BashInterpreter) CanHandle(cmdType string) bool {
-	return cmdType == "ktr@bash"
+	return cmdType == "bash" || cmdType == "ktr@bash"
+}
+
+// CommandTypes returns the command types handled by the bash interpreter
+func (i *BashInterpreter) CommandTypes() []string {
+	return []string{"bash", "ktr@bash"}
+}
+
+// ContentPrototype returns the content type of bash commands
+func (i *BashInterpreter) ContentPrototype() interface{} {
+	return BashCommand{}
 }
 
 // Execute runs the bash command and returns the result
@@ -61,18 +71,22 @@ func (i *BashInterpreter) Execute(ctx context.Context, cmd Command, taskCtx *Tas
 		shellCmd.Env = append(shellCmd.Env, fmt.Sprintf("%s=%s", k, v))
 	}
 
-	// Execute the command
-	output, err := shellCmd.CombinedOutput()
+	// Execute the command, keeping standard output apart for callers using it as a value
+	output, stdout, err := runProcess(shellCmd, taskCtx)
 	if err != nil {
 		return &Result{
-			Success: false,
-			Output:  string(output),
-			Error:   err,
+			Success:  false,
+			Output:   output,
+			Stdout:   stdout,
+			Error:    err,
+			ExitCode: exitCode(err),
 		}, nil
 	}
 
 	return &Result{
-		Success: true,
-		Output:  strings.TrimSpace(string(output)),
+		Success:  true,
+		Output:   strings.TrimSpace(output),
+		Stdout:   stdout,
+		ExitCode: exitCode(nil),
 	}, nil
 }
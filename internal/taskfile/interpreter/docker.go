@@ -9,11 +9,11 @@ import (
 
 // DockerCommand represents a Docker command to be executed
 type DockerCommand struct {
-	Image       string
-	Command     []string
-	Environment map[string]string
-	Volumes     map[string]string
-	Network     string
+	Image       string            `yaml:"image" jsonschema:"required"`
+	Command     []string          `yaml:"command,omitempty"`
+	Environment map[string]string `yaml:"environment,omitempty"`
+	Volumes     map[string]string `yaml:"volumes,omitempty"`
+	Network     string            `yaml:"network,omitempty"`
 }
 
 // DockerInterpreter implements the Interpreter interface for Docker commands
@@ -29,11 +29,21 @@ func (i *DockerInterpreter) CanHandle(cmdType string) bool {
 	return cmdType == "docker"
 }
 
+// CommandTypes returns the command types handled by the Docker interpreter
+func (i *DockerInterpreter) CommandTypes() []string {
+	return []string{"docker"}
+}
+
+// ContentPrototype returns the content type of Docker commands
+func (i *DockerInterpreter) ContentPrototype() interface{} {
+	return DockerCommand{}
+}
+
 // Execute runs the Docker command and returns the result
 func (i *DockerInterpreter) Execute(ctx context.Context, cmd Command, taskCtx *TaskContext) (*Result, error) {
-	dockerCmd, ok := cmd.Content.(DockerCommand)
-	if !ok {
-		return nil, fmt.Errorf("invalid command content for docker interpreter")
+	var dockerCmd DockerCommand
+	if err := DecodeContent(cmd.Content, &dockerCmd); err != nil {
+		return nil, err
 	}
 
 	if dockerCmd.Image == "" {
@@ -54,6 +64,10 @@ func (i *DockerInterpreter) Execute(ctx context.Context, cmd Command, taskCtx *T
 		args = append(args, "-e", fmt.Sprintf("%s=%s", k, v))
 	}
 
+	if taskCtx.Traceparent != "" {
+		args = append(args, "-e", "TRACEPARENT="+taskCtx.Traceparent)
+	}
+
 	// Add volumes
 	for host, container := range dockerCmd.Volumes {
 		args = append(args, "-v", fmt.Sprintf("%s:%s", host, container))
@@ -72,17 +86,21 @@ func (i *DockerInterpreter) Execute(ctx context.Context, cmd Command, taskCtx *T
 	shellCmd := exec.CommandContext(ctx, "docker", args...)
 
 	// Execute the command
-	output, err := shellCmd.CombinedOutput()
+	output, stdout, err := runProcess(shellCmd, taskCtx)
 	if err != nil {
 		return &Result{
-			Success: false,
-			Output:  string(output),
-			Error:   err,
+			Success:  false,
+			Output:   output,
+			Stdout:   stdout,
+			Error:    err,
+			ExitCode: exitCode(err),
 		}, nil
 	}
 
 	return &Result{
-		Success: true,
-		Output:  strings.TrimSpace(string(output)),
+		Success:  true,
+		Output:   strings.TrimSpace(output),
+		Stdout:   stdout,
+		ExitCode: exitCode(nil),
 	}, nil
 }
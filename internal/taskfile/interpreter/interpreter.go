@@ -3,27 +3,40 @@ package interpreter
 import (
 	"context"
 	"fmt"
+	"io"
 
 	"github.com/kontraktor-sh/kontraktor/internal/vars"
+	"gopkg.in/yaml.v3"
 )
 
 // TaskContext holds the execution context for a task
 type TaskContext struct {
 	Vars     *vars.Context
 	TaskName string
+	Stdout   io.Writer // Receives the standard output of commands as it is written, if set
+	Stderr   io.Writer // Receives the standard error of commands as it is written, if set
+
+	// Traceparent is passed to processes as TRACEPARENT, when the run is traced, so that
+	// instrumented tools add their spans to the span of the running step
+	Traceparent string
 }
 
 // Command represents a command to be executed by an interpreter
 type Command struct {
 	Type    string
 	Content interface{}
+	File    string // Taskfile defining the command, for error positions
+	Line    int
+	Column  int
 }
 
 // Result represents the result of a command execution
 type Result struct {
-	Success bool
-	Output  string
-	Error   error
+	Success  bool
+	Output   string
+	Stdout   string // Standard output alone, for interpreters that separate it from Output
+	Error    error
+	ExitCode *int // Exit code of the process run by the command, for interpreters running one
 }
 
 // Interpreter defines the interface that all command interpreters must implement
@@ -35,6 +48,16 @@ type Interpreter interface {
 	CanHandle(cmdType string) bool
 }
 
+// ContentDescriber is implemented by interpreters that can describe the content of their commands.
+// It is used to generate the taskfile schema.
+type ContentDescriber interface {
+	// CommandTypes returns the command types handled by the interpreter
+	CommandTypes() []string
+
+	// ContentPrototype returns a zero value of the type command content is decoded into
+	ContentPrototype() interface{}
+}
+
 // Registry holds all available interpreters
 type Registry struct {
 	interpreters []Interpreter
@@ -52,6 +75,11 @@ func (r *Registry) Register(interpreter Interpreter) {
 	r.interpreters = append(r.interpreters, interpreter)
 }
 
+// Interpreters returns all registered interpreters in registration order
+func (r *Registry) Interpreters() []Interpreter {
+	return append([]Interpreter(nil), r.interpreters...)
+}
+
 // GetInterpreter returns the appropriate interpreter for the given command type
 func (r *Registry) GetInterpreter(cmdType string) (Interpreter, error) {
 	for _, interpreter := range r.interpreters {
@@ -62,6 +90,33 @@ func (r *Registry) GetInterpreter(cmdType string) (Interpreter, error) {
 	return nil, fmt.Errorf("no interpreter found for command type: %s", cmdType)
 }
 
+// CanHandle returns true if any registered interpreter can handle the command type
+func (r *Registry) CanHandle(cmdType string) bool {
+	_, err := r.GetInterpreter(cmdType)
+	return err == nil
+}
+
+// Execute runs the command with the interpreter registered for its type
+func (r *Registry) Execute(ctx context.Context, cmd Command, taskCtx *TaskContext) (*Result, error) {
+	interpreter, err := r.GetInterpreter(cmd.Type)
+	if err != nil {
+		return nil, err
+	}
+	return interpreter.Execute(ctx, cmd, taskCtx)
+}
+
+// DecodeContent decodes command content, as read from a taskfile, into the struct pointed to by out
+func DecodeContent(content interface{}, out interface{}) error {
+	data, err := yaml.Marshal(content)
+	if err != nil {
+		return fmt.Errorf("invalid command content: %w", err)
+	}
+	if err := yaml.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("invalid command content: %w", err)
+	}
+	return nil
+}
+
 // NewTaskContext creates a new task context
 func NewTaskContext() *TaskContext {
 	return &TaskContext{
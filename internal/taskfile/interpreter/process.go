@@ -0,0 +1,61 @@
+package interpreter
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+// runProcess runs a command, streaming its output to the writers of the task context and
+// passing the traceparent of the context in its environment.
+// It returns the combined standard output and error, and the standard output alone.
+func runProcess(cmd *exec.Cmd, taskCtx *TaskContext) (string, string, error) {
+	var output, stdout bytes.Buffer
+	combined := &lockedWriter{w: &output}
+	outWriters := []io.Writer{combined, &stdout}
+	errWriters := []io.Writer{combined}
+	if taskCtx != nil && taskCtx.Stdout != nil {
+		outWriters = append(outWriters, taskCtx.Stdout)
+	}
+	if taskCtx != nil && taskCtx.Stderr != nil {
+		errWriters = append(errWriters, taskCtx.Stderr)
+	}
+	if taskCtx != nil && taskCtx.Traceparent != "" {
+		if cmd.Env == nil {
+			cmd.Env = os.Environ()
+		}
+		cmd.Env = append(cmd.Env, "TRACEPARENT="+taskCtx.Traceparent)
+	}
+	cmd.Stdout = io.MultiWriter(outWriters...)
+	cmd.Stderr = io.MultiWriter(errWriters...)
+	err := cmd.Run()
+	return output.String(), stdout.String(), err
+}
+
+// exitCode returns the exit code of a process from the error of running it, or nil if it did not exit
+func exitCode(err error) *int {
+	code := 0
+	if err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() < 0 {
+			return nil
+		}
+		code = exitErr.ExitCode()
+	}
+	return &code
+}
+
+// lockedWriter serializes writes from the goroutines copying standard output and error
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
@@ -9,8 +9,8 @@ import (
 
 // PythonCommand represents a Python command to be executed
 type PythonCommand struct {
-	Script string
-	Args   []string
+	Script string   `yaml:"script" jsonschema:"required"`
+	Args   []string `yaml:"args,omitempty"`
 }
 
 // PythonInterpreter implements the Interpreter interface for Python commands
@@ -26,11 +26,21 @@ func (i *PythonInterpreter) CanHandle(cmdType string) bool {
 	return cmdType == "python"
 }
 
+// CommandTypes returns the command types handled by the Python interpreter
+func (i *PythonInterpreter) CommandTypes() []string {
+	return []string{"python"}
+}
+
+// ContentPrototype returns the content type of Python commands
+func (i *PythonInterpreter) ContentPrototype() interface{} {
+	return PythonCommand{}
+}
+
 // Execute runs the Python command and returns the result
 func (i *PythonInterpreter) Execute(ctx context.Context, cmd Command, taskCtx *TaskContext) (*Result, error) {
-	pythonCmd, ok := cmd.Content.(PythonCommand)
-	if !ok {
-		return nil, fmt.Errorf("invalid command content for python interpreter")
+	var pythonCmd PythonCommand
+	if err := DecodeContent(cmd.Content, &pythonCmd); err != nil {
+		return nil, err
 	}
 
 	// Create the Python command
@@ -47,17 +57,21 @@ func (i *PythonInterpreter) Execute(ctx context.Context, cmd Command, taskCtx *T
 	}
 
 	// Execute the command
-	output, err := shellCmd.CombinedOutput()
+	output, stdout, err := runProcess(shellCmd, taskCtx)
 	if err != nil {
 		return &Result{
-			Success: false,
-			Output:  string(output),
-			Error:   err,
+			Success:  false,
+			Output:   output,
+			Stdout:   stdout,
+			Error:    err,
+			ExitCode: exitCode(err),
 		}, nil
 	}
 
 	return &Result{
-		Success: true,
-		Output:  strings.TrimSpace(string(output)),
+		Success:  true,
+		Output:   strings.TrimSpace(output),
+		Stdout:   stdout,
+		ExitCode: exitCode(nil),
 	}, nil
 }
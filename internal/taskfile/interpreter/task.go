@@ -3,14 +3,13 @@ package interpreter
 import (
 	"context"
 	"fmt"
-
-	"github.com/kontraktor-sh/kontraktor/internal/vars"
+	"sort"
 )
 
 // TaskCommand represents a reference to another task
 type TaskCommand struct {
-	Name string
-	Args map[string]interface{}
+	Name string                 `yaml:"name" jsonschema:"required"`
+	Args map[string]interface{} `yaml:"args,omitempty"`
 }
 
 // TaskInterpreter implements the Interpreter interface for task references
@@ -30,41 +29,51 @@ func (i *TaskInterpreter) CanHandle(cmdType string) bool {
 	return cmdType == "task"
 }
 
+// CommandTypes returns the command types handled by the task interpreter
+func (i *TaskInterpreter) CommandTypes() []string {
+	return []string{"task"}
+}
+
+// ContentPrototype returns the content type of task references
+func (i *TaskInterpreter) ContentPrototype() interface{} {
+	return TaskCommand{}
+}
+
 // Execute runs the referenced task and returns the result
 func (i *TaskInterpreter) Execute(ctx context.Context, cmd Command, taskCtx *TaskContext) (*Result, error) {
-	taskCmd, ok := cmd.Content.(TaskCommand)
-	if !ok {
-		return nil, fmt.Errorf("invalid command content for task interpreter")
+	var taskCmd TaskCommand
+	if err := DecodeContent(cmd.Content, &taskCmd); err != nil {
+		return nil, err
 	}
 
 	if taskCmd.Name == "" {
 		return nil, fmt.Errorf("task name is required")
 	}
 
-	// Merge task arguments with the current context
-	mergedArgs := make(map[string]interface{})
-	for k, v := range taskCtx.Vars.Args {
-		mergedArgs[k] = v
-	}
-	for k, v := range taskCmd.Args {
-		mergedArgs[k] = v
-	}
+	// Execute the referenced task
+	newTaskCtx := NewChildContext(taskCtx, taskCmd)
+	return i.taskExecutor(ctx, taskCmd.Name, newTaskCtx.Vars.Args, newTaskCtx)
+}
 
-	// Create a new task context for the referenced task
+// NewChildContext creates the context of a task referenced from the task of taskCtx.
+// The referenced task inherits the environment, secrets and arguments of its parent;
+// arguments passed by the reference override inherited ones.
+func NewChildContext(taskCtx *TaskContext, taskCmd TaskCommand) *TaskContext {
 	newTaskCtx := &TaskContext{
-		Vars:     vars.NewContext(),
+		Vars:     taskCtx.Vars.Clone(),
 		TaskName: taskCmd.Name,
 	}
-
-	// Copy environment variables and secrets
-	for k, v := range taskCtx.Vars.Environment {
-		newTaskCtx.Vars.Environment[k] = v
-	}
-	for k, v := range taskCtx.Vars.Secrets {
-		newTaskCtx.Vars.Secrets[k] = v
+	for _, k := range sortedKeys(taskCmd.Args) {
+		newTaskCtx.Vars.SetArg(k, taskCmd.Args[k], fmt.Sprintf("argument passed by task '%s'", taskCtx.TaskName))
 	}
-	newTaskCtx.Vars.Args = mergedArgs
+	return newTaskCtx
+}
 
-	// Execute the referenced task
-	return i.taskExecutor(ctx, taskCmd.Name, mergedArgs, newTaskCtx)
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
 }
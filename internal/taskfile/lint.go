@@ -0,0 +1,904 @@
+// lint.go
+// Reports all problems in a taskfile at once, positioned using the YAML node tree.
+package taskfile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kontraktor-sh/kontraktor/internal/env"
+	"github.com/kontraktor-sh/kontraktor/internal/vars"
+	"gopkg.in/yaml.v3"
+)
+
+// SupportedVersion is the taskfile format version understood by this release
+const SupportedVersion = "0.3"
+
+// Severity represents the severity of a diagnostic
+type Severity string
+
+const (
+	// SeverityError marks problems that make the taskfile invalid
+	SeverityError Severity = "error"
+	// SeverityWarning marks suspicious but valid constructs
+	SeverityWarning Severity = "warning"
+)
+
+// Diagnostic describes a single problem found in a taskfile
+type Diagnostic struct {
+	File     string   `json:"file"`
+	Line     int      `json:"line"`
+	Column   int      `json:"column"`
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+}
+
+// String formats the diagnostic as file:line:column: severity: message
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s: %s", d.File, d.Line, d.Column, d.Severity, d.Message)
+}
+
+// LintOptions configures the checks performed by Lint
+type LintOptions struct {
+	// KnownCommandType reports whether a command type can be executed.
+	// Command types are not checked when nil.
+	KnownCommandType func(cmdType string) bool
+
+	// FetchImport makes an import available locally and returns the path of the file to parse.
+	// Imports are fetched like ParseTaskfile does when nil.
+	FetchImport func(importPath string) (string, error)
+}
+
+var (
+	topLevelKeys = map[string]bool{"version": true, "imports": true, "environment": true, "vars": true, "precedence": true, "mask": true, "vaults": true, "tasks": true}
+	taskKeys     = map[string]bool{"desc": true, "args": true, "cmds": true, "environment": true, "vars": true, "output": true, "secrets": true}
+	yamlLineRe   = regexp.MustCompile(`line (\d+)`)
+)
+
+// Lint reads the taskfile at path and returns every problem found in it
+func Lint(path string, opts LintOptions) ([]Diagnostic, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read taskfile: %w", err)
+	}
+	return LintSource(path, src, opts), nil
+}
+
+// LintSource lints taskfile source that has already been read, reporting positions against path
+func LintSource(path string, src []byte, opts LintOptions) []Diagnostic {
+	l := &linter{
+		file:      path,
+		lines:     strings.Split(string(src), "\n"),
+		opts:      opts,
+		validator: env.NewValidator(),
+		subst:     vars.NewSubstitutor(),
+	}
+	l.run(src)
+	sort.SliceStable(l.diags, func(i, j int) bool {
+		if l.diags[i].Line != l.diags[j].Line {
+			return l.diags[i].Line < l.diags[j].Line
+		}
+		return l.diags[i].Column < l.diags[j].Column
+	})
+	return l.diags
+}
+
+// HasErrors reports whether any of the diagnostics is an error
+func HasErrors(diags []Diagnostic) bool {
+	for _, d := range diags {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// linter holds the state of a single lint run
+type linter struct {
+	file      string
+	lines     []string
+	opts      LintOptions
+	validator *env.Validator
+	subst     *vars.Substitutor
+	diags     []Diagnostic
+
+	globals    map[string]bool
+	globalVars map[string]bool
+	secrets    map[string]bool
+	precedence []vars.VariableType
+	imported   map[string]string // imported task name -> import path
+	tasks      map[string]*lintTask
+	order      []string
+}
+
+// lintTask collects what the linter knows about a task defined in the linted file
+type lintTask struct {
+	name    string
+	key     *yaml.Node
+	args    map[string]*yaml.Node
+	env     map[string]bool
+	vars    map[string]bool
+	secrets map[string]bool
+	refs    []varRef
+	calls   []taskCall
+	argUsed map[string]bool
+}
+
+// varRef is a variable reference with its source position
+type varRef struct {
+	name         string
+	optional     bool // Undefined values are handled by an operator such as :-
+	line, column int
+}
+
+// taskCall is a reference from one task to another
+type taskCall struct {
+	name string
+	args map[string]bool
+	node *yaml.Node
+}
+
+func (l *linter) report(node *yaml.Node, severity Severity, format string, args ...interface{}) {
+	line, column := 1, 1
+	if node != nil {
+		line, column = node.Line, node.Column
+	}
+	l.reportAt(line, column, severity, format, args...)
+}
+
+func (l *linter) reportAt(line, column int, severity Severity, format string, args ...interface{}) {
+	l.diags = append(l.diags, Diagnostic{
+		File:     l.file,
+		Line:     line,
+		Column:   column,
+		Severity: severity,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (l *linter) run(src []byte) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(src, &doc); err != nil {
+		line := 1
+		if m := yamlLineRe.FindStringSubmatch(err.Error()); m != nil {
+			line, _ = strconv.Atoi(m[1])
+		}
+		l.reportAt(line, 1, SeverityError, "%v", err)
+		return
+	}
+	if len(doc.Content) == 0 {
+		l.reportAt(1, 1, SeverityError, "taskfile is empty")
+		return
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		l.report(root, SeverityError, "taskfile must be a mapping")
+		return
+	}
+
+	for i := 0; i < len(root.Content); i += 2 {
+		key := root.Content[i]
+		if !topLevelKeys[key.Value] {
+			l.report(key, SeverityError, "unknown top-level key '%s'", key.Value)
+		}
+	}
+
+	l.checkVersion(root)
+	l.precedence = l.checkPrecedence(mappingValue(root, "precedence"))
+	l.globals = l.checkEnvironment(mappingValue(root, "environment"), "global environment")
+	l.globalVars = l.checkVars(mappingValue(root, "vars"), "global vars")
+	l.checkMasks(mappingValue(root, "mask"))
+	l.secrets = l.checkVaults(mappingValue(root, "vaults"))
+	l.imported = l.checkImports(mappingValue(root, "imports"))
+	l.collectTasks(root)
+	l.checkTaskCalls()
+	l.checkVariables()
+}
+
+func (l *linter) checkVersion(root *yaml.Node) {
+	_, value := mappingEntry(root, "version")
+	if value == nil {
+		l.reportAt(1, 1, SeverityError, "missing required key 'version'")
+		return
+	}
+	if value.Value != SupportedVersion {
+		l.report(value, SeverityError, "unsupported version '%s' (supported: %s)", value.Value, SupportedVersion)
+	}
+}
+
+// checkPrecedence validates the precedence of bare variable names and returns it
+func (l *linter) checkPrecedence(node *yaml.Node) []vars.VariableType {
+	if node == nil {
+		return vars.DefaultPrecedence
+	}
+	if node.Kind != yaml.SequenceNode {
+		l.report(node, SeverityError, "precedence must be a list")
+		return vars.DefaultPrecedence
+	}
+	var namespaces []string
+	for _, item := range node.Content {
+		namespaces = append(namespaces, item.Value)
+		if _, err := vars.ParsePrecedence(namespaces); err != nil {
+			l.report(item, SeverityError, "%v", err)
+			namespaces = namespaces[:len(namespaces)-1]
+		}
+	}
+	precedence, _ := vars.ParsePrecedence(namespaces)
+	if len(precedence) == 0 {
+		return vars.DefaultPrecedence
+	}
+	return precedence
+}
+
+// checkEnvironment validates an environment mapping and returns the names it defines
+func (l *linter) checkEnvironment(node *yaml.Node, where string) map[string]bool {
+	names := make(map[string]bool)
+	if node == nil {
+		return names
+	}
+	if node.Kind != yaml.MappingNode {
+		l.report(node, SeverityError, "%s must be a mapping", where)
+		return names
+	}
+	for i := 0; i < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		names[key.Value] = true
+		if err := l.validator.ValidateName(key.Value); err != nil {
+			l.report(key, SeverityError, "%v", err)
+		}
+		if err := l.validator.ValidateValue(value.Value); err != nil {
+			l.report(value, SeverityError, "invalid value for '%s' in %s: value cannot contain null bytes", key.Value, where)
+		}
+	}
+	return names
+}
+
+// checkVars validates a vars mapping and returns the names it defines
+func (l *linter) checkVars(node *yaml.Node, where string) map[string]bool {
+	names := make(map[string]bool)
+	if node == nil {
+		return names
+	}
+	if node.Kind != yaml.MappingNode {
+		l.report(node, SeverityError, "%s must be a mapping", where)
+		return names
+	}
+	for i := 0; i < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		names[key.Value] = true
+		if err := ValidateVarName(key.Value); err != nil {
+			l.report(key, SeverityError, "%v", err)
+		}
+		var v Var
+		if err := value.Decode(&v); err != nil {
+			l.report(value, SeverityError, "invalid variable '%s' in %s: %v", key.Value, where, strings.TrimPrefix(yamlLineRe.ReplaceAllString(err.Error(), ""), ": "))
+		} else if err := v.Validate(); err != nil {
+			l.report(value, SeverityError, "invalid variable '%s' in %s: %v", key.Value, where, err)
+		}
+	}
+	return names
+}
+
+// checkMasks validates the entries of the mask section
+func (l *linter) checkMasks(node *yaml.Node) {
+	if node == nil {
+		return
+	}
+	if node.Kind != yaml.SequenceNode {
+		l.report(node, SeverityError, "mask must be a list")
+		return
+	}
+	for _, item := range node.Content {
+		var m Mask
+		if err := item.Decode(&m); err != nil {
+			l.report(item, SeverityError, "invalid mask: %v", strings.TrimPrefix(yamlLineRe.ReplaceAllString(err.Error(), ""), ": "))
+		} else if _, err := m.Regexp(); err != nil {
+			l.report(item, SeverityError, "invalid mask: %v", err)
+		}
+	}
+}
+
+// checkVaults validates vault sections and secret names and returns the environment names they define.
+// Every vault type maps environment variable names to secrets under the secrets key of each vault.
+// Names defined by several vaults are reported unless the vault overriding them allows it.
+func (l *linter) checkVaults(node *yaml.Node) map[string]bool {
+	names := make(map[string]bool)
+	if node == nil {
+		return names
+	}
+	if node.Kind != yaml.MappingNode {
+		l.report(node, SeverityError, "vaults must be a mapping")
+		return names
+	}
+	type lintVault struct {
+		name    string
+		options VaultOptions
+		secrets *yaml.Node
+	}
+	var vaults []lintVault
+	for i := 0; i < len(node.Content); i += 2 {
+		key, section := node.Content[i], node.Content[i+1]
+		if !IsVaultType(key.Value) {
+			l.report(key, SeverityError, "unknown vault type '%s'", key.Value)
+			continue
+		}
+		if section.Kind != yaml.MappingNode {
+			l.report(section, SeverityError, "vaults.%s must be a mapping", key.Value)
+			continue
+		}
+		for j := 1; j < len(section.Content); j += 2 {
+			var options VaultOptions
+			name := key.Value + "." + section.Content[j-1].Value
+			if priority := mappingValue(section.Content[j], "priority"); priority != nil && priority.Decode(&options.Priority) != nil {
+				l.report(priority, SeverityError, "priority of vault %s must be an integer", name)
+			}
+			if allow := mappingValue(section.Content[j], "allow_override"); allow != nil && allow.Decode(&options.AllowOverride) != nil {
+				l.report(allow, SeverityError, "allow_override of vault %s must be a boolean", name)
+			}
+			if err := validateBuiltinVault(key.Value, section.Content[j]); err != nil {
+				l.report(section.Content[j-1], SeverityError, "invalid vault %s: %v", name, err)
+			}
+			secrets := mappingValue(section.Content[j], "secrets")
+			if secrets == nil || secrets.Kind != yaml.MappingNode {
+				continue
+			}
+			vaults = append(vaults, lintVault{name: name, options: options, secrets: secrets})
+			for k := 0; k < len(secrets.Content); k += 2 {
+				envName := secrets.Content[k]
+				names[envName.Value] = true
+				if err := l.validator.ValidateName(envName.Value); err != nil {
+					l.report(envName, SeverityError, "%v", err)
+				}
+			}
+		}
+	}
+
+	// Vaults are applied by priority, then in declaration order
+	sort.SliceStable(vaults, func(i, j int) bool {
+		return vaults[i].options.Priority < vaults[j].options.Priority
+	})
+	sources := make(map[string]string)
+	for _, v := range vaults {
+		for k := 0; k < len(v.secrets.Content); k += 2 {
+			envName := v.secrets.Content[k]
+			if previous, ok := sources[envName.Value]; ok && !v.options.AllowOverride {
+				l.report(envName, SeverityError, "secret '%s' is defined by both vault %s and vault %s (set a priority and allow_override: true on the vault that should provide it)", envName.Value, previous, v.name)
+			}
+			sources[envName.Value] = v.name
+		}
+	}
+	return names
+}
+
+// validateBuiltinVault validates the configuration of a vault of a built-in type. Options that
+// fail to decode are reported by checkVaults.
+func validateBuiltinVault(vaultType string, node *yaml.Node) error {
+	var cfg interface{ Validate() error }
+	switch vaultType {
+	case "azure_keyvault":
+		cfg = &AzureKeyVaultConfig{}
+	case "hashicorp_vault":
+		cfg = &HashiCorpVaultConfig{}
+	case "aws_secrets_manager":
+		cfg = &AWSSecretsManagerConfig{}
+	case "aws_ssm":
+		cfg = &AWSSSMConfig{}
+	default:
+		return nil
+	}
+	if node.Decode(cfg) != nil {
+		return nil
+	}
+	return cfg.Validate()
+}
+
+// checkImports loads every import and reports imports that fail to load or define the same task
+func (l *linter) checkImports(node *yaml.Node) map[string]string {
+	imported := make(map[string]string)
+	if node == nil {
+		return imported
+	}
+	if node.Kind != yaml.SequenceNode {
+		l.report(node, SeverityError, "imports must be a list")
+		return imported
+	}
+	fetch := l.opts.FetchImport
+	if fetch == nil {
+		fetch = FetchImport
+	}
+	for _, entry := range node.Content {
+		importFile, err := fetch(entry.Value)
+		if err != nil {
+			l.report(entry, SeverityError, "%v", err)
+			continue
+		}
+		tf, err := ParseTaskfile(importFile)
+		if err != nil {
+			l.report(entry, SeverityError, "import %s: %v", entry.Value, err)
+			continue
+		}
+		for _, name := range sortedKeys(tf.Tasks) {
+			if previous, exists := imported[name]; exists {
+				l.report(entry, SeverityError, "task '%s' is defined by both %s and %s", name, previous, entry.Value)
+				continue
+			}
+			imported[name] = entry.Value
+		}
+	}
+	return imported
+}
+
+func (l *linter) collectTasks(root *yaml.Node) {
+	l.tasks = make(map[string]*lintTask)
+	_, tasks := mappingEntry(root, "tasks")
+	if tasks == nil {
+		l.reportAt(1, 1, SeverityError, "missing required key 'tasks'")
+		return
+	}
+	if tasks.Kind != yaml.MappingNode {
+		l.report(tasks, SeverityError, "tasks must be a mapping")
+		return
+	}
+	for i := 0; i < len(tasks.Content); i += 2 {
+		key, value := tasks.Content[i], tasks.Content[i+1]
+		if _, exists := l.tasks[key.Value]; exists {
+			l.report(key, SeverityError, "task '%s' is defined more than once", key.Value)
+			continue
+		}
+		if source, exists := l.imported[key.Value]; exists {
+			l.report(key, SeverityWarning, "task '%s' overrides the task imported from %s", key.Value, source)
+		}
+		t := &lintTask{
+			name:    key.Value,
+			key:     key,
+			args:    make(map[string]*yaml.Node),
+			argUsed: make(map[string]bool),
+		}
+		l.tasks[key.Value] = t
+		l.order = append(l.order, key.Value)
+		l.collectTask(t, value)
+	}
+}
+
+func (l *linter) collectTask(t *lintTask, node *yaml.Node) {
+	if node.Kind != yaml.MappingNode {
+		l.report(node, SeverityError, "task '%s' must be a mapping", t.name)
+		return
+	}
+	for i := 0; i < len(node.Content); i += 2 {
+		key := node.Content[i]
+		if !taskKeys[key.Value] {
+			l.report(key, SeverityError, "unknown key '%s' in task '%s'", key.Value, t.name)
+		}
+	}
+
+	envNode := mappingValue(node, "environment")
+	t.env = l.checkEnvironment(envNode, fmt.Sprintf("environment of task '%s'", t.name))
+	if envNode != nil {
+		l.collectRefs(t, envNode)
+	}
+	if output := mappingValue(node, "output"); output != nil {
+		if err := ValidateOutputMode(output.Value); err != nil {
+			l.report(output, SeverityError, "%v in task '%s'", err, t.name)
+		}
+	}
+	t.secrets = l.checkTaskSecrets(t, mappingValue(node, "secrets"))
+	varsNode := mappingValue(node, "vars")
+	t.vars = l.checkVars(varsNode, fmt.Sprintf("vars of task '%s'", t.name))
+	if varsNode != nil {
+		l.collectRefs(t, varsNode)
+	}
+
+	if args := mappingValue(node, "args"); args != nil {
+		for _, argNode := range args.Content {
+			var arg TaskArg
+			if err := argNode.Decode(&arg); err != nil || arg.Name == "" {
+				l.report(argNode, SeverityError, "invalid argument in task '%s': name is required", t.name)
+				continue
+			}
+			if _, exists := t.args[arg.Name]; exists {
+				l.report(argNode, SeverityError, "argument '%s' is declared more than once in task '%s'", arg.Name, t.name)
+				continue
+			}
+			nameNode := mappingValue(argNode, "name")
+			t.args[arg.Name] = nameNode
+		}
+	}
+
+	cmds := mappingValue(node, "cmds")
+	if cmds == nil {
+		return
+	}
+	if cmds.Kind != yaml.SequenceNode {
+		l.report(cmds, SeverityError, "cmds of task '%s' must be a list", t.name)
+		return
+	}
+	for _, cmdNode := range cmds.Content {
+		l.collectCmd(t, cmdNode)
+	}
+}
+
+// checkTaskSecrets validates the secrets declared by a task and returns their names
+func (l *linter) checkTaskSecrets(t *lintTask, node *yaml.Node) map[string]bool {
+	secrets := make(map[string]bool)
+	if node == nil {
+		return secrets
+	}
+	if node.Kind != yaml.SequenceNode {
+		l.report(node, SeverityError, "secrets of task '%s' must be a list", t.name)
+		return secrets
+	}
+	for _, item := range node.Content {
+		if item.Kind != yaml.ScalarNode {
+			l.report(item, SeverityError, "invalid secret in task '%s': must be a name", t.name)
+			continue
+		}
+		if secrets[item.Value] {
+			l.report(item, SeverityError, "secret '%s' is declared more than once in task '%s'", item.Value, t.name)
+			continue
+		}
+		if !l.secrets[item.Value] {
+			l.report(item, SeverityError, "secret '%s' of task '%s' is not defined by any vault", item.Value, t.name)
+		}
+		secrets[item.Value] = true
+	}
+	return secrets
+}
+
+func (l *linter) collectCmd(t *lintTask, node *yaml.Node) {
+	var cmd TaskCmd
+	if err := node.Decode(&cmd); err != nil {
+		l.report(node, SeverityError, "invalid command in task '%s': %v", t.name, err)
+		return
+	}
+
+	if l.opts.KnownCommandType != nil && !l.opts.KnownCommandType(cmd.Type) {
+		typeNode := mappingValue(node, "type")
+		if typeNode == nil {
+			typeNode = node
+		}
+		l.report(typeNode, SeverityError, "unknown command type '%s' in task '%s'", cmd.Type, t.name)
+	}
+
+	if cmd.Type == "task" {
+		call := taskCall{args: make(map[string]bool)}
+		call.name, _ = cmd.Content["name"].(string)
+		call.node = mappingValue(mappingValue(node, "content"), "name")
+		if call.node == nil {
+			call.node = mappingValue(node, "task")
+		}
+		if call.node == nil {
+			call.node = node
+		}
+		if args, ok := cmd.Content["args"].(map[string]interface{}); ok {
+			for name := range args {
+				call.args[name] = true
+			}
+		}
+		if call.name == "" {
+			l.report(call.node, SeverityError, "task reference in task '%s' has no name", t.name)
+		} else {
+			t.calls = append(t.calls, call)
+		}
+	}
+
+	l.collectRefs(t, node)
+}
+
+// collectRefs records every variable referenced by scalar values below node
+func (l *linter) collectRefs(t *lintTask, node *yaml.Node) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		var verr *vars.Error
+		if err := l.subst.Check(node.Value); errors.As(err, &verr) {
+			line, column := locate(l.lines, node, verr.Offset, verr.Length)
+			l.reportAt(line, column, SeverityError, "%s in task '%s'", verr.Message, t.name)
+			return
+		}
+		for _, ref := range l.subst.FindReferences(node.Value) {
+			line, column := locate(l.lines, node, ref.Offset, ref.Length)
+			t.refs = append(t.refs, varRef{name: ref.Name, optional: ref.Optional, line: line, column: column})
+		}
+	case yaml.MappingNode:
+		for i := 1; i < len(node.Content); i += 2 {
+			l.collectRefs(t, node.Content[i])
+		}
+	case yaml.SequenceNode:
+		for _, child := range node.Content {
+			l.collectRefs(t, child)
+		}
+	}
+}
+
+// ReferencePosition locates the text at offset and length in the value of the scalar at
+// line and column of the taskfile at path, for reporting substitution errors.
+// Falls back to the position of the scalar when the text cannot be found.
+func ReferencePosition(path string, line, column int, value string, offset, length int) (int, int) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return line, column
+	}
+	node := &yaml.Node{Kind: yaml.ScalarNode, Line: line, Column: column, Value: value}
+	return locate(strings.Split(string(src), "\n"), node, offset, length)
+}
+
+// locate finds text of a scalar, given by offset and length in its value, in the source lines.
+// Falls back to the position of the scalar when the text cannot be found verbatim.
+func locate(lines []string, node *yaml.Node, offset, length int) (int, int) {
+	if offset+length > len(node.Value) {
+		return node.Line, node.Column
+	}
+	text := node.Value[offset : offset+length]
+	nth := strings.Count(node.Value[:offset], text)
+	last := node.Line + strings.Count(node.Value, "\n") + 1
+	for i := node.Line - 1; i < len(lines) && i < last; i++ {
+		line := lines[i]
+		start := 0
+		if i == node.Line-1 && node.Column-1 <= len(line) {
+			start = node.Column - 1
+		}
+		for {
+			idx := strings.Index(line[start:], text)
+			if idx < 0 {
+				break
+			}
+			if nth == 0 {
+				return i + 1, start + idx + 1
+			}
+			nth--
+			start += idx + len(text)
+		}
+	}
+	return node.Line, node.Column
+}
+
+// checkTaskCalls reports references to undefined tasks and circular references
+func (l *linter) checkTaskCalls() {
+	for _, name := range l.order {
+		for _, call := range l.tasks[name].calls {
+			if _, ok := l.tasks[call.name]; ok {
+				continue
+			}
+			if _, ok := l.imported[call.name]; ok {
+				continue
+			}
+			l.report(call.node, SeverityError, "task '%s' references undefined task '%s'", name, call.name)
+		}
+	}
+
+	state := make(map[string]int) // 0 unvisited, 1 in progress, 2 done
+	var visit func(name string, path []string)
+	visit = func(name string, path []string) {
+		state[name] = 1
+		for _, call := range l.tasks[name].calls {
+			if _, ok := l.tasks[call.name]; !ok {
+				continue
+			}
+			switch state[call.name] {
+			case 1:
+				cycle := append(append([]string{}, path...), call.name)
+				l.report(call.node, SeverityError, "circular task reference: %s", strings.Join(cycle, " -> "))
+			case 0:
+				visit(call.name, append(path, call.name))
+			}
+		}
+		state[name] = 2
+	}
+	for _, name := range l.order {
+		if state[name] == 0 {
+			visit(name, []string{name})
+		}
+	}
+}
+
+// checkVariables reports undefined variable references and unused arguments.
+// Arguments and environment are inherited by referenced tasks, so a task may use those of its callers.
+func (l *linter) checkVariables() {
+	callers := make(map[string][]string)
+	for _, name := range l.order {
+		for _, call := range l.tasks[name].calls {
+			callers[call.name] = append(callers[call.name], name)
+		}
+	}
+
+	for _, name := range l.order {
+		t := l.tasks[name]
+		scope := &varScope{
+			linter:        l,
+			task:          t,
+			inherited:     l.inheritedArgs(name, callers, make(map[string]bool)),
+			inheritedEnv:  l.inheritedNames(name, callers, make(map[string]bool), func(t *lintTask) map[string]bool { return t.env }),
+			inheritedVars: l.inheritedNames(name, callers, make(map[string]bool), func(t *lintTask) map[string]bool { return t.vars }),
+		}
+		for _, ref := range t.refs {
+			ns, key := vars.SplitName(ref.name)
+			switch {
+			case ns == vars.NamespaceHost:
+				continue
+			case ns == vars.NamespaceTask || ns == vars.NamespaceRun:
+				if vars.IsBuiltin(ns, key) {
+					continue
+				}
+			case ns != "":
+				if scope.defines(vars.NamespaceType(ns), key) {
+					continue
+				}
+			default:
+				if scope.resolves(key) {
+					continue
+				}
+			}
+			if scope.undeclared(ns, key) {
+				l.reportAt(ref.line, ref.column, SeverityError, "secret '%s' is not declared in the secrets of task '%s'", key, name)
+				continue
+			}
+			if !ref.optional {
+				l.reportAt(ref.line, ref.column, SeverityError, "undefined variable '%s' in task '%s'", ref.name, name)
+			}
+		}
+	}
+
+	for _, name := range l.order {
+		t := l.tasks[name]
+		for _, arg := range sortedKeys(t.args) {
+			if !t.argUsed[arg] && !l.passesArg(t, arg) {
+				l.report(t.args[arg], SeverityWarning, "argument '%s' of task '%s' is never used", arg, name)
+			}
+		}
+	}
+}
+
+// varScope resolves the variable references of a task
+type varScope struct {
+	linter        *linter
+	task          *lintTask
+	inherited     map[string][]string
+	inheritedEnv  map[string]bool
+	inheritedVars map[string]bool
+}
+
+// resolves reports whether a bare name is defined by any source in the precedence
+func (s *varScope) resolves(name string) bool {
+	for _, t := range s.linter.precedence {
+		if s.defines(t, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// undeclared reports whether an undefined reference names a vault secret the task did not
+// declare, which is an error even when the reference is optional
+func (s *varScope) undeclared(ns, name string) bool {
+	if !s.linter.secrets[name] || s.task.secrets[name] {
+		return false
+	}
+	if ns == vars.NamespaceSecrets {
+		return true
+	}
+	if ns != "" {
+		return false
+	}
+	for _, t := range s.linter.precedence {
+		if t == vars.TypeSecret {
+			return true
+		}
+	}
+	return false
+}
+
+// defines reports whether a source of type t defines name, marking arguments as used
+func (s *varScope) defines(t vars.VariableType, name string) bool {
+	switch t {
+	case vars.TypeEnv:
+		return s.task.env[name] || s.linter.globals[name] || s.inheritedEnv[name]
+	case vars.TypeVar:
+		return s.task.vars[name] || s.linter.globalVars[name] || s.inheritedVars[name]
+	case vars.TypeSecret:
+		return s.task.secrets[name]
+	case vars.TypeArg:
+		if _, ok := s.task.args[name]; ok {
+			s.task.argUsed[name] = true
+			return true
+		}
+		if owners, ok := s.inherited[name]; ok {
+			for _, owner := range owners {
+				s.linter.tasks[owner].argUsed[name] = true
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// inheritedArgs returns the arguments a task may receive from its callers, mapped to the tasks declaring them
+func (l *linter) inheritedArgs(name string, callers map[string][]string, seen map[string]bool) map[string][]string {
+	result := make(map[string][]string)
+	if seen[name] {
+		return result
+	}
+	seen[name] = true
+	for _, caller := range callers[name] {
+		c := l.tasks[caller]
+		for arg := range c.args {
+			result[arg] = append(result[arg], caller)
+		}
+		for _, call := range c.calls {
+			if call.name != name {
+				continue
+			}
+			for arg := range call.args {
+				if _, ok := result[arg]; !ok {
+					result[arg] = nil
+				}
+			}
+		}
+		for arg, owners := range l.inheritedArgs(caller, callers, seen) {
+			result[arg] = append(result[arg], owners...)
+		}
+	}
+	return result
+}
+
+// inheritedNames returns the environment variables or vars, as selected by names, that a
+// task inherits from its callers
+func (l *linter) inheritedNames(name string, callers map[string][]string, seen map[string]bool, names func(*lintTask) map[string]bool) map[string]bool {
+	result := make(map[string]bool)
+	if seen[name] {
+		return result
+	}
+	seen[name] = true
+	for _, caller := range callers[name] {
+		for n := range names(l.tasks[caller]) {
+			result[n] = true
+		}
+		for n := range l.inheritedNames(caller, callers, seen, names) {
+			result[n] = true
+		}
+	}
+	return result
+}
+
+// passesArg reports whether a task explicitly forwards an argument to one of the tasks it calls
+func (l *linter) passesArg(t *lintTask, arg string) bool {
+	for _, call := range t.calls {
+		if call.args[arg] {
+			return true
+		}
+	}
+	return false
+}
+
+// mappingEntry returns the key and value nodes for key in a mapping node
+func mappingEntry(node *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i], node.Content[i+1]
+		}
+	}
+	return nil, nil
+}
+
+// mappingValue returns the value node for key in a mapping node
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	_, value := mappingEntry(node, key)
+	return value
+}
+
+// sortedKeys returns the keys of a map in sorted order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,435 @@
+package taskfile
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLintSource(t *testing.T) {
+	knownTypes := func(cmdType string) bool {
+		return cmdType == "bash" || cmdType == "task"
+	}
+
+	tests := []struct {
+		name string
+		src  string
+		want []Diagnostic
+	}{
+		{
+			name: "valid taskfile",
+			src: `version: "0.3"
+environment:
+  GREETING: hello
+tasks:
+  hello:
+    desc: Say hello
+    args:
+      - name: name
+    cmds:
+      - echo "${GREETING}, ${name} ${$ESCAPED}"
+`,
+			want: nil,
+		},
+		{
+			name: "unknown top-level key and unsupported version",
+			src: `version: "0.4"
+task:
+  hello: {}
+tasks: {}
+`,
+			want: []Diagnostic{
+				{Line: 1, Column: 10, Severity: SeverityError, Message: "unsupported version '0.4' (supported: 0.3)"},
+				{Line: 2, Column: 1, Severity: SeverityError, Message: "unknown top-level key 'task'"},
+			},
+		},
+		{
+			name: "undefined variable in block scalar",
+			src: `version: "0.3"
+tasks:
+  build:
+    desc: Build
+    cmds:
+      - |
+        echo start
+        echo ${MISSING}
+`,
+			want: []Diagnostic{
+				{Line: 8, Column: 14, Severity: SeverityError, Message: "undefined variable 'MISSING' in task 'build'"},
+			},
+		},
+		{
+			name: "unknown command type and undefined task reference",
+			src: `version: "0.3"
+tasks:
+  deploy:
+    desc: Deploy
+    cmds:
+      - type: helm
+        content:
+          chart: app
+      - task: build
+`,
+			want: []Diagnostic{
+				{Line: 6, Column: 15, Severity: SeverityError, Message: "unknown command type 'helm' in task 'deploy'"},
+				{Line: 9, Column: 15, Severity: SeverityError, Message: "task 'deploy' references undefined task 'build'"},
+			},
+		},
+		{
+			name: "unused argument and inherited argument",
+			src: `version: "0.3"
+tasks:
+  release:
+    desc: Release
+    args:
+      - name: version
+      - name: unused
+    cmds:
+      - task: build
+  build:
+    desc: Build
+    cmds:
+      - echo ${version}
+`,
+			want: []Diagnostic{
+				{Line: 7, Column: 15, Severity: SeverityWarning, Message: "argument 'unused' of task 'release' is never used"},
+			},
+		},
+		{
+			name: "inherited environment",
+			src: `version: "0.3"
+tasks:
+  build:
+    desc: Build
+    environment:
+      OUT: dist
+    cmds:
+      - task: package
+  package:
+    desc: Package
+    cmds:
+      - tar czf app.tgz ${OUT}
+`,
+		},
+		{
+			name: "namespaced references and precedence",
+			src: `version: "0.3"
+precedence: [args, env, host]
+environment:
+  OUT: dist
+tasks:
+  build:
+    desc: Build
+    args:
+      - name: token
+    cmds:
+      - echo ${args.token} ${env.OUT} ${host.HOME} ${task.name} ${run.id}
+      - echo ${env.token} ${task.owner} ${secrets.OUT} ${OUT}
+`,
+			want: []Diagnostic{
+				{Line: 2, Column: 25, Severity: SeverityError, Message: "invalid precedence entry 'host' (expected env, vars, secrets or args)"},
+				{Line: 12, Column: 14, Severity: SeverityError, Message: "undefined variable 'env.token' in task 'build'"},
+				{Line: 12, Column: 27, Severity: SeverityError, Message: "undefined variable 'task.owner' in task 'build'"},
+				{Line: 12, Column: 41, Severity: SeverityError, Message: "undefined variable 'secrets.OUT' in task 'build'"},
+			},
+		},
+		{
+			name: "secrets defined by several vaults",
+			src: `version: "0.3"
+vaults:
+  azure_keyvault:
+    shared:
+      keyvault_name: shared
+      secrets:
+        API_KEY: api-key
+        DB_PASSWORD: db-password
+        1TOKEN: token
+    team:
+      keyvault_name: team
+      priority: 10
+      allow_override: true
+      secrets:
+        API_KEY: team-api-key
+    other:
+      keyvault_name: other
+      priority: high
+      secrets:
+        DB_PASSWORD: other-db-password
+tasks:
+  build:
+    desc: Build
+    secrets: [API_KEY, DB_PASSWORD]
+    cmds:
+      - echo ${API_KEY} ${DB_PASSWORD}
+`,
+			want: []Diagnostic{
+				{Line: 9, Column: 9, Severity: SeverityError, Message: "invalid environment variable '1TOKEN': name must start with a letter or underscore and contain only letters, numbers, and underscores"},
+				{Line: 18, Column: 17, Severity: SeverityError, Message: "priority of vault azure_keyvault.other must be an integer"},
+				{Line: 20, Column: 9, Severity: SeverityError, Message: "secret 'DB_PASSWORD' is defined by both vault azure_keyvault.shared and vault azure_keyvault.other (set a priority and allow_override: true on the vault that should provide it)"},
+			},
+		},
+		{
+			name: "invalid azure key vaults",
+			src: `version: "0.3"
+vaults:
+  azure_keyvault:
+    local:
+      vault_url: http://localhost:8080
+      secrets:
+        API_KEY: api-key@0123abcd
+    deploy:
+      keyvault_name: deploy
+      credential:
+        type: azure_cli
+        client_id: client
+      secrets:
+        TOKEN: token
+tasks: {}
+`,
+			want: []Diagnostic{
+				{Line: 4, Column: 5, Severity: SeverityError, Message: "invalid vault azure_keyvault.local: vault_url must be an https:// URL"},
+				{Line: 8, Column: 5, Severity: SeverityError, Message: "invalid vault azure_keyvault.deploy: invalid credential: client_id is not supported by credentials of type azure_cli"},
+			},
+		},
+		{
+			name: "invalid hashicorp vaults",
+			src: `version: "0.3"
+vaults:
+  hashicorp_vault:
+    platform:
+      address: vault.example.com
+      secrets:
+        DB_PASSWORD: app/db#password
+    ci:
+      auth:
+        method: approle
+      secrets:
+        API_KEY: app/api#key
+tasks: {}
+`,
+			want: []Diagnostic{
+				{Line: 4, Column: 5, Severity: SeverityError, Message: "invalid vault hashicorp_vault.platform: address must be an http:// or https:// URL"},
+				{Line: 8, Column: 5, Severity: SeverityError, Message: "invalid vault hashicorp_vault.ci: invalid auth: approle method requires role_id"},
+			},
+		},
+		{
+			name: "invalid aws vaults",
+			src: `version: "0.3"
+vaults:
+  aws_secrets_manager:
+    prod:
+      external_id: ext-123
+      secrets:
+        DB_PASSWORD: prod/db:password
+  aws_ssm:
+    local:
+      endpoint: localhost:4566
+      secrets:
+        DB_HOST: /prod/db/host
+tasks: {}
+`,
+			want: []Diagnostic{
+				{Line: 4, Column: 5, Severity: SeverityError, Message: "invalid vault aws_secrets_manager.prod: external_id and role_session_name require role_arn"},
+				{Line: 9, Column: 5, Severity: SeverityError, Message: "invalid vault aws_ssm.local: endpoint must be an http:// or https:// URL"},
+			},
+		},
+		{
+			name: "secrets of tasks",
+			src: `version: "0.3"
+vaults:
+  azure_keyvault:
+    main:
+      keyvault_name: main
+      secrets:
+        API_KEY: api-key
+        TOKEN: token
+tasks:
+  deploy:
+    desc: Deploy
+    secrets: [API_KEY, MISSING, API_KEY]
+    cmds:
+      - echo ${API_KEY} ${secrets.API_KEY}
+  lint:
+    desc: Lint
+    cmds:
+      - echo ${TOKEN:-none} ${secrets.API_KEY}
+  broken:
+    desc: Broken
+    secrets: API_KEY
+    cmds:
+      - echo
+`,
+			want: []Diagnostic{
+				{Line: 12, Column: 24, Severity: SeverityError, Message: "secret 'MISSING' of task 'deploy' is not defined by any vault"},
+				{Line: 12, Column: 33, Severity: SeverityError, Message: "secret 'API_KEY' is declared more than once in task 'deploy'"},
+				{Line: 18, Column: 14, Severity: SeverityError, Message: "secret 'TOKEN' is not declared in the secrets of task 'lint'"},
+				{Line: 18, Column: 29, Severity: SeverityError, Message: "secret 'API_KEY' is not declared in the secrets of task 'lint'"},
+				{Line: 21, Column: 14, Severity: SeverityError, Message: "secrets of task 'broken' must be a list"},
+			},
+		},
+		{
+			name: "substitution syntax errors",
+			src: `version: "0.3"
+tasks:
+  build:
+    desc: Build
+    cmds:
+      - echo $${escaped} ${OUT
+`,
+			want: []Diagnostic{
+				{Line: 6, Column: 26, Severity: SeverityError, Message: "unterminated variable reference in task 'build'"},
+			},
+		},
+		{
+			name: "defaults and filters",
+			src: `version: "0.3"
+tasks:
+  build:
+    desc: Build
+    cmds:
+      - echo ${REGION:-eu} ${DEBUG:+-v} ${ZONE | upper}
+      - echo ${NAME | title}
+`,
+			want: []Diagnostic{
+				{Line: 6, Column: 41, Severity: SeverityError, Message: "undefined variable 'ZONE' in task 'build'"},
+				{Line: 7, Column: 23, Severity: SeverityError, Message: "unknown filter 'title' in task 'build'"},
+			},
+		},
+		{
+			name: "vars",
+			src: `version: "0.3"
+vars:
+  GIT_SHA:
+    sh: git rev-parse HEAD
+  bad.name: x
+tasks:
+  build:
+    desc: Build
+    vars:
+      VERSION:
+        file: package.json
+        json_path: .version
+      BROKEN:
+        json_path: .version
+      TAG: ${VERSION}-${GIT_SHA}-${MISSING}
+    cmds:
+      - echo ${TAG} ${vars.GIT_SHA} ${vars.OUT}
+      - task: package
+  package:
+    desc: Package
+    cmds:
+      - tar czf app-${VERSION}.tgz dist
+`,
+			want: []Diagnostic{
+				{Line: 5, Column: 3, Severity: SeverityError, Message: "invalid variable name 'bad.name': must start with a letter or underscore and contain only letters, digits, underscores and dashes"},
+				{Line: 14, Column: 9, Severity: SeverityError, Message: "invalid variable 'BROKEN' in vars of task 'build': json_path requires file"},
+				{Line: 15, Column: 34, Severity: SeverityError, Message: "undefined variable 'MISSING' in task 'build'"},
+				{Line: 17, Column: 37, Severity: SeverityError, Message: "undefined variable 'vars.OUT' in task 'build'"},
+			},
+		},
+		{
+			name: "mask",
+			src: `version: "0.3"
+mask:
+  - 'token=(\S+)'
+  - literal: acme.internal
+  - pattern: '[unclosed'
+  - pattern: 'a*'
+  - literal: x
+    pattern: y
+  - regex: z
+tasks: {}
+`,
+			want: []Diagnostic{
+				{Line: 5, Column: 5, Severity: SeverityError, Message: "invalid mask: invalid pattern '[unclosed': error parsing regexp: missing closing ]: `[unclosed`"},
+				{Line: 6, Column: 5, Severity: SeverityError, Message: "invalid mask: pattern 'a*' matches empty text"},
+				{Line: 7, Column: 5, Severity: SeverityError, Message: "invalid mask: pattern and literal cannot be used together"},
+				{Line: 9, Column: 5, Severity: SeverityError, Message: "invalid mask: unknown key 'regex' in mask"},
+			},
+		},
+		{
+			name: "output modes",
+			src: `version: "0.3"
+tasks:
+  build:
+    desc: Build
+    output: grouped
+    cmds:
+      - make
+  test:
+    desc: Test
+    output: quiet
+    cmds:
+      - make test
+`,
+			want: []Diagnostic{
+				{Line: 10, Column: 13, Severity: SeverityError, Message: "unknown output mode 'quiet' (expected interleaved, grouped or quiet-success) in task 'test'"},
+			},
+		},
+		{
+			name: "reserved environment names",
+			src: `version: "0.3"
+environment:
+  PATH: /bin
+tasks:
+  build:
+    desc: Build
+    environment:
+      HOME: /tmp
+    cmds:
+      - echo ok
+`,
+			want: []Diagnostic{
+				{Line: 3, Column: 3, Severity: SeverityError, Message: "invalid environment variable 'PATH': name is reserved and cannot be overridden"},
+				{Line: 8, Column: 7, Severity: SeverityError, Message: "invalid environment variable 'HOME': name is reserved and cannot be overridden"},
+			},
+		},
+		{
+			name: "circular task reference",
+			src: `version: "0.3"
+tasks:
+  a:
+    desc: A
+    cmds:
+      - task: b
+  b:
+    desc: B
+    cmds:
+      - task: a
+`,
+			want: []Diagnostic{
+				{Line: 10, Column: 15, Severity: SeverityError, Message: "circular task reference: a -> b -> a"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := LintSource("taskfile.ktr.yml", []byte(tt.src), LintOptions{KnownCommandType: knownTypes})
+			for i := range tt.want {
+				tt.want[i].File = "taskfile.ktr.yml"
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestLint_DuplicateImportedTasks(t *testing.T) {
+	src := `version: "0.3"
+imports:
+  - ../../testdata/imports/local.yml
+  - ../../testdata/imports/local.yml
+tasks:
+  imported:
+    desc: Override
+    cmds:
+      - echo ${IMPORTED_VAR_IS_NOT_GLOBAL}
+`
+	got := LintSource("taskfile.ktr.yml", []byte(src), LintOptions{})
+	assert.Equal(t, []Diagnostic{
+		{File: "taskfile.ktr.yml", Line: 4, Column: 5, Severity: SeverityError, Message: "task 'imported' is defined by both ../../testdata/imports/local.yml and ../../testdata/imports/local.yml"},
+		{File: "taskfile.ktr.yml", Line: 6, Column: 3, Severity: SeverityWarning, Message: "task 'imported' overrides the task imported from ../../testdata/imports/local.yml"},
+		{File: "taskfile.ktr.yml", Line: 9, Column: 14, Severity: SeverityError, Message: "undefined variable 'IMPORTED_VAR_IS_NOT_GLOBAL' in task 'imported'"},
+	}, got)
+	assert.True(t, HasErrors(got))
+}
@@ -0,0 +1,68 @@
+// mask.go
+// Defines Mask for the mask section of Kontraktor taskfiles.
+package taskfile
+
+import (
+	"fmt"
+	"regexp"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Mask is an entry of the mask section: sensitive data replaced with [MASKED] in all output.
+// It is written as a regular expression, or as a mapping with pattern: a regular expression,
+// or literal: text matched exactly. When a pattern has capture groups, only the groups are masked.
+type Mask struct {
+	Pattern string `yaml:"pattern,omitempty" jsonschema_description:"Regular expression; when it has capture groups, only the groups are masked"`
+	Literal string `yaml:"literal,omitempty" jsonschema_description:"Text masked wherever it appears"`
+}
+
+// UnmarshalYAML implements custom YAML unmarshalling for Mask
+func (m *Mask) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		m.Pattern = value.Value
+		return nil
+	}
+	if value.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: mask must be a pattern or a mapping with pattern or literal", value.Line)
+	}
+	for i := 0; i+1 < len(value.Content); i += 2 {
+		switch key := value.Content[i].Value; key {
+		case "pattern", "literal":
+		default:
+			return fmt.Errorf("line %d: unknown key '%s' in mask", value.Content[i].Line, key)
+		}
+	}
+	type plain Mask
+	return value.Decode((*plain)(m))
+}
+
+// Regexp returns the regular expression matching the masked data
+func (m Mask) Regexp() (*regexp.Regexp, error) {
+	switch {
+	case m.Pattern != "" && m.Literal != "":
+		return nil, fmt.Errorf("pattern and literal cannot be used together")
+	case m.Literal != "":
+		return regexp.MustCompile(regexp.QuoteMeta(m.Literal)), nil
+	case m.Pattern == "":
+		return nil, fmt.Errorf("pattern or literal is required")
+	}
+	re, err := regexp.Compile(m.Pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern '%s': %w", m.Pattern, err)
+	}
+	if re.MatchString("") {
+		return nil, fmt.Errorf("pattern '%s' matches empty text", m.Pattern)
+	}
+	return re, nil
+}
+
+// validateMasks reports mask entries that cannot be compiled
+func validateMasks(masks []Mask) error {
+	for i, m := range masks {
+		if _, err := m.Regexp(); err != nil {
+			return fmt.Errorf("mask %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
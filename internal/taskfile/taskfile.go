@@ -6,6 +6,7 @@ import (
 
 	"github.com/kontraktor-sh/kontraktor/internal/env"
 	"github.com/kontraktor-sh/kontraktor/internal/vars"
+	"gopkg.in/yaml.v3"
 )
 
 // Task represents a single task in the taskfile
@@ -13,51 +14,139 @@ import (
 // args: list of task arguments
 // cmds: list of shell commands
 type Task struct {
-	Desc        string            `yaml:"desc"`
-	Args        []TaskArg         `yaml:"args,omitempty"`
-	Cmds        []TaskCmd         `yaml:"cmds"`
-	Environment map[string]string `yaml:"environment,omitempty"`
+	Desc        string            `yaml:"desc" jsonschema_description:"Description of the task"`
+	Args        []TaskArg         `yaml:"args,omitempty" jsonschema_description:"Arguments accepted by the task"`
+	Cmds        []TaskCmd         `yaml:"cmds" jsonschema:"required" jsonschema_description:"Commands run by the task, in order"`
+	Environment map[string]string `yaml:"environment,omitempty" jsonschema_description:"Environment variables of the task, overriding global ones"`
+	Vars        map[string]Var    `yaml:"vars,omitempty" jsonschema_description:"Variables of the task, overriding global ones; computed when first used"`
+	Output      string            `yaml:"output,omitempty" jsonschema:"enum=interleaved|grouped|quiet-success" jsonschema_description:"How the output of the task, and of tasks it runs, is printed: interleaved, grouped or quiet-success"`
+	Secrets     []string          `yaml:"secrets,omitempty" jsonschema_description:"Vault secrets available to the commands of the task; only these are fetched, when the task first runs"`
+	File        string            `yaml:"-"` // Path of the taskfile defining the task
+	Dir         string            `yaml:"-"` // Directory of the taskfile defining the task
 }
 
 // Vaults represents supported secret vaults configuration
-// Currently only Azure Key Vault is supported
+// Azure Key Vault, HashiCorp Vault, AWS Secrets Manager and SSM Parameter Store
+// are built in; sections of vault types registered with RegisterVaultType are
+// kept in Custom
 type Vaults struct {
-	AzureKeyVault map[string]AzureKeyVaultConfig `yaml:"azure_keyvault,omitempty"`
+	AzureKeyVault     map[string]AzureKeyVaultConfig     `yaml:"azure_keyvault,omitempty" jsonschema_description:"Azure Key Vaults by name"`
+	HashiCorpVault    map[string]HashiCorpVaultConfig    `yaml:"hashicorp_vault,omitempty" jsonschema_description:"HashiCorp Vault KV secrets engines by name"`
+	AWSSecretsManager map[string]AWSSecretsManagerConfig `yaml:"aws_secrets_manager,omitempty" jsonschema_description:"AWS Secrets Manager accounts and regions by name"`
+	AWSSSM            map[string]AWSSSMConfig            `yaml:"aws_ssm,omitempty" jsonschema_description:"AWS SSM Parameter Store accounts and regions by name"`
+	Custom            map[string]yaml.Node               `yaml:",inline"`
+	Order             []string                           `yaml:"-"` // Vaults as type.name, in declaration order
+	Options           map[string]VaultOptions            `yaml:"-"` // Options of every vault, by type.name
+}
+
+// VaultOptions are the options of every vault, whatever its type. Configuration types of
+// registered vault types should embed them inline.
+type VaultOptions struct {
+	Priority      int  `yaml:"priority,omitempty" jsonschema_description:"Vaults with a higher priority override secrets of the same name in vaults with a lower one; vaults of equal priority are applied in declaration order"`
+	AllowOverride bool `yaml:"allow_override,omitempty" jsonschema_description:"Override secrets of the same name in other vaults with a warning, instead of failing"`
 }
 
 // AzureKeyVaultConfig holds the config for a single Azure Key Vault
 // keyvault_name: the name of the Azure Key Vault
-// secrets: map of environment variable name to secret name in Key Vault
+// secrets: map of environment variable name to secret name in Key Vault, optionally name@version
 type AzureKeyVaultConfig struct {
-	KeyVaultName string            `yaml:"keyvault_name"`
-	Secrets      map[string]string `yaml:"secrets"`
+	KeyVaultName              string            `yaml:"keyvault_name,omitempty" jsonschema_description:"Name of the Azure Key Vault; required unless vault_url is set"`
+	VaultURL                  string            `yaml:"vault_url,omitempty" jsonschema_description:"URL of the Key Vault, for private endpoints or local stand-ins; defaults to the URL of keyvault_name in its cloud"`
+	Cloud                     string            `yaml:"cloud,omitempty" jsonschema:"enum=public|china|us_government" jsonschema_description:"Azure cloud of the Key Vault and of its credential; public by default"`
+	Secrets                   map[string]string `yaml:"secrets" jsonschema_description:"Environment variable name to secret name in the Key Vault; name@version pins a version of the secret"`
+	Credential                *AzureCredential  `yaml:"credential,omitempty" jsonschema_description:"Credential authenticating to the Key Vault; DefaultAzureCredential by default"`
+	Retry                     *AzureRetry       `yaml:"retry,omitempty" jsonschema_description:"Retries of failed requests to the Key Vault"`
+	SkipChallengeVerification bool              `yaml:"skip_challenge_verification,omitempty" jsonschema_description:"Accept authentication challenges for another domain than vault_url, as sent by local stand-ins"`
+	VaultOptions              `yaml:",inline"`
 }
 
 // Taskfile represents the root of a taskfile.ktr.yml
 // version: 0.3
 // tasks: map of task name to Task
 type Taskfile struct {
-	Version     string            `yaml:"version"`
-	Imports     []string          `yaml:"imports,omitempty"`
-	Environment map[string]string `yaml:"environment,omitempty"`
-	Vaults      *Vaults           `yaml:"vaults,omitempty"`
-	Tasks       map[string]Task   `yaml:"tasks"`
+	Version     string            `yaml:"version" jsonschema:"required" jsonschema_description:"Version of the taskfile format"`
+	Imports     []string          `yaml:"imports,omitempty" jsonschema_description:"Taskfiles to import tasks from: local paths, HTTP(S) URLs or git repositories (repo.git//path)"`
+	Environment map[string]string `yaml:"environment,omitempty" jsonschema_description:"Global environment variables"`
+	Vars        map[string]Var    `yaml:"vars,omitempty" jsonschema_description:"Global variables for substitution, computed when first used"`
+	Precedence  []string          `yaml:"precedence,omitempty" jsonschema:"enum=env|vars|secrets|args" jsonschema_description:"Order in which bare variable names are looked up in the environment, vars, secrets and arguments; sources left out are only visible with their namespace"`
+	Mask        []Mask            `yaml:"mask,omitempty" jsonschema_description:"Sensitive data masked in all output, in addition to vault secrets"`
+	Vaults      *Vaults           `yaml:"vaults,omitempty" jsonschema_description:"Secret vaults"`
+	Tasks       map[string]Task   `yaml:"tasks" jsonschema:"required" jsonschema_description:"Tasks by name"`
+	Dir         string            `yaml:"-"` // Directory of the taskfile
+	Hash        string            `yaml:"-"` // SHA-256 of the content of the taskfile and of its imports
+}
+
+// OutputModes are the values of the output key of tasks
+var OutputModes = []string{"interleaved", "grouped", "quiet-success"}
+
+// ValidateOutputMode reports an unknown output mode; an empty mode is the default one
+func ValidateOutputMode(mode string) error {
+	if mode == "" {
+		return nil
+	}
+	for _, m := range OutputModes {
+		if m == mode {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown output mode '%s' (expected interleaved, grouped or quiet-success)", mode)
 }
 
 // Validate validates the taskfile
 func (tf *Taskfile) Validate() error {
 	validator := env.NewValidator()
 
+	if _, err := vars.ParsePrecedence(tf.Precedence); err != nil {
+		return fmt.Errorf("invalid precedence: %w", err)
+	}
+
 	// Validate global environment variables
 	if err := validator.ValidateMap(tf.Environment); err != nil {
 		return fmt.Errorf("invalid global environment: %w", err)
 	}
 
+	if err := validateVars(tf.Vars); err != nil {
+		return fmt.Errorf("invalid global vars: %w", err)
+	}
+
+	if err := validateMasks(tf.Mask); err != nil {
+		return fmt.Errorf("invalid mask: %w", err)
+	}
+
+	if tf.Vaults != nil {
+		for name, cfg := range tf.Vaults.AzureKeyVault {
+			if err := validateVault(validator, "azure_keyvault."+name, cfg, cfg.Secrets); err != nil {
+				return err
+			}
+		}
+		for name, cfg := range tf.Vaults.HashiCorpVault {
+			if err := validateVault(validator, "hashicorp_vault."+name, cfg, cfg.Secrets); err != nil {
+				return err
+			}
+		}
+		for name, cfg := range tf.Vaults.AWSSecretsManager {
+			if err := validateVault(validator, "aws_secrets_manager."+name, cfg, cfg.Secrets); err != nil {
+				return err
+			}
+		}
+		for name, cfg := range tf.Vaults.AWSSSM {
+			if err := validateVault(validator, "aws_ssm."+name, cfg, cfg.Secrets); err != nil {
+				return err
+			}
+		}
+	}
+
 	// Validate task environment variables
 	for taskName, task := range tf.Tasks {
 		if err := validator.ValidateMap(task.Environment); err != nil {
 			return fmt.Errorf("invalid environment in task '%s': %w", taskName, err)
 		}
+		if err := validateVars(task.Vars); err != nil {
+			return fmt.Errorf("invalid vars in task '%s': %w", taskName, err)
+		}
+		if err := ValidateOutputMode(task.Output); err != nil {
+			return fmt.Errorf("invalid output in task '%s': %w", taskName, err)
+		}
 	}
 
 	return nil
@@ -102,3 +191,17 @@ func (tf *Taskfile) ProcessVariables() error {
 
 	return nil
 }
+
+// validateVault validates the configuration of a vault of a built-in type and the environment
+// variable names of its secrets
+func validateVault(validator *env.Validator, name string, cfg interface{ Validate() error }, secrets map[string]string) error {
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid vault %s: %w", name, err)
+	}
+	for secret := range secrets {
+		if err := validator.ValidateName(secret); err != nil {
+			return fmt.Errorf("invalid secret in vault %s: %w", name, err)
+		}
+	}
+	return nil
+}
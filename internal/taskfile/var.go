@@ -0,0 +1,75 @@
+// var.go
+// Defines Var for the vars sections of Kontraktor taskfiles.
+package taskfile
+
+import (
+	"fmt"
+	"regexp"
+
+	"gopkg.in/yaml.v3"
+)
+
+// varNameRe matches valid names of vars entries; dots are reserved for namespaces
+var varNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+// Var is an entry of a vars section. It is written as a literal value, or as a mapping
+// with sh: a command whose trimmed standard output is the value, or file: a file whose
+// trimmed content is the value, optionally a JSON document read at json_path.
+type Var struct {
+	Value    string `yaml:"-"`
+	Sh       string `yaml:"sh,omitempty" jsonschema_description:"Command whose trimmed standard output is the value"`
+	File     string `yaml:"file,omitempty" jsonschema_description:"File whose trimmed content is the value, relative to the taskfile"`
+	JSONPath string `yaml:"json_path,omitempty" jsonschema_description:"Path of the value in the JSON file, such as .version or .images[0].tag"`
+}
+
+// UnmarshalYAML implements custom YAML unmarshalling for Var
+func (v *Var) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		v.Value = value.Value
+		return nil
+	}
+	if value.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: variable must be a value or a mapping with sh or file", value.Line)
+	}
+	for i := 0; i+1 < len(value.Content); i += 2 {
+		switch key := value.Content[i].Value; key {
+		case "sh", "file", "json_path":
+		default:
+			return fmt.Errorf("line %d: unknown key '%s' in variable", value.Content[i].Line, key)
+		}
+	}
+	type plain Var
+	return value.Decode((*plain)(v))
+}
+
+// Validate reports an incomplete or ambiguous definition
+func (v Var) Validate() error {
+	switch {
+	case v.Sh != "" && v.File != "":
+		return fmt.Errorf("sh and file cannot be used together")
+	case v.JSONPath != "" && v.File == "":
+		return fmt.Errorf("json_path requires file")
+	}
+	return nil
+}
+
+// ValidateVarName reports names that cannot be used in vars sections
+func ValidateVarName(name string) error {
+	if !varNameRe.MatchString(name) {
+		return fmt.Errorf("invalid variable name '%s': must start with a letter or underscore and contain only letters, digits, underscores and dashes", name)
+	}
+	return nil
+}
+
+// validateVars validates the names and definitions of a vars section
+func validateVars(vs map[string]Var) error {
+	for _, name := range sortedKeys(vs) {
+		if err := ValidateVarName(name); err != nil {
+			return err
+		}
+		if err := vs[name].Validate(); err != nil {
+			return fmt.Errorf("variable '%s': %w", name, err)
+		}
+	}
+	return nil
+}
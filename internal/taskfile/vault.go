@@ -0,0 +1,97 @@
+// vault.go
+// Registry of vault configuration types beyond the built-in ones.
+package taskfile
+
+import (
+	"fmt"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	vaultTypesMu sync.RWMutex
+	vaultTypes   = make(map[string]interface{})
+)
+
+// RegisterVaultType registers the configuration type of a vault section, so that
+// vaults.<name> sections are accepted by validation and described in the taskfile schema.
+// config is a zero value of the configuration of a single vault in the section; it should
+// embed VaultOptions inline.
+func RegisterVaultType(name string, config interface{}) {
+	vaultTypesMu.Lock()
+	defer vaultTypesMu.Unlock()
+	vaultTypes[name] = config
+}
+
+// UnregisterVaultType removes a vault type registered with RegisterVaultType, so that tests
+// registering types leave the registry as they found it
+func UnregisterVaultType(name string) {
+	vaultTypesMu.Lock()
+	defer vaultTypesMu.Unlock()
+	delete(vaultTypes, name)
+}
+
+// VaultTypes returns the registered vault configuration types by section name
+func VaultTypes() map[string]interface{} {
+	vaultTypesMu.RLock()
+	defer vaultTypesMu.RUnlock()
+	result := make(map[string]interface{}, len(vaultTypes))
+	for name, config := range vaultTypes {
+		result[name] = config
+	}
+	return result
+}
+
+// IsVaultType reports whether name is a built-in or registered vault section
+func IsVaultType(name string) bool {
+	switch name {
+	case "azure_keyvault", "hashicorp_vault", "aws_secrets_manager", "aws_ssm":
+		return true
+	}
+	vaultTypesMu.RLock()
+	defer vaultTypesMu.RUnlock()
+	_, ok := vaultTypes[name]
+	return ok
+}
+
+// DecodeCustom decodes the section of a registered vault type into out,
+// which should point to a map of vault name to configuration.
+// It returns false when the taskfile has no such section.
+func (v *Vaults) DecodeCustom(name string, out interface{}) (bool, error) {
+	node, ok := v.Custom[name]
+	if !ok {
+		return false, nil
+	}
+	if err := node.Decode(out); err != nil {
+		return true, fmt.Errorf("decode vaults.%s: %w", name, err)
+	}
+	return true, nil
+}
+
+// UnmarshalYAML decodes the vault sections, recording the order in which vaults are declared
+// and their options
+func (v *Vaults) UnmarshalYAML(node *yaml.Node) error {
+	type plain Vaults
+	if err := node.Decode((*plain)(v)); err != nil {
+		return err
+	}
+	v.Order = nil
+	v.Options = make(map[string]VaultOptions)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		section := node.Content[i+1]
+		if section.Kind != yaml.MappingNode {
+			continue
+		}
+		for j := 0; j+1 < len(section.Content); j += 2 {
+			name := node.Content[i].Value + "." + section.Content[j].Value
+			var options VaultOptions
+			if err := section.Content[j+1].Decode(&options); err != nil {
+				return fmt.Errorf("decode vaults.%s: %w", name, err)
+			}
+			v.Order = append(v.Order, name)
+			v.Options[name] = options
+		}
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package taskfile
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestVaults_Order(t *testing.T) {
+	var tf Taskfile
+	require.NoError(t, yaml.Unmarshal([]byte(`version: "0.3"
+vaults:
+  azure_keyvault:
+    zeta:
+      keyvault_name: zeta
+      priority: 5
+      allow_override: true
+    alpha:
+      keyvault_name: alpha
+  file:
+    local:
+      path: secrets.env
+      priority: -1
+tasks: {}
+`), &tf))
+
+	assert.Equal(t, []string{"azure_keyvault.zeta", "azure_keyvault.alpha", "file.local"}, tf.Vaults.Order)
+	assert.Equal(t, map[string]VaultOptions{
+		"azure_keyvault.zeta":  {Priority: 5, AllowOverride: true},
+		"azure_keyvault.alpha": {},
+		"file.local":           {Priority: -1},
+	}, tf.Vaults.Options)
+	assert.Equal(t, VaultOptions{Priority: 5, AllowOverride: true}, tf.Vaults.AzureKeyVault["zeta"].VaultOptions)
+	assert.Contains(t, tf.Vaults.Custom, "file")
+}
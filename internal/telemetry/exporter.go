@@ -0,0 +1,397 @@
+// exporter.go
+// Exports runs as OpenTelemetry traces over OTLP, to a collector or to a file.
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kontraktor-sh/kontraktor/internal/output"
+)
+
+// Protocols of OTLP exporters
+const (
+	ProtocolGRPC         = "grpc"
+	ProtocolHTTPProtobuf = "http/protobuf"
+	ProtocolHTTPJSON     = "http/json"
+)
+
+// Protocols lists the OTLP protocols that can be selected
+var Protocols = []string{ProtocolGRPC, ProtocolHTTPProtobuf, ProtocolHTTPJSON}
+
+// scopeName is the instrumentation scope of the spans of runs
+const scopeName = "kontraktor"
+
+// grpcPath is the path of the gRPC method receiving traces
+const grpcPath = "/opentelemetry.proto.collector.trace.v1.TraceService/Export"
+
+// Config configures the export of runs. It follows the OTEL_* environment variables of
+// OpenTelemetry SDKs; see ConfigFromEnv.
+type Config struct {
+	Endpoint           string // Base URL of a collector; /v1/traces is appended for HTTP protocols
+	TracesEndpoint     string // URL receiving traces, used as is; takes precedence over Endpoint
+	Protocol           string // grpc, http/protobuf or http/json; http/protobuf by default
+	Headers            map[string]string
+	Timeout            time.Duration
+	File               string // File OTLP JSON requests are appended to, one per line
+	ServiceName        string
+	ResourceAttributes map[string]string
+}
+
+// ConfigFromEnv reads the configuration of OpenTelemetry SDKs from OTEL_* environment variables
+func ConfigFromEnv(getenv func(string) string) Config {
+	config := Config{
+		Endpoint:           getenv("OTEL_EXPORTER_OTLP_ENDPOINT"),
+		TracesEndpoint:     getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"),
+		Protocol:           firstSet(getenv("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL"), getenv("OTEL_EXPORTER_OTLP_PROTOCOL")),
+		Headers:            parseList(getenv("OTEL_EXPORTER_OTLP_HEADERS")),
+		ServiceName:        getenv("OTEL_SERVICE_NAME"),
+		ResourceAttributes: parseList(getenv("OTEL_RESOURCE_ATTRIBUTES")),
+	}
+	for k, v := range parseList(getenv("OTEL_EXPORTER_OTLP_TRACES_HEADERS")) {
+		config.Headers[k] = v
+	}
+	timeout := firstSet(getenv("OTEL_EXPORTER_OTLP_TRACES_TIMEOUT"), getenv("OTEL_EXPORTER_OTLP_TIMEOUT"))
+	if ms, err := time.ParseDuration(timeout + "ms"); err == nil && ms > 0 {
+		config.Timeout = ms
+	}
+	return config
+}
+
+// Enabled reports whether runs are exported
+func (c Config) Enabled() bool {
+	return c.Endpoint != "" || c.TracesEndpoint != "" || c.File != ""
+}
+
+// Exporter collects the spans of a run from its events and exports them when the run ends.
+// Register Event with output.Handler.AddListener; only events with span IDs are exported.
+type Exporter struct {
+	config   Config
+	url      string // URL receiving traces, if exported to a collector
+	client   *http.Client
+	resource []attribute
+
+	mu    sync.Mutex
+	spans []*span
+	open  map[string]*span // Spans started but not ended, by span ID
+}
+
+// NewExporter creates an exporter, checking its configuration
+func NewExporter(config Config) (*Exporter, error) {
+	if config.Protocol == "" {
+		config.Protocol = ProtocolHTTPProtobuf
+	}
+	if !IsProtocol(config.Protocol) {
+		return nil, fmt.Errorf("unknown OTLP protocol '%s' (expected %s)", config.Protocol, strings.Join(Protocols, ", "))
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 10 * time.Second
+	}
+	x := &Exporter{config: config, open: make(map[string]*span)}
+	if config.Endpoint != "" || config.TracesEndpoint != "" {
+		u, err := tracesURL(config)
+		if err != nil {
+			return nil, err
+		}
+		x.url = u.String()
+		x.client = newClient(config.Protocol, u.Scheme)
+		x.client.Timeout = config.Timeout
+	}
+
+	serviceName := config.ServiceName
+	if serviceName == "" {
+		serviceName = config.ResourceAttributes["service.name"]
+	}
+	if serviceName == "" {
+		serviceName = scopeName
+	}
+	x.resource = []attribute{{"service.name", serviceName}}
+	for _, k := range sortedKeys(config.ResourceAttributes) {
+		if k != "service.name" {
+			x.resource = append(x.resource, attribute{k, config.ResourceAttributes[k]})
+		}
+	}
+	return x, nil
+}
+
+// tracesURL returns the URL receiving traces
+func tracesURL(config Config) (*url.URL, error) {
+	endpoint := config.TracesEndpoint
+	switch {
+	case config.Protocol == ProtocolGRPC:
+		// gRPC endpoints name the collector only; the path is the method
+		endpoint = strings.TrimSuffix(firstSet(endpoint, config.Endpoint), "/") + grpcPath
+	case endpoint == "":
+		endpoint = strings.TrimSuffix(config.Endpoint, "/") + "/v1/traces"
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid OTLP endpoint '%s': expected an http:// or https:// URL", firstSet(config.TracesEndpoint, config.Endpoint))
+	}
+	return u, nil
+}
+
+// newClient creates the HTTP client of a protocol. gRPC runs over HTTP/2, without TLS for http:// endpoints.
+func newClient(protocol, scheme string) *http.Client {
+	if protocol != ProtocolGRPC {
+		return &http.Client{}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Protocols = new(http.Protocols)
+	if scheme == "http" {
+		transport.Protocols.SetUnencryptedHTTP2(true)
+	} else {
+		transport.Protocols.SetHTTP2(true)
+	}
+	return &http.Client{Transport: transport}
+}
+
+// Event adds an event of the run to the trace
+func (x *Exporter) Event(e output.Event) {
+	if e.SpanID == "" {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	switch e.Type {
+	case output.EventRunStart:
+		x.start(e, "run "+e.Task, attribute{"kontraktor.run.id", e.RunID}, attribute{"kontraktor.task.name", e.Task})
+	case output.EventTaskStart:
+		x.start(e, "task "+e.Task, attribute{"kontraktor.task.name", e.Task})
+	case output.EventStepStart:
+		attributes := []attribute{
+			{"kontraktor.task.name", e.Task},
+			{"kontraktor.step.number", int64(e.Step)},
+			{"kontraktor.step.interpreter", e.Interpreter},
+		}
+		if e.Command != "" {
+			attributes = append(attributes, attribute{"kontraktor.step.command", e.Command})
+		}
+		if e.File != "" {
+			attributes = append(attributes, attribute{"code.filepath", e.File}, attribute{"code.lineno", int64(e.FileLine)})
+		}
+		x.start(e, fmt.Sprintf("%s step %d", e.Task, e.Step), attributes...)
+	case output.EventRunEnd, output.EventTaskEnd, output.EventStepEnd:
+		s := x.open[e.SpanID]
+		if s == nil {
+			return
+		}
+		delete(x.open, e.SpanID)
+		if e.ExitCode != nil {
+			s.attributes = append(s.attributes, attribute{"process.exit.code", int64(*e.ExitCode)})
+		}
+		x.finish(s, e, e.Time)
+	case output.EventPhase:
+		s := &span{traceID: e.TraceID, spanID: e.SpanID, parentSpanID: e.ParentSpanID, start: e.Time, attributes: []attribute{{"kontraktor.phase", e.Phase}}}
+		switch {
+		case e.Vault != "":
+			s.name = e.Phase + " " + e.Vault
+			s.attributes = append(s.attributes, attribute{"kontraktor.vault.name", e.Vault})
+		case e.File != "":
+			s.name = e.Phase + " " + e.File
+			s.attributes = append(s.attributes, attribute{"kontraktor.import.path", e.File})
+		default:
+			s.name = e.Phase
+		}
+		var d time.Duration
+		if e.DurationMS != nil {
+			d = time.Duration(*e.DurationMS) * time.Millisecond
+		}
+		x.finish(s, e, e.Time.Add(d))
+	}
+}
+
+// start opens the span started by an event
+func (x *Exporter) start(e output.Event, name string, attributes ...attribute) {
+	x.open[e.SpanID] = &span{traceID: e.TraceID, spanID: e.SpanID, parentSpanID: e.ParentSpanID, name: name, start: e.Time, attributes: attributes}
+}
+
+// finish records a span ended by an event
+func (x *Exporter) finish(s *span, e output.Event, end time.Time) {
+	s.end = end
+	if e.Status == output.StatusFailure {
+		s.failed = true
+		s.message = e.Error
+	}
+	x.spans = append(x.spans, s)
+}
+
+// Export sends the spans collected so far to the collector and appends them to the file
+// of the configuration, as configured
+func (x *Exporter) Export(ctx context.Context) error {
+	x.mu.Lock()
+	spans := x.spans
+	x.spans = nil
+	x.mu.Unlock()
+	if len(spans) == 0 {
+		return nil
+	}
+
+	if x.config.File != "" {
+		if err := x.writeFile(spans); err != nil {
+			return fmt.Errorf("failed to export trace: %w", err)
+		}
+	}
+	if x.url == "" {
+		return nil
+	}
+	var err error
+	switch x.config.Protocol {
+	case ProtocolGRPC:
+		err = x.sendGRPC(ctx, encodeProto(x.resource, spans))
+	case ProtocolHTTPJSON:
+		var body []byte
+		if body, err = encodeJSON(x.resource, spans); err == nil {
+			err = x.sendHTTP(ctx, "application/json", body)
+		}
+	default:
+		err = x.sendHTTP(ctx, "application/x-protobuf", encodeProto(x.resource, spans))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to export trace to %s: %w", x.url, err)
+	}
+	return nil
+}
+
+// writeFile appends an export request to the file of the configuration, as a line of JSON
+func (x *Exporter) writeFile(spans []*span) error {
+	body, err := encodeJSON(x.resource, spans)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(x.config.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(body, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// request creates a request to the collector with the headers of the configuration
+func (x *Exporter) request(ctx context.Context, contentType string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, x.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range x.config.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", contentType)
+	return req, nil
+}
+
+// sendHTTP sends an export request with an OTLP/HTTP protocol
+func (x *Exporter) sendHTTP(ctx context.Context, contentType string, body []byte) error {
+	req, err := x.request(ctx, contentType, body)
+	if err != nil {
+		return err
+	}
+	resp, err := x.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("collector responded %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// sendGRPC sends an export request with the OTLP/gRPC protocol: a single length-prefixed
+// message over HTTP/2, whose status is reported in the trailers of the response
+func (x *Exporter) sendGRPC(ctx context.Context, message []byte) error {
+	body := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(body[1:], uint32(len(message)))
+	body = append(body, message...)
+	req, err := x.request(ctx, "application/grpc", body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("TE", "trailers")
+	resp, err := x.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("collector responded %s", resp.Status)
+	}
+	// Errors without a response message are sent in the headers alone
+	status := firstSet(resp.Trailer.Get("Grpc-Status"), resp.Header.Get("Grpc-Status"))
+	switch status {
+	case "0":
+		return nil
+	case "":
+		return fmt.Errorf("collector responded without a gRPC status")
+	}
+	grpcMessage := firstSet(resp.Trailer.Get("Grpc-Message"), resp.Header.Get("Grpc-Message"))
+	if decoded, err := url.PathUnescape(grpcMessage); err == nil {
+		grpcMessage = decoded
+	}
+	return fmt.Errorf("collector responded with gRPC status %s: %s", status, grpcMessage)
+}
+
+// IsProtocol reports whether an OTLP protocol can be selected
+func IsProtocol(protocol string) bool {
+	for _, p := range Protocols {
+		if p == protocol {
+			return true
+		}
+	}
+	return false
+}
+
+// parseList parses a list of key=value pairs separated by commas, with URL-encoded values,
+// as in OTEL_EXPORTER_OTLP_HEADERS
+func parseList(s string) map[string]string {
+	pairs := make(map[string]string)
+	for _, item := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(item, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			continue
+		}
+		if decoded, err := url.PathUnescape(strings.TrimSpace(value)); err == nil {
+			value = decoded
+		}
+		pairs[key] = strings.TrimSpace(value)
+	}
+	return pairs
+}
+
+// firstSet returns the first value that is not empty
+func firstSet(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
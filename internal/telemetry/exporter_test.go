@@ -0,0 +1,284 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kontraktor-sh/kontraktor/internal/output"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testTrace = "4bf92f3577b34da6a3ce929d0e0e4736"
+	runSpan   = "00f067aa0ba902b7"
+	taskSpan  = "1111111111111111"
+	stepSpan  = "2222222222222222"
+	vaultSpan = "3333333333333333"
+)
+
+// exportRun passes the events of a failed run to an exporter
+func exportRun(x *Exporter) {
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	at := func(ms int) time.Time {
+		return start.Add(time.Duration(ms) * time.Millisecond)
+	}
+	code := 3
+	vault := output.Event{Type: output.EventPhase, Time: at(1), Phase: output.PhaseSecrets, Vault: "azure_keyvault.prod", Status: output.StatusSuccess, TraceID: testTrace, SpanID: vaultSpan, ParentSpanID: runSpan}
+	vault.Duration(4 * time.Millisecond)
+	for _, e := range []output.Event{
+		{Type: output.EventRunStart, Time: at(0), RunID: "r1", Task: "deploy", TraceID: testTrace, SpanID: runSpan, ParentSpanID: "b7ad6b7169203331"},
+		vault,
+		{Type: output.EventTaskStart, Time: at(10), Task: "deploy", TraceID: testTrace, SpanID: taskSpan, ParentSpanID: runSpan},
+		{Type: output.EventStepStart, Time: at(11), Task: "deploy", Step: 1, Interpreter: "bash", Command: "./deploy.sh", File: "taskfile.ktr.yml", FileLine: 9, TraceID: testTrace, SpanID: stepSpan, ParentSpanID: taskSpan},
+		{Type: output.EventOutput, Time: at(12), Task: "deploy", Step: 1, Line: "deploying"},
+		{Type: output.EventStepEnd, Time: at(20), Task: "deploy", Step: 1, Status: output.StatusFailure, Error: "exit status 3", ExitCode: &code, TraceID: testTrace, SpanID: stepSpan, ParentSpanID: taskSpan},
+		{Type: output.EventTaskEnd, Time: at(21), Task: "deploy", Status: output.StatusFailure, Error: "command failed", TraceID: testTrace, SpanID: taskSpan, ParentSpanID: runSpan},
+		{Type: output.EventRunEnd, Time: at(22), Task: "deploy", Status: output.StatusFailure, Error: "command failed", TraceID: testTrace, SpanID: runSpan, ParentSpanID: "b7ad6b7169203331"},
+	} {
+		x.Event(e)
+	}
+}
+
+func TestExporter_HTTPJSON(t *testing.T) {
+	var req *http.Request
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req = r
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	x, err := NewExporter(Config{Endpoint: server.URL + "/", Protocol: ProtocolHTTPJSON, Headers: map[string]string{"Authorization": "Bearer t0ken"}, ServiceName: "ci"})
+	require.NoError(t, err)
+	exportRun(x)
+	require.NoError(t, x.Export(context.Background()))
+
+	assert.Equal(t, "/v1/traces", req.URL.Path)
+	assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+	assert.Equal(t, "Bearer t0ken", req.Header.Get("Authorization"))
+
+	var request jsonRequest
+	require.NoError(t, json.Unmarshal(body, &request))
+	require.Len(t, request.ResourceSpans, 1)
+	assert.Equal(t, []jsonKeyValue{{Key: "service.name", Value: map[string]interface{}{"stringValue": "ci"}}}, request.ResourceSpans[0].Resource.Attributes)
+	spans := request.ResourceSpans[0].ScopeSpans[0].Spans
+	require.Len(t, spans, 4)
+
+	names := make(map[string]jsonSpan)
+	for _, s := range spans {
+		assert.Equal(t, testTrace, s.TraceID)
+		names[s.Name] = s
+	}
+	step := names["deploy step 1"]
+	assert.Equal(t, taskSpan, step.ParentSpanID)
+	assert.Equal(t, "1772366400011000000", step.StartTimeUnixNano)
+	assert.Equal(t, "1772366400020000000", step.EndTimeUnixNano)
+	assert.Equal(t, &jsonStatus{Code: 2, Message: "exit status 3"}, step.Status)
+	assert.Contains(t, step.Attributes, jsonKeyValue{Key: "kontraktor.step.interpreter", Value: map[string]interface{}{"stringValue": "bash"}})
+	assert.Contains(t, step.Attributes, jsonKeyValue{Key: "process.exit.code", Value: map[string]interface{}{"intValue": "3"}})
+	assert.Contains(t, step.Attributes, jsonKeyValue{Key: "code.lineno", Value: map[string]interface{}{"intValue": "9"}})
+	assert.Equal(t, "b7ad6b7169203331", names["run deploy"].ParentSpanID)
+	assert.Equal(t, runSpan, names["task deploy"].ParentSpanID)
+	vault := names["secrets azure_keyvault.prod"]
+	assert.Equal(t, "1772366400005000000", vault.EndTimeUnixNano)
+	assert.Nil(t, vault.Status)
+
+	// Spans are exported once
+	req = nil
+	require.NoError(t, x.Export(context.Background()))
+	assert.Nil(t, req)
+}
+
+func TestExporter_HTTPProtobuf(t *testing.T) {
+	var body []byte
+	var contentType string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	x, err := NewExporter(Config{TracesEndpoint: server.URL + "/custom/traces"})
+	require.NoError(t, err)
+	exportRun(x)
+	require.NoError(t, x.Export(context.Background()))
+	assert.Equal(t, "application/x-protobuf", contentType)
+	assertProtoSpans(t, body)
+}
+
+func TestExporter_GRPC(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  string
+		message string
+		wantErr string
+	}{
+		{name: "ok", status: "0"},
+		{name: "unavailable", status: "14", message: "collector%20is%20down", wantErr: "gRPC status 14: collector is down"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body []byte
+			var req *http.Request
+			server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				req = r
+				body, _ = io.ReadAll(r.Body)
+				w.Header().Set("Content-Type", "application/grpc")
+				w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+				w.WriteHeader(http.StatusOK)
+				w.Header().Set("Grpc-Status", tt.status)
+				w.Header().Set("Grpc-Message", tt.message)
+			}))
+			server.Config.Protocols = new(http.Protocols)
+			server.Config.Protocols.SetUnencryptedHTTP2(true)
+			server.Start()
+			defer server.Close()
+
+			x, err := NewExporter(Config{Endpoint: server.URL, Protocol: ProtocolGRPC})
+			require.NoError(t, err)
+			exportRun(x)
+			err = x.Export(context.Background())
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, 2, req.ProtoMajor)
+			assert.Equal(t, grpcPath, req.URL.Path)
+			assert.Equal(t, "application/grpc", req.Header.Get("Content-Type"))
+			require.Greater(t, len(body), 5)
+			assert.Equal(t, byte(0), body[0], "message is not compressed")
+			assert.Equal(t, uint32(len(body)-5), binary.BigEndian.Uint32(body[1:5]))
+			assertProtoSpans(t, body[5:])
+		})
+	}
+}
+
+func TestExporter_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	x, err := NewExporter(Config{File: path})
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		exportRun(x)
+		require.NoError(t, x.Export(context.Background()))
+	}
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	dec := json.NewDecoder(bytes.NewReader(data))
+	count := 0
+	for dec.More() {
+		var request jsonRequest
+		require.NoError(t, dec.Decode(&request))
+		assert.Len(t, request.ResourceSpans[0].ScopeSpans[0].Spans, 4)
+		count++
+	}
+	assert.Equal(t, 2, count, "runs are appended")
+}
+
+func TestNewExporter_Errors(t *testing.T) {
+	_, err := NewExporter(Config{Endpoint: "localhost:4318"})
+	assert.EqualError(t, err, "invalid OTLP endpoint 'localhost:4318': expected an http:// or https:// URL")
+	_, err = NewExporter(Config{Endpoint: "http://localhost:4318", Protocol: "thrift"})
+	assert.EqualError(t, err, "unknown OTLP protocol 'thrift' (expected grpc, http/protobuf, http/json)")
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	env := map[string]string{
+		"OTEL_EXPORTER_OTLP_ENDPOINT":        "http://collector:4318",
+		"OTEL_EXPORTER_OTLP_PROTOCOL":        "http/json",
+		"OTEL_EXPORTER_OTLP_TRACES_PROTOCOL": "grpc",
+		"OTEL_EXPORTER_OTLP_HEADERS":         "api-key=a%2Bb, x-team = ci",
+		"OTEL_EXPORTER_OTLP_TRACES_HEADERS":  "x-team=deploy",
+		"OTEL_EXPORTER_OTLP_TIMEOUT":         "2500",
+		"OTEL_SERVICE_NAME":                  "builds",
+		"OTEL_RESOURCE_ATTRIBUTES":           "deployment.environment=prod",
+	}
+	config := ConfigFromEnv(func(key string) string { return env[key] })
+	assert.Equal(t, Config{
+		Endpoint:           "http://collector:4318",
+		Protocol:           "grpc",
+		Headers:            map[string]string{"api-key": "a+b", "x-team": "deploy"},
+		Timeout:            2500 * time.Millisecond,
+		ServiceName:        "builds",
+		ResourceAttributes: map[string]string{"deployment.environment": "prod"},
+	}, config)
+	assert.True(t, config.Enabled())
+	assert.False(t, ConfigFromEnv(func(string) string { return "" }).Enabled())
+}
+
+// assertProtoSpans checks the spans of an export request in the protobuf encoding
+func assertProtoSpans(t *testing.T, body []byte) {
+	t.Helper()
+	request := decodeProto(t, body)
+	resourceSpans := decodeProto(t, request[1][0])
+	resource := decodeProto(t, resourceSpans[1][0])
+	serviceName := decodeProto(t, resource[1][0])
+	assert.Equal(t, "service.name", string(serviceName[1][0]))
+	scopeSpans := decodeProto(t, resourceSpans[2][0])
+	assert.Equal(t, "kontraktor", string(decodeProto(t, scopeSpans[1][0])[1][0]))
+
+	parents := make(map[string]string)
+	for _, raw := range scopeSpans[2] {
+		s := decodeProto(t, raw)
+		assert.Equal(t, testTrace, hex.EncodeToString(s[1][0]))
+		parent := ""
+		if len(s[4]) > 0 {
+			parent = hex.EncodeToString(s[4][0])
+		}
+		parents[string(s[5][0])] = parent
+		if string(s[5][0]) == "deploy step 1" {
+			assert.Equal(t, uint64(1772366400011000000), binary.LittleEndian.Uint64(s[7][0]))
+			status := decodeProto(t, s[15][0])
+			assert.Equal(t, "exit status 3", string(status[2][0]))
+			assert.Equal(t, []byte{2}, status[3][0])
+		}
+	}
+	assert.Equal(t, map[string]string{
+		"run deploy":                  "b7ad6b7169203331",
+		"secrets azure_keyvault.prod": runSpan,
+		"task deploy":                 runSpan,
+		"deploy step 1":               taskSpan,
+	}, parents)
+}
+
+// decodeProto splits a protobuf message into the raw values of its fields: bytes of
+// length-delimited fields, little-endian bytes of fixed fields and varint bytes of varints
+func decodeProto(t *testing.T, b []byte) map[int][][]byte {
+	t.Helper()
+	fields := make(map[int][][]byte)
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		require.Greater(t, n, 0)
+		b = b[n:]
+		var value []byte
+		switch key & 7 {
+		case 0:
+			v, n := binary.Uvarint(b)
+			require.Greater(t, n, 0)
+			value, b = binary.AppendUvarint(nil, v), b[n:]
+		case 1:
+			value, b = b[:8], b[8:]
+		case 2:
+			l, n := binary.Uvarint(b)
+			require.Greater(t, n, 0)
+			value, b = b[n:n+int(l)], b[n+int(l):]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+		fields[int(key>>3)] = append(fields[int(key>>3)], value)
+	}
+	return fields
+}
@@ -0,0 +1,253 @@
+// otlp.go
+// Encodes spans as OTLP export requests, in the JSON and protobuf encodings.
+package telemetry
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Status codes and kinds of OTLP spans
+const (
+	statusCodeError  = 2
+	spanKindInternal = 1
+)
+
+// attribute is a key-value pair of a span or resource; values are strings, int64s or bools
+type attribute struct {
+	key   string
+	value interface{}
+}
+
+// span is a finished span of a run
+type span struct {
+	traceID      string
+	spanID       string
+	parentSpanID string
+	name         string
+	start        time.Time
+	end          time.Time
+	attributes   []attribute
+	failed       bool
+	message      string // Error of a failed span
+}
+
+type jsonRequest struct {
+	ResourceSpans []jsonResourceSpans `json:"resourceSpans"`
+}
+
+type jsonResourceSpans struct {
+	Resource   jsonResource     `json:"resource"`
+	ScopeSpans []jsonScopeSpans `json:"scopeSpans"`
+}
+
+type jsonResource struct {
+	Attributes []jsonKeyValue `json:"attributes"`
+}
+
+type jsonScopeSpans struct {
+	Scope jsonScope  `json:"scope"`
+	Spans []jsonSpan `json:"spans"`
+}
+
+type jsonScope struct {
+	Name string `json:"name"`
+}
+
+type jsonSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []jsonKeyValue `json:"attributes,omitempty"`
+	Status            *jsonStatus    `json:"status,omitempty"`
+}
+
+type jsonKeyValue struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+type jsonStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+// encodeJSON encodes an export request in the OTLP JSON encoding
+func encodeJSON(resource []attribute, spans []*span) ([]byte, error) {
+	scope := jsonScopeSpans{Scope: jsonScope{Name: scopeName}, Spans: []jsonSpan{}}
+	for _, s := range spans {
+		js := jsonSpan{
+			TraceID:           s.traceID,
+			SpanID:            s.spanID,
+			ParentSpanID:      s.parentSpanID,
+			Name:              s.name,
+			Kind:              spanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        jsonAttributes(s.attributes),
+		}
+		if s.failed {
+			js.Status = &jsonStatus{Code: statusCodeError, Message: s.message}
+		}
+		scope.Spans = append(scope.Spans, js)
+	}
+	request := jsonRequest{ResourceSpans: []jsonResourceSpans{{
+		Resource:   jsonResource{Attributes: jsonAttributes(resource)},
+		ScopeSpans: []jsonScopeSpans{scope},
+	}}}
+	return json.Marshal(request)
+}
+
+func jsonAttributes(attributes []attribute) []jsonKeyValue {
+	var kvs []jsonKeyValue
+	for _, a := range attributes {
+		var value map[string]interface{}
+		switch v := a.value.(type) {
+		case string:
+			value = map[string]interface{}{"stringValue": v}
+		case int64:
+			// 64-bit integers are strings in the JSON encoding of protobuf
+			value = map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+		case bool:
+			value = map[string]interface{}{"boolValue": v}
+		case float64:
+			value = map[string]interface{}{"doubleValue": v}
+		default:
+			continue
+		}
+		kvs = append(kvs, jsonKeyValue{Key: a.key, Value: value})
+	}
+	return kvs
+}
+
+// protoMessage builds a protobuf message. Fields with default values are left out, as proto3 does.
+type protoMessage []byte
+
+func (m *protoMessage) varint(v uint64) {
+	*m = binary.AppendUvarint(*m, v)
+}
+
+func (m *protoMessage) tag(field, wireType int) {
+	m.varint(uint64(field<<3 | wireType))
+}
+
+func (m *protoMessage) varintField(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	m.tag(field, 0)
+	m.varint(v)
+}
+
+func (m *protoMessage) fixed64Field(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	m.tag(field, 1)
+	*m = binary.LittleEndian.AppendUint64(*m, v)
+}
+
+func (m *protoMessage) bytesField(field int, v []byte) {
+	if len(v) == 0 {
+		return
+	}
+	m.tag(field, 2)
+	m.varint(uint64(len(v)))
+	*m = append(*m, v...)
+}
+
+func (m *protoMessage) stringField(field int, s string) {
+	m.bytesField(field, []byte(s))
+}
+
+// messageField appends an embedded message, even when it is empty
+func (m *protoMessage) messageField(field int, v protoMessage) {
+	m.tag(field, 2)
+	m.varint(uint64(len(v)))
+	*m = append(*m, v...)
+}
+
+// encodeProto encodes an export request in the OTLP protobuf encoding
+func encodeProto(resource []attribute, spans []*span) []byte {
+	var scope protoMessage
+	var scopeID protoMessage
+	scopeID.stringField(1, scopeName)
+	scope.messageField(1, scopeID)
+	for _, s := range spans {
+		scope.messageField(2, protoSpan(s))
+	}
+
+	var res protoMessage
+	for _, a := range resource {
+		res.messageField(1, protoAttribute(a))
+	}
+	var resourceSpans protoMessage
+	resourceSpans.messageField(1, res)
+	resourceSpans.messageField(2, scope)
+
+	var request protoMessage
+	request.messageField(1, resourceSpans)
+	return request
+}
+
+func protoSpan(s *span) protoMessage {
+	var m protoMessage
+	m.bytesField(1, hexBytes(s.traceID))
+	m.bytesField(2, hexBytes(s.spanID))
+	m.bytesField(4, hexBytes(s.parentSpanID))
+	m.stringField(5, s.name)
+	m.varintField(6, spanKindInternal)
+	m.fixed64Field(7, uint64(s.start.UnixNano()))
+	m.fixed64Field(8, uint64(s.end.UnixNano()))
+	for _, a := range s.attributes {
+		m.messageField(9, protoAttribute(a))
+	}
+	if s.failed {
+		var status protoMessage
+		status.stringField(2, s.message)
+		status.varintField(3, statusCodeError)
+		m.messageField(15, status)
+	}
+	return m
+}
+
+func protoAttribute(a attribute) protoMessage {
+	var value protoMessage
+	switch v := a.value.(type) {
+	case string:
+		value.tag(1, 2)
+		value.varint(uint64(len(v)))
+		value = append(value, v...)
+	case bool:
+		value.tag(2, 0)
+		if v {
+			value.varint(1)
+		} else {
+			value.varint(0)
+		}
+	case int64:
+		value.tag(3, 0)
+		value.varint(uint64(v))
+	case float64:
+		value.tag(4, 1)
+		value = binary.LittleEndian.AppendUint64(value, math.Float64bits(v))
+	}
+	var kv protoMessage
+	kv.stringField(1, a.key)
+	kv.messageField(2, value)
+	return kv
+}
+
+// hexBytes decodes a hex ID, which is valid by construction
+func hexBytes(s string) []byte {
+	b, _ := hex.DecodeString(s)
+	return b
+}
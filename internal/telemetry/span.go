@@ -0,0 +1,112 @@
+// span.go
+// Identifies spans of a trace and propagates them as W3C trace context.
+package telemetry
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// SpanContext identifies a span within a trace. IDs are lowercase hex strings.
+type SpanContext struct {
+	TraceID string // 32 hex digits
+	SpanID  string // 16 hex digits
+	Sampled bool
+}
+
+// IsValid reports whether both IDs are set
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != "" && sc.SpanID != ""
+}
+
+// Traceparent formats the span context as the value of a W3C traceparent header
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags)
+}
+
+// Child returns the context of a new span in the same trace
+func (sc SpanContext) Child() SpanContext {
+	return SpanContext{TraceID: sc.TraceID, SpanID: NewSpanID(), Sampled: sc.Sampled}
+}
+
+// NewRoot returns the context of a span starting a new trace
+func NewRoot() SpanContext {
+	return SpanContext{TraceID: randomHex(16), SpanID: NewSpanID(), Sampled: true}
+}
+
+// NewRunSpan returns the span of a run and the ID of its parent span: a child of the span of a
+// traceparent, such as the TRACEPARENT of the process running kontraktor, or the root of a new
+// trace if traceparent is empty or invalid
+func NewRunSpan(traceparent string) (SpanContext, string) {
+	parent, err := ParseTraceparent(traceparent)
+	if err != nil {
+		return NewRoot(), ""
+	}
+	return parent.Child(), parent.SpanID
+}
+
+// NewSpanID returns a random span ID
+func NewSpanID() string {
+	return randomHex(8)
+}
+
+// ParseTraceparent parses the value of a W3C traceparent header, such as the TRACEPARENT
+// environment variable set by an instrumented parent process
+func ParseTraceparent(s string) (SpanContext, error) {
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return SpanContext{}, fmt.Errorf("invalid traceparent '%s'", s)
+	}
+	traceID, spanID, flags := parts[1], parts[2], parts[3]
+	if !isHex(traceID, 32) || !isHex(spanID, 16) || !isHex(flags, 2) || allZero(traceID) || allZero(spanID) {
+		return SpanContext{}, fmt.Errorf("invalid traceparent '%s'", s)
+	}
+	b, _ := hex.DecodeString(flags)
+	return SpanContext{TraceID: traceID, SpanID: spanID, Sampled: b[0]&1 == 1}, nil
+}
+
+type spanKey struct{}
+
+// ContextWithSpan returns a context carrying the span context of the current span
+func ContextWithSpan(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanKey{}, sc)
+}
+
+// SpanFromContext returns the span context carried by a context, if any
+func SpanFromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(spanKey{}).(SpanContext)
+	return sc, ok
+}
+
+// randomHex returns n random bytes as hex
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("read random bytes: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
+
+// isHex reports whether s is made of n lowercase hex digits
+func isHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func allZero(s string) bool {
+	return strings.Trim(s, "0") == ""
+}
@@ -0,0 +1,65 @@
+package telemetry
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected SpanContext
+		wantErr  bool
+	}{
+		{
+			name:     "sampled",
+			input:    "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			expected: SpanContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7", Sampled: true},
+		},
+		{
+			name:     "not sampled",
+			input:    "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+			expected: SpanContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7"},
+		},
+		{
+			name:     "later version with more fields",
+			input:    "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+			expected: SpanContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7", Sampled: true},
+		},
+		{name: "empty", input: "", wantErr: true},
+		{name: "uppercase", input: "00-4BF92F3577B34DA6A3CE929D0E0E4736-00F067AA0BA902B7-01", wantErr: true},
+		{name: "zero trace", input: "00-00000000000000000000000000000000-00f067aa0ba902b7-01", wantErr: true},
+		{name: "zero span", input: "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", wantErr: true},
+		{name: "invalid version", input: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", wantErr: true},
+		{name: "extra field in version 00", input: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-x", wantErr: true},
+		{name: "short span", input: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa-01", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, err := ParseTraceparent(tt.input)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, sc)
+		})
+	}
+}
+
+func TestNewRunSpan(t *testing.T) {
+	sc, parent := NewRunSpan("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID)
+	assert.Equal(t, "00f067aa0ba902b7", parent)
+	assert.NotEqual(t, parent, sc.SpanID)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+sc.SpanID+"-01", sc.Traceparent())
+
+	sc, parent = NewRunSpan("")
+	assert.True(t, sc.IsValid())
+	assert.True(t, sc.Sampled)
+	assert.Empty(t, parent)
+	_, err := ParseTraceparent(sc.Traceparent())
+	assert.NoError(t, err)
+}
@@ -0,0 +1,71 @@
+// dynamic.go
+// Variables of vars sections, computed when first read.
+package vars
+
+import "fmt"
+
+// Dynamic is the value of a vars entry. It is computed when first read and cached,
+// so a command producing it runs at most once however many contexts share it.
+type Dynamic struct {
+	Definition string // How the value is computed, e.g. "sh: git rev-parse HEAD"
+
+	compute  func() (string, error)
+	pending  bool
+	computed bool
+	value    string
+	err      error
+}
+
+// NewDynamic creates a variable whose value is computed by compute when first read
+func NewDynamic(definition string, compute func() (string, error)) *Dynamic {
+	return &Dynamic{Definition: definition, compute: compute}
+}
+
+// Value returns the value of the variable, computing it on first use
+func (d *Dynamic) Value() (string, error) {
+	if d.computed {
+		return d.value, d.err
+	}
+	if d.pending {
+		return "", errCircular
+	}
+	d.pending = true
+	value, err := d.compute()
+	d.pending = false
+	d.computed = true
+	d.value, d.err = value, err
+	return value, err
+}
+
+// dynamicError is the failure to compute the value of a dynamic variable
+type dynamicError struct {
+	message string
+}
+
+func (e *dynamicError) Error() string {
+	return e.message
+}
+
+// SetVar sets a variable of a vars section, recording its definition as its value origin
+func (c *Context) SetVar(name string, value *Dynamic, source string) {
+	c.Dynamic[name] = value
+	c.record(name, Origin{Type: TypeVar, Source: source, Value: value.Definition})
+}
+
+// lookupDynamic returns the value of a variable of a vars section
+func (c *Context) lookupDynamic(name string) (string, bool, error) {
+	d, ok := c.Dynamic[name]
+	if !ok {
+		return "", false, nil
+	}
+	value, err := d.Value()
+	if err == nil || err == errCircular {
+		return value, true, err
+	}
+	message := err.Error()
+	if verr, ok := err.(*Error); ok {
+		// Positions in the definition mean nothing where the variable is used
+		message = verr.Message
+	}
+	return "", true, &dynamicError{message: fmt.Sprintf("variable '%s': %s", name, message)}
+}
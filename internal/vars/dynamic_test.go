@@ -0,0 +1,90 @@
+package vars
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDynamic_ComputedOnce(t *testing.T) {
+	calls := 0
+	ctx := NewContext()
+	ctx.SetVar("SHA", NewDynamic("sh: git rev-parse HEAD", func() (string, error) {
+		calls++
+		return "abc123", nil
+	}), "global vars")
+	assert.Equal(t, 0, calls)
+
+	child := ctx.Clone()
+	got, err := ctx.Substitutor.Substitute("${SHA} ${vars.SHA}", ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "abc123 abc123", got)
+	got, err = child.Substitutor.Substitute("${SHA}", child)
+	require.NoError(t, err)
+	assert.Equal(t, "abc123", got)
+	assert.Equal(t, 1, calls)
+}
+
+func TestDynamic_Precedence(t *testing.T) {
+	ctx := NewContext()
+	ctx.SetArg("VERSION", "1.0.0", "command line")
+	ctx.SetVar("VERSION", NewDynamic("file: VERSION", func() (string, error) { return "2.0.0", nil }), "global vars")
+
+	got, err := ctx.Substitutor.Substitute("${VERSION} ${args.VERSION}", ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "2.0.0 1.0.0", got)
+
+	ctx.Precedence = []VariableType{TypeArg, TypeVar}
+	got, err = ctx.Substitutor.Substitute("${VERSION} ${vars.VERSION}", ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "1.0.0 2.0.0", got)
+}
+
+func TestDynamic_Errors(t *testing.T) {
+	ctx := NewContext()
+	ctx.SetVar("BROKEN", NewDynamic("sh: false", func() (string, error) {
+		return "", errors.New("command 'false' failed: exit status 1")
+	}), "global vars")
+	literal := func(name, value string) {
+		ctx.SetVar(name, NewDynamic(value, func() (string, error) {
+			return ctx.Substitutor.Substitute(value, ctx)
+		}), "global vars")
+	}
+	literal("A", "${B}")
+	literal("B", "x${A}")
+	literal("C", "${MISSING}")
+
+	tests := []struct {
+		input   string
+		wantErr string
+	}{
+		{"run ${BROKEN}", "variable 'BROKEN': command 'false' failed: exit status 1 at line 1, column 5"},
+		{"${A}", "variable 'A': variable 'B': circular reference to variable 'A' at line 1, column 1"},
+		{"- ${C}", "variable 'C': undefined variable 'MISSING' at line 1, column 3"},
+		{"${C:-default}", "variable 'C': undefined variable 'MISSING' at line 1, column 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := ctx.Substitutor.Substitute(tt.input, ctx)
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestDynamic_ExplainDoesNotCompute(t *testing.T) {
+	ctx := NewContext()
+	ctx.SetEnvironment("SHA", "override", "global environment")
+	ctx.SetVar("SHA", NewDynamic("sh: git rev-parse HEAD", func() (string, error) {
+		panic("must not be computed")
+	}), "vars of task 'build'")
+
+	used, shadowed := ctx.Explain("SHA")
+	assert.Equal(t, &Origin{Type: TypeEnv, Source: "global environment", Value: "override"}, used)
+	assert.Equal(t, []Origin{{Type: TypeVar, Source: "vars of task 'build'", Value: "sh: git rev-parse HEAD"}}, shadowed)
+
+	ctx.Precedence = []VariableType{TypeVar, TypeEnv}
+	used, _ = ctx.Explain("SHA")
+	assert.Equal(t, &Origin{Type: TypeVar, Source: "vars of task 'build'", Value: "sh: git rev-parse HEAD"}, used)
+}
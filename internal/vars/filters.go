@@ -0,0 +1,85 @@
+// filters.go
+// Filters shaping substituted values, as in ${name | upper}.
+package vars
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// filterSpec defines a filter: its number of arguments and how it transforms a value.
+// Values are strings, or lists for list arguments.
+type filterSpec struct {
+	args  int
+	apply func(value interface{}, args []string) (interface{}, error)
+}
+
+// filters are the filters available in references
+var filters = map[string]filterSpec{
+	"upper": {0, stringFilter(strings.ToUpper)},
+	"lower": {0, stringFilter(strings.ToLower)},
+	"trim":  {0, stringFilter(strings.TrimSpace)},
+	"replace": {2, func(value interface{}, args []string) (interface{}, error) {
+		return strings.ReplaceAll(toString(value), args[0], args[1]), nil
+	}},
+	"base64": {0, stringFilter(func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	})},
+	"sha256": {0, stringFilter(func(s string) string {
+		sum := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(sum[:])
+	})},
+	"json": {0, func(value interface{}, args []string) (interface{}, error) {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		return string(data), nil
+	}},
+	"quote": {0, stringFilter(ShellQuote)},
+	"join": {1, func(value interface{}, args []string) (interface{}, error) {
+		list, ok := value.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("value is not a list")
+		}
+		items := make([]string, len(list))
+		for i, item := range list {
+			items[i] = toString(item)
+		}
+		return strings.Join(items, args[0]), nil
+	}},
+}
+
+// FilterNames returns the names of the available filters, sorted
+func FilterNames() []string {
+	names := make([]string, 0, len(filters))
+	for name := range filters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func stringFilter(fn func(string) string) func(interface{}, []string) (interface{}, error) {
+	return func(value interface{}, args []string) (interface{}, error) {
+		return fn(toString(value)), nil
+	}
+}
+
+// ShellQuote quotes s as a single word for POSIX shells
+func ShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// toString formats a value for insertion into text
+func toString(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	return fmt.Sprintf("%v", value)
+}
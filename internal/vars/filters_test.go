@@ -0,0 +1,91 @@
+package vars
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubstitutor_Operators(t *testing.T) {
+	ctx := NewContext()
+	ctx.Environment["REGION"] = "eu-west"
+	ctx.Environment["EMPTY"] = ""
+	ctx.Args["stage"] = "prod"
+	s := NewSubstitutor()
+
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr string
+	}{
+		{"default unused", "${REGION:-us-east}", "eu-west", ""},
+		{"default for undefined", "${ZONE:-a}", "a", ""},
+		{"default for empty", "${EMPTY:-fallback value}", "fallback value", ""},
+		{"default with reference", "${ZONE:-${REGION}-1}", "eu-west-1", ""},
+		{"default with escape", "${ZONE:-$$HOME}", "$HOME", ""},
+		{"empty default", "[${ZONE:-}]", "[]", ""},
+		{"alternative when set", "${stage:+--prod}", "--prod", ""},
+		{"alternative when unset", "[${debug:+--verbose}]", "[]", ""},
+		{"required and set", "${REGION:?region is required}", "eu-west", ""},
+		{"required and unset", "deploy ${ZONE:?set ZONE first}", "", "variable 'ZONE': set ZONE first at line 1, column 8"},
+		{"required without message", "${EMPTY:?}", "", "variable 'EMPTY': parameter not set or empty at line 1, column 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.Substitute(tt.input, ctx)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestSubstitutor_Filters(t *testing.T) {
+	ctx := NewContext()
+	ctx.Environment["NAME"] = "  Hello World  "
+	ctx.Environment["MESSAGE"] = "it's done"
+	ctx.Args["targets"] = []interface{}{"linux", "darwin", 3}
+	ctx.Args["stage"] = "prod"
+	s := NewSubstitutor()
+
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr string
+	}{
+		{"upper", "${stage | upper}", "PROD", ""},
+		{"lower and trim", "${NAME|trim|lower}", "hello world", ""},
+		{"replace", `${NAME | trim | replace " " "-"}`, "Hello-World", ""},
+		{"replace with single quotes", `${stage | replace 'o' '0'}`, "pr0d", ""},
+		{"base64", "${stage | base64}", "cHJvZA==", ""},
+		{"sha256", "${stage | sha256}", "6754af9632a2745e85c293e5aac0863370d9bd3330b9938c00cadfd215227d77", ""},
+		{"json string", `${MESSAGE | json}`, `"it's done"`, ""},
+		{"json list", "${targets | json}", `["linux","darwin",3]`, ""},
+		{"quote", "echo ${MESSAGE | quote}", `echo 'it'\''s done'`, ""},
+		{"join", `${targets | join ","}`, "linux,darwin,3", ""},
+		{"filter after default", "${ZONE:-eu west | upper}", "EU WEST", ""},
+		{"join needs a list", `${stage | join ","}`, "", `filter 'join' on variable 'stage': value is not a list at line 1, column 11`},
+		{"unknown filter", "${stage | title}", "", "unknown filter 'title' at line 1, column 11"},
+		{"wrong number of arguments", "${stage | replace x}", "", "filter 'replace' takes 2 argument(s), got 1 at line 1, column 11"},
+		{"missing filter name", "${stage | }", "", "expected filter name, found '}' at line 1, column 11"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.Substitute(tt.input, ctx)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,162 @@
+// lexer.go
+// Splits substitution templates into tokens, in text, expression and word modes.
+package vars
+
+import "strings"
+
+// tokenKind identifies the kind of a token
+type tokenKind int
+
+const (
+	tokenEOF      tokenKind = iota
+	tokenText               // Literal text, with $$ escapes already reduced to $
+	tokenOpen               // ${ starting a reference
+	tokenClose              // } ending a reference
+	tokenName               // Run of name characters inside a reference
+	tokenOperator           // :- :? or :+ after a variable name
+	tokenPipe               // | before a filter
+	tokenString             // Quoted filter argument, without quotes
+)
+
+// token is a lexical token with its byte offset in the input
+type token struct {
+	kind   tokenKind
+	value  string
+	offset int
+}
+
+// lexer produces tokens from a template. The parser chooses the mode of each
+// token: text outside references, expression inside them, and word for the
+// operand of an operator.
+type lexer struct {
+	input string
+	pos   int
+}
+
+func newLexer(input string) *lexer {
+	return &lexer{input: input}
+}
+
+// nextText returns the next token outside a reference: text, ${ or EOF.
+// $$ is an escaped $, and the legacy ${$NAME} form is the literal ${NAME}.
+func (l *lexer) nextText() token {
+	start := l.pos
+	if l.pos >= len(l.input) {
+		return token{kind: tokenEOF, offset: l.pos}
+	}
+	if strings.HasPrefix(l.input[l.pos:], "${$") {
+		if end := strings.IndexByte(l.input[l.pos:], '}'); end > 0 {
+			l.pos += end + 1
+			return token{kind: tokenText, value: "${" + l.input[start+3:l.pos-1] + "}", offset: start}
+		}
+	}
+	if strings.HasPrefix(l.input[l.pos:], "${") {
+		l.pos += 2
+		return token{kind: tokenOpen, value: "${", offset: start}
+	}
+	return l.text(start, "")
+}
+
+// nextWord returns the next token of an operator operand: text, ${, |, } or EOF
+func (l *lexer) nextWord() token {
+	start := l.pos
+	if l.pos >= len(l.input) {
+		return token{kind: tokenEOF, offset: l.pos}
+	}
+	switch {
+	case strings.HasPrefix(l.input[l.pos:], "${"):
+		l.pos += 2
+		return token{kind: tokenOpen, value: "${", offset: start}
+	case l.input[l.pos] == '|':
+		l.pos++
+		return token{kind: tokenPipe, value: "|", offset: start}
+	case l.input[l.pos] == '}':
+		l.pos++
+		return token{kind: tokenClose, value: "}", offset: start}
+	}
+	return l.text(start, "|}")
+}
+
+// text scans literal text up to the next ${, a byte of stop or the end of input
+func (l *lexer) text(start int, stop string) token {
+	var text strings.Builder
+	for l.pos < len(l.input) {
+		rest := l.input[l.pos:]
+		if strings.HasPrefix(rest, "$$") {
+			text.WriteByte('$')
+			l.pos += 2
+			continue
+		}
+		if strings.HasPrefix(rest, "${") || strings.IndexByte(stop, rest[0]) >= 0 {
+			break
+		}
+		text.WriteByte(l.input[l.pos])
+		l.pos++
+	}
+	return token{kind: tokenText, value: text.String(), offset: start}
+}
+
+// nextExpr returns the next token inside a reference: a name, ${, }, an operator,
+// a pipe, a quoted string or EOF. Spaces are skipped; any other character is
+// returned as a single-character text token. An unterminated string is EOF.
+func (l *lexer) nextExpr() token {
+	for l.pos < len(l.input) && (l.input[l.pos] == ' ' || l.input[l.pos] == '\t') {
+		l.pos++
+	}
+	start := l.pos
+	if l.pos >= len(l.input) {
+		return token{kind: tokenEOF, offset: l.pos}
+	}
+	rest := l.input[l.pos:]
+	switch {
+	case strings.HasPrefix(rest, "${"):
+		l.pos += 2
+		return token{kind: tokenOpen, value: "${", offset: start}
+	case rest[0] == '}':
+		l.pos++
+		return token{kind: tokenClose, value: "}", offset: start}
+	case rest[0] == '|':
+		l.pos++
+		return token{kind: tokenPipe, value: "|", offset: start}
+	case strings.HasPrefix(rest, ":-"), strings.HasPrefix(rest, ":?"), strings.HasPrefix(rest, ":+"):
+		l.pos += 2
+		return token{kind: tokenOperator, value: rest[:2], offset: start}
+	case rest[0] == '"' || rest[0] == '\'':
+		return l.quoted(start)
+	}
+	for l.pos < len(l.input) && isNameChar(l.input[l.pos]) {
+		l.pos++
+	}
+	if l.pos == start {
+		l.pos++
+		return token{kind: tokenText, value: l.input[start:l.pos], offset: start}
+	}
+	return token{kind: tokenName, value: l.input[start:l.pos], offset: start}
+}
+
+// quoted scans a single or double quoted string; double quoted strings accept \" and \\ escapes
+func (l *lexer) quoted(start int) token {
+	quote := l.input[l.pos]
+	l.pos++
+	var value strings.Builder
+	for l.pos < len(l.input) {
+		c := l.input[l.pos]
+		switch {
+		case c == quote:
+			l.pos++
+			return token{kind: tokenString, value: value.String(), offset: start}
+		case c == '\\' && quote == '"' && l.pos+1 < len(l.input):
+			value.WriteByte(l.input[l.pos+1])
+			l.pos += 2
+		default:
+			value.WriteByte(c)
+			l.pos++
+		}
+	}
+	return token{kind: tokenEOF, offset: l.pos}
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || c == '.' || c == '-' ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
@@ -1,8 +1,9 @@
 package vars
 
 import (
+	"errors"
 	"fmt"
-	"regexp"
+	"sort"
 	"strings"
 )
 
@@ -13,6 +14,9 @@ const (
 	TypeEnv     VariableType = "env"     // Environment variables
 	TypeSecret  VariableType = "secret"  // Vault secrets
 	TypeArg     VariableType = "arg"     // Task arguments
+	TypeVar     VariableType = "var"     // Variables of vars sections
+	TypeHost    VariableType = "host"    // Environment of the kontraktor process
+	TypeBuiltin VariableType = "builtin" // Task and run information
 	TypeUnknown VariableType = "unknown" // Unknown type
 )
 
@@ -23,12 +27,26 @@ type Variable struct {
 	Value string
 }
 
+// Origin records a value offered for a variable and where it came from
+type Origin struct {
+	Type   VariableType
+	Source string // e.g. "global environment", "command line argument"
+	Value  string
+}
+
 // Context holds all available variables for substitution
 type Context struct {
-	Environment map[string]string      // Environment variables
-	Secrets     map[string]string      // Vault secrets
-	Args        map[string]interface{} // Task arguments
-	Substitutor *Substitutor           // Variable substitutor
+	Environment map[string]string           // Environment variables
+	Secrets     map[string]string           // Vault secrets
+	Undeclared  map[string]bool             // Secrets of vaults the task did not declare: references to them are errors
+	Args        map[string]interface{}      // Task arguments
+	Dynamic     map[string]*Dynamic         // Variables of vars sections, computed when first read
+	Origins     map[string][]Origin         // Values offered for each variable, in the order they were set
+	Precedence  []VariableType              // Lookup order of bare names, DefaultPrecedence when empty
+	Task        TaskInfo                    // Values of task.* references
+	Run         RunInfo                     // Values of run.* references
+	LookupHost  func(string) (string, bool) // Values of host.* references, os.LookupEnv when nil
+	Substitutor *Substitutor                // Variable substitutor
 }
 
 // NewContext creates a new variable context
@@ -37,98 +55,417 @@ func NewContext() *Context {
 		Environment: make(map[string]string),
 		Secrets:     make(map[string]string),
 		Args:        make(map[string]interface{}),
+		Dynamic:     make(map[string]*Dynamic),
+		Origins:     make(map[string][]Origin),
 		Substitutor: NewSubstitutor(),
 	}
 }
 
-// GetVariable retrieves a variable by name, checking all sources
-func (c *Context) GetVariable(name string) (*Variable, error) {
-	// Check environment variables
-	if value, ok := c.Environment[name]; ok {
-		return &Variable{Type: TypeEnv, Name: name, Value: value}, nil
+// SetEnvironment sets an environment variable, recording where its value came from
+func (c *Context) SetEnvironment(name, value, source string) {
+	c.Environment[name] = value
+	c.record(name, Origin{Type: TypeEnv, Source: source, Value: value})
+}
+
+// SetSecret sets a secret, recording where its value came from
+func (c *Context) SetSecret(name, value, source string) {
+	c.Secrets[name] = value
+	c.record(name, Origin{Type: TypeSecret, Source: source, Value: value})
+}
+
+// ClearSecrets removes all secrets, with their origins
+func (c *Context) ClearSecrets() {
+	c.Secrets = make(map[string]string)
+	for name, origins := range c.Origins {
+		var kept []Origin
+		for _, origin := range origins {
+			if origin.Type != TypeSecret {
+				kept = append(kept, origin)
+			}
+		}
+		if len(kept) == 0 {
+			delete(c.Origins, name)
+		} else {
+			c.Origins[name] = kept
+		}
 	}
+}
 
-	// Check secrets
-	if value, ok := c.Secrets[name]; ok {
-		return &Variable{Type: TypeSecret, Name: name, Value: value}, nil
+// SetArg sets a task argument, recording where its value came from
+func (c *Context) SetArg(name string, value interface{}, source string) {
+	c.Args[name] = value
+	c.record(name, Origin{Type: TypeArg, Source: source, Value: fmt.Sprintf("%v", value)})
+}
+
+func (c *Context) record(name string, origin Origin) {
+	if c.Origins == nil {
+		c.Origins = make(map[string][]Origin)
 	}
+	c.Origins[name] = append(c.Origins[name], origin)
+}
 
-	// Check arguments
-	if value, ok := c.Args[name]; ok {
-		// Convert argument value to string
-		strValue := fmt.Sprintf("%v", value)
-		return &Variable{Type: TypeArg, Name: name, Value: strValue}, nil
+// Names returns the names of all variables in the context, sorted
+func (c *Context) Names() []string {
+	seen := make(map[string]bool)
+	var names []string
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	for name := range c.Environment {
+		add(name)
+	}
+	for name := range c.Secrets {
+		add(name)
+	}
+	for name := range c.Args {
+		add(name)
 	}
+	for name := range c.Dynamic {
+		add(name)
+	}
+	sort.Strings(names)
+	return names
+}
 
-	return nil, fmt.Errorf("variable '%s' not found", name)
+// Explain returns the origin of the value GetVariable resolves for name, and the
+// origins whose values were overridden or shadowed by it, most recent first.
+// The used origin is nil when the value was set without recording its source,
+// or when the name is not visible as a bare name with the configured precedence.
+// Variables of vars sections are not computed: their origin value is their definition.
+func (c *Context) Explain(name string) (*Origin, []Origin) {
+	origins := c.Origins[name]
+	var used *Origin
+	var shadowed []Origin
+	t, value, ok := c.recorded(name)
+	for i := len(origins) - 1; i >= 0; i-- {
+		if ok && used == nil && origins[i].Type == t && origins[i].Value == value {
+			used = &origins[i]
+			continue
+		}
+		shadowed = append(shadowed, origins[i])
+	}
+	return used, shadowed
 }
 
-// Substitutor handles variable substitution
-type Substitutor struct {
-	varRegex *regexp.Regexp
+// recorded returns the type of the source a bare name resolves to and its value as
+// recorded in origins, without computing dynamic variables
+func (c *Context) recorded(name string) (VariableType, string, bool) {
+	for _, t := range c.precedence() {
+		if t == TypeVar {
+			if d, ok := c.Dynamic[name]; ok {
+				return t, d.Definition, true
+			}
+			continue
+		}
+		if value, ok, _ := c.lookup(t, name); ok {
+			return t, value, true
+		}
+	}
+	return TypeUnknown, "", false
 }
 
+// Clone returns a copy of the context sharing its substitutor
+func (c *Context) Clone() *Context {
+	clone := NewContext()
+	clone.Substitutor = c.Substitutor
+	clone.Precedence = c.Precedence
+	clone.Task = c.Task
+	clone.Run = c.Run
+	clone.LookupHost = c.LookupHost
+	for k, v := range c.Environment {
+		clone.Environment[k] = v
+	}
+	for k, v := range c.Secrets {
+		clone.Secrets[k] = v
+	}
+	for k, v := range c.Undeclared {
+		if clone.Undeclared == nil {
+			clone.Undeclared = make(map[string]bool)
+		}
+		clone.Undeclared[k] = v
+	}
+	for k, v := range c.Args {
+		clone.Args[k] = v
+	}
+	for k, v := range c.Dynamic {
+		clone.Dynamic[k] = v
+	}
+	for k, v := range c.Origins {
+		clone.Origins[k] = append([]Origin(nil), v...)
+	}
+	return clone
+}
+
+// GetVariable retrieves a variable by name. Qualified names such as env.HOME are looked
+// up in their namespace only; bare names are looked up in the order of Precedence.
+// Variables of vars sections are computed on first use, which may fail.
+func (c *Context) GetVariable(name string) (*Variable, error) {
+	variable, err := c.find(name)
+	if err != nil {
+		return nil, err
+	}
+	if variable == nil {
+		return nil, fmt.Errorf("variable '%s' not found", name)
+	}
+	return variable, nil
+}
+
+// find looks up a variable like GetVariable, returning nil without error when it is undefined
+func (c *Context) find(name string) (*Variable, error) {
+	if ns, key := SplitName(name); ns != "" {
+		return c.lookupNamespaced(ns, key)
+	}
+	for _, t := range c.precedence() {
+		value, ok, err := c.lookup(t, name)
+		if ok {
+			return &Variable{Type: t, Name: name, Value: value}, err
+		}
+	}
+	return nil, nil
+}
+
+// precedence returns the lookup order of bare names
+func (c *Context) precedence() []VariableType {
+	if len(c.Precedence) == 0 {
+		return DefaultPrecedence
+	}
+	return c.Precedence
+}
+
+// Substitutor handles variable substitution
+type Substitutor struct{}
+
 // NewSubstitutor creates a new variable substitutor
 func NewSubstitutor() *Substitutor {
-	return &Substitutor{
-		varRegex: regexp.MustCompile(`\${([^}]+)}`),
-	}
+	return &Substitutor{}
 }
 
-// Substitute performs variable substitution using the context
+// lookupFunc returns the value of a variable and whether it is defined.
+// Values are strings, or lists for list arguments.
+type lookupFunc func(name string) (interface{}, bool, error)
+
+// errCircular is returned by lookups of a variable whose value refers back to itself
+var errCircular = errors.New("circular reference")
+
+// Substitute performs variable substitution using the context.
+// $$ produces a literal $. Values are inserted verbatim: references inside them,
+// for example in secrets, are never expanded.
 func (s *Substitutor) Substitute(input string, ctx *Context) (string, error) {
-	var err error
-	result := input
+	segments, err := parse(input)
+	if err != nil {
+		return "", err
+	}
+	return evaluate(segments, input, ctx.lookupValue)
+}
+
+// Check reports syntax errors in input without substituting it
+func (s *Substitutor) Check(input string) error {
+	_, err := parse(input)
+	return err
+}
 
-	// Keep substituting until no more variables are found
-	for {
-		// Check if there are any variables to substitute
-		if !s.varRegex.MatchString(result) {
-			break
+// evaluate concatenates the text of segments and the values of their references
+func evaluate(segments []segment, input string, lookup lookupFunc) (string, error) {
+	var out strings.Builder
+	for _, seg := range segments {
+		if seg.ref == nil {
+			out.WriteString(seg.text)
+			continue
 		}
+		value, err := evaluateReference(seg.ref, input, lookup)
+		if err != nil {
+			return "", err
+		}
+		out.WriteString(toString(value))
+	}
+	return out.String(), nil
+}
 
-		// Replace all variables in the current iteration
-		result = s.varRegex.ReplaceAllStringFunc(result, func(match string) string {
-			// Extract variable name from ${VAR_NAME}
-			varName := match[2 : len(match)-1]
+// evaluateReference returns the value of a reference after its operator and filters
+func evaluateReference(ref *reference, input string, lookup lookupFunc) (interface{}, error) {
+	refError := func(name, message string) *Error {
+		return &Error{Name: name, Input: input, Offset: ref.offset, Length: ref.length, Message: message}
+	}
 
-			// Handle escaped variables
-			if strings.HasPrefix(varName, "$") {
-				return "${" + varName[1:] + "}"
-			}
+	name, err := evaluate(ref.name, input, lookup)
+	if err != nil {
+		return nil, err
+	}
+	value, ok, err := lookup(name)
+	if errors.Is(err, errCircular) {
+		return nil, refError(name, fmt.Sprintf("circular reference to variable '%s'", name))
+	}
+	var derr *dynamicError
+	var uerr *undeclaredError
+	if errors.As(err, &derr) {
+		return nil, refError(name, derr.Error())
+	}
+	if errors.As(err, &uerr) {
+		return nil, refError(name, uerr.Error())
+	}
+	if err != nil {
+		return nil, err
+	}
 
-			// Get variable from context
-			variable, err := ctx.GetVariable(varName)
+	set := ok && toString(value) != ""
+	switch ref.op {
+	case ":-":
+		if !set {
+			if value, err = evaluate(ref.operand, input, lookup); err != nil {
+				return nil, err
+			}
+		}
+	case ":?":
+		if !set {
+			message, err := evaluate(ref.operand, input, lookup)
 			if err != nil {
-				return match // Return original if not found
+				return nil, err
 			}
+			if message == "" {
+				message = "parameter not set or empty"
+			}
+			return nil, refError(name, fmt.Sprintf("variable '%s': %s", name, message))
+		}
+	case ":+":
+		value = ""
+		if set {
+			if value, err = evaluate(ref.operand, input, lookup); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		if !ok {
+			return nil, refError(name, fmt.Sprintf("undefined variable '%s'", name))
+		}
+	}
 
-			return variable.Value
-		})
-
-		// If no changes were made in this iteration, break
-		if result == input {
-			break
+	for _, f := range ref.filters {
+		if value, err = filters[f.name].apply(value, f.args); err != nil {
+			return nil, &Error{Name: name, Input: input, Offset: f.offset, Length: f.length,
+				Message: fmt.Sprintf("filter '%s' on variable '%s': %v", f.name, name, err)}
 		}
 	}
+	return value, nil
+}
 
-	// Check if there are any remaining unsubstituted variables
-	if s.varRegex.MatchString(result) {
-		return "", fmt.Errorf("undefined variables found in: %s", result)
+// lookupValue returns the value of a variable, keeping list arguments as lists
+func (c *Context) lookupValue(name string) (interface{}, bool, error) {
+	variable, err := c.find(name)
+	if variable == nil && err == nil {
+		return nil, false, c.undeclared(name)
+	}
+	if variable == nil || err != nil {
+		return nil, variable != nil, err
+	}
+	if variable.Type == TypeArg {
+		_, key := SplitName(name)
+		return c.Args[key], true, nil
 	}
+	return variable.Value, true, nil
+}
 
-	return result, err
+// Reference represents a variable reference found in a string
+type Reference struct {
+	Name     string // Variable name between the braces
+	Offset   int    // Byte offset of the opening "${" in the input
+	Length   int    // Length of the whole reference including braces
+	Optional bool   // The reference has an operator handling undefined variables
 }
 
-// SubstituteMap performs variable substitution on a map of strings
+// FindReferences returns all unescaped variable references in the input.
+// References whose name is built from nested references are not returned, the nested ones are.
+// Nothing is returned for input with syntax errors, see Check.
+func (s *Substitutor) FindReferences(input string) []Reference {
+	segments, err := parse(input)
+	if err != nil {
+		return nil
+	}
+	var refs []Reference
+	var walk func([]segment)
+	walk = func(segments []segment) {
+		for _, seg := range segments {
+			if seg.ref == nil {
+				continue
+			}
+			if name, ok := seg.ref.staticName(); ok {
+				refs = append(refs, Reference{Name: name, Offset: seg.ref.offset, Length: seg.ref.length, Optional: seg.ref.op != ""})
+			} else {
+				walk(seg.ref.name)
+			}
+			walk(seg.ref.operand)
+		}
+	}
+	walk(segments)
+	return refs
+}
+
+// SubstituteMap performs variable substitution on a map of strings, such as an environment.
+// Entries may refer to each other in any order; an entry referring to its own name gets the
+// value the context had before.
 func (s *Substitutor) SubstituteMap(input map[string]string, ctx *Context) (map[string]string, error) {
-	result := make(map[string]string)
+	overlay := ctx.Clone()
 	for k, v := range input {
-		substituted, err := s.Substitute(v, ctx)
+		overlay.Environment[k] = v
+	}
+
+	result := make(map[string]string)
+	var stack []string
+	var resolve func(key string) (string, error)
+	lookup := func(name string) (interface{}, bool, error) {
+		variable, err := overlay.find(name)
+		if variable == nil && err == nil {
+			return nil, false, overlay.undeclared(name)
+		}
+		if variable == nil || err != nil {
+			return nil, variable != nil, err
+		}
+		ns, key := SplitName(name)
+		if _, ok := input[key]; !ok || variable.Type != TypeEnv || (ns != "" && ns != NamespaceEnv) {
+			return overlay.lookupValue(name)
+		}
+		for i, pending := range stack {
+			if pending != key {
+				continue
+			}
+			if i == len(stack)-1 {
+				// Self reference: the value being overridden
+				return ctx.lookupValue(name)
+			}
+			return nil, false, errCircular
+		}
+		value, err := resolve(key)
+		return value, true, err
+	}
+	resolve = func(key string) (string, error) {
+		if value, ok := result[key]; ok {
+			return value, nil
+		}
+		segments, err := parse(input[key])
 		if err != nil {
-			return nil, fmt.Errorf("failed to substitute in value for '%s': %w", k, err)
+			return "", fmt.Errorf("failed to substitute in value for '%s': %w", key, err)
+		}
+		stack = append(stack, key)
+		value, err := evaluate(segments, input[key], lookup)
+		stack = stack[:len(stack)-1]
+		if err != nil {
+			return "", fmt.Errorf("failed to substitute in value for '%s': %w", key, err)
+		}
+		result[key] = value
+		return value, nil
+	}
+
+	keys := make([]string, 0, len(input))
+	for k := range input {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if _, err := resolve(k); err != nil {
+			return nil, err
 		}
-		result[k] = substituted
 	}
 	return result, nil
 }
@@ -0,0 +1,66 @@
+package vars
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContext_Explain(t *testing.T) {
+	ctx := NewContext()
+	ctx.SetArg("NAME", "from-arg", "command line")
+	ctx.SetSecret("NAME", "from-vault", "vault azure_keyvault.main")
+	ctx.SetEnvironment("NAME", "global", "global environment")
+	ctx.SetEnvironment("NAME", "task", "environment of task 'build'")
+	ctx.SetArg("target", 42, "default of task 'build'")
+
+	tests := []struct {
+		name         string
+		variable     string
+		wantUsed     *Origin
+		wantShadowed []Origin
+	}{
+		{
+			name:     "environment shadows secrets and arguments",
+			variable: "NAME",
+			wantUsed: &Origin{Type: TypeEnv, Source: "environment of task 'build'", Value: "task"},
+			wantShadowed: []Origin{
+				{Type: TypeEnv, Source: "global environment", Value: "global"},
+				{Type: TypeSecret, Source: "vault azure_keyvault.main", Value: "from-vault"},
+				{Type: TypeArg, Source: "command line", Value: "from-arg"},
+			},
+		},
+		{
+			name:     "single origin",
+			variable: "target",
+			wantUsed: &Origin{Type: TypeArg, Source: "default of task 'build'", Value: "42"},
+		},
+		{
+			name:     "unknown variable",
+			variable: "MISSING",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			used, shadowed := ctx.Explain(tt.variable)
+			assert.Equal(t, tt.wantUsed, used)
+			assert.Equal(t, tt.wantShadowed, shadowed)
+		})
+	}
+}
+
+func TestContext_Clone(t *testing.T) {
+	ctx := NewContext()
+	ctx.SetEnvironment("A", "1", "global environment")
+
+	clone := ctx.Clone()
+	clone.SetEnvironment("A", "2", "environment of task 'build'")
+	clone.SetArg("b", true, "command line")
+
+	assert.Equal(t, "1", ctx.Environment["A"])
+	assert.Len(t, ctx.Origins["A"], 1)
+	assert.Equal(t, []string{"A"}, ctx.Names())
+	assert.Equal(t, []string{"A", "b"}, clone.Names())
+	assert.Len(t, clone.Origins["A"], 2)
+}
@@ -0,0 +1,186 @@
+// namespace.go
+// Namespaced variable references such as ${env.HOME} and the precedence of bare names.
+package vars
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Namespaces of qualified variable references
+const (
+	NamespaceEnv     = "env"     // Environment variables of the taskfile
+	NamespaceArgs    = "args"    // Task arguments
+	NamespaceVars    = "vars"    // Variables of vars sections
+	NamespaceSecrets = "secrets" // Vault secrets
+	NamespaceHost    = "host"    // Environment of the kontraktor process
+	NamespaceTask    = "task"    // name and dir of the running task
+	NamespaceRun     = "run"     // id and timestamp of the current run
+)
+
+// Builtins lists the names defined in the task and run namespaces
+var Builtins = map[string][]string{
+	NamespaceTask: {"name", "dir"},
+	NamespaceRun:  {"id", "timestamp"},
+}
+
+// DefaultPrecedence is the order in which bare variable names are looked up
+var DefaultPrecedence = []VariableType{TypeEnv, TypeVar, TypeSecret, TypeArg}
+
+// TaskInfo describes the task a context belongs to
+type TaskInfo struct {
+	Name string
+	Dir  string // Directory of the taskfile defining the task
+}
+
+// RunInfo describes the run a context belongs to
+type RunInfo struct {
+	ID        string
+	Timestamp time.Time
+}
+
+// namespaceTypes maps the namespaces of variable sources to their type
+var namespaceTypes = map[string]VariableType{
+	NamespaceEnv:     TypeEnv,
+	NamespaceArgs:    TypeArg,
+	NamespaceVars:    TypeVar,
+	NamespaceSecrets: TypeSecret,
+}
+
+// SplitName splits a variable reference into its namespace and name.
+// The namespace is empty for bare names.
+func SplitName(ref string) (string, string) {
+	if ns, name, ok := strings.Cut(ref, "."); ok {
+		switch ns {
+		case NamespaceEnv, NamespaceArgs, NamespaceVars, NamespaceSecrets, NamespaceHost, NamespaceTask, NamespaceRun:
+			return ns, name
+		}
+	}
+	return "", ref
+}
+
+// NamespaceType returns the variable type looked up by a namespace, or TypeUnknown
+// for namespaces that are not variable sources
+func NamespaceType(ns string) VariableType {
+	if t, ok := namespaceTypes[ns]; ok {
+		return t
+	}
+	return TypeUnknown
+}
+
+// IsBuiltin reports whether name is defined in the task or run namespace
+func IsBuiltin(ns, name string) bool {
+	for _, builtin := range Builtins[ns] {
+		if builtin == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ParsePrecedence parses the order in which bare names are looked up, given as
+// namespace names (env, vars, secrets, args). Sources left out can only be read with
+// their namespace. An empty list keeps DefaultPrecedence.
+func ParsePrecedence(namespaces []string) ([]VariableType, error) {
+	seen := make(map[VariableType]bool)
+	precedence := make([]VariableType, 0, len(namespaces))
+	for _, ns := range namespaces {
+		t := NamespaceType(ns)
+		if t == TypeUnknown {
+			return nil, fmt.Errorf("invalid precedence entry '%s' (expected %s, %s, %s or %s)", ns, NamespaceEnv, NamespaceVars, NamespaceSecrets, NamespaceArgs)
+		}
+		if seen[t] {
+			return nil, fmt.Errorf("duplicate precedence entry '%s'", ns)
+		}
+		seen[t] = true
+		precedence = append(precedence, t)
+	}
+	return precedence, nil
+}
+
+// lookup returns the value of name in the source of type t.
+// Only computing the value of a dynamic variable can fail.
+func (c *Context) lookup(t VariableType, name string) (string, bool, error) {
+	switch t {
+	case TypeEnv:
+		value, ok := c.Environment[name]
+		return value, ok, nil
+	case TypeVar:
+		return c.lookupDynamic(name)
+	case TypeSecret:
+		value, ok := c.Secrets[name]
+		return value, ok, nil
+	case TypeArg:
+		if value, ok := c.Args[name]; ok {
+			// Convert argument value to string
+			return fmt.Sprintf("%v", value), true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// undeclaredError is a reference to a secret the task did not declare
+type undeclaredError struct {
+	name string
+}
+
+func (e *undeclaredError) Error() string {
+	return fmt.Sprintf("undeclared secret '%s'", e.name)
+}
+
+// undeclared returns an error if an undefined reference names a secret the task did not declare
+func (c *Context) undeclared(name string) error {
+	ns, key := SplitName(name)
+	if !c.Undeclared[key] {
+		return nil
+	}
+	if ns == NamespaceSecrets {
+		return &undeclaredError{name: key}
+	}
+	if ns != "" {
+		return nil
+	}
+	for _, t := range c.precedence() {
+		if t == TypeSecret {
+			return &undeclaredError{name: key}
+		}
+	}
+	return nil
+}
+
+// lookupNamespaced returns the value of a qualified reference, or nil when it is undefined
+func (c *Context) lookupNamespaced(ns, name string) (*Variable, error) {
+	ref := ns + "." + name
+	switch ns {
+	case NamespaceHost:
+		lookupHost := c.LookupHost
+		if lookupHost == nil {
+			lookupHost = os.LookupEnv
+		}
+		if value, ok := lookupHost(name); ok {
+			return &Variable{Type: TypeHost, Name: ref, Value: value}, nil
+		}
+	case NamespaceTask:
+		switch name {
+		case "name":
+			return &Variable{Type: TypeBuiltin, Name: ref, Value: c.Task.Name}, nil
+		case "dir":
+			return &Variable{Type: TypeBuiltin, Name: ref, Value: c.Task.Dir}, nil
+		}
+	case NamespaceRun:
+		switch name {
+		case "id":
+			return &Variable{Type: TypeBuiltin, Name: ref, Value: c.Run.ID}, nil
+		case "timestamp":
+			return &Variable{Type: TypeBuiltin, Name: ref, Value: c.Run.Timestamp.UTC().Format(time.RFC3339)}, nil
+		}
+	default:
+		t := NamespaceType(ns)
+		if value, ok, err := c.lookup(t, name); ok {
+			return &Variable{Type: t, Name: ref, Value: value}, err
+		}
+	}
+	return nil, nil
+}
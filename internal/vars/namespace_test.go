@@ -0,0 +1,137 @@
+package vars
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContext_GetVariable_Namespaces(t *testing.T) {
+	ctx := NewContext()
+	ctx.Environment["token"] = "from-env"
+	ctx.Secrets["token"] = "from-vault"
+	ctx.Args["token"] = "from-arg"
+	ctx.Args["count"] = 3
+	ctx.Task = TaskInfo{Name: "build", Dir: "/src"}
+	ctx.Run = RunInfo{ID: "abc123", Timestamp: time.Date(2024, 5, 1, 12, 0, 0, 0, time.FixedZone("CET", 3600))}
+	ctx.LookupHost = func(name string) (string, bool) {
+		if name == "HOME" {
+			return "/home/me", true
+		}
+		return "", false
+	}
+
+	tests := []struct {
+		name     string
+		ref      string
+		wantType VariableType
+		want     string
+		wantErr  bool
+	}{
+		{"bare name uses default precedence", "token", TypeEnv, "from-env", false},
+		{"environment namespace", "env.token", TypeEnv, "from-env", false},
+		{"secrets namespace", "secrets.token", TypeSecret, "from-vault", false},
+		{"args namespace", "args.token", TypeArg, "from-arg", false},
+		{"args namespace formats values", "args.count", TypeArg, "3", false},
+		{"host namespace", "host.HOME", TypeHost, "/home/me", false},
+		{"task name", "task.name", TypeBuiltin, "build", false},
+		{"task dir", "task.dir", TypeBuiltin, "/src", false},
+		{"run id", "run.id", TypeBuiltin, "abc123", false},
+		{"run timestamp", "run.timestamp", TypeBuiltin, "2024-05-01T11:00:00Z", false},
+		{"namespace does not fall back", "env.count", "", "", true},
+		{"undefined host variable", "host.MISSING", "", "", true},
+		{"unknown builtin", "task.owner", "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			variable, err := ctx.GetVariable(tt.ref)
+			if tt.wantErr {
+				assert.EqualError(t, err, "variable '"+tt.ref+"' not found")
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantType, variable.Type)
+			assert.Equal(t, tt.want, variable.Value)
+		})
+	}
+}
+
+func TestContext_GetVariable_Precedence(t *testing.T) {
+	ctx := NewContext()
+	ctx.Environment["token"] = "from-env"
+	ctx.Args["token"] = "from-arg"
+	ctx.Environment["HOME_DIR"] = "/home"
+
+	ctx.Precedence = []VariableType{TypeArg, TypeSecret}
+	variable, err := ctx.GetVariable("token")
+	require.NoError(t, err)
+	assert.Equal(t, "from-arg", variable.Value)
+
+	_, err = ctx.GetVariable("HOME_DIR")
+	assert.Error(t, err, "sources left out of the precedence are only visible with a namespace")
+	variable, err = ctx.GetVariable("env.HOME_DIR")
+	require.NoError(t, err)
+	assert.Equal(t, "/home", variable.Value)
+
+	out, err := ctx.Substitutor.Substitute("${token} ${env.token}", ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "from-arg from-env", out)
+}
+
+func TestContext_Undeclared(t *testing.T) {
+	ctx := NewContext()
+	ctx.Environment["TOKEN"] = "from-env"
+	ctx.Undeclared = map[string]bool{"API_KEY": true, "TOKEN": true}
+
+	tests := []struct {
+		input   string
+		want    string
+		wantErr string
+	}{
+		{"${API_KEY}", "", "undeclared secret 'API_KEY' at line 1, column 1"},
+		{"${secrets.API_KEY:-none}", "", "undeclared secret 'API_KEY' at line 1, column 1"},
+		{"${TOKEN}", "from-env", ""},
+		{"${env.API_KEY:-none}", "none", ""},
+		{"${OTHER:-none}", "none", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			out, err := ctx.Substitutor.Substitute(tt.input, ctx)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, out)
+		})
+	}
+}
+
+func TestParsePrecedence(t *testing.T) {
+	tests := []struct {
+		name       string
+		namespaces []string
+		want       []VariableType
+		wantErr    string
+	}{
+		{"all sources", []string{"args", "env", "secrets"}, []VariableType{TypeArg, TypeEnv, TypeSecret}, ""},
+		{"subset", []string{"args"}, []VariableType{TypeArg}, ""},
+		{"unknown source", []string{"env", "host"}, nil, "invalid precedence entry 'host' (expected env, vars, secrets or args)"},
+		{"duplicate source", []string{"env", "env"}, nil, "duplicate precedence entry 'env'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePrecedence(tt.namespaces)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
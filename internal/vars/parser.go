@@ -0,0 +1,215 @@
+// parser.go
+// Parses substitution templates into segments of literal text and variable references.
+package vars
+
+import (
+	"fmt"
+	"strings"
+)
+
+// segment is a piece of a parsed template: literal text or a variable reference
+type segment struct {
+	text string
+	ref  *reference
+}
+
+// reference is a ${...} expression
+type reference struct {
+	name    []segment // Pieces of the name; nested references build names dynamically
+	op      string    // Operator applied when the variable is unset or empty: :- :? :+
+	operand []segment // Word following the operator
+	filters []filter  // Filters applied to the value, in order
+	offset  int       // Byte offset of ${ in the input
+	length  int       // Length of the whole reference including braces
+}
+
+// filter is a | filter of a reference with its literal arguments
+type filter struct {
+	name   string
+	args   []string
+	offset int
+	length int
+}
+
+// staticName returns the name of a reference without nested references
+func (r *reference) staticName() (string, bool) {
+	var name strings.Builder
+	for _, part := range r.name {
+		if part.ref != nil {
+			return "", false
+		}
+		name.WriteString(part.text)
+	}
+	return name.String(), true
+}
+
+// Error is a substitution error at a position of the input
+type Error struct {
+	Name    string // Variable concerned, empty for syntax errors
+	Input   string // Text being substituted
+	Offset  int    // Byte offset of the error in Input
+	Length  int    // Length of the offending text
+	Message string
+}
+
+// Position returns the 1-based line and column of the error in Input
+func (e *Error) Position() (int, int) {
+	before := e.Input[:e.Offset]
+	line := strings.Count(before, "\n") + 1
+	column := len(before) - strings.LastIndex(before, "\n")
+	return line, column
+}
+
+func (e *Error) Error() string {
+	line, column := e.Position()
+	return fmt.Sprintf("%s at line %d, column %d", e.Message, line, column)
+}
+
+// parser builds segments from the tokens of a template
+type parser struct {
+	lex *lexer
+}
+
+// parse parses a whole template
+func parse(input string) ([]segment, error) {
+	p := &parser{lex: newLexer(input)}
+	var segments []segment
+	for {
+		tok := p.lex.nextText()
+		switch tok.kind {
+		case tokenEOF:
+			return segments, nil
+		case tokenText:
+			segments = append(segments, segment{text: tok.value})
+		case tokenOpen:
+			ref, err := p.parseReference(tok)
+			if err != nil {
+				return nil, err
+			}
+			segments = append(segments, segment{ref: ref})
+		}
+	}
+}
+
+// parseReference parses a reference after its opening ${
+func (p *parser) parseReference(open token) (*reference, error) {
+	ref := &reference{offset: open.offset}
+	end := -1 // End offset of the previous name piece
+	for {
+		tok := p.lex.nextExpr()
+		switch tok.kind {
+		case tokenEOF:
+			return nil, p.errorAt(open.offset, 2, "unterminated variable reference")
+		case tokenClose, tokenOperator, tokenPipe:
+			if len(ref.name) == 0 {
+				return nil, p.errorAt(open.offset, tok.offset+len(tok.value)-open.offset, "empty variable reference")
+			}
+			if tok.kind == tokenOperator {
+				ref.op = tok.value
+				operand, next, err := p.parseOperand(open)
+				if err != nil {
+					return nil, err
+				}
+				ref.operand = operand
+				tok = next
+			}
+			if tok.kind == tokenPipe {
+				next, err := p.parseFilters(ref, open)
+				if err != nil {
+					return nil, err
+				}
+				tok = next
+			}
+			ref.length = tok.offset + 1 - open.offset
+			return ref, nil
+		case tokenName, tokenOpen:
+			if end >= 0 && tok.offset != end {
+				return nil, p.errorAt(tok.offset, len(tok.value), fmt.Sprintf("unexpected '%s' in variable reference", tok.value))
+			}
+			if tok.kind == tokenName {
+				ref.name = append(ref.name, segment{text: tok.value})
+				end = tok.offset + len(tok.value)
+				continue
+			}
+			nested, err := p.parseReference(tok)
+			if err != nil {
+				return nil, err
+			}
+			ref.name = append(ref.name, segment{ref: nested})
+			end = nested.offset + nested.length
+		default:
+			return nil, p.errorAt(tok.offset, len(tok.value), fmt.Sprintf("unexpected '%s' in variable reference", tok.value))
+		}
+	}
+}
+
+// parseOperand parses the word after an operator, up to a pipe or the closing brace,
+// which is returned. Spaces before a pipe are not part of the word.
+func (p *parser) parseOperand(open token) ([]segment, token, error) {
+	var operand []segment
+	for {
+		tok := p.lex.nextWord()
+		switch tok.kind {
+		case tokenEOF:
+			return nil, tok, p.errorAt(open.offset, 2, "unterminated variable reference")
+		case tokenText:
+			operand = append(operand, segment{text: tok.value})
+		case tokenOpen:
+			nested, err := p.parseReference(tok)
+			if err != nil {
+				return nil, tok, err
+			}
+			operand = append(operand, segment{ref: nested})
+		case tokenPipe, tokenClose:
+			if n := len(operand); tok.kind == tokenPipe && n > 0 && operand[n-1].ref == nil {
+				operand[n-1].text = strings.TrimRight(operand[n-1].text, " \t")
+			}
+			return operand, tok, nil
+		}
+	}
+}
+
+// parseFilters parses the filters following a pipe and returns the closing brace
+func (p *parser) parseFilters(ref *reference, open token) (token, error) {
+	for {
+		tok := p.lex.nextExpr()
+		if tok.kind == tokenEOF {
+			return tok, p.errorAt(open.offset, 2, "unterminated variable reference")
+		}
+		if tok.kind != tokenName {
+			return tok, p.errorAt(tok.offset, len(tok.value), fmt.Sprintf("expected filter name, found '%s'", tok.value))
+		}
+		f := filter{name: tok.value, offset: tok.offset}
+		spec, ok := filters[f.name]
+		if !ok {
+			return tok, p.errorAt(tok.offset, len(tok.value), fmt.Sprintf("unknown filter '%s'", f.name))
+		}
+		for {
+			tok = p.lex.nextExpr()
+			if tok.kind == tokenString || tok.kind == tokenName {
+				f.args = append(f.args, tok.value)
+				continue
+			}
+			break
+		}
+		switch tok.kind {
+		case tokenEOF:
+			return tok, p.errorAt(open.offset, 2, "unterminated variable reference")
+		case tokenPipe, tokenClose:
+		default:
+			return tok, p.errorAt(tok.offset, len(tok.value), fmt.Sprintf("unexpected '%s' in filter '%s'", tok.value, f.name))
+		}
+		f.length = tok.offset - f.offset
+		if len(f.args) != spec.args {
+			return tok, p.errorAt(f.offset, f.length, fmt.Sprintf("filter '%s' takes %d argument(s), got %d", f.name, spec.args, len(f.args)))
+		}
+		ref.filters = append(ref.filters, f)
+		if tok.kind == tokenClose {
+			return tok, nil
+		}
+	}
+}
+
+func (p *parser) errorAt(offset, length int, message string) *Error {
+	return &Error{Input: p.lex.input, Offset: offset, Length: length, Message: message}
+}
@@ -0,0 +1,89 @@
+package vars
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubstitutor_Substitute(t *testing.T) {
+	ctx := NewContext()
+	ctx.Environment["OUT"] = "dist"
+	ctx.Environment["ENV_prod"] = "production"
+	ctx.Secrets["TOKEN"] = "s3cr${OUT}"
+	ctx.Args["stage"] = "prod"
+	s := NewSubstitutor()
+
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr string
+	}{
+		{"plain text", "echo hello", "echo hello", ""},
+		{"reference", "cp app ${OUT}/", "cp app dist/", ""},
+		{"spaces inside braces", "${ OUT }", "dist", ""},
+		{"dollar escape", "echo $${OUT} $$$$ costs $$5", "echo ${OUT} $$ costs $5", ""},
+		{"legacy escape", "echo ${$OUT}", "echo ${OUT}", ""},
+		{"shell variables pass through", "echo $HOME $1", "echo $HOME $1", ""},
+		{"nested reference", "deploy to ${ENV_${stage}}", "deploy to production", ""},
+		{"secrets are not expanded again", "curl -H ${TOKEN}", "curl -H s3cr${OUT}", ""},
+		{"undefined variable", "echo ${OUT}\n  ${MISSING}", "", "undefined variable 'MISSING' at line 2, column 3"},
+		{"undefined nested name", "${ENV_${OUT}}", "", "undefined variable 'ENV_dist' at line 1, column 1"},
+		{"unterminated reference", "echo ${OUT", "", "unterminated variable reference at line 1, column 6"},
+		{"empty reference", "echo ${}", "", "empty variable reference at line 1, column 6"},
+		{"invalid character", "echo ${OUT/x}", "", "unexpected '/' in variable reference at line 1, column 11"},
+		{"space inside name", "${OUT ${stage}}", "", "unexpected '${' in variable reference at line 1, column 7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.Substitute(tt.input, ctx)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				var verr *Error
+				assert.True(t, errors.As(err, &verr))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestSubstitutor_FindReferences(t *testing.T) {
+	s := NewSubstitutor()
+	assert.Equal(t, []Reference{
+		{Name: "A", Offset: 5, Length: 4},
+		{Name: "stage", Offset: 28, Length: 8},
+	}, s.FindReferences("echo ${A} $${B} ${$C} ${ENV_${stage}}"))
+	assert.Nil(t, s.FindReferences("echo ${A"))
+}
+
+func TestSubstitutor_SubstituteMap(t *testing.T) {
+	s := NewSubstitutor()
+
+	t.Run("entries refer to each other and to the context", func(t *testing.T) {
+		ctx := NewContext()
+		ctx.Environment["PATH_EXTRA"] = "/usr/bin"
+		ctx.Args["version"] = "1.0"
+		got, err := s.SubstituteMap(map[string]string{
+			"A_DIR":      "${Z_ROOT}/a",
+			"PATH_EXTRA": "${PATH_EXTRA}:/opt/bin",
+			"Z_ROOT":     "/srv/${version}",
+		}, ctx)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{
+			"A_DIR":      "/srv/1.0/a",
+			"PATH_EXTRA": "/usr/bin:/opt/bin",
+			"Z_ROOT":     "/srv/1.0",
+		}, got)
+	})
+
+	t.Run("circular references", func(t *testing.T) {
+		_, err := s.SubstituteMap(map[string]string{"A": "${B}", "B": "${A}"}, NewContext())
+		assert.EqualError(t, err, "failed to substitute in value for 'A': failed to substitute in value for 'B': circular reference to variable 'A' at line 1, column 1")
+	})
+}
@@ -0,0 +1,531 @@
+// aws.go
+// Region, endpoint and credentials of AWS vaults, resolved like the AWS CLI resolves them.
+package vault
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kontraktor-sh/kontraktor/internal/taskfile"
+)
+
+const (
+	// awsRefreshWindow is how long before they expire temporary credentials are refreshed
+	awsRefreshWindow = time.Minute
+	// awsMetadataTimeout bounds requests to the instance metadata service, absent outside EC2
+	awsMetadataTimeout = time.Second
+)
+
+// awsCredentials sign requests to AWS; temporary credentials expire
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expires         time.Time
+}
+
+// awsClient sends signed requests to the JSON API of an AWS service, for the region, endpoint
+// and identity of the options of a vault
+type awsClient struct {
+	options     taskfile.AWSOptions
+	service     string // Signing name and endpoint prefix of the service, such as ssm
+	endpointEnv string // Environment variable overriding the endpoint of the service
+	getenv      func(string) string
+	http        *http.Client
+
+	profilesOnce sync.Once
+	profiles     map[string]map[string]string // Profiles of the shared files, once read
+
+	mu    sync.Mutex
+	creds *awsCredentials
+}
+
+func newAWSClient(options taskfile.AWSOptions, service, endpointEnv string, getenv func(string) string) *awsClient {
+	return &awsClient{
+		options:     options,
+		service:     service,
+		endpointEnv: endpointEnv,
+		getenv:      getenv,
+		http:        &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// awsError is the error body of AWS JSON APIs
+type awsError struct {
+	Type     string `json:"__type"`
+	Message  string `json:"message"`
+	Message2 string `json:"Message"`
+}
+
+// call sends a request to an operation of the service, decoding its response into out
+func (c *awsClient) call(ctx context.Context, target string, in, out interface{}) error {
+	region, err := c.region()
+	if err != nil {
+		return err
+	}
+	creds, err := c.credentials(ctx)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint(region), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", target)
+	signAWS(req, body, creds, region, c.service, time.Now())
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr awsError
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Type != "" {
+			// Types may be qualified, as in com.amazonaws.ssm#ParameterNotFound
+			code := apiErr.Type[strings.LastIndex(apiErr.Type, "#")+1:]
+			if message := valueOr(apiErr.Message, apiErr.Message2); message != "" {
+				return fmt.Errorf("%s: %s", code, message)
+			}
+			return fmt.Errorf("%s", code)
+		}
+		return fmt.Errorf("%s", resp.Status)
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("invalid response: %w", err)
+	}
+	return nil
+}
+
+// region returns the region of the vault, from its options, the environment or its profile
+func (c *awsClient) region() (string, error) {
+	region := valueOr(c.options.Region, valueOr(c.getenv("AWS_REGION"), c.getenv("AWS_DEFAULT_REGION")))
+	if region == "" {
+		region = c.profile(c.profileName())["region"]
+	}
+	if region == "" {
+		return "", fmt.Errorf("AWS vault requires region, AWS_REGION or a profile with a region")
+	}
+	return region, nil
+}
+
+// endpoint returns the URL of the service: the endpoint of the options or the environment, or
+// the one of the region
+func (c *awsClient) endpoint(region string) string {
+	if endpoint := valueOr(c.options.Endpoint, valueOr(c.getenv(c.endpointEnv), c.getenv("AWS_ENDPOINT_URL"))); endpoint != "" {
+		return endpoint
+	}
+	return awsServiceURL(c.service, region)
+}
+
+func awsServiceURL(service, region string) string {
+	domain := "amazonaws.com"
+	if strings.HasPrefix(region, "cn-") {
+		domain = "amazonaws.com.cn"
+	}
+	return fmt.Sprintf("https://%s.%s.%s/", service, region, domain)
+}
+
+// identity describes where and as whom the vault reads secrets, for the disk cache
+func (c *awsClient) identity() string {
+	region, _ := c.region()
+	return fmt.Sprintf("%s %s %s %s", c.endpoint(region), c.profileName(), c.principal(), c.options.RoleARN)
+}
+
+// principal names the credentials of the vault without resolving them, in the order of
+// baseCredentials: the access key ID of static keys, or the role assumed with a web identity or
+// by a profile, which holds the account. Credentials of other accounts have other principals.
+func (c *awsClient) principal() string {
+	if c.options.Profile == "" {
+		if id := c.getenv("AWS_ACCESS_KEY_ID"); id != "" && c.getenv("AWS_SECRET_ACCESS_KEY") != "" {
+			return id
+		}
+		if tokenFile, role := c.getenv("AWS_WEB_IDENTITY_TOKEN_FILE"), c.getenv("AWS_ROLE_ARN"); tokenFile != "" && role != "" {
+			return role
+		}
+	}
+	p := c.profile(c.profileName())
+	if role := p["role_arn"]; role != "" {
+		return role
+	}
+	if id := p["aws_access_key_id"]; id != "" {
+		return id
+	}
+	if uri := c.containerCredentialsURI(); uri != "" {
+		return uri
+	}
+	return "instance"
+}
+
+// credentials returns the credentials of the vault, resolving them on first use and when they
+// are about to expire
+func (c *awsClient) credentials(ctx context.Context) (awsCredentials, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.creds != nil && (c.creds.Expires.IsZero() || time.Until(c.creds.Expires) > awsRefreshWindow) {
+		return *c.creds, nil
+	}
+	creds, err := c.baseCredentials(ctx)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	if c.options.RoleARN != "" {
+		creds, err = c.assumeRole(ctx, creds, c.options.RoleARN, c.options.ExternalID, c.options.RoleSessionName)
+		if err != nil {
+			return awsCredentials{}, err
+		}
+	}
+	c.creds = &creds
+	return creds, nil
+}
+
+// baseCredentials returns the credentials of the environment, of the profile, or of the container
+// or instance, in the order of the AWS CLI. A profile named in the options takes precedence.
+func (c *awsClient) baseCredentials(ctx context.Context) (awsCredentials, error) {
+	if c.options.Profile == "" {
+		if id, secret := c.getenv("AWS_ACCESS_KEY_ID"), c.getenv("AWS_SECRET_ACCESS_KEY"); id != "" && secret != "" {
+			return awsCredentials{AccessKeyID: id, SecretAccessKey: secret, SessionToken: c.getenv("AWS_SESSION_TOKEN")}, nil
+		}
+		if tokenFile, role := c.getenv("AWS_WEB_IDENTITY_TOKEN_FILE"), c.getenv("AWS_ROLE_ARN"); tokenFile != "" && role != "" {
+			return c.assumeRoleWithWebIdentity(ctx, role, tokenFile, c.getenv("AWS_ROLE_SESSION_NAME"))
+		}
+	}
+	name := c.profileName()
+	creds, ok, err := c.profileCredentials(ctx, name, 0)
+	if err != nil || ok {
+		return creds, err
+	}
+	if c.options.Profile != "" || c.getenv("AWS_PROFILE") != "" {
+		return awsCredentials{}, fmt.Errorf("AWS profile %s has no credentials", name)
+	}
+	return c.instanceCredentials(ctx)
+}
+
+// profileCredentials returns the credentials of a profile, assuming its role if it has one
+func (c *awsClient) profileCredentials(ctx context.Context, name string, depth int) (awsCredentials, bool, error) {
+	p := c.profile(name)
+	static := awsCredentials{AccessKeyID: p["aws_access_key_id"], SecretAccessKey: p["aws_secret_access_key"], SessionToken: p["aws_session_token"]}
+	if role := p["role_arn"]; role != "" {
+		if depth > 4 {
+			return awsCredentials{}, false, fmt.Errorf("AWS profile %s: too many chained source profiles", name)
+		}
+		if tokenFile := p["web_identity_token_file"]; tokenFile != "" {
+			creds, err := c.assumeRoleWithWebIdentity(ctx, role, tokenFile, p["role_session_name"])
+			return creds, err == nil, err
+		}
+		// A role may be assumed with the keys of its own profile
+		source, ok := static, static.AccessKeyID != ""
+		if sourceProfile := p["source_profile"]; sourceProfile != "" && sourceProfile != name {
+			var err error
+			source, ok, err = c.profileCredentials(ctx, sourceProfile, depth+1)
+			if err != nil {
+				return awsCredentials{}, false, err
+			}
+		}
+		if !ok {
+			return awsCredentials{}, false, fmt.Errorf("AWS profile %s assumes role %s without source_profile credentials", name, role)
+		}
+		creds, err := c.assumeRole(ctx, source, role, p["external_id"], p["role_session_name"])
+		return creds, err == nil, err
+	}
+	if static.AccessKeyID != "" && static.SecretAccessKey != "" {
+		return static, true, nil
+	}
+	if p["credential_process"] != "" || p["sso_session"] != "" || p["sso_start_url"] != "" {
+		return awsCredentials{}, false, fmt.Errorf("AWS profile %s uses credential_process or SSO, which are not supported (export credentials with aws configure export-credentials --format env)", name)
+	}
+	return awsCredentials{}, false, nil
+}
+
+// profileName returns the profile of the vault: from its options, AWS_PROFILE, or default
+func (c *awsClient) profileName() string {
+	return valueOr(c.options.Profile, valueOr(c.getenv("AWS_PROFILE"), "default"))
+}
+
+// profile returns the keys of a profile in the shared config and credentials files, the latter
+// taking precedence
+func (c *awsClient) profile(name string) map[string]string {
+	c.profilesOnce.Do(func() {
+		c.profiles = make(map[string]map[string]string)
+		home, _ := os.UserHomeDir()
+		configFile := valueOr(c.getenv("AWS_CONFIG_FILE"), filepath.Join(home, ".aws", "config"))
+		credentialsFile := valueOr(c.getenv("AWS_SHARED_CREDENTIALS_FILE"), filepath.Join(home, ".aws", "credentials"))
+		readAWSProfiles(configFile, true, c.profiles)
+		readAWSProfiles(credentialsFile, false, c.profiles)
+	})
+	return c.profiles[name]
+}
+
+// readAWSProfiles adds the profiles of a shared file to profiles. Sections of the config file
+// are named profile <name>, except default; those of the credentials file are named <name>.
+// A missing file has no profiles.
+func readAWSProfiles(path string, config bool, profiles map[string]map[string]string) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	var current map[string]string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section := strings.Join(strings.Fields(line[1:len(line)-1]), " ")
+			name, isProfile := section, !config
+			if config {
+				name, isProfile = strings.CutPrefix(section, "profile ")
+				isProfile = isProfile || section == "default"
+			}
+			current = nil
+			if isProfile {
+				if profiles[name] == nil {
+					profiles[name] = make(map[string]string)
+				}
+				current = profiles[name]
+			}
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if ok && current != nil {
+			current[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+}
+
+// stsCredentials are the temporary credentials returned by STS
+type stsCredentials struct {
+	AccessKeyID     string    `xml:"AccessKeyId"`
+	SecretAccessKey string    `xml:"SecretAccessKey"`
+	SessionToken    string    `xml:"SessionToken"`
+	Expiration      time.Time `xml:"Expiration"`
+}
+
+// assumeRole returns the credentials of a role, assumed with creds
+func (c *awsClient) assumeRole(ctx context.Context, creds awsCredentials, role, externalID, sessionName string) (awsCredentials, error) {
+	form := url.Values{
+		"Action":          {"AssumeRole"},
+		"RoleArn":         {role},
+		"RoleSessionName": {valueOr(sessionName, "kontraktor")},
+	}
+	if externalID != "" {
+		form.Set("ExternalId", externalID)
+	}
+	assumed, err := c.sts(ctx, &creds, form)
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("failed to assume role %s: %w", role, err)
+	}
+	return assumed, nil
+}
+
+// assumeRoleWithWebIdentity returns the credentials of a role, assumed with the OIDC token in
+// tokenFile, such as the service account token of an EKS pod
+func (c *awsClient) assumeRoleWithWebIdentity(ctx context.Context, role, tokenFile, sessionName string) (awsCredentials, error) {
+	token, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("failed to read web identity token: %w", err)
+	}
+	assumed, err := c.sts(ctx, nil, url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"RoleArn":          {role},
+		"RoleSessionName":  {valueOr(sessionName, "kontraktor")},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+	})
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("failed to assume role %s with web identity: %w", role, err)
+	}
+	return assumed, nil
+}
+
+// sts calls an action of STS, signing it with creds unless they are nil
+func (c *awsClient) sts(ctx context.Context, creds *awsCredentials, form url.Values) (awsCredentials, error) {
+	form.Set("Version", "2011-06-15")
+	region, err := c.region()
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	endpoint := valueOr(c.getenv("AWS_ENDPOINT_URL_STS"), valueOr(c.getenv("AWS_ENDPOINT_URL"), awsServiceURL("sts", region)))
+	body := []byte(form.Encode())
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	if creds != nil {
+		signAWS(req, body, *creds, region, "sts", time.Now())
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var stsErr struct {
+			Code    string `xml:"Error>Code"`
+			Message string `xml:"Error>Message"`
+		}
+		if xml.Unmarshal(data, &stsErr) == nil && stsErr.Code != "" {
+			return awsCredentials{}, fmt.Errorf("%s: %s", stsErr.Code, stsErr.Message)
+		}
+		return awsCredentials{}, fmt.Errorf("%s", resp.Status)
+	}
+	var result struct {
+		AssumeRole      stsCredentials `xml:"AssumeRoleResult>Credentials"`
+		WebIdentityRole stsCredentials `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}
+	if err := xml.Unmarshal(data, &result); err != nil {
+		return awsCredentials{}, fmt.Errorf("invalid response: %w", err)
+	}
+	assumed := result.AssumeRole
+	if assumed.AccessKeyID == "" {
+		assumed = result.WebIdentityRole
+	}
+	if assumed.AccessKeyID == "" {
+		return awsCredentials{}, fmt.Errorf("invalid response: no credentials")
+	}
+	return awsCredentials{AccessKeyID: assumed.AccessKeyID, SecretAccessKey: assumed.SecretAccessKey, SessionToken: assumed.SessionToken, Expires: assumed.Expiration}, nil
+}
+
+// instanceCredentials returns the credentials of the ECS or EKS container, or of the EC2 instance
+func (c *awsClient) instanceCredentials(ctx context.Context) (awsCredentials, error) {
+	var creds struct {
+		AccessKeyID     string    `json:"AccessKeyId"`
+		SecretAccessKey string    `json:"SecretAccessKey"`
+		Token           string    `json:"Token"`
+		Expiration      time.Time `json:"Expiration"`
+	}
+	if uri := c.containerCredentialsURI(); uri != "" {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+		if err != nil {
+			return awsCredentials{}, err
+		}
+		token := c.getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+		if tokenFile := c.getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"); tokenFile != "" {
+			data, err := os.ReadFile(tokenFile)
+			if err != nil {
+				return awsCredentials{}, fmt.Errorf("failed to read container authorization token: %w", err)
+			}
+			token = strings.TrimSpace(string(data))
+		}
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+		if err := c.getJSON(req, &creds); err != nil {
+			return awsCredentials{}, fmt.Errorf("failed to get container credentials: %w", err)
+		}
+	} else {
+		if strings.EqualFold(c.getenv("AWS_EC2_METADATA_DISABLED"), "true") {
+			return awsCredentials{}, fmt.Errorf("no AWS credentials found (set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, or a profile)")
+		}
+		if err := c.metadataCredentials(ctx, &creds); err != nil {
+			return awsCredentials{}, fmt.Errorf("no AWS credentials found (set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, or a profile): instance metadata: %w", err)
+		}
+	}
+	return awsCredentials{AccessKeyID: creds.AccessKeyID, SecretAccessKey: creds.SecretAccessKey, SessionToken: creds.Token, Expires: creds.Expiration}, nil
+}
+
+// containerCredentialsURI returns the credentials endpoint of ECS tasks and EKS pod identities
+func (c *awsClient) containerCredentialsURI() string {
+	if relative := c.getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); relative != "" {
+		return "http://169.254.170.2" + relative
+	}
+	return c.getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+}
+
+// metadataCredentials reads the credentials of the role of the EC2 instance with IMDSv2
+func (c *awsClient) metadataCredentials(ctx context.Context, out interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*awsMetadataTimeout)
+	defer cancel()
+	endpoint := strings.TrimSuffix(valueOr(c.getenv("AWS_EC2_METADATA_SERVICE_ENDPOINT"), "http://169.254.169.254"), "/")
+	client := &http.Client{Timeout: awsMetadataTimeout}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint+"/latest/api/token", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "300")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	token, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s", resp.Status)
+	}
+
+	get := func(path string) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"/latest/meta-data/iam/security-credentials/"+path, nil)
+		if err == nil {
+			req.Header.Set("X-aws-ec2-metadata-token", string(token))
+		}
+		return req, err
+	}
+	req, err = get("")
+	if err != nil {
+		return err
+	}
+	resp, err = client.Do(req)
+	if err != nil {
+		return err
+	}
+	role, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("no role: %s", resp.Status)
+	}
+	req, err = get(strings.TrimSpace(strings.SplitN(string(role), "\n", 2)[0]))
+	if err != nil {
+		return err
+	}
+	return c.getJSON(req, out)
+}
+
+// getJSON sends a request, decoding its JSON response into out
+func (c *awsClient) getJSON(req *http.Request, out interface{}) error {
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("invalid response: %w", err)
+	}
+	return nil
+}
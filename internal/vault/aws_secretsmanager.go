@@ -0,0 +1,126 @@
+// aws_secretsmanager.go
+// Reads secrets, or JSON keys of their values, from AWS Secrets Manager.
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/kontraktor-sh/kontraktor/internal/taskfile"
+)
+
+// AWSSecretsManagerVault provides the secrets of an AWS Secrets Manager configuration to a
+// secret manager. Each version of a secret is read once, whatever number of its JSON keys are used.
+type AWSSecretsManagerVault struct {
+	config taskfile.AWSSecretsManagerConfig
+	client *awsClient
+
+	mu    sync.Mutex
+	reads map[taskfile.AWSSecret]*awsSecretRead
+}
+
+// awsSecretRead is the read of a version of a secret, shared by the keys of its value
+type awsSecretRead struct {
+	once  sync.Once
+	value string
+	err   error
+}
+
+// NewAWSSecretsManagerVault creates an AWSSecretsManagerVault, reading values the configuration
+// leaves empty from the environment variables of the AWS CLI with getenv
+func NewAWSSecretsManagerVault(config taskfile.AWSSecretsManagerConfig, getenv func(string) string) *AWSSecretsManagerVault {
+	return &AWSSecretsManagerVault{
+		config: config,
+		client: newAWSClient(config.AWSOptions, "secretsmanager", "AWS_ENDPOINT_URL_SECRETS_MANAGER", getenv),
+		reads:  make(map[taskfile.AWSSecret]*awsSecretRead),
+	}
+}
+
+// GetSecrets fetches the declared secrets, reading each version of a secret once
+func (v *AWSSecretsManagerVault) GetSecrets(ctx context.Context) (map[string]string, error) {
+	return getSecrets(ctx, v.config.Secrets, v.GetSecret)
+}
+
+// SecretNames returns the environment variable names declared for secrets and their JSON keys
+func (v *AWSSecretsManagerVault) SecretNames() []string {
+	return secretNames(v.config.Secrets)
+}
+
+// GetSecret fetches the secret declared for an environment variable name
+func (v *AWSSecretsManagerVault) GetSecret(ctx context.Context, name string) (string, error) {
+	value, ok := v.config.Secrets[name]
+	if !ok {
+		return "", fmt.Errorf("secret %s is not declared in AWS Secrets Manager", name)
+	}
+	secret, err := taskfile.ParseAWSSecret(value)
+	if err != nil {
+		return "", err
+	}
+	key := secret.JSONKey
+	secret.JSONKey = ""
+	data, err := v.read(ctx, secret)
+	if err != nil || key == "" {
+		return data, err
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &fields); err != nil {
+		return "", fmt.Errorf("secret %s does not hold a JSON object, so it has no key %s", secret.SecretID, key)
+	}
+	field, ok := fields[key]
+	if !ok {
+		return "", fmt.Errorf("secret %s has no key %s", secret.SecretID, key)
+	}
+	if s, ok := field.(string); ok {
+		return s, nil
+	}
+	encoded, err := json.Marshal(field)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode key %s of secret %s: %w", key, secret.SecretID, err)
+	}
+	return string(encoded), nil
+}
+
+// SecretID identifies a secret by the endpoint and identity of its vault, for the disk cache
+func (v *AWSSecretsManagerVault) SecretID(name string) string {
+	return fmt.Sprintf("aws_secrets_manager %s %s", v.client.identity(), v.config.Secrets[name])
+}
+
+// read returns the value of a version of a secret, reading it once
+func (v *AWSSecretsManagerVault) read(ctx context.Context, secret taskfile.AWSSecret) (string, error) {
+	v.mu.Lock()
+	r, ok := v.reads[secret]
+	if !ok {
+		r = &awsSecretRead{}
+		v.reads[secret] = r
+	}
+	v.mu.Unlock()
+
+	r.once.Do(func() {
+		r.value, r.err = v.getSecretValue(ctx, secret)
+	})
+	return r.value, r.err
+}
+
+func (v *AWSSecretsManagerVault) getSecretValue(ctx context.Context, secret taskfile.AWSSecret) (string, error) {
+	in := map[string]string{"SecretId": secret.SecretID}
+	if secret.VersionStage != "" {
+		in["VersionStage"] = secret.VersionStage
+	}
+	if secret.VersionID != "" {
+		in["VersionId"] = secret.VersionID
+	}
+	var out struct {
+		SecretString *string `json:"SecretString"`
+		SecretBinary []byte  `json:"SecretBinary"`
+	}
+	if err := v.client.call(ctx, "secretsmanager.GetSecretValue", in, &out); err != nil {
+		return "", fmt.Errorf("failed to get secret %s: %w", secret.SecretID, err)
+	}
+	if out.SecretString != nil {
+		return *out.SecretString, nil
+	}
+	return string(out.SecretBinary), nil
+}
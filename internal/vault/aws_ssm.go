@@ -0,0 +1,63 @@
+// aws_ssm.go
+// Reads parameters from AWS SSM Parameter Store.
+package vault
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/kontraktor-sh/kontraktor/internal/taskfile"
+)
+
+// AWSSSMVault provides the parameters of an SSM Parameter Store configuration to a secret
+// manager, decrypting SecureString parameters
+type AWSSSMVault struct {
+	config taskfile.AWSSSMConfig
+	client *awsClient
+}
+
+// NewAWSSSMVault creates an AWSSSMVault, reading values the configuration leaves empty from the
+// environment variables of the AWS CLI with getenv
+func NewAWSSSMVault(config taskfile.AWSSSMConfig, getenv func(string) string) *AWSSSMVault {
+	return &AWSSSMVault{
+		config: config,
+		client: newAWSClient(config.AWSOptions, "ssm", "AWS_ENDPOINT_URL_SSM", getenv),
+	}
+}
+
+// GetSecrets fetches the parameters declared in the configuration
+func (v *AWSSSMVault) GetSecrets(ctx context.Context) (map[string]string, error) {
+	return getSecrets(ctx, v.config.Secrets, v.GetSecret)
+}
+
+// SecretNames returns the environment variable names of the declared parameters
+func (v *AWSSSMVault) SecretNames() []string {
+	return secretNames(v.config.Secrets)
+}
+
+// GetSecret fetches the parameter declared for an environment variable name. Versions and
+// labels are selected by GetParameter itself, as in name:3.
+func (v *AWSSSMVault) GetSecret(ctx context.Context, name string) (string, error) {
+	parameter, ok := v.config.Secrets[name]
+	if !ok {
+		return "", fmt.Errorf("secret %s is not declared in AWS SSM Parameter Store", name)
+	}
+	var out struct {
+		Parameter *struct {
+			Value string `json:"Value"`
+		} `json:"Parameter"`
+	}
+	in := map[string]interface{}{"Name": parameter, "WithDecryption": true}
+	if err := v.client.call(ctx, "AmazonSSM.GetParameter", in, &out); err != nil {
+		return "", fmt.Errorf("failed to get parameter %s: %w", parameter, err)
+	}
+	if out.Parameter == nil {
+		return "", fmt.Errorf("failed to get parameter %s: no parameter in response", parameter)
+	}
+	return out.Parameter.Value, nil
+}
+
+// SecretID identifies a parameter by the endpoint and identity of its vault, for the disk cache
+func (v *AWSSSMVault) SecretID(name string) string {
+	return fmt.Sprintf("aws_ssm %s %s", v.client.identity(), v.config.Secrets[name])
+}
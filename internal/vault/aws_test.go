@@ -0,0 +1,509 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kontraktor-sh/kontraktor/internal/secret"
+	"github.com/kontraktor-sh/kontraktor/internal/taskfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// awsVersion is a version of a secret of the stand-in
+type awsVersion struct {
+	id     string
+	stages []string
+	value  string
+	binary []byte
+}
+
+// awsStandIn serves Secrets Manager, SSM, STS and container credentials, checking signatures
+type awsStandIn struct {
+	keys        map[string]awsCredentials // Credentials by access key ID
+	roles       map[string]string         // Access key ID of the credentials of a role, by role ARN:external ID
+	webIdentity string                    // Token accepted by AssumeRoleWithWebIdentity
+	container   string                    // Authorization token of container credentials
+	secrets     map[string][]awsVersion
+	parameters  map[string]string // Decrypted values by name and selector
+
+	mu    sync.Mutex
+	calls []string // Operations as access key ID, region and target, in order
+}
+
+func newAWSStandIn(t *testing.T) (*awsStandIn, *httptest.Server) {
+	s := &awsStandIn{
+		keys: map[string]awsCredentials{
+			"AKIDENV":     {AccessKeyID: "AKIDENV", SecretAccessKey: "env-secret"},
+			"AKIDPROFILE": {AccessKeyID: "AKIDPROFILE", SecretAccessKey: "profile-secret"},
+			"ASIADEPLOY":  {AccessKeyID: "ASIADEPLOY", SecretAccessKey: "deploy-secret", SessionToken: "deploy-session"},
+			"ASIAWEB":     {AccessKeyID: "ASIAWEB", SecretAccessKey: "web-secret", SessionToken: "web-session"},
+			"ASIATASK":    {AccessKeyID: "ASIATASK", SecretAccessKey: "task-secret", SessionToken: "task-session"},
+		},
+		roles: map[string]string{
+			"arn:aws:iam::123456789012:role/deploy:":         "ASIADEPLOY",
+			"arn:aws:iam::123456789012:role/deploy:partner":  "ASIADEPLOY",
+			"arn:aws:iam::123456789012:role/web-identity:":   "ASIAWEB",
+			"arn:aws:iam::123456789012:role/partner:ext-123": "ASIADEPLOY",
+		},
+		webIdentity: "oidc-token",
+		container:   "container-token",
+		secrets: map[string][]awsVersion{
+			"prod/db": {
+				{id: "v2", stages: []string{"AWSCURRENT"}, value: `{"username":"app","password":"new-password","port":5432}`},
+				{id: "v1", stages: []string{"AWSPREVIOUS"}, value: `{"username":"app","password":"old-password","port":5432}`},
+			},
+			"prod/api-key":  {{id: "v1", stages: []string{"AWSCURRENT"}, value: "api-key"}},
+			"prod/keystore": {{id: "v1", stages: []string{"AWSCURRENT"}, binary: []byte{0x01, 0x02, 'k'}}},
+		},
+		parameters: map[string]string{
+			"/prod/db/password":          "ssm-password",
+			"/prod/db/password:1":        "first-password",
+			"/prod/db/password:released": "released-password",
+		},
+	}
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+	return s, server
+}
+
+func (s *awsStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	fail := func(status int, code, message string) {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{"__type": code, "message": message})
+	}
+	if r.URL.Path == "/container-credentials" {
+		if r.Header.Get("Authorization") != s.container {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		task := s.keys["ASIATASK"]
+		json.NewEncoder(w).Encode(map[string]string{
+			"AccessKeyId": task.AccessKeyID, "SecretAccessKey": task.SecretAccessKey, "Token": task.SessionToken,
+			"Expiration": time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+		})
+		return
+	}
+
+	form, _ := url.ParseQuery(string(body))
+	if form.Get("Action") == "AssumeRoleWithWebIdentity" {
+		if form.Get("WebIdentityToken") != s.webIdentity {
+			s.stsError(w, "InvalidIdentityToken", "invalid token")
+			return
+		}
+		s.record("-", "sts", "AssumeRoleWithWebIdentity "+form.Get("RoleArn"))
+		s.stsCredentials(w, "AssumeRoleWithWebIdentity", s.roles[form.Get("RoleArn")+":"])
+		return
+	}
+
+	// Every other request must be signed by a known key, and the signature must cover the request
+	creds, region, service, ok := s.verify(r, body)
+	if !ok {
+		fail(http.StatusForbidden, "InvalidSignatureException", "signature does not match")
+		return
+	}
+	switch {
+	case service == "sts" && form.Get("Action") == "AssumeRole":
+		key, ok := s.roles[form.Get("RoleArn")+":"+form.Get("ExternalId")]
+		if !ok {
+			s.stsError(w, "AccessDenied", "not authorized to perform sts:AssumeRole")
+			return
+		}
+		s.record(creds.AccessKeyID, region, "AssumeRole "+form.Get("RoleArn")+" "+form.Get("RoleSessionName"))
+		s.stsCredentials(w, "AssumeRole", key)
+
+	case r.Header.Get("X-Amz-Target") == "secretsmanager.GetSecretValue" && service == "secretsmanager":
+		var in struct{ SecretId, VersionStage, VersionId string }
+		json.Unmarshal(body, &in)
+		s.record(creds.AccessKeyID, region, "GetSecretValue "+in.SecretId)
+		id := in.SecretId
+		if strings.HasPrefix(id, "arn:") {
+			id = id[strings.LastIndex(id, ":")+1:]
+		}
+		stage := in.VersionStage
+		if stage == "" && in.VersionId == "" {
+			stage = "AWSCURRENT"
+		}
+		for _, version := range s.secrets[id] {
+			if (in.VersionId == "" || version.id == in.VersionId) && (stage == "" || hasStage(version.stages, stage)) {
+				out := map[string]interface{}{"Name": id, "VersionId": version.id, "VersionStages": version.stages}
+				if version.binary != nil {
+					out["SecretBinary"] = version.binary
+				} else {
+					out["SecretString"] = version.value
+				}
+				json.NewEncoder(w).Encode(out)
+				return
+			}
+		}
+		fail(http.StatusBadRequest, "ResourceNotFoundException", "Secrets Manager can't find the specified secret.")
+
+	case r.Header.Get("X-Amz-Target") == "AmazonSSM.GetParameter" && service == "ssm":
+		var in struct {
+			Name           string
+			WithDecryption bool
+		}
+		json.Unmarshal(body, &in)
+		s.record(creds.AccessKeyID, region, "GetParameter "+in.Name)
+		value, ok := s.parameters[in.Name]
+		if !ok || !in.WithDecryption {
+			fail(http.StatusBadRequest, "com.amazonaws.ssm#ParameterNotFound", "")
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"Parameter": map[string]interface{}{"Name": in.Name, "Type": "SecureString", "Value": value}})
+
+	default:
+		fail(http.StatusBadRequest, "UnknownOperationException", "")
+	}
+}
+
+// verify checks the signature of a request by signing it again with the key it names
+func (s *awsStandIn) verify(r *http.Request, body []byte) (awsCredentials, string, string, bool) {
+	auth := r.Header.Get("Authorization")
+	credential, _, _ := strings.Cut(strings.TrimPrefix(auth, "AWS4-HMAC-SHA256 Credential="), ",")
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 {
+		return awsCredentials{}, "", "", false
+	}
+	creds, ok := s.keys[parts[0]]
+	if !ok || r.Header.Get("X-Amz-Security-Token") != creds.SessionToken {
+		return awsCredentials{}, "", "", false
+	}
+	now, err := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return awsCredentials{}, "", "", false
+	}
+	signed := r.Clone(context.Background())
+	signed.URL.Host = r.Host
+	for name := range signed.Header {
+		// Headers added by the transport are not signed
+		if name == "User-Agent" || name == "Accept-Encoding" || name == "Content-Length" {
+			signed.Header.Del(name)
+		}
+	}
+	signAWS(signed, body, creds, parts[2], parts[3], now)
+	return creds, parts[2], parts[3], signed.Header.Get("Authorization") == auth
+}
+
+func (s *awsStandIn) record(key, region, call string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, key+" "+region+" "+call)
+}
+
+func (s *awsStandIn) stsCredentials(w http.ResponseWriter, action, key string) {
+	creds := s.keys[key]
+	fmt.Fprintf(w, `<%[1]sResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><%[1]sResult><Credentials>`+
+		`<AccessKeyId>%[2]s</AccessKeyId><SecretAccessKey>%[3]s</SecretAccessKey><SessionToken>%[4]s</SessionToken>`+
+		`<Expiration>%[5]s</Expiration></Credentials></%[1]sResult><ResponseMetadata><RequestId>1</RequestId></ResponseMetadata></%[1]sResponse>`,
+		action, creds.AccessKeyID, creds.SecretAccessKey, creds.SessionToken, time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+}
+
+func (s *awsStandIn) stsError(w http.ResponseWriter, code, message string) {
+	w.WriteHeader(http.StatusForbidden)
+	fmt.Fprintf(w, `<ErrorResponse><Error><Type>Sender</Type><Code>%s</Code><Message>%s</Message></Error></ErrorResponse>`, code, message)
+}
+
+func hasStage(stages []string, stage string) bool {
+	for _, s := range stages {
+		if s == stage {
+			return true
+		}
+	}
+	return false
+}
+
+// awsEnv is the environment of a vault using the stand-in, without shared files or instance
+// metadata
+func awsEnv(server *httptest.Server, env map[string]string) func(string) string {
+	dir := filepath.Join(os.TempDir(), "kontraktor-aws-missing")
+	vars := map[string]string{
+		"AWS_ENDPOINT_URL":            server.URL,
+		"AWS_CONFIG_FILE":             filepath.Join(dir, "config"),
+		"AWS_SHARED_CREDENTIALS_FILE": filepath.Join(dir, "credentials"),
+		"AWS_EC2_METADATA_DISABLED":   "true",
+	}
+	for name, value := range env {
+		vars[name] = value
+	}
+	return func(name string) string { return vars[name] }
+}
+
+func TestAWSSecretsManagerVault_GetSecret(t *testing.T) {
+	standIn, server := newAWSStandIn(t)
+	v := NewAWSSecretsManagerVault(taskfile.AWSSecretsManagerConfig{
+		AWSOptions: taskfile.AWSOptions{Region: "eu-west-1"},
+		Secrets: map[string]string{
+			"API_KEY":      "prod/api-key",
+			"DB_USER":      "prod/db:username",
+			"DB_PASSWORD":  "prod/db:password",
+			"DB_PORT":      "prod/db:port",
+			"OLD_PASSWORD": "prod/db:password:AWSPREVIOUS",
+			"V1_PASSWORD":  "prod/db:password::v1",
+			"DB":           "prod/db",
+			"BY_ARN":       "arn:aws:secretsmanager:eu-west-1:123456789012:secret:prod/api-key",
+			"ARN_KEY":      "arn:aws:secretsmanager:eu-west-1:123456789012:secret:prod/db:username",
+			"KEYSTORE":     "prod/keystore",
+			"MISSING":      "prod/missing",
+			"NOT_JSON":     "prod/api-key:key",
+			"NO_KEY":       "prod/db:token",
+		},
+	}, awsEnv(server, map[string]string{"AWS_ACCESS_KEY_ID": "AKIDENV", "AWS_SECRET_ACCESS_KEY": "env-secret"}))
+
+	for name, want := range map[string]string{
+		"API_KEY":      "api-key",
+		"DB_USER":      "app",
+		"DB_PASSWORD":  "new-password",
+		"DB_PORT":      "5432",
+		"OLD_PASSWORD": "old-password",
+		"V1_PASSWORD":  "old-password",
+		"DB":           `{"username":"app","password":"new-password","port":5432}`,
+		"BY_ARN":       "api-key",
+		"ARN_KEY":      "app",
+		"KEYSTORE":     "\x01\x02k",
+	} {
+		got, err := v.GetSecret(context.Background(), name)
+		assert.NoError(t, err, name)
+		assert.Equal(t, want, got, name)
+	}
+	_, err := v.GetSecret(context.Background(), "MISSING")
+	assert.EqualError(t, err, "failed to get secret prod/missing: ResourceNotFoundException: Secrets Manager can't find the specified secret.")
+	_, err = v.GetSecret(context.Background(), "NOT_JSON")
+	assert.EqualError(t, err, "secret prod/api-key does not hold a JSON object, so it has no key key")
+	_, err = v.GetSecret(context.Background(), "NO_KEY")
+	assert.EqualError(t, err, "secret prod/db has no key token")
+	_, err = v.GetSecret(context.Background(), "TOKEN")
+	assert.EqualError(t, err, "secret TOKEN is not declared in AWS Secrets Manager")
+
+	// Each version of a secret is read once, however many of its keys are used
+	reads := make(map[string]int)
+	for _, call := range standIn.calls {
+		assert.True(t, strings.HasPrefix(call, "AKIDENV eu-west-1 GetSecretValue "), call)
+		reads[strings.TrimPrefix(call, "AKIDENV eu-west-1 GetSecretValue ")]++
+	}
+	assert.Equal(t, map[string]int{
+		"prod/api-key": 1, "prod/db": 3, "prod/keystore": 1, "prod/missing": 1,
+		"arn:aws:secretsmanager:eu-west-1:123456789012:secret:prod/api-key": 1,
+		"arn:aws:secretsmanager:eu-west-1:123456789012:secret:prod/db":      1,
+	}, reads)
+}
+
+func TestAWSSSMVault_GetSecret(t *testing.T) {
+	_, server := newAWSStandIn(t)
+	v := NewAWSSSMVault(taskfile.AWSSSMConfig{
+		Secrets: map[string]string{
+			"DB_PASSWORD":       "/prod/db/password",
+			"FIRST_PASSWORD":    "/prod/db/password:1",
+			"RELEASED_PASSWORD": "/prod/db/password:released",
+			"MISSING":           "/prod/missing",
+		},
+	}, awsEnv(server, map[string]string{"AWS_REGION": "us-east-2", "AWS_ACCESS_KEY_ID": "AKIDENV", "AWS_SECRET_ACCESS_KEY": "env-secret"}))
+
+	for name, want := range map[string]string{
+		"DB_PASSWORD":       "ssm-password",
+		"FIRST_PASSWORD":    "first-password",
+		"RELEASED_PASSWORD": "released-password",
+	} {
+		got, err := v.GetSecret(context.Background(), name)
+		assert.NoError(t, err, name)
+		assert.Equal(t, want, got, name)
+	}
+	_, err := v.GetSecret(context.Background(), "MISSING")
+	assert.EqualError(t, err, "failed to get parameter /prod/missing: ParameterNotFound")
+}
+
+func TestAWSClient_Credentials(t *testing.T) {
+	standIn, server := newAWSStandIn(t)
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config")
+	require.NoError(t, os.WriteFile(configFile, []byte(`[default]
+region = eu-central-1
+
+[profile deploy]
+role_arn = arn:aws:iam::123456789012:role/deploy
+source_profile = base
+region = eu-west-3
+
+[profile sso]
+sso_session = company
+`), 0o600))
+	credentialsFile := filepath.Join(dir, "credentials")
+	require.NoError(t, os.WriteFile(credentialsFile, []byte(`[base]
+aws_access_key_id = AKIDPROFILE
+aws_secret_access_key = profile-secret
+`), 0o600))
+	tokenFile := filepath.Join(dir, "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("oidc-token\n"), 0o600))
+	files := map[string]string{"AWS_CONFIG_FILE": configFile, "AWS_SHARED_CREDENTIALS_FILE": credentialsFile}
+	envKeys := map[string]string{"AWS_ACCESS_KEY_ID": "AKIDENV", "AWS_SECRET_ACCESS_KEY": "env-secret"}
+
+	tests := []struct {
+		name      string
+		options   taskfile.AWSOptions
+		env       []map[string]string
+		wantCalls []string
+		wantErr   string
+	}{
+		{
+			name:      "environment",
+			env:       []map[string]string{files, envKeys},
+			wantCalls: []string{"AKIDENV eu-central-1 GetParameter /prod/db/password"},
+		},
+		{
+			name:      "profile with a source profile",
+			options:   taskfile.AWSOptions{Profile: "deploy"},
+			env:       []map[string]string{files, envKeys},
+			wantCalls: []string{"AKIDPROFILE eu-west-3 AssumeRole arn:aws:iam::123456789012:role/deploy kontraktor", "ASIADEPLOY eu-west-3 GetParameter /prod/db/password"},
+		},
+		{
+			name:      "profile from the environment",
+			env:       []map[string]string{files, {"AWS_PROFILE": "base", "AWS_REGION": "us-west-2"}},
+			wantCalls: []string{"AKIDPROFILE us-west-2 GetParameter /prod/db/password"},
+		},
+		{
+			name:      "role with an external id",
+			options:   taskfile.AWSOptions{Region: "ap-southeast-2", RoleARN: "arn:aws:iam::123456789012:role/partner", ExternalID: "ext-123", RoleSessionName: "ci"},
+			env:       []map[string]string{envKeys},
+			wantCalls: []string{"AKIDENV ap-southeast-2 AssumeRole arn:aws:iam::123456789012:role/partner ci", "ASIADEPLOY ap-southeast-2 GetParameter /prod/db/password"},
+		},
+		{
+			name:    "role without access",
+			options: taskfile.AWSOptions{Region: "ap-southeast-2", RoleARN: "arn:aws:iam::123456789012:role/admin"},
+			env:     []map[string]string{envKeys},
+			wantErr: "failed to get parameter /prod/db/password: failed to assume role arn:aws:iam::123456789012:role/admin: AccessDenied: not authorized to perform sts:AssumeRole",
+		},
+		{
+			name: "web identity",
+			env: []map[string]string{{
+				"AWS_REGION": "us-east-1", "AWS_ROLE_ARN": "arn:aws:iam::123456789012:role/web-identity", "AWS_WEB_IDENTITY_TOKEN_FILE": tokenFile,
+			}},
+			wantCalls: []string{"- sts AssumeRoleWithWebIdentity arn:aws:iam::123456789012:role/web-identity", "ASIAWEB us-east-1 GetParameter /prod/db/password"},
+		},
+		{
+			name: "container",
+			env: []map[string]string{{
+				"AWS_REGION": "us-east-1", "AWS_CONTAINER_CREDENTIALS_FULL_URI": server.URL + "/container-credentials", "AWS_CONTAINER_AUTHORIZATION_TOKEN": "container-token",
+			}},
+			wantCalls: []string{"ASIATASK us-east-1 GetParameter /prod/db/password"},
+		},
+		{
+			name:    "no credentials",
+			env:     []map[string]string{{"AWS_REGION": "us-east-1"}},
+			wantErr: "failed to get parameter /prod/db/password: no AWS credentials found (set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, or a profile)",
+		},
+		{
+			name:    "unknown profile",
+			options: taskfile.AWSOptions{Region: "us-east-1", Profile: "staging"},
+			env:     []map[string]string{files},
+			wantErr: "failed to get parameter /prod/db/password: AWS profile staging has no credentials",
+		},
+		{
+			name:    "sso profile",
+			options: taskfile.AWSOptions{Region: "us-east-1", Profile: "sso"},
+			env:     []map[string]string{files},
+			wantErr: "failed to get parameter /prod/db/password: AWS profile sso uses credential_process or SSO, which are not supported (export credentials with aws configure export-credentials --format env)",
+		},
+		{
+			name:    "no region",
+			env:     []map[string]string{envKeys},
+			wantErr: "failed to get parameter /prod/db/password: AWS vault requires region, AWS_REGION or a profile with a region",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			standIn.calls = nil
+			env := make(map[string]string)
+			for _, vars := range tt.env {
+				for name, value := range vars {
+					env[name] = value
+				}
+			}
+			v := NewAWSSSMVault(taskfile.AWSSSMConfig{
+				AWSOptions: tt.options,
+				Secrets:    map[string]string{"DB_PASSWORD": "/prod/db/password"},
+			}, awsEnv(server, env))
+			got, err := v.GetSecret(context.Background(), "DB_PASSWORD")
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "ssm-password", got)
+			assert.Equal(t, tt.wantCalls, standIn.calls)
+		})
+	}
+}
+
+func TestAWSClient_Endpoint(t *testing.T) {
+	getenv := func(env map[string]string) func(string) string {
+		return func(name string) string { return env[name] }
+	}
+	c := newAWSClient(taskfile.AWSOptions{}, "secretsmanager", "AWS_ENDPOINT_URL_SECRETS_MANAGER", getenv(nil))
+	assert.Equal(t, "https://secretsmanager.eu-west-1.amazonaws.com/", c.endpoint("eu-west-1"))
+	assert.Equal(t, "https://secretsmanager.cn-north-1.amazonaws.com.cn/", c.endpoint("cn-north-1"))
+
+	c = newAWSClient(taskfile.AWSOptions{}, "secretsmanager", "AWS_ENDPOINT_URL_SECRETS_MANAGER", getenv(map[string]string{
+		"AWS_ENDPOINT_URL":                 "http://localhost:4566",
+		"AWS_ENDPOINT_URL_SECRETS_MANAGER": "http://localhost:4584",
+	}))
+	assert.Equal(t, "http://localhost:4584", c.endpoint("eu-west-1"))
+
+	c = newAWSClient(taskfile.AWSOptions{Endpoint: "https://vpce-123.secretsmanager.eu-west-1.vpce.amazonaws.com"}, "secretsmanager", "AWS_ENDPOINT_URL_SECRETS_MANAGER", getenv(map[string]string{
+		"AWS_ENDPOINT_URL": "http://localhost:4566",
+	}))
+	assert.Equal(t, "https://vpce-123.secretsmanager.eu-west-1.vpce.amazonaws.com", c.endpoint("eu-west-1"))
+}
+
+func TestAWSVaults_SecretID(t *testing.T) {
+	getenv := func(name string) string { return map[string]string{"AWS_PROFILE": "deploy"}[name] }
+	sm := NewAWSSecretsManagerVault(taskfile.AWSSecretsManagerConfig{
+		AWSOptions: taskfile.AWSOptions{Region: "eu-west-1"},
+		Secrets:    map[string]string{"DB_PASSWORD": "prod/db:password"},
+	}, getenv)
+	assert.Equal(t, "aws_secrets_manager https://secretsmanager.eu-west-1.amazonaws.com/ deploy instance  prod/db:password", sm.SecretID("DB_PASSWORD"))
+
+	ssm := NewAWSSSMVault(taskfile.AWSSSMConfig{
+		AWSOptions: taskfile.AWSOptions{Region: "eu-west-1", RoleARN: "arn:aws:iam::123456789012:role/deploy"},
+		Secrets:    map[string]string{"DB_PASSWORD": "/prod/db/password"},
+	}, getenv)
+	assert.Equal(t, "aws_ssm https://ssm.eu-west-1.amazonaws.com/ deploy instance arn:aws:iam::123456789012:role/deploy /prod/db/password", ssm.SecretID("DB_PASSWORD"))
+}
+
+func TestAWSVaults_DiskCache(t *testing.T) {
+	standIn, server := newAWSStandIn(t)
+	standIn.keys["AKIDOTHER"] = awsCredentials{AccessKeyID: "AKIDOTHER", SecretAccessKey: "other-secret"}
+	dir := t.TempDir()
+	run := func(accessKeyID, secretAccessKey string) {
+		m := secret.NewManager()
+		m.SetDiskCache(secret.NewDiskCache(dir, time.Minute))
+		m.RegisterVault("aws_secrets_manager.prod", NewAWSSecretsManagerVault(taskfile.AWSSecretsManagerConfig{
+			AWSOptions: taskfile.AWSOptions{Region: "eu-west-1"},
+			Secrets:    map[string]string{"API_KEY": "prod/api-key"},
+		}, awsEnv(server, map[string]string{"AWS_ACCESS_KEY_ID": accessKeyID, "AWS_SECRET_ACCESS_KEY": secretAccessKey})))
+		secrets, err := m.FetchTimed(context.Background(), []string{"API_KEY"}, nil)
+		require.NoError(t, err)
+		assert.Equal(t, "api-key", secrets["API_KEY"])
+	}
+
+	// Credentials of another account read the secret again instead of the cached value
+	run("AKIDENV", "env-secret")
+	run("AKIDOTHER", "other-secret")
+	run("AKIDENV", "env-secret")
+	assert.Equal(t, []string{
+		"AKIDENV eu-west-1 GetSecretValue prod/api-key",
+		"AKIDOTHER eu-west-1 GetSecretValue prod/api-key",
+	}, standIn.calls)
+}
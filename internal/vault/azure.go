@@ -1,18 +1,24 @@
-// Package vault provides secret retrieval from Azure Key Vault.
+// Package vault provides secret retrieval from Azure Key Vault, HashiCorp Vault, AWS Secrets
+// Manager and AWS SSM Parameter Store.
 package vault
 
 import (
 	"context"
 	"fmt"
+	"os"
+	"sync"
+	"time"
 
-	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
 	"github.com/Azure/azure-sdk-for-go/sdk/keyvault/azsecrets"
 	"github.com/kontraktor-sh/kontraktor/internal/taskfile"
 )
 
-// AzureClient defines the interface for Azure Key Vault operations
+// AzureClient defines the interface for Azure Key Vault operations. An empty version is the
+// latest version of the secret.
 type AzureClient interface {
-	GetSecret(ctx context.Context, vaultName, secretName string) (string, error)
+	GetSecret(ctx context.Context, vaultName, secretName, version string) (string, error)
 }
 
 // AzureKeyVaultClient implements AzureClient using the Azure SDK
@@ -20,32 +26,66 @@ type AzureKeyVaultClient struct {
 	client *azsecrets.Client
 }
 
-// NewAzureKeyVaultClient creates a new AzureKeyVaultClient
-func NewAzureKeyVaultClient(vaultName string) (*AzureKeyVaultClient, error) {
-	cred, err := azidentity.NewDefaultAzureCredential(nil)
+// NewAzureKeyVaultClient creates a client of the Key Vault of a configuration, authenticating
+// with its credential
+func NewAzureKeyVaultClient(config taskfile.AzureKeyVaultConfig) (*AzureKeyVaultClient, error) {
+	cred, err := NewAzureCredential(config, os.Getenv)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get Azure credential: %w", err)
 	}
-	client, err := azsecrets.NewClient(fmt.Sprintf("https://%s.vault.azure.net/", vaultName), cred, nil)
+	return newAzureKeyVaultClient(config, cred, nil)
+}
+
+// newAzureKeyVaultClient creates a client with a credential, sending requests with transport
+// unless it is nil
+func newAzureKeyVaultClient(config taskfile.AzureKeyVaultConfig, cred azcore.TokenCredential, transport policy.Transporter) (*AzureKeyVaultClient, error) {
+	options := &azsecrets.ClientOptions{DisableChallengeResourceVerification: config.SkipChallengeVerification}
+	options.Cloud = azureCloud(config.Cloud)
+	options.Transport = transport
+	if config.Retry != nil {
+		options.Retry = retryOptions(*config.Retry)
+	}
+	client, err := azsecrets.NewClient(config.URL(), cred, options)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Azure Key Vault client: %w", err)
 	}
 	return &AzureKeyVaultClient{client: client}, nil
 }
 
+// retryOptions converts retries of the taskfile, checked by taskfile validation, to those of the SDK
+func retryOptions(retry taskfile.AzureRetry) policy.RetryOptions {
+	var options policy.RetryOptions
+	if retry.MaxRetries != nil {
+		options.MaxRetries = int32(*retry.MaxRetries)
+		if options.MaxRetries == 0 {
+			options.MaxRetries = -1 // No retries; 0 is the default of the SDK
+		}
+	}
+	options.RetryDelay, _ = time.ParseDuration(retry.RetryDelay)
+	options.MaxRetryDelay, _ = time.ParseDuration(retry.MaxRetryDelay)
+	options.TryTimeout, _ = time.ParseDuration(retry.TryTimeout)
+	return options
+}
+
 // GetSecret retrieves a secret from Azure Key Vault
-func (c *AzureKeyVaultClient) GetSecret(ctx context.Context, vaultName, secretName string) (string, error) {
-	resp, err := c.client.GetSecret(ctx, secretName, "", nil)
+func (c *AzureKeyVaultClient) GetSecret(ctx context.Context, vaultName, secretName, version string) (string, error) {
+	resp, err := c.client.GetSecret(ctx, secretName, version, nil)
 	if err != nil {
+		if version != "" {
+			return "", fmt.Errorf("failed to get secret %s@%s: %w", secretName, version, err)
+		}
 		return "", fmt.Errorf("failed to get secret %s: %w", secretName, err)
 	}
+	if resp.Value == nil {
+		return "", fmt.Errorf("secret %s has no value", secretName)
+	}
 	return *resp.Value, nil
 }
 
 // FetchAzureSecrets fetches secrets from Azure Key Vault as specified in the config.
 // Returns a map of environment variable names to secret values.
 func FetchAzureSecrets(ctx context.Context, config taskfile.AzureKeyVaultConfig) (map[string]string, error) {
-	client, err := NewAzureKeyVaultClient(config.KeyVaultName)
+	client, err := NewAzureKeyVaultClient(config)
 	if err != nil {
 		return nil, err
 	}
@@ -54,13 +94,72 @@ func FetchAzureSecrets(ctx context.Context, config taskfile.AzureKeyVaultConfig)
 
 // FetchSecrets fetches secrets using the provided AzureClient
 func FetchSecrets(ctx context.Context, client AzureClient, config taskfile.AzureKeyVaultConfig) (map[string]string, error) {
-	result := make(map[string]string)
-	for envVar, secretName := range config.Secrets {
-		value, err := client.GetSecret(ctx, config.KeyVaultName, secretName)
+	return getSecrets(ctx, config.Secrets, func(ctx context.Context, name string) (string, error) {
+		secretName, version := taskfile.ParseAzureSecret(config.Secrets[name])
+		return client.GetSecret(ctx, vaultName(config), secretName, version)
+	})
+}
+
+// AzureVault provides the secrets of an Azure Key Vault configuration to a secret manager
+type AzureVault struct {
+	config taskfile.AzureKeyVaultConfig
+	mu     sync.Mutex
+	client AzureClient
+}
+
+// NewAzureVault creates an AzureVault; a nil client is created on first use
+func NewAzureVault(config taskfile.AzureKeyVaultConfig, client AzureClient) *AzureVault {
+	return &AzureVault{config: config, client: client}
+}
+
+// GetSecrets fetches the secrets declared in the configuration
+func (v *AzureVault) GetSecrets(ctx context.Context) (map[string]string, error) {
+	return getSecrets(ctx, v.config.Secrets, v.GetSecret)
+}
+
+// getClient returns the client of the vault, creating it on first use
+func (v *AzureVault) getClient() (AzureClient, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.client == nil {
+		client, err := NewAzureKeyVaultClient(v.config)
 		if err != nil {
 			return nil, err
 		}
-		result[envVar] = value
+		v.client = client
+	}
+	return v.client, nil
+}
+
+// SecretNames returns the environment variable names of the declared secrets
+func (v *AzureVault) SecretNames() []string {
+	return secretNames(v.config.Secrets)
+}
+
+// GetSecret fetches the secret declared for an environment variable name
+func (v *AzureVault) GetSecret(ctx context.Context, name string) (string, error) {
+	secret, ok := v.config.Secrets[name]
+	if !ok {
+		return "", fmt.Errorf("secret %s is not declared in Azure Key Vault %s", name, vaultName(v.config))
 	}
-	return result, nil
+	client, err := v.getClient()
+	if err != nil {
+		return "", err
+	}
+	secretName, version := taskfile.ParseAzureSecret(secret)
+	return client.GetSecret(ctx, vaultName(v.config), secretName, version)
+}
+
+// vaultName returns the name of a Key Vault, or its URL when only vault_url is set
+func vaultName(config taskfile.AzureKeyVaultConfig) string {
+	if config.KeyVaultName != "" {
+		return config.KeyVaultName
+	}
+	return config.URL()
+}
+
+// SecretID identifies a secret by the URL of its Key Vault, its name and its version, for the
+// disk cache
+func (v *AzureVault) SecretID(name string) string {
+	return fmt.Sprintf("azure_keyvault %s %s", v.config.URL(), v.config.Secrets[name])
 }
@@ -0,0 +1,125 @@
+// azure_credential.go
+// Credentials authenticating to Azure Key Vaults, as selected by the credential of each vault.
+package vault
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/kontraktor-sh/kontraktor/internal/taskfile"
+)
+
+// NewAzureCredential creates the credential selected by the configuration of a Key Vault,
+// DefaultAzureCredential when it selects none. Values the credential leaves empty are read
+// from the environment variables used by the Azure SDK with getenv.
+func NewAzureCredential(config taskfile.AzureKeyVaultConfig, getenv func(string) string) (azcore.TokenCredential, error) {
+	c := taskfile.AzureCredential{Type: taskfile.AzureCredentialDefault}
+	if config.Credential != nil {
+		c = *config.Credential
+	}
+	options := azcore.ClientOptions{Cloud: azureCloud(config.Cloud)}
+	tenantID := valueOr(c.TenantID, getenv("AZURE_TENANT_ID"))
+	clientID := valueOr(c.ClientID, getenv("AZURE_CLIENT_ID"))
+
+	switch c.Type {
+	case taskfile.AzureCredentialDefault:
+		return azidentity.NewDefaultAzureCredential(&azidentity.DefaultAzureCredentialOptions{ClientOptions: options, TenantID: c.TenantID})
+
+	case taskfile.AzureCredentialClientSecret:
+		secretEnv := valueOr(c.ClientSecretEnv, "AZURE_CLIENT_SECRET")
+		secret := getenv(secretEnv)
+		if err := requireValue(c.Type, "tenant_id", "AZURE_TENANT_ID", tenantID); err != nil {
+			return nil, err
+		}
+		if err := requireValue(c.Type, "client_id", "AZURE_CLIENT_ID", clientID); err != nil {
+			return nil, err
+		}
+		if secret == "" {
+			return nil, fmt.Errorf("%s credential requires a client secret in %s", c.Type, secretEnv)
+		}
+		return azidentity.NewClientSecretCredential(tenantID, clientID, secret, &azidentity.ClientSecretCredentialOptions{ClientOptions: options})
+
+	case taskfile.AzureCredentialCertificate:
+		path := valueOr(c.CertificatePath, getenv("AZURE_CLIENT_CERTIFICATE_PATH"))
+		if err := requireValue(c.Type, "tenant_id", "AZURE_TENANT_ID", tenantID); err != nil {
+			return nil, err
+		}
+		if err := requireValue(c.Type, "client_id", "AZURE_CLIENT_ID", clientID); err != nil {
+			return nil, err
+		}
+		if err := requireValue(c.Type, "certificate_path", "AZURE_CLIENT_CERTIFICATE_PATH", path); err != nil {
+			return nil, err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read certificate: %w", err)
+		}
+		password := getenv(valueOr(c.CertificatePasswordEnv, "AZURE_CLIENT_CERTIFICATE_PASSWORD"))
+		certs, key, err := azidentity.ParseCertificates(data, []byte(password))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate %s: %w", path, err)
+		}
+		return azidentity.NewClientCertificateCredential(tenantID, clientID, certs, key, &azidentity.ClientCertificateCredentialOptions{ClientOptions: options})
+
+	case taskfile.AzureCredentialWorkloadIdentity:
+		tokenFile := valueOr(c.TokenFile, getenv("AZURE_FEDERATED_TOKEN_FILE"))
+		if err := requireValue(c.Type, "tenant_id", "AZURE_TENANT_ID", tenantID); err != nil {
+			return nil, err
+		}
+		if err := requireValue(c.Type, "client_id", "AZURE_CLIENT_ID", clientID); err != nil {
+			return nil, err
+		}
+		if err := requireValue(c.Type, "token_file", "AZURE_FEDERATED_TOKEN_FILE", tokenFile); err != nil {
+			return nil, err
+		}
+		return azidentity.NewWorkloadIdentityCredential(&azidentity.WorkloadIdentityCredentialOptions{
+			ClientOptions: options,
+			TenantID:      tenantID,
+			ClientID:      clientID,
+			TokenFilePath: tokenFile,
+		})
+
+	case taskfile.AzureCredentialManagedIdentity:
+		// The system-assigned identity unless a user-assigned one is given
+		miOptions := &azidentity.ManagedIdentityCredentialOptions{ClientOptions: options}
+		if c.ResourceID != "" {
+			miOptions.ID = azidentity.ResourceID(c.ResourceID)
+		} else if c.ClientID != "" {
+			miOptions.ID = azidentity.ClientID(c.ClientID)
+		}
+		return azidentity.NewManagedIdentityCredential(miOptions)
+
+	case taskfile.AzureCredentialAzureCLI:
+		return azidentity.NewAzureCLICredential(&azidentity.AzureCLICredentialOptions{TenantID: c.TenantID})
+	}
+	return nil, fmt.Errorf("unknown credential type '%s'", c.Type)
+}
+
+// azureCloud returns the configuration of an Azure cloud by its name in taskfiles
+func azureCloud(name string) cloud.Configuration {
+	switch name {
+	case "china":
+		return cloud.AzureChina
+	case "us_government":
+		return cloud.AzureGovernment
+	}
+	return cloud.AzurePublic
+}
+
+// requireValue reports a value of a credential missing from both the taskfile and the environment
+func requireValue(credential, field, env, value string) error {
+	if value == "" {
+		return fmt.Errorf("%s credential requires %s or %s", credential, field, env)
+	}
+	return nil
+}
+
+func valueOr(value, fallback string) string {
+	if value != "" {
+		return value
+	}
+	return fallback
+}
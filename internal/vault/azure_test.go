@@ -2,8 +2,19 @@ package vault
 
 import (
 	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
 	"github.com/kontraktor-sh/kontraktor/internal/taskfile"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -14,8 +25,8 @@ type MockAzureClient struct {
 	mock.Mock
 }
 
-func (m *MockAzureClient) GetSecret(ctx context.Context, vaultName, secretName string) (string, error) {
-	args := m.Called(ctx, vaultName, secretName)
+func (m *MockAzureClient) GetSecret(ctx context.Context, vaultName, secretName, version string) (string, error) {
+	args := m.Called(ctx, vaultName, secretName, version)
 	return args.String(0), args.Error(1)
 }
 
@@ -46,6 +57,22 @@ func TestFetchAzureSecrets(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "pinned version",
+			config: taskfile.AzureKeyVaultConfig{
+				KeyVaultName: "test-vault",
+				Secrets: map[string]string{
+					"API_KEY": "api-secret@0123abcd",
+				},
+			},
+			mockSecrets: map[string]string{
+				"api-secret@0123abcd": "previous-api-key",
+			},
+			want: map[string]string{
+				"API_KEY": "previous-api-key",
+			},
+			wantErr: false,
+		},
 		{
 			name: "missing secret",
 			config: taskfile.AzureKeyVaultConfig{
@@ -65,15 +92,17 @@ func TestFetchAzureSecrets(t *testing.T) {
 			mockClient := new(MockAzureClient)
 
 			// Set up mock expectations
-			for secretName, secretValue := range tt.mockSecrets {
-				mockClient.On("GetSecret", mock.Anything, tt.config.KeyVaultName, secretName).
+			for secret, secretValue := range tt.mockSecrets {
+				secretName, version := taskfile.ParseAzureSecret(secret)
+				mockClient.On("GetSecret", mock.Anything, tt.config.KeyVaultName, secretName, version).
 					Return(secretValue, nil)
 			}
 
 			// For missing secrets, return an error
-			for _, secretName := range tt.config.Secrets {
-				if _, exists := tt.mockSecrets[secretName]; !exists {
-					mockClient.On("GetSecret", mock.Anything, tt.config.KeyVaultName, secretName).
+			for _, secret := range tt.config.Secrets {
+				if _, exists := tt.mockSecrets[secret]; !exists {
+					secretName, version := taskfile.ParseAzureSecret(secret)
+					mockClient.On("GetSecret", mock.Anything, tt.config.KeyVaultName, secretName, version).
 						Return("", assert.AnError)
 				}
 			}
@@ -91,3 +120,220 @@ func TestFetchAzureSecrets(t *testing.T) {
 		})
 	}
 }
+
+func TestAzureVault_GetSecret(t *testing.T) {
+	mockClient := new(MockAzureClient)
+	mockClient.On("GetSecret", mock.Anything, "test-vault", "api-secret", "").Return("actual-api-key", nil)
+	v := NewAzureVault(taskfile.AzureKeyVaultConfig{
+		KeyVaultName: "test-vault",
+		Secrets:      map[string]string{"API_KEY": "api-secret", "DB_PASSWORD": "db-secret"},
+	}, mockClient)
+
+	got, err := v.GetSecret(context.Background(), "API_KEY")
+	assert.NoError(t, err)
+	assert.Equal(t, "actual-api-key", got)
+
+	_, err = v.GetSecret(context.Background(), "TOKEN")
+	assert.EqualError(t, err, "secret TOKEN is not declared in Azure Key Vault test-vault")
+	mockClient.AssertExpectations(t)
+}
+
+// fakeCredential hands out a fixed token, as credentials do after authenticating
+type fakeCredential struct{}
+
+func (fakeCredential) GetToken(ctx context.Context, options policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: "fake-token", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+// newKeyVaultStandIn serves secrets by name and version like a Key Vault, challenging
+// unauthenticated requests and failing the first request of each secret once
+func newKeyVaultStandIn(t *testing.T, secrets map[string]string) (*httptest.Server, *atomic.Int32) {
+	var requests atomic.Int32
+	var mu sync.Mutex
+	failed := make(map[string]bool)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.Header.Get("Authorization") != "Bearer fake-token" {
+			w.Header().Set("WWW-Authenticate", `Bearer authorization="https://login.microsoftonline.com/tenant", resource="https://vault.azure.net"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		secret := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/secrets/"), "/")
+		mu.Lock()
+		retry := !failed[secret]
+		failed[secret] = true
+		mu.Unlock()
+		if retry {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		name, version, _ := strings.Cut(secret, "/")
+		if version != "" {
+			name += "@" + version
+		}
+		value, ok := secrets[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":{"code":"SecretNotFound","message":"secret not found"}}`)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"value":%q,"id":"%s/secrets/%s"}`, value, "https://"+r.Host, secret)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestAzureKeyVaultClient_StandIn(t *testing.T) {
+	server, requests := newKeyVaultStandIn(t, map[string]string{
+		"api-secret":          "latest-api-key",
+		"api-secret@0123abcd": "previous-api-key",
+	})
+	maxRetries := 2
+	config := taskfile.AzureKeyVaultConfig{
+		VaultURL:                  server.URL,
+		SkipChallengeVerification: true,
+		Retry:                     &taskfile.AzureRetry{MaxRetries: &maxRetries, RetryDelay: "1ms", MaxRetryDelay: "5ms"},
+		Secrets: map[string]string{
+			"API_KEY":     "api-secret",
+			"OLD_API_KEY": "api-secret@0123abcd",
+			"MISSING":     "missing-secret",
+		},
+	}
+	client, err := newAzureKeyVaultClient(config, fakeCredential{}, server.Client())
+	assert.NoError(t, err)
+	v := NewAzureVault(config, client)
+
+	got, err := v.GetSecret(context.Background(), "API_KEY")
+	assert.NoError(t, err)
+	assert.Equal(t, "latest-api-key", got)
+	got, err = v.GetSecret(context.Background(), "OLD_API_KEY")
+	assert.NoError(t, err)
+	assert.Equal(t, "previous-api-key", got)
+	_, err = v.GetSecret(context.Background(), "MISSING")
+	assert.ErrorContains(t, err, "failed to get secret missing-secret")
+	assert.ErrorContains(t, err, "SecretNotFound")
+
+	// A challenge, a failed and a retried request for the first secret, then two for each other
+	assert.Equal(t, int32(7), requests.Load())
+}
+
+func TestAzureKeyVaultClient_NoRetries(t *testing.T) {
+	server, _ := newKeyVaultStandIn(t, map[string]string{"api-secret": "latest-api-key"})
+	maxRetries := 0
+	config := taskfile.AzureKeyVaultConfig{
+		VaultURL:                  server.URL,
+		SkipChallengeVerification: true,
+		Retry:                     &taskfile.AzureRetry{MaxRetries: &maxRetries},
+	}
+	client, err := newAzureKeyVaultClient(config, fakeCredential{}, server.Client())
+	assert.NoError(t, err)
+	_, err = client.GetSecret(context.Background(), server.URL, "api-secret", "")
+	assert.ErrorContains(t, err, "503")
+}
+
+func TestAzureVault_SecretID(t *testing.T) {
+	v := NewAzureVault(taskfile.AzureKeyVaultConfig{
+		KeyVaultName: "test-vault",
+		Cloud:        "china",
+		Secrets:      map[string]string{"API_KEY": "api-secret@0123abcd"},
+	}, nil)
+	assert.Equal(t, "azure_keyvault https://test-vault.vault.azure.cn/ api-secret@0123abcd", v.SecretID("API_KEY"))
+}
+
+func TestNewAzureCredential(t *testing.T) {
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "token")
+	assert.NoError(t, os.WriteFile(tokenFile, []byte("federated-token"), 0o600))
+	env := map[string]string{
+		"AZURE_TENANT_ID":     "env-tenant",
+		"AZURE_CLIENT_ID":     "env-client",
+		"AZURE_CLIENT_SECRET": "env-secret",
+		"DEPLOY_SECRET":       "deploy-secret",
+	}
+
+	tests := []struct {
+		name       string
+		credential *taskfile.AzureCredential
+		env        map[string]string
+		wantType   string
+		wantErr    string
+	}{
+		{
+			name:     "default",
+			env:      env,
+			wantType: "*azidentity.DefaultAzureCredential",
+		},
+		{
+			name:       "client secret from the environment",
+			credential: &taskfile.AzureCredential{Type: taskfile.AzureCredentialClientSecret},
+			env:        env,
+			wantType:   "*azidentity.ClientSecretCredential",
+		},
+		{
+			name:       "client secret in another variable",
+			credential: &taskfile.AzureCredential{Type: taskfile.AzureCredentialClientSecret, TenantID: "tenant", ClientID: "client", ClientSecretEnv: "DEPLOY_SECRET"},
+			env:        map[string]string{"DEPLOY_SECRET": "deploy-secret"},
+			wantType:   "*azidentity.ClientSecretCredential",
+		},
+		{
+			name:       "missing client secret",
+			credential: &taskfile.AzureCredential{Type: taskfile.AzureCredentialClientSecret, ClientSecretEnv: "OTHER_SECRET"},
+			env:        env,
+			wantErr:    "client_secret credential requires a client secret in OTHER_SECRET",
+		},
+		{
+			name:       "missing tenant",
+			credential: &taskfile.AzureCredential{Type: taskfile.AzureCredentialClientSecret, ClientID: "client"},
+			env:        map[string]string{"AZURE_CLIENT_SECRET": "secret"},
+			wantErr:    "client_secret credential requires tenant_id or AZURE_TENANT_ID",
+		},
+		{
+			name:       "missing certificate",
+			credential: &taskfile.AzureCredential{Type: taskfile.AzureCredentialCertificate, CertificatePath: filepath.Join(dir, "missing.pem")},
+			env:        env,
+			wantErr:    "failed to read certificate",
+		},
+		{
+			name:       "invalid certificate",
+			credential: &taskfile.AzureCredential{Type: taskfile.AzureCredentialCertificate, CertificatePath: tokenFile},
+			env:        env,
+			wantErr:    "failed to parse certificate",
+		},
+		{
+			name:       "workload identity",
+			credential: &taskfile.AzureCredential{Type: taskfile.AzureCredentialWorkloadIdentity, TokenFile: tokenFile},
+			env:        env,
+			wantType:   "*azidentity.WorkloadIdentityCredential",
+		},
+		{
+			name:       "workload identity without token file",
+			credential: &taskfile.AzureCredential{Type: taskfile.AzureCredentialWorkloadIdentity},
+			env:        env,
+			wantErr:    "workload_identity credential requires token_file or AZURE_FEDERATED_TOKEN_FILE",
+		},
+		{
+			name:       "user-assigned managed identity",
+			credential: &taskfile.AzureCredential{Type: taskfile.AzureCredentialManagedIdentity, ClientID: "client"},
+			wantType:   "*azidentity.ManagedIdentityCredential",
+		},
+		{
+			name:       "azure cli",
+			credential: &taskfile.AzureCredential{Type: taskfile.AzureCredentialAzureCLI, TenantID: "tenant"},
+			wantType:   "*azidentity.AzureCLICredential",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := taskfile.AzureKeyVaultConfig{KeyVaultName: "test-vault", Credential: tt.credential}
+			cred, err := NewAzureCredential(config, func(name string) string { return tt.env[name] })
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantType, fmt.Sprintf("%T", cred))
+		})
+	}
+}
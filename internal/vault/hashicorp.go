@@ -0,0 +1,329 @@
+// hashicorp.go
+// Reads secrets from the KV secrets engines of HashiCorp Vaults through their HTTP API.
+package vault
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kontraktor-sh/kontraktor/internal/taskfile"
+)
+
+// kubernetesTokenFile is the service account token mounted in Kubernetes pods
+const kubernetesTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// HashiCorpVault provides the secrets of a HashiCorp Vault configuration to a secret manager.
+// It authenticates on first use, and reads each path of the secrets engine once.
+type HashiCorpVault struct {
+	config taskfile.HashiCorpVaultConfig
+	getenv func(string) string
+
+	mu      sync.Mutex
+	client  *http.Client
+	address string
+	token   string
+	paths   map[string]*hashiCorpRead
+}
+
+// hashiCorpRead is the read of a path of the secrets engine, shared by the secrets it holds
+type hashiCorpRead struct {
+	once   sync.Once
+	fields map[string]interface{}
+	err    error
+}
+
+// NewHashiCorpVault creates a HashiCorpVault, reading values the configuration leaves empty
+// from the environment variables of the vault CLI with getenv
+func NewHashiCorpVault(config taskfile.HashiCorpVaultConfig, getenv func(string) string) *HashiCorpVault {
+	return &HashiCorpVault{config: config, getenv: getenv, paths: make(map[string]*hashiCorpRead)}
+}
+
+// GetSecrets fetches the declared secrets, reading each path of the secrets engine once
+func (v *HashiCorpVault) GetSecrets(ctx context.Context) (map[string]string, error) {
+	return getSecrets(ctx, v.config.Secrets, v.GetSecret)
+}
+
+// SecretNames returns the environment variable names declared for paths and fields
+func (v *HashiCorpVault) SecretNames() []string {
+	return secretNames(v.config.Secrets)
+}
+
+// GetSecret fetches the secret declared for an environment variable name
+func (v *HashiCorpVault) GetSecret(ctx context.Context, name string) (string, error) {
+	secret, ok := v.config.Secrets[name]
+	if !ok {
+		return "", fmt.Errorf("secret %s is not declared in HashiCorp Vault secrets engine %s", name, v.mount())
+	}
+	path, field := taskfile.ParseHashiCorpSecret(secret)
+	fields, err := v.read(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	if field == "" {
+		if len(fields) != 1 {
+			return "", fmt.Errorf("secret %s has %d fields (select one with %s#field)", path, len(fields), path)
+		}
+		for f := range fields {
+			field = f
+		}
+	}
+	value, ok := fields[field]
+	if !ok {
+		return "", fmt.Errorf("secret %s has no field %s", path, field)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode field %s of secret %s: %w", field, path, err)
+	}
+	return string(data), nil
+}
+
+// SecretID identifies a secret by the address, namespace and secrets engine of its Vault, for
+// the disk cache
+func (v *HashiCorpVault) SecretID(name string) string {
+	return fmt.Sprintf("hashicorp_vault %s %s %s v%d %s", v.valueOr(v.config.Address, "VAULT_ADDR"),
+		v.valueOr(v.config.Namespace, "VAULT_NAMESPACE"), v.mount(), v.kvVersion(), v.config.Secrets[name])
+}
+
+// read returns the fields of a path of the secrets engine, reading it once
+func (v *HashiCorpVault) read(ctx context.Context, path string) (map[string]interface{}, error) {
+	v.mu.Lock()
+	r, ok := v.paths[path]
+	if !ok {
+		r = &hashiCorpRead{}
+		v.paths[path] = r
+	}
+	v.mu.Unlock()
+
+	r.once.Do(func() {
+		r.fields, r.err = v.readPath(ctx, path)
+	})
+	return r.fields, r.err
+}
+
+func (v *HashiCorpVault) readPath(ctx context.Context, path string) (map[string]interface{}, error) {
+	if err := v.login(ctx); err != nil {
+		return nil, err
+	}
+	apiPath := v.mount() + "/" + path
+	if v.kvVersion() == 2 {
+		apiPath = v.mount() + "/data/" + path
+	}
+	var resp struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := v.do(ctx, http.MethodGet, apiPath, nil, &resp); err != nil {
+		return nil, fmt.Errorf("failed to read secret %s: %w", path, err)
+	}
+	if v.kvVersion() == 1 {
+		return resp.Data, nil
+	}
+	// Version 2 nests the fields of the secret next to its metadata
+	fields, ok := resp.Data["data"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("failed to read secret %s: it was deleted", path)
+	}
+	return fields, nil
+}
+
+// login creates the HTTP client of the vault and obtains a token, once
+func (v *HashiCorpVault) login(ctx context.Context) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.token != "" {
+		return nil
+	}
+	v.address = strings.TrimSuffix(v.valueOr(v.config.Address, "VAULT_ADDR"), "/")
+	if v.address == "" {
+		return fmt.Errorf("HashiCorp Vault requires address or VAULT_ADDR")
+	}
+	if v.client == nil {
+		client, err := v.newClient()
+		if err != nil {
+			return err
+		}
+		v.client = client
+	}
+
+	auth := taskfile.HashiCorpAuth{Method: taskfile.HashiCorpAuthToken}
+	if v.config.Auth != nil {
+		auth = *v.config.Auth
+	}
+	switch auth.Method {
+	case taskfile.HashiCorpAuthToken:
+		token, err := v.envToken(auth)
+		if err != nil {
+			return err
+		}
+		v.token = token
+		return nil
+
+	case taskfile.HashiCorpAuthAppRole:
+		secretIDEnv := valueOr(auth.SecretIDEnv, "VAULT_SECRET_ID")
+		secretID := v.getenv(secretIDEnv)
+		if secretID == "" {
+			return fmt.Errorf("approle method requires a secret ID in %s", secretIDEnv)
+		}
+		return v.loginWith(ctx, valueOr(auth.Mount, "approle"), map[string]string{"role_id": auth.RoleID, "secret_id": secretID})
+
+	case taskfile.HashiCorpAuthKubernetes:
+		path := valueOr(auth.TokenFile, kubernetesTokenFile)
+		jwt, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read service account token: %w", err)
+		}
+		return v.loginWith(ctx, valueOr(auth.Mount, "kubernetes"), map[string]string{"role": auth.Role, "jwt": strings.TrimSpace(string(jwt))})
+	}
+	return fmt.Errorf("unknown auth method '%s'", auth.Method)
+}
+
+// envToken returns the token of the token method: from its environment variable, or the token
+// file written by vault login
+func (v *HashiCorpVault) envToken(auth taskfile.HashiCorpAuth) (string, error) {
+	tokenEnv := valueOr(auth.TokenEnv, "VAULT_TOKEN")
+	if token := v.getenv(tokenEnv); token != "" {
+		return token, nil
+	}
+	if auth.TokenEnv == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			if data, err := os.ReadFile(filepath.Join(home, ".vault-token")); err == nil && len(bytes.TrimSpace(data)) > 0 {
+				return string(bytes.TrimSpace(data)), nil
+			}
+		}
+		return "", fmt.Errorf("token method requires a token in VAULT_TOKEN or ~/.vault-token")
+	}
+	return "", fmt.Errorf("token method requires a token in %s", tokenEnv)
+}
+
+// loginWith logs in to an auth method, keeping the token it returns
+func (v *HashiCorpVault) loginWith(ctx context.Context, mount string, body map[string]string) error {
+	var resp struct {
+		Auth *struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	if err := v.do(ctx, http.MethodPost, "auth/"+strings.Trim(mount, "/")+"/login", body, &resp); err != nil {
+		return fmt.Errorf("failed to log in to HashiCorp Vault: %w", err)
+	}
+	if resp.Auth == nil || resp.Auth.ClientToken == "" {
+		return fmt.Errorf("failed to log in to HashiCorp Vault: no token in response")
+	}
+	v.token = resp.Auth.ClientToken
+	return nil
+}
+
+// do sends a request to the API of the vault, decoding its JSON response into out
+func (v *HashiCorpVault) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, v.address+"/v1/"+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if v.token != "" {
+		req.Header.Set("X-Vault-Token", v.token)
+	}
+	if namespace := v.valueOr(v.config.Namespace, "VAULT_NAMESPACE"); namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Errors []string `json:"errors"`
+		}
+		if json.Unmarshal(data, &apiErr) == nil && len(apiErr.Errors) > 0 {
+			return fmt.Errorf("%s: %s", resp.Status, strings.Join(apiErr.Errors, "; "))
+		}
+		return fmt.Errorf("%s", resp.Status)
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("invalid response: %w", err)
+	}
+	return nil
+}
+
+// newClient creates the HTTP client of the vault with its TLS options
+func (v *HashiCorpVault) newClient() (*http.Client, error) {
+	var opts taskfile.HashiCorpTLS
+	if v.config.TLS != nil {
+		opts = *v.config.TLS
+	}
+	tlsConfig := &tls.Config{
+		ServerName:         v.valueOr(opts.ServerName, "VAULT_TLS_SERVER_NAME"),
+		InsecureSkipVerify: opts.InsecureSkipVerify,
+	}
+	if skip, err := strconv.ParseBool(v.getenv("VAULT_SKIP_VERIFY")); err == nil && skip {
+		tlsConfig.InsecureSkipVerify = true
+	}
+	if caCert := v.valueOr(opts.CACert, "VAULT_CACERT"); caCert != "" {
+		data, err := os.ReadFile(caCert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificate found in %s", caCert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	certFile, keyFile := v.valueOr(opts.ClientCert, "VAULT_CLIENT_CERT"), v.valueOr(opts.ClientKey, "VAULT_CLIENT_KEY")
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: transport, Timeout: 30 * time.Second}, nil
+}
+
+// mount returns the path of the secrets engine
+func (v *HashiCorpVault) mount() string {
+	return strings.Trim(valueOr(v.config.Mount, "secret"), "/")
+}
+
+func (v *HashiCorpVault) kvVersion() int {
+	if v.config.KVVersion == 0 {
+		return 2
+	}
+	return v.config.KVVersion
+}
+
+// valueOr returns a value of the configuration, or the environment variable standing for it
+func (v *HashiCorpVault) valueOr(value, env string) string {
+	return valueOr(value, v.getenv(env))
+}
@@ -0,0 +1,281 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/kontraktor-sh/kontraktor/internal/taskfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// vaultStandIn serves the KV secrets engines and auth methods of a HashiCorp Vault
+type vaultStandIn struct {
+	namespace string            // Namespace required of every request, if any
+	tokens    map[string]bool   // Accepted tokens
+	logins    map[string]string // Token returned by the login of an auth mount, as mount:field=value
+	kv1       map[string]map[string]interface{}
+	kv2       map[string]map[string]interface{}
+
+	mu    sync.Mutex
+	reads map[string]int
+}
+
+func (s *vaultStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fail := func(status int, errs ...string) {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string][]string{"errors": errs})
+	}
+	if r.Header.Get("X-Vault-Namespace") != s.namespace {
+		fail(http.StatusForbidden, "namespace not authorized")
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/v1/")
+	if strings.HasPrefix(path, "auth/") && r.Method == http.MethodPost {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		mount := strings.TrimSuffix(strings.TrimPrefix(path, "auth/"), "/login")
+		for field, value := range body {
+			if token, ok := s.logins[mount+":"+field+"="+value]; ok {
+				json.NewEncoder(w).Encode(map[string]interface{}{"auth": map[string]string{"client_token": token}})
+				return
+			}
+		}
+		fail(http.StatusBadRequest, "invalid credentials")
+		return
+	}
+	if !s.tokens[r.Header.Get("X-Vault-Token")] {
+		fail(http.StatusForbidden, "permission denied")
+		return
+	}
+	s.mu.Lock()
+	s.reads[path]++
+	s.mu.Unlock()
+	if secret, ok := strings.CutPrefix(path, "secret/data/"); ok && s.kv2[secret] != nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{"data": s.kv2[secret], "metadata": map[string]interface{}{"version": 3}},
+		})
+		return
+	}
+	if secret, ok := strings.CutPrefix(path, "kv/"); ok && s.kv1[secret] != nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": s.kv1[secret]})
+		return
+	}
+	fail(http.StatusNotFound)
+}
+
+func newVaultStandIn(t *testing.T, tlsServer bool) (*vaultStandIn, *httptest.Server) {
+	s := &vaultStandIn{
+		tokens: map[string]bool{"root-token": true, "approle-token": true, "k8s-token": true},
+		logins: map[string]string{
+			"approle:secret_id=ci-secret-id":  "approle-token",
+			"k8s/cluster:jwt=service-account": "k8s-token",
+		},
+		kv1: map[string]map[string]interface{}{
+			"legacy/app": {"token": "legacy-token"},
+		},
+		kv2: map[string]map[string]interface{}{
+			"app/db":     {"username": "app", "password": "db-password", "port": 5432},
+			"app/api":    {"key": "api-key"},
+			"app/config": {"tls": map[string]interface{}{"enabled": true}},
+		},
+		reads: make(map[string]int),
+	}
+	server := httptest.NewUnstartedServer(s)
+	if tlsServer {
+		server.StartTLS()
+	} else {
+		server.Start()
+	}
+	t.Cleanup(server.Close)
+	return s, server
+}
+
+func TestHashiCorpVault_GetSecret(t *testing.T) {
+	standIn, server := newVaultStandIn(t, false)
+	v := NewHashiCorpVault(taskfile.HashiCorpVaultConfig{
+		Address: server.URL,
+		Secrets: map[string]string{
+			"DB_USER":     "app/db#username",
+			"DB_PASSWORD": "/app/db#password",
+			"DB_PORT":     "app/db#port",
+			"API_KEY":     "app/api",
+			"TLS":         "app/config#tls",
+			"DB":          "app/db",
+			"MISSING":     "app/missing#key",
+			"NO_FIELD":    "app/api#secret",
+		},
+	}, func(name string) string { return map[string]string{"VAULT_TOKEN": "root-token"}[name] })
+
+	for name, want := range map[string]string{
+		"DB_USER":     "app",
+		"DB_PASSWORD": "db-password",
+		"DB_PORT":     "5432",
+		"API_KEY":     "api-key",
+		"TLS":         `{"enabled":true}`,
+	} {
+		got, err := v.GetSecret(context.Background(), name)
+		assert.NoError(t, err, name)
+		assert.Equal(t, want, got, name)
+	}
+	_, err := v.GetSecret(context.Background(), "DB")
+	assert.EqualError(t, err, "secret app/db has 3 fields (select one with app/db#field)")
+	_, err = v.GetSecret(context.Background(), "MISSING")
+	assert.EqualError(t, err, "failed to read secret app/missing: 404 Not Found")
+	_, err = v.GetSecret(context.Background(), "NO_FIELD")
+	assert.EqualError(t, err, "secret app/api has no field secret")
+	_, err = v.GetSecret(context.Background(), "TOKEN")
+	assert.EqualError(t, err, "secret TOKEN is not declared in HashiCorp Vault secrets engine secret")
+
+	// Each path is read once, however many of its fields are used
+	assert.Equal(t, map[string]int{"secret/data/app/db": 1, "secret/data/app/api": 1, "secret/data/app/config": 1, "secret/data/app/missing": 1}, standIn.reads)
+}
+
+func TestHashiCorpVault_Configurations(t *testing.T) {
+	_, server := newVaultStandIn(t, false)
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("service-account\n"), 0o600))
+
+	tests := []struct {
+		name      string
+		config    taskfile.HashiCorpVaultConfig
+		namespace string
+		env       map[string]string
+		want      string
+		wantErr   string
+	}{
+		{
+			name:   "kv version 1",
+			config: taskfile.HashiCorpVaultConfig{Mount: "kv", KVVersion: 1, Secrets: map[string]string{"TOKEN": "legacy/app#token"}},
+			env:    map[string]string{"VAULT_TOKEN": "root-token"},
+			want:   "legacy-token",
+		},
+		{
+			name:    "invalid token",
+			config:  taskfile.HashiCorpVaultConfig{Secrets: map[string]string{"TOKEN": "app/api#key"}},
+			env:     map[string]string{"VAULT_TOKEN": "expired-token"},
+			wantErr: "failed to read secret app/api: 403 Forbidden: permission denied",
+		},
+		{
+			name: "token in another variable",
+			config: taskfile.HashiCorpVaultConfig{
+				Auth:    &taskfile.HashiCorpAuth{Method: taskfile.HashiCorpAuthToken, TokenEnv: "CI_VAULT_TOKEN"},
+				Secrets: map[string]string{"TOKEN": "app/api#key"},
+			},
+			env:  map[string]string{"CI_VAULT_TOKEN": "root-token"},
+			want: "api-key",
+		},
+		{
+			name: "missing token",
+			config: taskfile.HashiCorpVaultConfig{
+				Auth:    &taskfile.HashiCorpAuth{Method: taskfile.HashiCorpAuthToken, TokenEnv: "CI_VAULT_TOKEN"},
+				Secrets: map[string]string{"TOKEN": "app/api#key"},
+			},
+			wantErr: "token method requires a token in CI_VAULT_TOKEN",
+		},
+		{
+			name: "approle",
+			config: taskfile.HashiCorpVaultConfig{
+				Auth:    &taskfile.HashiCorpAuth{Method: taskfile.HashiCorpAuthAppRole, RoleID: "ci"},
+				Secrets: map[string]string{"TOKEN": "app/api#key"},
+			},
+			env:  map[string]string{"VAULT_SECRET_ID": "ci-secret-id"},
+			want: "api-key",
+		},
+		{
+			name: "approle with invalid secret id",
+			config: taskfile.HashiCorpVaultConfig{
+				Auth:    &taskfile.HashiCorpAuth{Method: taskfile.HashiCorpAuthAppRole, RoleID: "ci", SecretIDEnv: "CI_SECRET_ID"},
+				Secrets: map[string]string{"TOKEN": "app/api#key"},
+			},
+			env:     map[string]string{"CI_SECRET_ID": "revoked"},
+			wantErr: "failed to log in to HashiCorp Vault: 400 Bad Request: invalid credentials",
+		},
+		{
+			name: "kubernetes",
+			config: taskfile.HashiCorpVaultConfig{
+				Auth:    &taskfile.HashiCorpAuth{Method: taskfile.HashiCorpAuthKubernetes, Mount: "k8s/cluster", Role: "deployer", TokenFile: tokenFile},
+				Secrets: map[string]string{"TOKEN": "app/api#key"},
+			},
+			want: "api-key",
+		},
+		{
+			name:      "namespace",
+			config:    taskfile.HashiCorpVaultConfig{Namespace: "team-a", Secrets: map[string]string{"TOKEN": "app/api#key"}},
+			namespace: "team-a",
+			env:       map[string]string{"VAULT_TOKEN": "root-token"},
+			want:      "api-key",
+		},
+		{
+			name:      "namespace from the environment",
+			config:    taskfile.HashiCorpVaultConfig{Secrets: map[string]string{"TOKEN": "app/api#key"}},
+			namespace: "team-a",
+			env:       map[string]string{"VAULT_TOKEN": "root-token", "VAULT_NAMESPACE": "team-a"},
+			want:      "api-key",
+		},
+		{
+			name:    "missing address",
+			config:  taskfile.HashiCorpVaultConfig{Secrets: map[string]string{"TOKEN": "app/api#key"}},
+			env:     map[string]string{"VAULT_TOKEN": "root-token", "VAULT_ADDR": ""},
+			wantErr: "HashiCorp Vault requires address or VAULT_ADDR",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server.Config.Handler.(*vaultStandIn).namespace = tt.namespace
+			env := map[string]string{"VAULT_ADDR": server.URL}
+			for name, value := range tt.env {
+				env[name] = value
+			}
+			v := NewHashiCorpVault(tt.config, func(name string) string { return env[name] })
+			got, err := v.GetSecret(context.Background(), "TOKEN")
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestHashiCorpVault_TLS(t *testing.T) {
+	_, server := newVaultStandIn(t, true)
+	caCert := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caCert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o600))
+	getenv := func(name string) string { return map[string]string{"VAULT_TOKEN": "root-token"}[name] }
+	secrets := map[string]string{"API_KEY": "app/api#key"}
+
+	v := NewHashiCorpVault(taskfile.HashiCorpVaultConfig{Address: server.URL, Secrets: secrets}, getenv)
+	_, err := v.GetSecret(context.Background(), "API_KEY")
+	assert.ErrorContains(t, err, "certificate")
+
+	v = NewHashiCorpVault(taskfile.HashiCorpVaultConfig{Address: server.URL, Secrets: secrets, TLS: &taskfile.HashiCorpTLS{CACert: caCert}}, getenv)
+	got, err := v.GetSecret(context.Background(), "API_KEY")
+	assert.NoError(t, err)
+	assert.Equal(t, "api-key", got)
+
+	v = NewHashiCorpVault(taskfile.HashiCorpVaultConfig{Address: server.URL, Secrets: secrets, TLS: &taskfile.HashiCorpTLS{InsecureSkipVerify: true}}, getenv)
+	got, err = v.GetSecret(context.Background(), "API_KEY")
+	assert.NoError(t, err)
+	assert.Equal(t, "api-key", got)
+}
+
+func TestHashiCorpVault_SecretID(t *testing.T) {
+	v := NewHashiCorpVault(taskfile.HashiCorpVaultConfig{
+		Address: "https://vault.example.com:8200",
+		Mount:   "/kv/",
+		Secrets: map[string]string{"API_KEY": "app/api#key"},
+	}, func(name string) string { return map[string]string{"VAULT_NAMESPACE": "team-a"}[name] })
+	assert.Equal(t, "hashicorp_vault https://vault.example.com:8200 team-a kv v2 app/api#key", v.SecretID("API_KEY"))
+}
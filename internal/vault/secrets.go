@@ -0,0 +1,32 @@
+// secrets.go
+// Helpers shared by vaults declaring their secrets as environment variable names mapped to
+// references in the vault.
+package vault
+
+import (
+	"context"
+	"sort"
+)
+
+// getSecrets fetches each declared secret with get, by environment variable name
+func getSecrets(ctx context.Context, secrets map[string]string, get func(context.Context, string) (string, error)) (map[string]string, error) {
+	result := make(map[string]string, len(secrets))
+	for name := range secrets {
+		value, err := get(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		result[name] = value
+	}
+	return result, nil
+}
+
+// secretNames returns the sorted environment variable names of declared secrets
+func secretNames(secrets map[string]string) []string {
+	names := make([]string, 0, len(secrets))
+	for name := range secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}